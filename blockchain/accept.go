@@ -72,10 +72,30 @@ func (b *BlockChain) maybeAcceptBlock(block *btcutil.Block, flags BehaviorFlags)
 	}
 	b.index.AddNode(newNode)
 
+	// Reject blocks that were manually invalidated.  The node is kept in
+	// the block index marked as failed, so its descendants are rejected as
+	// well until the block is reconsidered.
+	if _, ok := b.invalidatedBlocks[newNode.hash]; ok {
+		b.index.SetStatusFlags(newNode, statusValidateFailed)
+		if err := b.index.flushToDB(); err != nil {
+			return false, err
+		}
+		str := fmt.Sprintf("block %s has been invalidated", newNode.hash)
+		return false, ruleError(ErrInvalidatedBlock, str)
+	}
+	if err := b.index.flushToDB(); err != nil {
+		return false, err
+	}
+
 	// Connect the passed block to the chain while respecting proper chain
 	// selection according to the chain with the most proof of work.  This
-	// also handles validation of the transaction scripts.
+	// also handles validation of the transaction scripts.  The validation
+	// state of the blocks is written to the database either way, so blocks
+	// that failed validation are still known to be invalid after restarts.
 	isMainChain, err := b.connectBestChain(newNode, block, flags)
+	if flushErr := b.index.flushToDB(); err == nil {
+		err = flushErr
+	}
 	if err != nil {
 		return false, err
 	}
@@ -29,3 +29,80 @@ func BenchmarkIsCoinBaseTx(b *testing.B) {
 		IsCoinBaseTx(tx)
 	}
 }
+
+// loadBenchBlock returns block 277647 along with a utxo view that contains all
+// of the outputs it spends for use in benchmarks.
+func loadBenchBlock(b *testing.B) (*btcutil.Block, *UtxoViewpoint) {
+	blocks, err := loadBlocks("277647.dat.bz2")
+	if err != nil {
+		b.Fatalf("Error loading file: %v", err)
+	}
+	view, err := loadUtxoView("277647.utxostore.bz2")
+	if err != nil {
+		b.Fatalf("Error loading txstore: %v", err)
+	}
+	blocks[0].SetHeight(277647)
+	return blocks[0], view
+}
+
+// BenchmarkConnectTransactions performs a benchmark against connecting all of
+// the transactions of a block to a utxo view, which is the primary work done
+// on the utxo set for each block during the initial block download.
+func BenchmarkConnectTransactions(b *testing.B) {
+	block, view := loadBenchBlock(b)
+	var numStxos int
+	for _, tx := range block.Transactions()[1:] {
+		numStxos += len(tx.MsgTx().TxIn)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Copy the view so every iteration starts with all of the
+		// spent outputs unspent.
+		b.StopTimer()
+		viewCopy := NewUtxoViewpoint()
+		for outpoint, entry := range view.Entries() {
+			viewCopy.Entries()[outpoint] = entry.Clone()
+		}
+		stxos := make([]SpentTxOut, 0, numStxos)
+		b.StartTimer()
+
+		err := viewCopy.connectTransactions(block, &stxos)
+		if err != nil {
+			b.Fatalf("connectTransactions: unexpected error: %v", err)
+		}
+	}
+}
+
+// BenchmarkAddTxOuts performs a benchmark against adding the outputs of all of
+// the transactions of a block to an empty utxo view.  The reported bytes per
+// operation reflect the memory used by the view for the outputs of the block.
+func BenchmarkAddTxOuts(b *testing.B) {
+	block, _ := loadBenchBlock(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		view := NewUtxoViewpoint()
+		for _, tx := range block.Transactions() {
+			view.AddTxOuts(tx, block.Height())
+		}
+	}
+}
+
+// BenchmarkDeserializeUtxoEntry performs a benchmark against deserializing a
+// utxo entry, which is done for every output loaded from the database.
+func BenchmarkDeserializeUtxoEntry(b *testing.B) {
+	serialized := hexToBytes("8cf316800900b8025be1b3efc63b0ad48e7f9f10e87544528d58")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := deserializeUtxoEntry(serialized)
+		if err != nil {
+			b.Fatalf("deserializeUtxoEntry: unexpected error: %v",
+				err)
+		}
+	}
+}
@@ -231,6 +231,14 @@ type blockIndex struct {
 
 	sync.RWMutex
 	index map[chainhash.Hash]*blockNode
+
+	// tips houses the nodes that don't have any children, which are the
+	// tips of the best chain and of all side chains.
+	tips map[*blockNode]struct{}
+
+	// dirty houses the nodes that were added or whose status changed since
+	// the index was last written to the database.
+	dirty map[*blockNode]struct{}
 }
 
 // newBlockIndex returns a new empty instance of a block index.  The index will
@@ -241,6 +249,8 @@ func newBlockIndex(db database.DB, chainParams *chaincfg.Params) *blockIndex {
 		db:          db,
 		chainParams: chainParams,
 		index:       make(map[chainhash.Hash]*blockNode),
+		tips:        make(map[*blockNode]struct{}),
+		dirty:       make(map[*blockNode]struct{}),
 	}
 }
 
@@ -265,16 +275,56 @@ func (bi *blockIndex) LookupNode(hash *chainhash.Hash) *blockNode {
 	return node
 }
 
-// AddNode adds the provided node to the block index.  Duplicate entries are not
-// checked so it is up to caller to avoid adding them.
+// AddNode adds the provided node to the block index and marks it to be written
+// to the database on the next flush.  Duplicate entries are not checked so it
+// is up to caller to avoid adding them.  The node replaces its parent as a tip
+// of the block tree.
 //
 // This function is safe for concurrent access.
 func (bi *blockIndex) AddNode(node *blockNode) {
 	bi.Lock()
-	bi.index[node.hash] = node
+	bi.addNode(node)
+	bi.dirty[node] = struct{}{}
 	bi.Unlock()
 }
 
+// addNode adds the provided node to the block index without marking it to be
+// written to the database, which is used when loading the index from it.
+//
+// This function MUST be called with the block index lock held (for writes).
+func (bi *blockIndex) addNode(node *blockNode) {
+	bi.index[node.hash] = node
+	delete(bi.tips, node.parent)
+	bi.tips[node] = struct{}{}
+}
+
+// Nodes returns all of the nodes in the block index in no particular order.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) Nodes() []*blockNode {
+	bi.RLock()
+	nodes := make([]*blockNode, 0, len(bi.index))
+	for _, node := range bi.index {
+		nodes = append(nodes, node)
+	}
+	bi.RUnlock()
+	return nodes
+}
+
+// Tips returns the nodes in the block index that don't have any children in no
+// particular order.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) Tips() []*blockNode {
+	bi.RLock()
+	tips := make([]*blockNode, 0, len(bi.tips))
+	for node := range bi.tips {
+		tips = append(tips, node)
+	}
+	bi.RUnlock()
+	return tips
+}
+
 // NodeStatus provides concurrent-safe access to the status field of a node.
 //
 // This function is safe for concurrent access.
@@ -292,7 +342,10 @@ func (bi *blockIndex) NodeStatus(node *blockNode) blockStatus {
 // This function is safe for concurrent access.
 func (bi *blockIndex) SetStatusFlags(node *blockNode, flags blockStatus) {
 	bi.Lock()
-	node.status |= flags
+	if node.status|flags != node.status {
+		node.status |= flags
+		bi.dirty[node] = struct{}{}
+	}
 	bi.Unlock()
 }
 
@@ -302,6 +355,38 @@ func (bi *blockIndex) SetStatusFlags(node *blockNode, flags blockStatus) {
 // This function is safe for concurrent access.
 func (bi *blockIndex) UnsetStatusFlags(node *blockNode, flags blockStatus) {
 	bi.Lock()
-	node.status &^= flags
+	if node.status&flags != 0 {
+		node.status &^= flags
+		bi.dirty[node] = struct{}{}
+	}
 	bi.Unlock()
 }
+
+// flushToDB writes the header and status of the nodes that were added or whose
+// status changed since the last flush to the block header index in the
+// database.  The nodes remain marked when it fails, so they are written by the
+// next flush.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) flushToDB() error {
+	bi.Lock()
+	defer bi.Unlock()
+
+	if len(bi.dirty) == 0 {
+		return nil
+	}
+	err := bi.db.Update(func(dbTx database.Tx) error {
+		for node := range bi.dirty {
+			err := dbStoreBlockNode(dbTx, node, node.status)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	bi.dirty = make(map[*blockNode]struct{})
+	return nil
+}
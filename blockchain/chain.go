@@ -125,6 +125,26 @@ type BlockChain struct {
 	index     *blockIndex
 	bestChain *chainView
 
+	// utxoCache holds the unspent transaction outputs that were recently
+	// created or loaded along with the modifications to the utxo set that
+	// have not been written to the database yet.  It has its own lock,
+	// however it is only modified while holding the chain lock for writes.
+	utxoCache *utxoCache
+
+	// pruneTarget is the number of bytes the stored blocks are pruned to
+	// once they exceed it.  It is zero when pruning is disabled.
+	//
+	// pruneHeight is the height of the first main chain block after the
+	// highest one that was pruned.  It is protected by the chain lock.
+	pruneTarget uint64
+	pruneHeight int32
+
+	// invalidatedBlocks houses the hashes of the blocks that were manually
+	// invalidated with InvalidateBlock.  It is also stored in the database
+	// so the blocks remain invalid across restarts.  It is protected by the
+	// chain lock.
+	invalidatedBlocks map[chainhash.Hash]struct{}
+
 	// These fields are related to handling of orphan blocks.  They are
 	// protected by a combination of the chain lock and the orphan lock.
 	orphanLock   sync.RWMutex
@@ -399,7 +419,7 @@ func (b *BlockChain) calcSequenceLock(node *blockNode, tx *btcutil.Tx, utxoView
 	nextHeight := node.height + 1
 
 	for txInIndex, txIn := range mTx.TxIn {
-		utxo := utxoView.LookupEntry(&txIn.PreviousOutPoint.Hash)
+		utxo := utxoView.LookupEntry(txIn.PreviousOutPoint)
 		if utxo == nil {
 			str := fmt.Sprintf("output %v referenced from "+
 				"transaction %s:%d either does not exist or "+
@@ -503,7 +523,7 @@ func (b *BlockChain) getReorganizeNodes(node *blockNode) (*list.List, *list.List
 	// Do not reorganize to a known invalid chain. Ancestors deeper than the
 	// direct parent are checked below but this is a quick check before doing
 	// more unnecessary work.
-	if b.index.NodeStatus(node.parent).KnownInvalid() {
+	if node.parent != nil && b.index.NodeStatus(node.parent).KnownInvalid() {
 		b.index.SetStatusFlags(node, statusInvalidAncestor)
 		return detachNodes, attachNodes
 	}
@@ -569,7 +589,7 @@ func dbMaybeStoreBlock(dbTx database.Tx, block *btcutil.Block) error {
 // it would be inefficient to repeat it.
 //
 // This function MUST be called with the chain state lock held (for writes).
-func (b *BlockChain) connectBlock(node *blockNode, block *btcutil.Block, view *UtxoViewpoint, stxos []spentTxOut) error {
+func (b *BlockChain) connectBlock(node *blockNode, block *btcutil.Block, view *UtxoViewpoint, stxos []SpentTxOut) error {
 	// Make sure it's extending the end of the best chain.
 	prevHash := &block.MsgBlock().Header.PrevBlock
 	if !prevHash.IsEqual(&b.bestChain.Tip().hash) {
@@ -610,6 +630,12 @@ func (b *BlockChain) connectBlock(node *blockNode, block *btcutil.Block, view *U
 	state := newBestState(node, blockSize, blockWeight, numTxns,
 		curTotalTxns+numTxns, node.CalcPastMedianTime())
 
+	// Write any block status changes to the database before updating the
+	// best state.
+	if err := b.index.flushToDB(); err != nil {
+		return err
+	}
+
 	// Atomically insert info into the database.
 	err := b.db.Update(func(dbTx database.Tx) error {
 		// Update best block state.
@@ -625,14 +651,6 @@ func (b *BlockChain) connectBlock(node *blockNode, block *btcutil.Block, view *U
 			return err
 		}
 
-		// Update the utxo set using the state of the utxo view.  This
-		// entails removing all of the utxos spent and adding the new
-		// ones created by the block.
-		err = dbPutUtxoView(dbTx, view)
-		if err != nil {
-			return err
-		}
-
 		// Update the transaction spend journal by adding a record for
 		// the block that contains all txos spent by it.
 		err = dbPutSpendJournalEntry(dbTx, block.Hash(), stxos)
@@ -656,8 +674,14 @@ func (b *BlockChain) connectBlock(node *blockNode, block *btcutil.Block, view *U
 		return err
 	}
 
+	// Update the utxo cache using the state of the utxo view.  This entails
+	// removing all of the utxos spent and adding the new ones created by
+	// the block.  They are written to the database when the cache is
+	// flushed.
+	b.utxoCache.commit(view)
+
 	// Prune fully spent entries and mark all entries in the view unmodified
-	// now that the modifications have been committed to the database.
+	// now that the modifications have been committed to the cache.
 	view.commit()
 
 	// This node is now the end of the best chain.
@@ -672,6 +696,23 @@ func (b *BlockChain) connectBlock(node *blockNode, block *btcutil.Block, view *U
 	b.stateSnapshot = state
 	b.stateLock.Unlock()
 
+	// Write the utxo cache to the database when it is due.  The block is
+	// already the tip at this point, so failures are only logged rather
+	// than reported as the block being rejected.  A failed flush is retried
+	// with the next block since the cache keeps the modifications, and the
+	// blocks are replayed from the last flush after an unclean shutdown.
+	err = b.utxoCache.flush(&node.hash, FlushPeriodic)
+	if err != nil {
+		log.Errorf("Unable to flush the utxo cache: %v", err)
+	}
+
+	// Delete the oldest blocks when the stored blocks exceed the prune
+	// target.  Likewise, a failure only delays pruning until the next
+	// block.
+	if err := b.maybePruneBlocks(); err != nil {
+		log.Errorf("Unable to prune blocks: %v", err)
+	}
+
 	// Notify the caller that the block was connected to the main chain.
 	// The caller would typically want to react with actions such as
 	// updating wallets.
@@ -717,6 +758,12 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *btcutil.Block, view
 	state := newBestState(prevNode, blockSize, blockWeight, numTxns,
 		newTotalTxns, prevNode.CalcPastMedianTime())
 
+	// Write any block status changes to the database before updating the
+	// best state.
+	if err := b.index.flushToDB(); err != nil {
+		return err
+	}
+
 	err = b.db.Update(func(dbTx database.Tx) error {
 		// Update best block state.
 		err := dbPutBestState(dbTx, state, node.workSum)
@@ -733,11 +780,16 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *btcutil.Block, view
 
 		// Update the utxo set using the state of the utxo view.  This
 		// entails restoring all of the utxos spent and removing the new
-		// ones created by the block.
+		// ones created by the block.  The utxo cache was flushed before
+		// disconnecting, so this is written to the database directly.
 		err = dbPutUtxoView(dbTx, view)
 		if err != nil {
 			return err
 		}
+		err = dbPutUtxoStateConsistency(dbTx, &prevNode.hash)
+		if err != nil {
+			return err
+		}
 
 		// Update the transaction spend journal by removing the record
 		// that contains all txos spent by the block .
@@ -762,8 +814,12 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *btcutil.Block, view
 		return err
 	}
 
-	// Prune fully spent entries and mark all entries in the view unmodified
-	// now that the modifications have been committed to the database.
+	// Remove the stale entries from the utxo cache, then prune fully spent
+	// entries and mark all entries in the view unmodified now that the
+	// modifications have been committed to the database.
+	if err := b.utxoCache.invalidate(view); err != nil {
+		return err
+	}
 	view.commit()
 
 	// This node's parent is now the end of the best chain.
@@ -814,9 +870,19 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 	// then they are needed again when doing the actual database updates.
 	// Rather than doing two loads, cache the loaded data into these slices.
 	detachBlocks := make([]*btcutil.Block, 0, detachNodes.Len())
-	detachSpentTxOuts := make([][]spentTxOut, 0, detachNodes.Len())
+	detachSpentTxOuts := make([][]SpentTxOut, 0, detachNodes.Len())
 	attachBlocks := make([]*btcutil.Block, 0, attachNodes.Len())
 
+	// Disconnecting blocks relies on the spend journal and updates the utxo
+	// set in the database directly, so the utxo cache must not hold any
+	// modifications when doing so.
+	if detachNodes.Len() != 0 {
+		err := b.utxoCache.flush(&b.bestChain.Tip().hash, FlushRequired)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Disconnect all of the blocks back to the point of the fork.  This
 	// entails loading the blocks and their associated spent txos from the
 	// database and using that information to unspend all of the spent txos
@@ -837,16 +903,16 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 
 		// Load all of the utxos referenced by the block that aren't
 		// already in the view.
-		err = view.fetchInputUtxos(b.db, block)
+		err = view.fetchInputUtxos(b.utxoCache, block)
 		if err != nil {
 			return err
 		}
 
 		// Load all of the spent txos for the block from the spend
 		// journal.
-		var stxos []spentTxOut
+		var stxos []SpentTxOut
 		err = b.db.View(func(dbTx database.Tx) error {
-			stxos, err = dbFetchSpendJournalEntry(dbTx, block, view)
+			stxos, err = dbFetchSpendJournalEntry(dbTx, block)
 			return err
 		})
 		if err != nil {
@@ -857,7 +923,7 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		detachBlocks = append(detachBlocks, block)
 		detachSpentTxOuts = append(detachSpentTxOuts, stxos)
 
-		err = view.disconnectTransactions(block, stxos)
+		err = view.disconnectTransactions(b.db, block, stxos)
 		if err != nil {
 			return err
 		}
@@ -903,7 +969,7 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		// checkConnectBlock gets skipped, we still need to update the UTXO
 		// view.
 		if b.index.NodeStatus(n).KnownValid() {
-			err = view.fetchInputUtxos(b.db, block)
+			err = view.fetchInputUtxos(b.utxoCache, block)
 			if err != nil {
 				return err
 			}
@@ -952,14 +1018,14 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 
 		// Load all of the utxos referenced by the block that aren't
 		// already in the view.
-		err := view.fetchInputUtxos(b.db, block)
+		err := view.fetchInputUtxos(b.utxoCache, block)
 		if err != nil {
 			return err
 		}
 
 		// Update the view to unspend all of the spent txos and remove
 		// the utxos created by the block.
-		err = view.disconnectTransactions(block, detachSpentTxOuts[i])
+		err = view.disconnectTransactions(b.db, block, detachSpentTxOuts[i])
 		if err != nil {
 			return err
 		}
@@ -978,7 +1044,7 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 
 		// Load all of the utxos referenced by the block that aren't
 		// already in the view.
-		err := view.fetchInputUtxos(b.db, block)
+		err := view.fetchInputUtxos(b.utxoCache, block)
 		if err != nil {
 			return err
 		}
@@ -987,7 +1053,7 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		// as spent and add all transactions being created by this block
 		// to it.  Also, provide an stxo slice so the spent txout
 		// details are generated.
-		stxos := make([]spentTxOut, 0, countSpentOutputs(block))
+		stxos := make([]SpentTxOut, 0, countSpentOutputs(block))
 		err = view.connectTransactions(block, &stxos)
 		if err != nil {
 			return err
@@ -1001,13 +1067,22 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 	}
 
 	// Log the point where the chain forked and old and new best chain
-	// heads.
-	firstAttachNode := attachNodes.Front().Value.(*blockNode)
-	firstDetachNode := detachNodes.Front().Value.(*blockNode)
-	lastAttachNode := attachNodes.Back().Value.(*blockNode)
-	log.Infof("REORGANIZE: Chain forks at %v", firstAttachNode.parent.hash)
-	log.Infof("REORGANIZE: Old best chain head was %v", firstDetachNode.hash)
-	log.Infof("REORGANIZE: New best chain head is %v", lastAttachNode.hash)
+	// heads.  Either list may be empty when the chain is only rewound or
+	// extended due to blocks being invalidated or reconsidered.
+	if detachNodes.Len() != 0 {
+		firstDetachNode := detachNodes.Front().Value.(*blockNode)
+		lastDetachNode := detachNodes.Back().Value.(*blockNode)
+		log.Infof("REORGANIZE: Chain forks at %v",
+			lastDetachNode.parent.hash)
+		log.Infof("REORGANIZE: Old best chain head was %v",
+			firstDetachNode.hash)
+	} else if attachNodes.Len() != 0 {
+		firstAttachNode := attachNodes.Front().Value.(*blockNode)
+		log.Infof("REORGANIZE: Chain forks at %v",
+			firstAttachNode.parent.hash)
+	}
+	log.Infof("REORGANIZE: New best chain head is %v",
+		b.bestChain.Tip().hash)
 
 	return nil
 }
@@ -1041,7 +1116,7 @@ func (b *BlockChain) connectBestChain(node *blockNode, block *btcutil.Block, fla
 		// actually connecting the block.
 		view := NewUtxoViewpoint()
 		view.SetBestHash(parentHash)
-		stxos := make([]spentTxOut, 0, countSpentOutputs(block))
+		stxos := make([]SpentTxOut, 0, countSpentOutputs(block))
 		if !fastAdd {
 			err := b.checkConnectBlock(node, block, view, &stxos)
 			if err != nil {
@@ -1058,7 +1133,7 @@ func (b *BlockChain) connectBestChain(node *blockNode, block *btcutil.Block, fla
 		// utxos, spend them, and add the new utxos being created by
 		// this block.
 		if fastAdd {
-			err := view.fetchInputUtxos(b.db, block)
+			err := view.fetchInputUtxos(b.utxoCache, block)
 			if err != nil {
 				return false, err
 			}
@@ -1537,6 +1612,24 @@ type Config struct {
 	// This field can be nil if the caller is not interested in using a
 	// signature cache.
 	HashCache *txscript.HashCache
+
+	// UtxoCacheMaxSize is the maximum number of bytes the utxo cache is
+	// allowed to use before its contents are written to the database.
+	// Callers must call FlushUtxoCache with FlushRequired before shutting
+	// down to avoid replaying the blocks connected since the last flush on
+	// the next start.
+	//
+	// When zero, the utxo set is written to the database every time a
+	// block is connected.
+	UtxoCacheMaxSize uint64
+
+	// Prune is the number of bytes the stored blocks are allowed to use
+	// before the oldest ones are deleted.  The most recent MinBlocksToKeep
+	// main chain blocks are always kept.
+	//
+	// When zero, pruning is disabled.  A database that has been pruned
+	// can't be used with pruning disabled.
+	Prune uint64
 }
 
 // New returns a BlockChain instance using the provided configuration details.
@@ -1588,6 +1681,9 @@ func New(config *Config) (*BlockChain, error) {
 		index:               newBlockIndex(config.DB, params),
 		hashCache:           config.HashCache,
 		bestChain:           newChainView(nil),
+		utxoCache:           newUtxoCache(config.DB, config.UtxoCacheMaxSize),
+		pruneTarget:         config.Prune,
+		invalidatedBlocks:   make(map[chainhash.Hash]struct{}),
 		orphans:             make(map[chainhash.Hash]*orphanBlock),
 		prevOrphans:         make(map[chainhash.Hash][]*orphanBlock),
 		warningCaches:       newThresholdCaches(vbNumBits),
@@ -1601,6 +1697,22 @@ func New(config *Config) (*BlockChain, error) {
 		return nil, err
 	}
 
+	// Perform any upgrades to the various chain-specific buckets as needed.
+	if err := b.maybeUpgradeDbBuckets(config.Interrupt); err != nil {
+		return nil, err
+	}
+
+	// Make sure the utxo set reflects the end of the main chain in case
+	// the chain was not shut down cleanly.
+	if err := b.initUtxoCache(config.Interrupt); err != nil {
+		return nil, err
+	}
+
+	// Load the prune state and ensure pruned databases keep pruning.
+	if err := b.initPruneState(); err != nil {
+		return nil, err
+	}
+
 	// Initialize and catch up all of the currently active optional indexes
 	// as needed.
 	if config.IndexManager != nil {
@@ -1610,6 +1722,17 @@ func New(config *Config) (*BlockChain, error) {
 		}
 	}
 
+	// Reorganize away from invalidated blocks that are still in the best
+	// chain because the chain was shut down before it finished doing so.
+	if b.index.NodeStatus(b.bestChain.Tip()).KnownInvalid() {
+		b.chainLock.Lock()
+		err := b.activateBestChain()
+		b.chainLock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Initialize rule change threshold state caches.
 	if err := b.initThresholdCaches(); err != nil {
 		return nil, err
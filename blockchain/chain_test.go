@@ -5,14 +5,17 @@
 package blockchain
 
 import (
+	"bytes"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
+	"github.com/davecgh/go-spew/spew"
 )
 
 // TestHaveBlock tests the HaveBlock API to ensure proper functionality.
@@ -800,3 +803,477 @@ func TestLocateInventory(t *testing.T) {
 		}
 	}
 }
+
+// TestFetchSpendJournal ensures the spent outputs returned for the tip of the
+// main chain match the outputs the block inputs reference.
+func TestFetchSpendJournal(t *testing.T) {
+	blocks, err := loadBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("Error loading file: %v\n", err)
+	}
+
+	// Create a new database and chain instance to run tests against.
+	chain, teardownFunc, err := chainSetup("fetchspendjournal",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Since we're not dealing with the real block chain, set the coinbase
+	// maturity to 1.
+	chain.TstSetCoinbaseMaturity(1)
+
+	txns := make(map[chainhash.Hash]*wire.MsgTx)
+	for _, tx := range blocks[0].Transactions() {
+		txns[*tx.Hash()] = tx.MsgTx()
+	}
+	for i := 1; i < len(blocks); i++ {
+		block := blocks[i]
+		_, _, err := chain.ProcessBlock(block, BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock fail on block %v: %v\n", i, err)
+		}
+
+		spent, err := chain.FetchSpendJournal(block)
+		if err != nil {
+			t.Fatalf("FetchSpendJournal fail on block %v: %v", i, err)
+		}
+		if len(spent) != countSpentOutputs(block) {
+			t.Fatalf("FetchSpendJournal #%d: unexpected number of "+
+				"spent outputs -- got %d, want %d", i,
+				len(spent), countSpentOutputs(block))
+		}
+
+		// Ensure each spent output matches the referenced output.
+		var spentIdx int
+		for _, tx := range block.Transactions()[1:] {
+			for _, txIn := range tx.MsgTx().TxIn {
+				prevOut := &txIn.PreviousOutPoint
+				originTx, ok := txns[prevOut.Hash]
+				if !ok {
+					t.Fatalf("FetchSpendJournal #%d: unknown "+
+						"output %v", i, prevOut)
+				}
+				want := originTx.TxOut[prevOut.Index]
+				got := spent[spentIdx]
+				spentIdx++
+				if got.Amount != want.Value ||
+					!bytes.Equal(got.PkScript, want.PkScript) {
+
+					t.Fatalf("FetchSpendJournal #%d: "+
+						"mismatched output %v -- got "+
+						"%v, want %v", i, prevOut,
+						spew.Sdump(got),
+						spew.Sdump(want))
+				}
+			}
+			txns[*tx.Hash()] = tx.MsgTx()
+		}
+		txns[*block.Transactions()[0].Hash()] =
+			block.Transactions()[0].MsgTx()
+	}
+
+	// Ensure blocks earlier in the main chain can still be loaded.
+	spent, err := chain.FetchSpendJournal(blocks[2])
+	if err != nil {
+		t.Fatalf("FetchSpendJournal: unexpected error for block "+
+			"before the tip: %v", err)
+	}
+	if len(spent) != countSpentOutputs(blocks[2]) {
+		t.Fatalf("FetchSpendJournal: unexpected number of spent "+
+			"outputs for block before the tip -- got %d, want %d",
+			len(spent), countSpentOutputs(blocks[2]))
+	}
+
+	// Ensure blocks that are not in the main chain are rejected.
+	orphan := btcutil.NewBlock(&wire.MsgBlock{
+		Header:       blocks[1].MsgBlock().Header,
+		Transactions: blocks[1].MsgBlock().Transactions,
+	})
+	orphan.MsgBlock().Header.Nonce++
+	_, err = chain.FetchSpendJournal(orphan)
+	if !isNotInMainChainErr(err) {
+		t.Fatalf("FetchSpendJournal: did not receive expected error "+
+			"for block that is not in the main chain -- got %v", err)
+	}
+}
+
+// TestForEachUnspentOutput ensures iterating the utxo set returns exactly the
+// outputs created by the main chain blocks that have not been spent by them.
+func TestForEachUnspentOutput(t *testing.T) {
+	blocks, err := loadBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("Error loading file: %v\n", err)
+	}
+
+	// Create a new database and chain instance to run tests against.
+	chain, teardownFunc, err := chainSetup("foreachunspentoutput",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Since we're not dealing with the real block chain, set the coinbase
+	// maturity to 1.
+	chain.TstSetCoinbaseMaturity(1)
+
+	// The outputs of the genesis block are never added to the utxo set.
+	want := make(map[wire.OutPoint]*wire.TxOut)
+	for i := 1; i < len(blocks); i++ {
+		block := blocks[i]
+		_, _, err := chain.ProcessBlock(block, BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock fail on block %v: %v\n", i, err)
+		}
+
+		for txIdx, tx := range block.Transactions() {
+			if txIdx != 0 {
+				for _, txIn := range tx.MsgTx().TxIn {
+					delete(want, txIn.PreviousOutPoint)
+				}
+			}
+			for outIdx, txOut := range tx.MsgTx().TxOut {
+				outpoint := wire.OutPoint{
+					Hash:  *tx.Hash(),
+					Index: uint32(outIdx),
+				}
+				want[outpoint] = txOut
+			}
+		}
+	}
+
+	err = chain.ForEachUnspentOutput(func(outpoint wire.OutPoint, amount int64, pkScript []byte) error {
+		txOut, ok := want[outpoint]
+		if !ok {
+			t.Fatalf("ForEachUnspentOutput: unexpected output %v",
+				outpoint)
+		}
+		if amount != txOut.Value || !bytes.Equal(pkScript, txOut.PkScript) {
+			t.Fatalf("ForEachUnspentOutput: mismatched output %v "+
+				"-- got %d %x, want %d %x", outpoint, amount,
+				pkScript, txOut.Value, txOut.PkScript)
+		}
+		delete(want, outpoint)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachUnspentOutput: unexpected error: %v", err)
+	}
+	if len(want) != 0 {
+		t.Fatalf("ForEachUnspentOutput: %d outputs not returned",
+			len(want))
+	}
+}
+
+// TestInvalidateBlock ensures invalidating, reconsidering and preferring blocks
+// reorganizes the chain as expected and that invalidated blocks remain invalid
+// across restarts.
+func TestInvalidateBlock(t *testing.T) {
+	// Load up blocks such that there is a side chain with the same work as
+	// the main chain.
+	// (genesis block) -> 1 -> 2 -> 3 -> 4
+	//                          \-> 3a -> 4a
+	testFiles := []string{
+		"blk_0_to_4.dat.bz2",
+		"blk_3A.dat.bz2",
+		"blk_4A.dat.bz2",
+	}
+	var blocks []*btcutil.Block
+	for _, file := range testFiles {
+		blockTmp, err := loadBlocks(file)
+		if err != nil {
+			t.Fatalf("Error loading file: %v\n", err)
+		}
+		blocks = append(blocks, blockTmp...)
+	}
+	block1, block3, block4 := blocks[1], blocks[3], blocks[4]
+	block3a, block4a := blocks[5], blocks[6]
+
+	chain, teardownFunc, err := chainSetup("invalidateblock",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+	chain.TstSetCoinbaseMaturity(1)
+
+	// restart returns a new chain instance using the database of the
+	// current one as if it was restarted.
+	restart := func() *BlockChain {
+		t.Helper()
+
+		newChain, err := New(&Config{
+			DB:          chain.db,
+			ChainParams: chain.chainParams,
+			TimeSource:  NewMedianTime(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to restart chain instance: %v", err)
+		}
+		newChain.TstSetCoinbaseMaturity(1)
+		return newChain
+	}
+	checkTip := func(want *btcutil.Block) {
+		t.Helper()
+
+		if tip := chain.BestSnapshot().Hash; tip != *want.Hash() {
+			t.Fatalf("mismatched best chain tip -- got %v, want %v",
+				tip, want.Hash())
+		}
+	}
+
+	for _, block := range blocks[1:] {
+		_, isOrphan, err := chain.ProcessBlock(block, BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock fail on block %v: %v", block.Hash(),
+				err)
+		}
+		if isOrphan {
+			t.Fatalf("ProcessBlock incorrectly returned block %v is "+
+				"an orphan", block.Hash())
+		}
+	}
+	checkTip(block4)
+
+	// Preferring a block with the same work as the best chain reorganizes
+	// to it, while a block with less work is left alone.
+	if err := chain.PreciousBlock(block4a.Hash()); err != nil {
+		t.Fatalf("PreciousBlock: unexpected error: %v", err)
+	}
+	checkTip(block4a)
+	if err := chain.PreciousBlock(block4.Hash()); err != nil {
+		t.Fatalf("PreciousBlock: unexpected error: %v", err)
+	}
+	checkTip(block4)
+	if err := chain.PreciousBlock(block3a.Hash()); err != nil {
+		t.Fatalf("PreciousBlock: unexpected error: %v", err)
+	}
+	checkTip(block4)
+
+	// A block can't be preferred while the data of a block in its branch
+	// is not available, such as after it was pruned.
+	node3a := chain.index.LookupNode(block3a.Hash())
+	chain.index.UnsetStatusFlags(node3a, statusDataStored)
+	if err := chain.PreciousBlock(block4a.Hash()); err == nil {
+		t.Fatal("PreciousBlock: did not receive expected error for " +
+			"branch with missing block data")
+	}
+	checkTip(block4)
+	chain.index.SetStatusFlags(node3a, statusDataStored)
+
+	// Invalidating a block in the best chain reorganizes to the side
+	// chain, which has the most work of the remaining chains.
+	if err := chain.InvalidateBlock(block3.Hash()); err != nil {
+		t.Fatalf("InvalidateBlock: unexpected error: %v", err)
+	}
+	checkTip(block4a)
+	if err := chain.PreciousBlock(block4.Hash()); err == nil {
+		t.Fatal("PreciousBlock: did not receive expected error for " +
+			"invalidated chain")
+	}
+
+	// The invalidated block and its descendants remain invalid after a
+	// restart since their status is loaded along with the block index.
+	chain = restart()
+	checkTip(block4a)
+	if err := chain.PreciousBlock(block4.Hash()); err == nil {
+		t.Fatal("PreciousBlock: did not receive expected error for " +
+			"invalidated chain after restart")
+	}
+
+	// Reconsidering the block makes it valid again without reorganizing to
+	// it since it doesn't have more work.  The chain is then reorganized to
+	// it on demand, which validates the blocks anew.
+	if err := chain.ReconsiderBlock(block3.Hash()); err != nil {
+		t.Fatalf("ReconsiderBlock: unexpected error: %v", err)
+	}
+	checkTip(block4a)
+	if err := chain.PreciousBlock(block4.Hash()); err != nil {
+		t.Fatalf("PreciousBlock: unexpected error: %v", err)
+	}
+	checkTip(block4)
+
+	// Invalidating all blocks but the genesis block rewinds to it and
+	// reconsidering them reorganizes back to a chain with the most work.
+	if err := chain.InvalidateBlock(block1.Hash()); err != nil {
+		t.Fatalf("InvalidateBlock: unexpected error: %v", err)
+	}
+	if height := chain.BestSnapshot().Height; height != 0 {
+		t.Fatalf("mismatched best chain height -- got %d, want 0",
+			height)
+	}
+	if err := chain.ReconsiderBlock(block1.Hash()); err != nil {
+		t.Fatalf("ReconsiderBlock: unexpected error: %v", err)
+	}
+	if height := chain.BestSnapshot().Height; height != 4 {
+		t.Fatalf("mismatched best chain height -- got %d, want 4",
+			height)
+	}
+
+	// A chain shut down after invalidating a block in the best chain but
+	// before reorganizing away from it does so when it is started, which
+	// reorganizes to the side chain that is loaded along with it.
+	if err := chain.PreciousBlock(block4.Hash()); err != nil {
+		t.Fatalf("PreciousBlock: unexpected error: %v", err)
+	}
+	err = chain.db.Update(func(dbTx database.Tx) error {
+		return dbPutInvalidatedBlock(dbTx, block4.Hash())
+	})
+	if err != nil {
+		t.Fatalf("unable to record invalidated block: %v", err)
+	}
+	chain = restart()
+	checkTip(block4a)
+
+	// The genesis block and unknown blocks can't be invalidated.
+	genesisHash := chain.chainParams.GenesisHash
+	if err := chain.InvalidateBlock(genesisHash); err == nil {
+		t.Fatal("InvalidateBlock: did not receive expected error for " +
+			"genesis block")
+	}
+	if err := chain.InvalidateBlock(&chainhash.Hash{}); err == nil {
+		t.Fatal("InvalidateBlock: did not receive expected error for " +
+			"unknown block")
+	}
+}
+
+// TestChainTips ensures the tips of the best chain and the side chains are
+// reported with the expected branch lengths and statuses.
+func TestChainTips(t *testing.T) {
+	// Load up blocks such that there is a side chain with the same work as
+	// the main chain.
+	// (genesis block) -> 1 -> 2 -> 3 -> 4
+	//                          \-> 3a -> 4a
+	testFiles := []string{
+		"blk_0_to_4.dat.bz2",
+		"blk_3A.dat.bz2",
+		"blk_4A.dat.bz2",
+	}
+	var blocks []*btcutil.Block
+	for _, file := range testFiles {
+		blockTmp, err := loadBlocks(file)
+		if err != nil {
+			t.Fatalf("Error loading file: %v\n", err)
+		}
+		blocks = append(blocks, blockTmp...)
+	}
+	block3, block4 := blocks[3], blocks[4]
+	block3a, block4a := blocks[5], blocks[6]
+
+	chain, teardownFunc, err := chainSetup("chaintips",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+	chain.TstSetCoinbaseMaturity(1)
+
+	// restart returns a new chain instance using the database of the
+	// current one as if it was restarted.
+	restart := func() *BlockChain {
+		t.Helper()
+
+		newChain, err := New(&Config{
+			DB:          chain.db,
+			ChainParams: chain.chainParams,
+			TimeSource:  NewMedianTime(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to restart chain instance: %v", err)
+		}
+		newChain.TstSetCoinbaseMaturity(1)
+		return newChain
+	}
+	checkTips := func(want []ChainTip) {
+		t.Helper()
+
+		tips := chain.ChainTips()
+		if !reflect.DeepEqual(tips, want) {
+			t.Fatalf("ChainTips: mismatched tips -- got %v, want %v",
+				spew.Sdump(tips), spew.Sdump(want))
+		}
+	}
+
+	// Only the genesis block is known initially.
+	genesisHash := chain.chainParams.GenesisHash
+	checkTips([]ChainTip{{Hash: *genesisHash, Status: ChainTipActive}})
+
+	for _, block := range blocks[1:] {
+		if _, _, err := chain.ProcessBlock(block, BFNone); err != nil {
+			t.Fatalf("ProcessBlock fail on block %v: %v", block.Hash(),
+				err)
+		}
+	}
+
+	// The side chain was never connected, so its blocks were not fully
+	// validated.  The side chain is loaded along with the best chain, so
+	// it is still reported after a restart.
+	wantTips := []ChainTip{
+		{Height: 4, Hash: *block4.Hash(), Status: ChainTipActive},
+		{Height: 4, Hash: *block4a.Hash(), BranchLen: 2,
+			Status: ChainTipValidHeaders},
+	}
+	checkTips(wantTips)
+	chain = restart()
+	checkTips(wantTips)
+
+	// Reorganizing to the side chain validates it, which leaves the former
+	// best chain as a valid fork.
+	if err := chain.PreciousBlock(block4a.Hash()); err != nil {
+		t.Fatalf("PreciousBlock: unexpected error: %v", err)
+	}
+	checkTips([]ChainTip{
+		{Height: 4, Hash: *block4a.Hash(), Status: ChainTipActive},
+		{Height: 4, Hash: *block4.Hash(), BranchLen: 2,
+			Status: ChainTipValidFork},
+	})
+
+	// Invalidating the side chain moves the best chain to block 3, which
+	// is reported as the active tip even though it has an invalid child.
+	if err := chain.InvalidateBlock(block4.Hash()); err != nil {
+		t.Fatalf("InvalidateBlock: unexpected error: %v", err)
+	}
+	if err := chain.InvalidateBlock(block3a.Hash()); err != nil {
+		t.Fatalf("InvalidateBlock: unexpected error: %v", err)
+	}
+	wantTips = []ChainTip{
+		{Height: 4, Hash: *block4.Hash(), BranchLen: 1,
+			Status: ChainTipInvalid},
+		{Height: 4, Hash: *block4a.Hash(), BranchLen: 2,
+			Status: ChainTipInvalid},
+		{Height: 3, Hash: *block3.Hash(), Status: ChainTipActive},
+	}
+	checkTips(wantTips)
+
+	// The validation state of the side chains is stored along with them,
+	// so their statuses are unchanged after a restart.
+	chain = restart()
+	checkTips(wantTips)
+}
+
+// TestChainTipStatusStringer tests the stringized output for the
+// ChainTipStatus type.
+func TestChainTipStatusStringer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in   ChainTipStatus
+		want string
+	}{
+		{ChainTipActive, "ChainTipActive"},
+		{ChainTipValidFork, "ChainTipValidFork"},
+		{ChainTipValidHeaders, "ChainTipValidHeaders"},
+		{ChainTipHeadersOnly, "ChainTipHeadersOnly"},
+		{ChainTipInvalid, "ChainTipInvalid"},
+		{0xff, "Unknown ChainTipStatus (255)"},
+	}
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+		}
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"reflect"
 	"testing"
 
-	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/database"
 	"github.com/btcsuite/btcd/wire"
 )
@@ -38,19 +37,6 @@ func TestErrNotInMainChain(t *testing.T) {
 	}
 }
 
-// maybeDecompress decompresses the amount and public key script fields of the
-// stxo and marks it decompressed if needed.
-func (o *spentTxOut) maybeDecompress(version int32) {
-	// Nothing to do if it's not compressed.
-	if !o.compressed {
-		return
-	}
-
-	o.amount = int64(decompressTxOutAmount(uint64(o.amount)))
-	o.pkScript = decompressScript(o.pkScript, version)
-	o.compressed = false
-}
-
 // TestStxoSerialization ensures serializing and deserializing spent transaction
 // output entries works as expected.
 func TestStxoSerialization(t *testing.T) {
@@ -58,43 +44,41 @@ func TestStxoSerialization(t *testing.T) {
 
 	tests := []struct {
 		name       string
-		stxo       spentTxOut
-		txVersion  int32 // When the txout is not fully spent.
+		stxo       SpentTxOut
 		serialized []byte
+		legacy     []byte // Legacy serialization decoding to the stxo.
 	}{
 		// From block 170 in main blockchain.
 		{
-			name: "Spends last output of coinbase",
-			stxo: spentTxOut{
-				amount:     5000000000,
-				pkScript:   hexToBytes("410411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5cb2e0eaddfb84ccf9744464f82e160bfa9b8b64f9d4c03f999b8643f656b412a3ac"),
-				isCoinBase: true,
-				height:     9,
-				version:    1,
+			name: "Spends coinbase",
+			stxo: SpentTxOut{
+				Amount:     5000000000,
+				PkScript:   hexToBytes("410411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5cb2e0eaddfb84ccf9744464f82e160bfa9b8b64f9d4c03f999b8643f656b412a3ac"),
+				IsCoinBase: true,
+				Height:     9,
 			},
-			serialized: hexToBytes("1301320511db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5c"),
+			serialized: hexToBytes("1300320511db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5c"),
+			legacy:     hexToBytes("1301320511db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5c"),
 		},
 		// Adapted from block 100025 in main blockchain.
 		{
-			name: "Spends last output of non coinbase",
-			stxo: spentTxOut{
-				amount:     13761000000,
-				pkScript:   hexToBytes("76a914b2fb57eadf61e106a100a7445a8c3f67898841ec88ac"),
-				isCoinBase: false,
-				height:     100024,
-				version:    1,
+			name: "Spends non coinbase",
+			stxo: SpentTxOut{
+				Amount:     13761000000,
+				PkScript:   hexToBytes("76a914b2fb57eadf61e106a100a7445a8c3f67898841ec88ac"),
+				IsCoinBase: false,
+				Height:     100024,
 			},
-			serialized: hexToBytes("8b99700186c64700b2fb57eadf61e106a100a7445a8c3f67898841ec"),
+			serialized: hexToBytes("8b99700086c64700b2fb57eadf61e106a100a7445a8c3f67898841ec"),
+			legacy:     hexToBytes("8b99700186c64700b2fb57eadf61e106a100a7445a8c3f67898841ec"),
 		},
 		// Adapted from block 100025 in main blockchain.
 		{
-			name: "Does not spend last output",
-			stxo: spentTxOut{
-				amount:   34405000000,
-				pkScript: hexToBytes("76a9146edbc6c4d31bae9f1ccc38538a114bf42de65e8688ac"),
-				version:  1,
+			name: "Legacy spend without height",
+			stxo: SpentTxOut{
+				Amount:   34405000000,
+				PkScript: hexToBytes("76a9146edbc6c4d31bae9f1ccc38538a114bf42de65e8688ac"),
 			},
-			txVersion:  1,
 			serialized: hexToBytes("0091f20f006edbc6c4d31bae9f1ccc38538a114bf42de65e86"),
 		},
 	}
@@ -127,27 +111,33 @@ func TestStxoSerialization(t *testing.T) {
 			continue
 		}
 
-		// Ensure the serialized bytes are decoded back to the expected
-		// stxo.
-		var gotStxo spentTxOut
-		gotBytesRead, err := decodeSpentTxOut(test.serialized, &gotStxo,
-			test.txVersion)
-		if err != nil {
-			t.Errorf("decodeSpentTxOut (%s): unexpected error: %v",
-				test.name, err)
-			continue
-		}
-		gotStxo.maybeDecompress(test.stxo.version)
-		if !reflect.DeepEqual(gotStxo, test.stxo) {
-			t.Errorf("decodeSpentTxOut (%s) mismatched entries - "+
-				"got %v, want %v", test.name, gotStxo, test.stxo)
-			continue
-		}
-		if gotBytesRead != len(test.serialized) {
-			t.Errorf("decodeSpentTxOut (%s): did not get expected "+
-				"number of bytes read - got %d, want %d",
-				test.name, gotBytesRead, len(test.serialized))
-			continue
+		// Ensure the serialized bytes, as well as the legacy ones when
+		// there are any, are decoded back to the expected stxo.
+		for _, serialized := range [][]byte{test.serialized, test.legacy} {
+			if serialized == nil {
+				continue
+			}
+
+			var gotStxo SpentTxOut
+			gotBytesRead, err := decodeSpentTxOut(serialized, &gotStxo)
+			if err != nil {
+				t.Errorf("decodeSpentTxOut (%s): unexpected "+
+					"error: %v", test.name, err)
+				continue
+			}
+			if !reflect.DeepEqual(gotStxo, test.stxo) {
+				t.Errorf("decodeSpentTxOut (%s) mismatched "+
+					"entries - got %v, want %v", test.name,
+					gotStxo, test.stxo)
+				continue
+			}
+			if gotBytesRead != len(serialized) {
+				t.Errorf("decodeSpentTxOut (%s): did not get "+
+					"expected number of bytes read - got %d, "+
+					"want %d", test.name, gotBytesRead,
+					len(serialized))
+				continue
+			}
 		}
 	}
 }
@@ -159,52 +149,42 @@ func TestStxoDecodeErrors(t *testing.T) {
 
 	tests := []struct {
 		name       string
-		stxo       spentTxOut
-		txVersion  int32 // When the txout is not fully spent.
+		stxo       SpentTxOut
 		serialized []byte
 		bytesRead  int // Expected number of bytes read.
 		errType    error
 	}{
 		{
 			name:       "nothing serialized",
-			stxo:       spentTxOut{},
+			stxo:       SpentTxOut{},
 			serialized: hexToBytes(""),
 			errType:    errDeserialize(""),
 			bytesRead:  0,
 		},
 		{
-			name:       "no data after header code w/o version",
-			stxo:       spentTxOut{},
+			name:       "no data after header code w/o height",
+			stxo:       SpentTxOut{},
 			serialized: hexToBytes("00"),
 			errType:    errDeserialize(""),
 			bytesRead:  1,
 		},
 		{
-			name:       "no data after header code with version",
-			stxo:       spentTxOut{},
+			name:       "no data after header code with height",
+			stxo:       SpentTxOut{},
 			serialized: hexToBytes("13"),
 			errType:    errDeserialize(""),
 			bytesRead:  1,
 		},
 		{
 			name:       "no data after version",
-			stxo:       spentTxOut{},
-			serialized: hexToBytes("1301"),
+			stxo:       SpentTxOut{},
+			serialized: hexToBytes("1300"),
 			errType:    errDeserialize(""),
 			bytesRead:  2,
 		},
-		{
-			name:       "no serialized tx version and passed -1",
-			stxo:       spentTxOut{},
-			txVersion:  -1,
-			serialized: hexToBytes("003205"),
-			errType:    AssertError(""),
-			bytesRead:  1,
-		},
 		{
 			name:       "incomplete compressed txout",
-			stxo:       spentTxOut{},
-			txVersion:  1,
+			stxo:       SpentTxOut{},
 			serialized: hexToBytes("0032"),
 			errType:    errDeserialize(""),
 			bytesRead:  2,
@@ -213,8 +193,7 @@ func TestStxoDecodeErrors(t *testing.T) {
 
 	for _, test := range tests {
 		// Ensure the expected error type is returned.
-		gotBytesRead, err := decodeSpentTxOut(test.serialized,
-			&test.stxo, test.txVersion)
+		gotBytesRead, err := decodeSpentTxOut(test.serialized, &test.stxo)
 		if reflect.TypeOf(err) != reflect.TypeOf(test.errType) {
 			t.Errorf("decodeSpentTxOut (%s): expected error type "+
 				"does not match - got %T, want %T", test.name,
@@ -239,9 +218,8 @@ func TestSpendJournalSerialization(t *testing.T) {
 
 	tests := []struct {
 		name       string
-		entry      []spentTxOut
+		entry      []SpentTxOut
 		blockTxns  []*wire.MsgTx
-		utxoView   *UtxoViewpoint
 		serialized []byte
 	}{
 		// From block 2 in main blockchain.
@@ -249,18 +227,16 @@ func TestSpendJournalSerialization(t *testing.T) {
 			name:       "No spends",
 			entry:      nil,
 			blockTxns:  nil,
-			utxoView:   NewUtxoViewpoint(),
 			serialized: nil,
 		},
 		// From block 170 in main blockchain.
 		{
 			name: "One tx with one input spends last output of coinbase",
-			entry: []spentTxOut{{
-				amount:     5000000000,
-				pkScript:   hexToBytes("410411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5cb2e0eaddfb84ccf9744464f82e160bfa9b8b64f9d4c03f999b8643f656b412a3ac"),
-				isCoinBase: true,
-				height:     9,
-				version:    1,
+			entry: []SpentTxOut{{
+				Amount:     5000000000,
+				PkScript:   hexToBytes("410411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5cb2e0eaddfb84ccf9744464f82e160bfa9b8b64f9d4c03f999b8643f656b412a3ac"),
+				IsCoinBase: true,
+				Height:     9,
 			}},
 			blockTxns: []*wire.MsgTx{{ // Coinbase omitted.
 				Version: 1,
@@ -281,22 +257,21 @@ func TestSpendJournalSerialization(t *testing.T) {
 				}},
 				LockTime: 0,
 			}},
-			utxoView:   NewUtxoViewpoint(),
-			serialized: hexToBytes("1301320511db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5c"),
+			serialized: hexToBytes("1300320511db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5c"),
 		},
 		// Adapted from block 100025 in main blockchain.
 		{
-			name: "Two txns when one spends last output, one doesn't",
-			entry: []spentTxOut{{
-				amount:   34405000000,
-				pkScript: hexToBytes("76a9146edbc6c4d31bae9f1ccc38538a114bf42de65e8688ac"),
-				version:  1,
+			name: "Two txns with one input each",
+			entry: []SpentTxOut{{
+				Amount:     34405000000,
+				PkScript:   hexToBytes("76a9146edbc6c4d31bae9f1ccc38538a114bf42de65e8688ac"),
+				IsCoinBase: false,
+				Height:     100024,
 			}, {
-				amount:     13761000000,
-				pkScript:   hexToBytes("76a914b2fb57eadf61e106a100a7445a8c3f67898841ec88ac"),
-				isCoinBase: false,
-				height:     100024,
-				version:    1,
+				Amount:     13761000000,
+				PkScript:   hexToBytes("76a914b2fb57eadf61e106a100a7445a8c3f67898841ec88ac"),
+				IsCoinBase: false,
+				Height:     100024,
 			}},
 			blockTxns: []*wire.MsgTx{{ // Coinbase omitted.
 				Version: 1,
@@ -335,32 +310,17 @@ func TestSpendJournalSerialization(t *testing.T) {
 				}},
 				LockTime: 0,
 			}},
-			utxoView: &UtxoViewpoint{entries: map[chainhash.Hash]*UtxoEntry{
-				*newHashFromStr("c0ed017828e59ad5ed3cf70ee7c6fb0f426433047462477dc7a5d470f987a537"): {
-					version:     1,
-					isCoinBase:  false,
-					blockHeight: 100024,
-					sparseOutputs: map[uint32]*utxoOutput{
-						1: {
-							amount:   34405000000,
-							pkScript: hexToBytes("76a9142084541c3931677527a7eafe56fd90207c344eb088ac"),
-						},
-					},
-				},
-			}},
-			serialized: hexToBytes("8b99700186c64700b2fb57eadf61e106a100a7445a8c3f67898841ec0091f20f006edbc6c4d31bae9f1ccc38538a114bf42de65e86"),
+			serialized: hexToBytes("8b99700086c64700b2fb57eadf61e106a100a7445a8c3f67898841ec8b99700091f20f006edbc6c4d31bae9f1ccc38538a114bf42de65e86"),
 		},
 		// Hand crafted.
 		{
-			name: "One tx, two inputs from same tx, neither spend last output",
-			entry: []spentTxOut{{
-				amount:   165125632,
-				pkScript: hexToBytes("51"),
-				version:  1,
+			name: "Legacy tx, two inputs from same tx, neither spend last output",
+			entry: []SpentTxOut{{
+				Amount:   165125632,
+				PkScript: hexToBytes("51"),
 			}, {
-				amount:   154370000,
-				pkScript: hexToBytes("51"),
-				version:  1,
+				Amount:   154370000,
+				PkScript: hexToBytes("51"),
 			}},
 			blockTxns: []*wire.MsgTx{{ // Coinbase omitted.
 				Version: 1,
@@ -388,19 +348,6 @@ func TestSpendJournalSerialization(t *testing.T) {
 				}},
 				LockTime: 0,
 			}},
-			utxoView: &UtxoViewpoint{entries: map[chainhash.Hash]*UtxoEntry{
-				*newHashFromStr("c0ed017828e59ad5ed3cf70ee7c6fb0f426433047462477dc7a5d470f987a537"): {
-					version:     1,
-					isCoinBase:  false,
-					blockHeight: 100000,
-					sparseOutputs: map[uint32]*utxoOutput{
-						0: {
-							amount:   165712179,
-							pkScript: hexToBytes("51"),
-						},
-					},
-				},
-			}},
 			serialized: hexToBytes("0087bc3707510084c3d19a790751"),
 		},
 	}
@@ -417,16 +364,12 @@ func TestSpendJournalSerialization(t *testing.T) {
 
 		// Deserialize to a spend journal entry.
 		gotEntry, err := deserializeSpendJournalEntry(test.serialized,
-			test.blockTxns, test.utxoView)
+			test.blockTxns)
 		if err != nil {
 			t.Errorf("deserializeSpendJournalEntry #%d (%s) "+
 				"unexpected error: %v", i, test.name, err)
 			continue
 		}
-		for stxoIdx := range gotEntry {
-			stxo := &gotEntry[stxoIdx]
-			stxo.maybeDecompress(test.entry[stxoIdx].version)
-		}
 
 		// Ensure that the deserialized spend journal entry has the
 		// correct properties.
@@ -447,7 +390,6 @@ func TestSpendJournalErrors(t *testing.T) {
 	tests := []struct {
 		name       string
 		blockTxns  []*wire.MsgTx
-		utxoView   *UtxoViewpoint
 		serialized []byte
 		errType    error
 	}{
@@ -466,7 +408,6 @@ func TestSpendJournalErrors(t *testing.T) {
 				}},
 				LockTime: 0,
 			}},
-			utxoView:   NewUtxoViewpoint(),
 			serialized: hexToBytes(""),
 			errType:    AssertError(""),
 		},
@@ -484,7 +425,6 @@ func TestSpendJournalErrors(t *testing.T) {
 				}},
 				LockTime: 0,
 			}},
-			utxoView:   NewUtxoViewpoint(),
 			serialized: hexToBytes("1301320511db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a"),
 			errType:    errDeserialize(""),
 		},
@@ -494,7 +434,7 @@ func TestSpendJournalErrors(t *testing.T) {
 		// Ensure the expected error type is returned and the returned
 		// slice is nil.
 		stxos, err := deserializeSpendJournalEntry(test.serialized,
-			test.blockTxns, test.utxoView)
+			test.blockTxns)
 		if reflect.TypeOf(err) != reflect.TypeOf(test.errType) {
 			t.Errorf("deserializeSpendJournalEntry (%s): expected "+
 				"error type does not match - got %T, want %T",
@@ -521,186 +461,64 @@ func TestUtxoSerialization(t *testing.T) {
 		serialized []byte
 	}{
 		// From tx in main blockchain:
-		// 0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098
+		// 0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098:0
 		{
-			name: "Only output 0, coinbase",
+			name: "height 1, coinbase",
 			entry: &UtxoEntry{
-				version:     1,
-				isCoinBase:  true,
+				amount:      5000000000,
+				pkScript:    hexToBytes("410496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52da7589379515d4e0a604f8141781e62294721166bf621e73a82cbf2342c858eeac"),
 				blockHeight: 1,
-				sparseOutputs: map[uint32]*utxoOutput{
-					0: {
-						amount:   5000000000,
-						pkScript: hexToBytes("410496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52da7589379515d4e0a604f8141781e62294721166bf621e73a82cbf2342c858eeac"),
-					},
-				},
+				packedFlags: tfCoinBase,
 			},
-			serialized: hexToBytes("010103320496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52"),
+			serialized: hexToBytes("03320496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52"),
 		},
 		// From tx in main blockchain:
-		// 8131ffb0a2c945ecaf9b9063e59558784f9c3a74741ce6ae2a18d0571dac15bb
-		{
-			name: "Only output 1, not coinbase",
-			entry: &UtxoEntry{
-				version:     1,
-				isCoinBase:  false,
-				blockHeight: 100001,
-				sparseOutputs: map[uint32]*utxoOutput{
-					1: {
-						amount:   1000000,
-						pkScript: hexToBytes("76a914ee8bd501094a7d5ca318da2506de35e1cb025ddc88ac"),
-					},
-				},
-			},
-			serialized: hexToBytes("01858c21040700ee8bd501094a7d5ca318da2506de35e1cb025ddc"),
-		},
-		// Adapted from tx in main blockchain:
-		// df3f3f442d9699857f7f49de4ff0b5d0f3448bec31cdc7b5bf6d25f2abd637d5
-		{
-			name: "Only output 2, coinbase",
-			entry: &UtxoEntry{
-				version:     1,
-				isCoinBase:  true,
-				blockHeight: 99004,
-				sparseOutputs: map[uint32]*utxoOutput{
-					2: {
-						amount:   100937281,
-						pkScript: hexToBytes("76a914da33f77cee27c2a975ed5124d7e4f7f97513510188ac"),
-					},
-				},
-			},
-			serialized: hexToBytes("0185843c010182b095bf4100da33f77cee27c2a975ed5124d7e4f7f975135101"),
-		},
-		// Adapted from tx in main blockchain:
-		// 4a16969aa4764dd7507fc1de7f0baa4850a246de90c45e59a3207f9a26b5036f
-		{
-			name: "outputs 0 and 2 not coinbase",
-			entry: &UtxoEntry{
-				version:     1,
-				isCoinBase:  false,
-				blockHeight: 113931,
-				sparseOutputs: map[uint32]*utxoOutput{
-					0: {
-						amount:   20000000,
-						pkScript: hexToBytes("76a914e2ccd6ec7c6e2e581349c77e067385fa8236bf8a88ac"),
-					},
-					2: {
-						amount:   15000000,
-						pkScript: hexToBytes("76a914b8025be1b3efc63b0ad48e7f9f10e87544528d5888ac"),
-					},
-				},
-			},
-			serialized: hexToBytes("0185f90b0a011200e2ccd6ec7c6e2e581349c77e067385fa8236bf8a800900b8025be1b3efc63b0ad48e7f9f10e87544528d58"),
-		},
-		// Adapted from tx in main blockchain:
-		// 4a16969aa4764dd7507fc1de7f0baa4850a246de90c45e59a3207f9a26b5036f
+		// 0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098:0
 		{
-			name: "outputs 0 and 2, not coinbase, 1 marked spent",
+			name: "height 1, coinbase, spent",
 			entry: &UtxoEntry{
-				version:     1,
-				isCoinBase:  false,
-				blockHeight: 113931,
-				sparseOutputs: map[uint32]*utxoOutput{
-					0: {
-						amount:   20000000,
-						pkScript: hexToBytes("76a914e2ccd6ec7c6e2e581349c77e067385fa8236bf8a88ac"),
-					},
-					1: { // This won't be serialized.
-						spent:    true,
-						amount:   1000000,
-						pkScript: hexToBytes("76a914e43031c3e46f20bf1ccee9553ce815de5a48467588ac"),
-					},
-					2: {
-						amount:   15000000,
-						pkScript: hexToBytes("76a914b8025be1b3efc63b0ad48e7f9f10e87544528d5888ac"),
-					},
-				},
+				amount:      5000000000,
+				pkScript:    hexToBytes("410496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52da7589379515d4e0a604f8141781e62294721166bf621e73a82cbf2342c858eeac"),
+				blockHeight: 1,
+				packedFlags: tfCoinBase | tfSpent,
 			},
-			serialized: hexToBytes("0185f90b0a011200e2ccd6ec7c6e2e581349c77e067385fa8236bf8a800900b8025be1b3efc63b0ad48e7f9f10e87544528d58"),
+			serialized: nil,
 		},
-		// Adapted from tx in main blockchain:
-		// 4a16969aa4764dd7507fc1de7f0baa4850a246de90c45e59a3207f9a26b5036f
+		// From tx in main blockchain:
+		// 8131ffb0a2c945ecaf9b9063e59558784f9c3a74741ce6ae2a18d0571dac15bb:1
 		{
-			name: "outputs 0 and 2, not coinbase, output 2 compressed",
+			name: "height 100001, not coinbase",
 			entry: &UtxoEntry{
-				version:     1,
-				isCoinBase:  false,
-				blockHeight: 113931,
-				sparseOutputs: map[uint32]*utxoOutput{
-					0: {
-						amount:   20000000,
-						pkScript: hexToBytes("76a914e2ccd6ec7c6e2e581349c77e067385fa8236bf8a88ac"),
-					},
-					2: {
-						// Uncompressed Amount: 15000000
-						// Uncompressed PkScript: 76a914b8025be1b3efc63b0ad48e7f9f10e87544528d5888ac
-						compressed: true,
-						amount:     137,
-						pkScript:   hexToBytes("00b8025be1b3efc63b0ad48e7f9f10e87544528d58"),
-					},
-				},
+				amount:      1000000,
+				pkScript:    hexToBytes("76a914ee8bd501094a7d5ca318da2506de35e1cb025ddc88ac"),
+				blockHeight: 100001,
+				packedFlags: 0,
 			},
-			serialized: hexToBytes("0185f90b0a011200e2ccd6ec7c6e2e581349c77e067385fa8236bf8a800900b8025be1b3efc63b0ad48e7f9f10e87544528d58"),
+			serialized: hexToBytes("8b99420700ee8bd501094a7d5ca318da2506de35e1cb025ddc"),
 		},
-		// Adapted from tx in main blockchain:
-		// 4a16969aa4764dd7507fc1de7f0baa4850a246de90c45e59a3207f9a26b5036f
+		// From tx in main blockchain:
+		// 4a16969aa4764dd7507fc1de7f0baa4850a246de90c45e59a3207f9a26b5036f:2
 		{
-			name: "outputs 0 and 2, not coinbase, output 2 compressed, packed indexes reversed",
+			name: "height 113931, not coinbase, modified",
 			entry: &UtxoEntry{
-				version:     1,
-				isCoinBase:  false,
+				amount:      15000000,
+				pkScript:    hexToBytes("76a914b8025be1b3efc63b0ad48e7f9f10e87544528d5888ac"),
 				blockHeight: 113931,
-				sparseOutputs: map[uint32]*utxoOutput{
-					0: {
-						amount:   20000000,
-						pkScript: hexToBytes("76a914e2ccd6ec7c6e2e581349c77e067385fa8236bf8a88ac"),
-					},
-					2: {
-						// Uncompressed Amount: 15000000
-						// Uncompressed PkScript: 76a914b8025be1b3efc63b0ad48e7f9f10e87544528d5888ac
-						compressed: true,
-						amount:     137,
-						pkScript:   hexToBytes("00b8025be1b3efc63b0ad48e7f9f10e87544528d58"),
-					},
-				},
+				packedFlags: tfModified,
 			},
-			serialized: hexToBytes("0185f90b0a011200e2ccd6ec7c6e2e581349c77e067385fa8236bf8a800900b8025be1b3efc63b0ad48e7f9f10e87544528d58"),
+			serialized: hexToBytes("8cf316800900b8025be1b3efc63b0ad48e7f9f10e87544528d58"),
 		},
 		// From tx in main blockchain:
-		// 0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098
-		{
-			name: "Only output 0, coinbase, fully spent",
-			entry: &UtxoEntry{
-				version:     1,
-				isCoinBase:  true,
-				blockHeight: 1,
-				sparseOutputs: map[uint32]*utxoOutput{
-					0: {
-						spent:    true,
-						amount:   5000000000,
-						pkScript: hexToBytes("410496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52da7589379515d4e0a604f8141781e62294721166bf621e73a82cbf2342c858eeac"),
-					},
-				},
-			},
-			serialized: nil,
-		},
-		// Adapted from tx in main blockchain:
-		// 1b02d1c8cfef60a189017b9a420c682cf4a0028175f2f563209e4ff61c8c3620
+		// 1b02d1c8cfef60a189017b9a420c682cf4a0028175f2f563209e4ff61c8c3620:22
 		{
-			name: "Only output 22, not coinbase",
+			name: "height 338156, not coinbase, p2sh",
 			entry: &UtxoEntry{
-				version:     1,
-				isCoinBase:  false,
+				amount:      366875659,
+				pkScript:    hexToBytes("a9141dd46a006572d820e448e12d2bbb38640bc718e687"),
 				blockHeight: 338156,
-				sparseOutputs: map[uint32]*utxoOutput{
-					22: {
-						spent:    false,
-						amount:   366875659,
-						pkScript: hexToBytes("a9141dd46a006572d820e448e12d2bbb38640bc718e687"),
-					},
-				},
+				packedFlags: 0,
 			},
-			serialized: hexToBytes("0193d06c100000108ba5b9e763011dd46a006572d820e448e12d2bbb38640bc718e6"),
+			serialized: hexToBytes("a8a2588ba5b9e763011dd46a006572d820e448e12d2bbb38640bc718e6"),
 		},
 	}
 
@@ -719,9 +537,9 @@ func TestUtxoSerialization(t *testing.T) {
 			continue
 		}
 
-		// Don't try to deserialize if the test entry was fully spent
-		// since it will have a nil serialization.
-		if test.entry.IsFullySpent() {
+		// Don't try to deserialize if the test entry was spent since it
+		// will have a nil serialization.
+		if test.entry.IsSpent() {
 			continue
 		}
 
@@ -733,85 +551,44 @@ func TestUtxoSerialization(t *testing.T) {
 			continue
 		}
 
-		// Ensure that the deserialized utxo entry has the same
-		// properties for the containing transaction and block height.
-		if utxoEntry.Version() != test.entry.Version() {
+		// The deserialized entry must not be marked spent or modified.
+		if utxoEntry.IsSpent() {
+			t.Errorf("deserializeUtxoEntry #%d (%s) output should "+
+				"not be marked spent", i, test.name)
+			continue
+		}
+		if utxoEntry.isModified() {
+			t.Errorf("deserializeUtxoEntry #%d (%s) output should "+
+				"not be marked modified", i, test.name)
+			continue
+		}
+
+		// Ensure the deserialized entry has the same properties as the
+		// ones in the test entry.
+		if utxoEntry.Amount() != test.entry.Amount() {
 			t.Errorf("deserializeUtxoEntry #%d (%s) mismatched "+
-				"version: got %d, want %d", i, test.name,
-				utxoEntry.Version(), test.entry.Version())
+				"amounts: got %d, want %d", i, test.name,
+				utxoEntry.Amount(), test.entry.Amount())
 			continue
 		}
-		if utxoEntry.IsCoinBase() != test.entry.IsCoinBase() {
+		if !bytes.Equal(utxoEntry.PkScript(), test.entry.PkScript()) {
 			t.Errorf("deserializeUtxoEntry #%d (%s) mismatched "+
-				"coinbase flag: got %v, want %v", i, test.name,
-				utxoEntry.IsCoinBase(), test.entry.IsCoinBase())
+				"scripts: got %x, want %x", i, test.name,
+				utxoEntry.PkScript(), test.entry.PkScript())
 			continue
 		}
 		if utxoEntry.BlockHeight() != test.entry.BlockHeight() {
 			t.Errorf("deserializeUtxoEntry #%d (%s) mismatched "+
 				"block height: got %d, want %d", i, test.name,
-				utxoEntry.BlockHeight(),
-				test.entry.BlockHeight())
+				utxoEntry.BlockHeight(), test.entry.BlockHeight())
 			continue
 		}
-		if utxoEntry.IsFullySpent() != test.entry.IsFullySpent() {
+		if utxoEntry.IsCoinBase() != test.entry.IsCoinBase() {
 			t.Errorf("deserializeUtxoEntry #%d (%s) mismatched "+
-				"fully spent: got %v, want %v", i, test.name,
-				utxoEntry.IsFullySpent(),
-				test.entry.IsFullySpent())
-			continue
-		}
-
-		// Ensure all of the outputs in the test entry match the
-		// spentness of the output in the deserialized entry and the
-		// deserialized entry does not contain any additional utxos.
-		var numUnspent int
-		for outputIndex := range test.entry.sparseOutputs {
-			gotSpent := utxoEntry.IsOutputSpent(outputIndex)
-			wantSpent := test.entry.IsOutputSpent(outputIndex)
-			if !wantSpent {
-				numUnspent++
-			}
-			if gotSpent != wantSpent {
-				t.Errorf("deserializeUtxoEntry #%d (%s) output "+
-					"#%d: mismatched spent: got %v, want "+
-					"%v", i, test.name, outputIndex,
-					gotSpent, wantSpent)
-				continue
-
-			}
-		}
-		if len(utxoEntry.sparseOutputs) != numUnspent {
-			t.Errorf("deserializeUtxoEntry #%d (%s): mismatched "+
-				"number of unspent outputs: got %d, want %d", i,
-				test.name, len(utxoEntry.sparseOutputs),
-				numUnspent)
+				"coinbase flag: got %v, want %v", i, test.name,
+				utxoEntry.IsCoinBase(), test.entry.IsCoinBase())
 			continue
 		}
-
-		// Ensure all of the amounts and scripts of the utxos in the
-		// deserialized entry match the ones in the test entry.
-		for outputIndex := range utxoEntry.sparseOutputs {
-			gotAmount := utxoEntry.AmountByIndex(outputIndex)
-			wantAmount := test.entry.AmountByIndex(outputIndex)
-			if gotAmount != wantAmount {
-				t.Errorf("deserializeUtxoEntry #%d (%s) "+
-					"output #%d: mismatched amounts: got "+
-					"%d, want %d", i, test.name,
-					outputIndex, gotAmount, wantAmount)
-				continue
-			}
-
-			gotPkScript := utxoEntry.PkScriptByIndex(outputIndex)
-			wantPkScript := test.entry.PkScriptByIndex(outputIndex)
-			if !bytes.Equal(gotPkScript, wantPkScript) {
-				t.Errorf("deserializeUtxoEntry #%d (%s) "+
-					"output #%d mismatched scripts: got "+
-					"%x, want %x", i, test.name,
-					outputIndex, gotPkScript, wantPkScript)
-				continue
-			}
-		}
 	}
 }
 
@@ -821,23 +598,21 @@ func TestUtxoEntryHeaderCodeErrors(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
-		name      string
-		entry     *UtxoEntry
-		code      uint64
-		bytesRead int // Expected number of bytes read.
-		errType   error
+		name    string
+		entry   *UtxoEntry
+		code    uint64
+		errType error
 	}{
 		{
-			name:      "Force assertion due to fully spent tx",
-			entry:     &UtxoEntry{},
-			errType:   AssertError(""),
-			bytesRead: 0,
+			name:    "Force assertion due to spent output",
+			entry:   &UtxoEntry{packedFlags: tfSpent},
+			errType: AssertError(""),
 		},
 	}
 
 	for _, test := range tests {
 		// Ensure the expected error type is returned and the code is 0.
-		code, gotBytesRead, err := utxoEntryHeaderCode(test.entry, 0)
+		code, err := utxoEntryHeaderCode(test.entry)
 		if reflect.TypeOf(err) != reflect.TypeOf(test.errType) {
 			t.Errorf("utxoEntryHeaderCode (%s): expected error "+
 				"type does not match - got %T, want %T",
@@ -849,14 +624,6 @@ func TestUtxoEntryHeaderCodeErrors(t *testing.T) {
 				"on error - got %d, want 0", test.name, code)
 			continue
 		}
-
-		// Ensure the expected number of bytes read is returned.
-		if gotBytesRead != test.bytesRead {
-			t.Errorf("utxoEntryHeaderCode (%s): unexpected number "+
-				"of bytes read - got %d, want %d", test.name,
-				gotBytesRead, test.bytesRead)
-			continue
-		}
 	}
 }
 
@@ -870,29 +637,14 @@ func TestUtxoEntryDeserializeErrors(t *testing.T) {
 		serialized []byte
 		errType    error
 	}{
-		{
-			name:       "no data after version",
-			serialized: hexToBytes("01"),
-			errType:    errDeserialize(""),
-		},
-		{
-			name:       "no data after block height",
-			serialized: hexToBytes("0101"),
-			errType:    errDeserialize(""),
-		},
 		{
 			name:       "no data after header code",
-			serialized: hexToBytes("010102"),
-			errType:    errDeserialize(""),
-		},
-		{
-			name:       "not enough bytes for unspentness bitmap",
-			serialized: hexToBytes("01017800"),
+			serialized: hexToBytes("02"),
 			errType:    errDeserialize(""),
 		},
 		{
 			name:       "incomplete compressed txout",
-			serialized: hexToBytes("01010232"),
+			serialized: hexToBytes("0232"),
 			errType:    errDeserialize(""),
 		},
 	}
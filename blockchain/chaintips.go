@@ -0,0 +1,134 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// ChainTipStatus describes the state of the branch of the block tree that ends
+// at a chain tip.
+type ChainTipStatus byte
+
+// These constants are used to identify the state of a chain tip.
+const (
+	// ChainTipActive is the status of the tip of the best chain.
+	ChainTipActive ChainTipStatus = iota
+
+	// ChainTipValidFork is the status of side chain tips that were fully
+	// validated, but are not part of the best chain.
+	ChainTipValidFork
+
+	// ChainTipValidHeaders is the status of side chain tips whose blocks
+	// are all stored, but were never fully validated.
+	ChainTipValidHeaders
+
+	// ChainTipHeadersOnly is the status of side chain tips whose branch
+	// has blocks that only their headers are known of.
+	ChainTipHeadersOnly
+
+	// ChainTipInvalid is the status of side chain tips whose branch has a
+	// block that is known to be invalid.
+	ChainTipInvalid
+)
+
+// chainTipStatusStrings is a map of ChainTipStatus values back to their
+// constant names for pretty printing.
+var chainTipStatusStrings = map[ChainTipStatus]string{
+	ChainTipActive:       "ChainTipActive",
+	ChainTipValidFork:    "ChainTipValidFork",
+	ChainTipValidHeaders: "ChainTipValidHeaders",
+	ChainTipHeadersOnly:  "ChainTipHeadersOnly",
+	ChainTipInvalid:      "ChainTipInvalid",
+}
+
+// String returns the ChainTipStatus as a human-readable name.
+func (s ChainTipStatus) String() string {
+	if str := chainTipStatusStrings[s]; str != "" {
+		return str
+	}
+	return fmt.Sprintf("Unknown ChainTipStatus (%d)", int(s))
+}
+
+// ChainTip describes the tip of a branch of the block tree.
+type ChainTip struct {
+	// Height is the height of the tip.
+	Height int32
+
+	// Hash is the hash of the tip.
+	Hash chainhash.Hash
+
+	// BranchLen is the number of blocks from the tip back to the point
+	// where its branch forks from the best chain.  It is zero for the tip
+	// of the best chain.
+	BranchLen int32
+
+	// Status is the state of the branch.
+	Status ChainTipStatus
+}
+
+// branchStatus returns the status of the branch from the passed node back to
+// the passed fork point in the best chain.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) branchStatus(node, fork *blockNode) ChainTipStatus {
+	status := ChainTipValidFork
+	for n := node; n != nil && n != fork; n = n.parent {
+		nodeStatus := b.index.NodeStatus(n)
+		switch {
+		case nodeStatus.KnownInvalid():
+			return ChainTipInvalid
+		case !nodeStatus.HaveData():
+			status = ChainTipHeadersOnly
+		case !nodeStatus.KnownValid() && status == ChainTipValidFork:
+			status = ChainTipValidHeaders
+		}
+	}
+	return status
+}
+
+// ChainTips returns the tips of all branches of the block tree that are known,
+// which are the tip of the best chain and the tips of all side chains, ordered
+// by descending height, then status and hash.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ChainTips() []ChainTip {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	// The tip of the best chain has children when the blocks after it
+	// were invalidated, so it is not necessarily a leaf of the tree.
+	bestTip := b.bestChain.Tip()
+	tips := []ChainTip{{
+		Height: bestTip.height,
+		Hash:   bestTip.hash,
+		Status: ChainTipActive,
+	}}
+	for _, node := range b.index.Tips() {
+		if node == bestTip {
+			continue
+		}
+		fork := b.bestChain.FindFork(node)
+		tips = append(tips, ChainTip{
+			Height:    node.height,
+			Hash:      node.hash,
+			BranchLen: node.height - fork.height,
+			Status:    b.branchStatus(node, fork),
+		})
+	}
+	sort.Slice(tips, func(i, j int) bool {
+		if tips[i].Height != tips[j].Height {
+			return tips[i].Height > tips[j].Height
+		}
+		if tips[i].Status != tips[j].Status {
+			return tips[i].Status < tips[j].Status
+		}
+		return tips[i].Hash.String() < tips[j].Hash.String()
+	})
+	return tips
+}
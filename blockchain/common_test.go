@@ -193,7 +193,7 @@ func loadUtxoView(filename string) (*UtxoViewpoint, error) {
 	// <tx hash><serialized utxo len><serialized utxo>
 	//
 	// The serialized utxo len is a little endian uint32 and the serialized
-	// utxo uses the format described in chainio.go.
+	// utxo uses the legacy version 1 format described in upgrade.go.
 
 	filename = filepath.Join("testdata", filename)
 	fi, err := os.Open(filename)
@@ -237,12 +237,16 @@ func loadUtxoView(filename string) (*UtxoViewpoint, error) {
 			return nil, err
 		}
 
-		// Deserialize it and add it to the view.
-		utxoEntry, err := deserializeUtxoEntry(serialized)
+		// Deserialize it and add the utxos for each of its outputs to
+		// the view.
+		entries, err := deserializeUtxoEntryV0(serialized)
 		if err != nil {
 			return nil, err
 		}
-		view.Entries()[hash] = utxoEntry
+		for txOutIdx, entry := range entries {
+			outpoint := wire.OutPoint{Hash: hash, Index: txOutIdx}
+			view.Entries()[outpoint] = entry
+		}
 	}
 
 	return view, nil
@@ -0,0 +1,343 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package indexers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/data"
+	"github.com/btcsuite/btcd/database"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// balanceIndexName is the human-readable name for the index.
+	balanceIndexName = "address balance index"
+
+	// balanceDeltaSize is the number of bytes a single balance change
+	// consumes in a block entry.
+	balanceDeltaSize = chainhash.HashSize + 16
+
+	// balancePolicySeparateP2PK is the flag of the serialized key policy
+	// that is set when pay-to-pubkey outputs are recorded separately.
+	balancePolicySeparateP2PK = 0x01
+)
+
+var (
+	// balanceIndexKey is the key of the address balance index and the db
+	// bucket used to house it.
+	balanceIndexKey = []byte("balancedeltaidx")
+
+	// balanceIndexPolicyKey is the key of the key policy the index was
+	// built with.  It can't collide with the block hashes the other
+	// entries are keyed by since it has a different length.
+	balanceIndexPolicyKey = []byte("keypolicy")
+)
+
+// -----------------------------------------------------------------------------
+// The address balance index maps every block that has been connected to the
+// main chain to the change in balance it caused for every balance key it
+// touched, as defined by the key policy the balance repositories use.  It
+// requires the transaction index since the outputs spent by old blocks are
+// needed in order to catch up and they will already be pruned from the utxo
+// set.
+//
+// The entries are written in the same database transaction that connects the
+// block, so they always match the chain, and they are kept when the block is
+// disconnected.  That allows the balance explorer to apply the changes of every
+// block to the external balance repositories, and to unwind them again, without
+// having to load the block or the outputs it spent, even once the block is no
+// longer part of the main chain.
+//
+// The serialized key format is:
+//
+//   <block hash>
+//
+//   Field           Type             Size
+//   block hash      chainhash.Hash   32 bytes
+//   -----
+//   Total: 32 bytes
+//
+// The serialized value format is:
+//
+//   <num keys>[<key len><key><num deltas>[<tx hash><received><sent>,...],...]
+//
+//   Field           Type             Size
+//   num keys        uint32           4 bytes
+//   key len         uint16           2 bytes
+//   key             string           key len bytes
+//   num deltas      uint32           4 bytes
+//   tx hash         chainhash.Hash   32 bytes
+//   received        uint64           8 bytes
+//   sent            uint64           8 bytes
+//
+// The keys are serialized in sorted order and the changes of every key in the
+// order of the transactions of the block.
+//
+// The key policy the index was built with is stored under balanceIndexPolicyKey
+// as a single byte of flags, since the index has to be rebuilt when it changes.
+// -----------------------------------------------------------------------------
+
+// serializeBlockDeltas serializes the passed balance changes of a block into a
+// block entry according to the format described above.
+func serializeBlockDeltas(deltas map[string][]data.BalanceDelta) []byte {
+	keys := make([]string, 0, len(deltas))
+	size := 4
+	for key, keyDeltas := range deltas {
+		keys = append(keys, key)
+		size += 2 + len(key) + 4 + len(keyDeltas)*balanceDeltaSize
+	}
+	sort.Strings(keys)
+
+	serialized := make([]byte, size)
+	byteOrder.PutUint32(serialized, uint32(len(keys)))
+	offset := 4
+	for _, key := range keys {
+		keyDeltas := deltas[key]
+		byteOrder.PutUint16(serialized[offset:], uint16(len(key)))
+		offset += 2
+		offset += copy(serialized[offset:], key)
+		byteOrder.PutUint32(serialized[offset:], uint32(len(keyDeltas)))
+		offset += 4
+		for i := range keyDeltas {
+			delta := &keyDeltas[i]
+			offset += copy(serialized[offset:], delta.TxHash[:])
+			byteOrder.PutUint64(serialized[offset:],
+				uint64(delta.Received))
+			offset += 8
+			byteOrder.PutUint64(serialized[offset:],
+				uint64(delta.Sent))
+			offset += 8
+		}
+	}
+
+	return serialized
+}
+
+// deserializeBlockDeltas decodes the passed serialized block entry according to
+// the format described above.
+func deserializeBlockDeltas(serialized []byte) (map[string][]data.BalanceDelta, error) {
+	if len(serialized) < 4 {
+		return nil, errDeserialize("unexpected end of data")
+	}
+	numKeys := byteOrder.Uint32(serialized)
+	offset := 4
+
+	deltas := make(map[string][]data.BalanceDelta, numKeys)
+	for i := uint32(0); i < numKeys; i++ {
+		if len(serialized[offset:]) < 2 {
+			return nil, errDeserialize("unexpected end of data")
+		}
+		keyLen := int(byteOrder.Uint16(serialized[offset:]))
+		offset += 2
+		if len(serialized[offset:]) < keyLen+4 {
+			return nil, errDeserialize("unexpected end of data")
+		}
+		key := string(serialized[offset : offset+keyLen])
+		offset += keyLen
+		numDeltas := byteOrder.Uint32(serialized[offset:])
+		offset += 4
+		if uint64(len(serialized[offset:])) <
+			uint64(numDeltas)*balanceDeltaSize {
+
+			return nil, errDeserialize("unexpected end of data")
+		}
+
+		keyDeltas := make([]data.BalanceDelta, numDeltas)
+		for j := range keyDeltas {
+			delta := &keyDeltas[j]
+			copy(delta.TxHash[:], serialized[offset:])
+			offset += chainhash.HashSize
+			delta.Received = int64(byteOrder.Uint64(serialized[offset:]))
+			offset += 8
+			delta.Sent = int64(byteOrder.Uint64(serialized[offset:]))
+			offset += 8
+			delta.Value = delta.Received - delta.Sent
+		}
+		deltas[key] = keyDeltas
+	}
+
+	return deltas, nil
+}
+
+// serializeKeyPolicy returns the flags the passed key policy is stored as.
+func serializeKeyPolicy(keys *data.KeyPolicy) []byte {
+	var flags byte
+	if keys.SeparateP2PK {
+		flags |= balancePolicySeparateP2PK
+	}
+	return []byte{flags}
+}
+
+// BalanceIndex implements an address balance index.  That is to say, it
+// records the change in balance every block of the main chain caused for each
+// balance key so the balance explorer can feed them to the balance
+// repositories.
+type BalanceIndex struct {
+	db   database.DB
+	keys *data.KeyPolicy
+}
+
+// Ensure the BalanceIndex type implements the Indexer interface.
+var _ Indexer = (*BalanceIndex)(nil)
+
+// Ensure the BalanceIndex type implements the NeedsInputser interface.
+var _ NeedsInputser = (*BalanceIndex)(nil)
+
+// NeedsInputs signals that the index requires the referenced inputs in order
+// to properly create the index.
+//
+// This implements the NeedsInputser interface.
+func (idx *BalanceIndex) NeedsInputs() bool {
+	return true
+}
+
+// Init ensures the index was built with the same key policy it is used with
+// since the balance keys of the existing entries would not match otherwise.
+//
+// This is part of the Indexer interface.
+func (idx *BalanceIndex) Init() error {
+	return idx.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(balanceIndexKey)
+		policy := bucket.Get(balanceIndexPolicyKey)
+		if len(policy) != 1 || policy[0] != serializeKeyPolicy(idx.keys)[0] {
+			return fmt.Errorf("the %s was built with a different "+
+				"balance key policy, so it must be dropped "+
+				"with --dropbalanceindex and rebuilt",
+				balanceIndexName)
+		}
+		return nil
+	})
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *BalanceIndex) Key() []byte {
+	return balanceIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *BalanceIndex) Name() string {
+	return balanceIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the address
+// balance index and records the key policy it is built with.
+//
+// This is part of the Indexer interface.
+func (idx *BalanceIndex) Create(dbTx database.Tx) error {
+	bucket, err := dbTx.Metadata().CreateBucket(balanceIndexKey)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(balanceIndexPolicyKey, serializeKeyPolicy(idx.keys))
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer records the balance changes caused
+// by the block.
+//
+// This is part of the Indexer interface.
+func (idx *BalanceIndex) ConnectBlock(dbTx database.Tx, block *btcutil.Block, view *blockchain.UtxoViewpoint) error {
+	// Unlike the address index, a missing input can't be ignored since it
+	// would silently make the balances wrong.
+	var spent []blockchain.SpentTxOut
+	for _, tx := range block.Transactions()[1:] {
+		for _, txIn := range tx.MsgTx().TxIn {
+			origin := txIn.PreviousOutPoint
+			entry := view.LookupEntry(origin)
+			if entry == nil {
+				return AssertError(fmt.Sprintf("view missing "+
+					"input %v", origin))
+			}
+			spent = append(spent, blockchain.SpentTxOut{
+				Amount:   entry.Amount(),
+				PkScript: entry.PkScript(),
+			})
+		}
+	}
+
+	deltas, err := idx.keys.BlockDeltas(block, spent)
+	if err != nil {
+		return err
+	}
+
+	bucket := dbTx.Metadata().Bucket(balanceIndexKey)
+	return bucket.Put(block.Hash()[:], serializeBlockDeltas(deltas))
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  The entry of the block is kept so the
+// balance explorer is able to unwind the block from the balance repositories
+// even when it only learns about the disconnect after the node restarts.
+//
+// This is part of the Indexer interface.
+func (idx *BalanceIndex) DisconnectBlock(dbTx database.Tx, block *btcutil.Block, view *blockchain.UtxoViewpoint) error {
+	// Nothing to do.
+	return nil
+}
+
+// ErrBlockNotIndexed is returned by BlockDeltas for blocks the index has never
+// seen connected to the main chain.
+var ErrBlockNotIndexed = errors.New("the block is not in the address " +
+	"balance index")
+
+// BlockDeltas returns the balance changes the passed block caused when it was
+// connected to the main chain keyed by balance key and in the order of the
+// transactions of the block.  They are available for every block that has been
+// connected since the index was created, including the ones that are no longer
+// part of the main chain.  ErrBlockNotIndexed is returned for all others.
+//
+// This function is safe for concurrent access.
+func (idx *BalanceIndex) BlockDeltas(hash *chainhash.Hash) (map[string][]data.BalanceDelta, error) {
+	var deltas map[string][]data.BalanceDelta
+	err := idx.db.View(func(dbTx database.Tx) error {
+		serialized := dbTx.Metadata().Bucket(balanceIndexKey).Get(hash[:])
+		if serialized == nil {
+			return ErrBlockNotIndexed
+		}
+
+		var err error
+		deltas, err = deserializeBlockDeltas(serialized)
+		if err != nil {
+			return database.Error{
+				ErrorCode: database.ErrCorruption,
+				Description: fmt.Sprintf("failed to deserialize "+
+					"balance changes for block %v: %v", hash,
+					err),
+			}
+		}
+		return nil
+	})
+	return deltas, err
+}
+
+// NewBalanceIndex returns a new instance of an indexer that is used to record
+// the balance changes of every block in the main chain under the keys defined
+// by the passed key policy.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewBalanceIndex(db database.DB, keys *data.KeyPolicy) *BalanceIndex {
+	return &BalanceIndex{
+		db:   db,
+		keys: keys,
+	}
+}
+
+// DropBalanceIndex drops the address balance index from the provided database
+// if it exists.
+func DropBalanceIndex(db database.DB, interrupt <-chan struct{}) error {
+	return dropIndex(db, balanceIndexKey, balanceIndexName, interrupt)
+}
@@ -0,0 +1,256 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package indexers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/data"
+	"github.com/btcsuite/btcd/database"
+	_ "github.com/btcsuite/btcd/database/ffldb"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// payToTestAddr returns a pay-to-pubkey-hash script for a test address derived
+// from the passed byte along with the balance key of the address.
+func payToTestAddr(t *testing.T, b byte) ([]byte, string) {
+	var hash [20]byte
+	hash[0] = b
+	addr, err := btcutil.NewAddressPubKeyHash(hash[:],
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+	return pkScript, addr.EncodeAddress()
+}
+
+// createBalanceIndexDb returns a new database with the bucket of the address
+// balance index created for the passed index along with a function to remove
+// it again.
+func createBalanceIndexDb(t *testing.T, keys *data.KeyPolicy) (*BalanceIndex, func()) {
+	dbPath, err := ioutil.TempDir("", "balanceindex")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		wire.MainNet)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("unable to create database: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+
+	idx := NewBalanceIndex(db, keys)
+	if err := db.Update(idx.Create); err != nil {
+		teardown()
+		t.Fatalf("Create: unexpected error: %v", err)
+	}
+	return idx, teardown
+}
+
+// TestBalanceIndexConnectDisconnect ensures the balance changes calculated for
+// a block are recorded when it is connected, kept when it is disconnected, and
+// that the index refuses to be used with a different key policy.
+func TestBalanceIndexConnectDisconnect(t *testing.T) {
+	t.Parallel()
+
+	scriptA, keyA := payToTestAddr(t, 1)
+	scriptB, keyB := payToTestAddr(t, 2)
+	scriptC, keyC := payToTestAddr(t, 3)
+
+	// Create a transaction from an earlier block that pays to A and B.
+	originTx := wire.NewMsgTx(1)
+	originTx.AddTxIn(&wire.TxIn{})
+	originTx.AddTxOut(wire.NewTxOut(5000, scriptA))
+	originTx.AddTxOut(wire.NewTxOut(3000, scriptB))
+	originHash := originTx.TxHash()
+
+	// Create a block with a coinbase paying to C, a transaction spending
+	// the output to A and paying C and A, and a transaction spending the
+	// change in the same block back to B.
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+	})
+	coinbase.AddTxOut(wire.NewTxOut(1000, scriptC))
+	spendTx := wire.NewMsgTx(1)
+	spendTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&originHash, 0), nil,
+		nil))
+	spendTx.AddTxOut(wire.NewTxOut(4000, scriptC))
+	spendTx.AddTxOut(wire.NewTxOut(900, scriptA))
+	spendHash := spendTx.TxHash()
+	changeTx := wire.NewMsgTx(1)
+	changeTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&spendHash, 1), nil,
+		nil))
+	changeTx.AddTxOut(wire.NewTxOut(800, scriptB))
+	block := btcutil.NewBlock(&wire.MsgBlock{
+		Transactions: []*wire.MsgTx{coinbase, spendTx, changeTx},
+	})
+
+	view := blockchain.NewUtxoViewpoint()
+	view.AddTxOuts(btcutil.NewTx(originTx), 1)
+	view.AddTxOuts(btcutil.NewTx(spendTx), 2)
+
+	keys := &data.KeyPolicy{ChainParams: &chaincfg.MainNetParams}
+	idx, teardown := createBalanceIndexDb(t, keys)
+	defer teardown()
+	if err := idx.Init(); err != nil {
+		t.Fatalf("Init: unexpected error: %v", err)
+	}
+
+	// The changes of a block that was never connected are unavailable.
+	if _, err := idx.BlockDeltas(block.Hash()); err != ErrBlockNotIndexed {
+		t.Fatalf("BlockDeltas: unexpected error -- got %v, want %v",
+			err, ErrBlockNotIndexed)
+	}
+
+	err := idx.db.Update(func(dbTx database.Tx) error {
+		return idx.ConnectBlock(dbTx, block, view)
+	})
+	if err != nil {
+		t.Fatalf("ConnectBlock: unexpected error: %v", err)
+	}
+	want := map[string][]data.BalanceDelta{
+		keyA: {
+			{TxHash: spendHash, Value: -4100, Received: 900,
+				Sent: 5000},
+			{TxHash: changeTx.TxHash(), Value: -900, Sent: 900},
+		},
+		keyB: {{TxHash: changeTx.TxHash(), Value: 800, Received: 800}},
+		keyC: {
+			{TxHash: coinbase.TxHash(), Value: 1000, Received: 1000},
+			{TxHash: spendHash, Value: 4000, Received: 4000},
+		},
+	}
+	checkDeltas := func(name string) {
+		t.Helper()
+
+		got, err := idx.BlockDeltas(block.Hash())
+		if err != nil {
+			t.Fatalf("%s: BlockDeltas: unexpected error: %v", name,
+				err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: BlockDeltas: mismatched deltas -- got %v, "+
+				"want %v", name, got, want)
+		}
+	}
+	checkDeltas("connect")
+
+	// The changes are kept when the block is disconnected.
+	err = idx.db.Update(func(dbTx database.Tx) error {
+		return idx.DisconnectBlock(dbTx, block, view)
+	})
+	if err != nil {
+		t.Fatalf("DisconnectBlock: unexpected error: %v", err)
+	}
+	checkDeltas("disconnect")
+
+	// The index can't be used with a different key policy.
+	other := NewBalanceIndex(idx.db, &data.KeyPolicy{
+		ChainParams:  &chaincfg.MainNetParams,
+		SeparateP2PK: true,
+	})
+	if err := other.Init(); err == nil {
+		t.Fatalf("Init: expected error for different key policy")
+	}
+}
+
+// TestBalanceIndexMissingInput ensures connecting a block fails when the view
+// does not contain a spent output.
+func TestBalanceIndexMissingInput(t *testing.T) {
+	t.Parallel()
+
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{})
+	spendTx := wire.NewMsgTx(1)
+	spendTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+	block := btcutil.NewBlock(&wire.MsgBlock{
+		Transactions: []*wire.MsgTx{coinbase, spendTx},
+	})
+
+	idx, teardown := createBalanceIndexDb(t,
+		&data.KeyPolicy{ChainParams: &chaincfg.MainNetParams})
+	defer teardown()
+	err := idx.db.Update(func(dbTx database.Tx) error {
+		return idx.ConnectBlock(dbTx, block,
+			blockchain.NewUtxoViewpoint())
+	})
+	if _, ok := err.(AssertError); !ok {
+		t.Fatalf("ConnectBlock: did not receive expected error -- "+
+			"got %v", err)
+	}
+}
+
+// TestBlockDeltasSerialization ensures serializing and deserializing the
+// balance changes of a block round trips and that truncated entries are
+// rejected.
+func TestBlockDeltasSerialization(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		deltas map[string][]data.BalanceDelta
+	}{
+		{
+			name:   "no changes",
+			deltas: map[string][]data.BalanceDelta{},
+		},
+		{
+			name: "multiple keys",
+			deltas: map[string][]data.BalanceDelta{
+				"a": {
+					{TxHash: chainhash.Hash{1}, Value: 5,
+						Received: 5},
+					{TxHash: chainhash.Hash{2}, Value: -5,
+						Sent: 5},
+				},
+				data.NonstandardKey: {
+					{TxHash: chainhash.Hash{2}, Value: 0,
+						Received: 7, Sent: 7},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		serialized := serializeBlockDeltas(test.deltas)
+		got, err := deserializeBlockDeltas(serialized)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.deltas) {
+			t.Errorf("%s: mismatched deltas -- got %v, want %v",
+				test.name, got, test.deltas)
+			continue
+		}
+
+		for i := 0; i < len(serialized); i++ {
+			_, err := deserializeBlockDeltas(serialized[:i])
+			if !isDeserializeErr(err) {
+				t.Errorf("%s: truncated to %d bytes: unexpected "+
+					"error %v", test.name, i, err)
+				break
+			}
+		}
+	}
+}
@@ -512,7 +512,7 @@ func makeUtxoView(dbTx database.Tx, block *btcutil.Block, interrupt <-chan struc
 				return nil, err
 			}
 
-			view.AddTxOuts(btcutil.NewTx(originTx), 0)
+			view.AddTxOut(btcutil.NewTx(originTx), originOut.Index, 0)
 		}
 
 		if interruptRequested(interrupt) {
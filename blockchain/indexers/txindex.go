@@ -467,13 +467,18 @@ func dropBlockIDIndex(db database.DB) error {
 }
 
 // DropTxIndex drops the transaction index from the provided database if it
-// exists.  Since the address index relies on it, the address index will also be
-// dropped when it exists.
+// exists.  Since the address and address balance indexes rely on it, they will
+// also be dropped when they exist.
 func DropTxIndex(db database.DB, interrupt <-chan struct{}) error {
 	err := dropIndex(db, addrIndexKey, addrIndexName, interrupt)
 	if err != nil {
 		return err
 	}
 
+	err = dropIndex(db, balanceIndexKey, balanceIndexName, interrupt)
+	if err != nil {
+		return err
+	}
+
 	return dropIndex(db, txIndexKey, txIndexName, interrupt)
 }
@@ -0,0 +1,329 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+)
+
+// isDescendant returns whether the passed node is a descendant of the passed
+// ancestor node.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) isDescendant(node, ancestor *blockNode) bool {
+	// Walk back until reaching either the height of the ancestor or the
+	// best chain, whose nodes are all descendants of the ancestor when it
+	// is in the best chain as well.  This avoids walking all the way back
+	// from nodes on short side chains.
+	for node != nil && node.height > ancestor.height {
+		if b.bestChain.Contains(node) {
+			return b.bestChain.Contains(ancestor)
+		}
+		node = node.parent
+	}
+	return node == ancestor
+}
+
+// descendants returns all nodes in the block index that descend from the
+// passed node.  Only the branches leading to the chain tips that descend from
+// the node are walked rather than the entire block index.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) descendants(node *blockNode) []*blockNode {
+	var descendants []*blockNode
+	seen := make(map[*blockNode]struct{})
+	for _, tip := range b.index.Tips() {
+		if !b.isDescendant(tip, node) {
+			continue
+		}
+
+		// Stop at the first node that was already reached from another
+		// tip since the rest of the branch was walked along with it.
+		for n := tip; n != node; n = n.parent {
+			if _, ok := seen[n]; ok {
+				break
+			}
+			seen[n] = struct{}{}
+			descendants = append(descendants, n)
+		}
+	}
+	return descendants
+}
+
+// isKnownInvalidChain returns whether the passed node or any of its ancestors
+// is known to be invalid.  Only the ancestors back to the best chain need to
+// be checked since the descendants of invalid nodes in the best chain are
+// always marked as having an invalid ancestor.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) isKnownInvalidChain(node *blockNode) bool {
+	for n := node; n != nil; n = n.parent {
+		if b.index.NodeStatus(n).KnownInvalid() {
+			return true
+		}
+		if b.bestChain.Contains(n) {
+			break
+		}
+	}
+	return false
+}
+
+// haveBranchData returns whether the passed node and all of its ancestors back
+// to the best chain have their data stored, which is required to connect them.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) haveBranchData(node *blockNode) bool {
+	for n := node; n != nil && !b.bestChain.Contains(n); n = n.parent {
+		if !b.index.NodeStatus(n).HaveData() {
+			return false
+		}
+	}
+	return true
+}
+
+// bestChainCandidate returns the node with the most cumulative work that the
+// best chain can be reorganized to, which means neither it nor any of its
+// ancestors is known to be invalid and all of its ancestors back to the best
+// chain have their data stored.  The current best chain tip is preferred over
+// other nodes with the same amount of work.
+//
+// Since the cumulative work only grows along a branch, the best candidate of
+// each branch is its highest eligible node, so only the branches leading to the
+// chain tips with more work than the current best candidate are walked.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) bestChainCandidate() *blockNode {
+	var best *blockNode
+	if tip := b.bestChain.Tip(); !b.index.NodeStatus(tip).KnownInvalid() {
+		best = tip
+	}
+	for _, tip := range b.index.Tips() {
+		if best != nil && tip.workSum.Cmp(best.workSum) <= 0 {
+			continue
+		}
+
+		// Walk back from the tip until reaching a best chain node that
+		// isn't known to be invalid.  Every node that is invalid or
+		// that has to be connected without its data being stored rules
+		// out the nodes after it, so the candidate is the highest node
+		// below the lowest such node.
+		var candidate *blockNode
+		for n := tip; n != nil; n = n.parent {
+			status := b.index.NodeStatus(n)
+			inBestChain := b.bestChain.Contains(n)
+			if status.KnownInvalid() ||
+				(!inBestChain && !status.HaveData()) {
+
+				candidate = nil
+			} else if candidate == nil {
+				candidate = n
+			}
+			if inBestChain && !status.KnownInvalid() {
+				break
+			}
+		}
+		if candidate != nil && (best == nil ||
+			candidate.workSum.Cmp(best.workSum) > 0) {
+
+			best = candidate
+		}
+	}
+	return best
+}
+
+// activateBestChain reorganizes the chain to the valid chain with the most
+// cumulative work.  Candidate chains that turn out to be invalid while
+// reorganizing to them are marked as such and skipped in favor of the next
+// best one.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) activateBestChain() error {
+	for {
+		best := b.bestChainCandidate()
+		if best == b.bestChain.Tip() {
+			return nil
+		}
+
+		detachNodes, attachNodes := b.getReorganizeNodes(best)
+		err := b.reorganizeChain(detachNodes, attachNodes)
+		if flushErr := b.index.flushToDB(); flushErr != nil {
+			return flushErr
+		}
+		if _, ok := err.(RuleError); ok &&
+			b.index.NodeStatus(best).KnownInvalid() {
+
+			log.Infof("Best chain candidate %v is invalid: %v",
+				best.hash, err)
+			continue
+		}
+		return err
+	}
+}
+
+// InvalidateBlock marks the block identified by the passed hash and all of its
+// descendants as invalid.  When the block is in the main chain, the chain is
+// reorganized to the valid chain with the most cumulative work that remains.
+// The block is recorded in the database, so it remains invalid and is rejected
+// when it is received again, including after restarts, until it is passed to
+// ReconsiderBlock.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) InvalidateBlock(hash *chainhash.Hash) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	// Side chain blocks stored before the block header index existed are
+	// not in the index, so they are recorded without being in it.
+	node := b.index.LookupNode(hash)
+	if node == nil {
+		var exists bool
+		err := b.db.View(func(dbTx database.Tx) error {
+			var err error
+			exists, err = dbTx.HasBlock(hash)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("block %s is not known", hash)
+		}
+	} else if node.parent == nil {
+		return fmt.Errorf("the genesis block can't be invalidated")
+	}
+
+	// Record the block before reorganizing so it is reorganized away from
+	// on the next start when the chain is shut down midway.
+	err := b.db.Update(func(dbTx database.Tx) error {
+		return dbPutInvalidatedBlock(dbTx, hash)
+	})
+	if err != nil {
+		return err
+	}
+	b.invalidatedBlocks[*hash] = struct{}{}
+	if node == nil {
+		return nil
+	}
+
+	b.index.SetStatusFlags(node, statusValidateFailed)
+	for _, n := range b.descendants(node) {
+		b.index.SetStatusFlags(n, statusInvalidAncestor)
+	}
+	if err := b.index.flushToDB(); err != nil {
+		return err
+	}
+	if !b.bestChain.Contains(node) {
+		return nil
+	}
+
+	log.Infof("Block %v (height %d) has been invalidated, reorganizing "+
+		"the chain", hash, node.height)
+	return b.activateBestChain()
+}
+
+// ReconsiderBlock undoes the effect of InvalidateBlock on the block identified
+// by the passed hash, its ancestors and its descendants, which also clears
+// their validation failures so they are validated again as needed.  The chain
+// is then reorganized to the valid chain with the most cumulative work.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ReconsiderBlock(hash *chainhash.Hash) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	node := b.index.LookupNode(hash)
+	if _, ok := b.invalidatedBlocks[*hash]; !ok && node == nil {
+		return fmt.Errorf("block %s is not known", hash)
+	}
+
+	// Gather the nodes to clear, which are the block along with its
+	// descendants and its ancestors back to the best chain, whose nodes are
+	// all valid, and the invalidated blocks among them to remove.
+	var nodes []*blockNode
+	if node != nil {
+		for n := node.parent; n != nil && !b.bestChain.Contains(n); n = n.parent {
+			nodes = append(nodes, n)
+		}
+		nodes = append(nodes, node)
+		nodes = append(nodes, b.descendants(node)...)
+	}
+	hashes := []chainhash.Hash{*hash}
+	for _, n := range nodes {
+		if _, ok := b.invalidatedBlocks[n.hash]; ok && n != node {
+			hashes = append(hashes, n.hash)
+		}
+	}
+	err := b.db.Update(func(dbTx database.Tx) error {
+		for i := range hashes {
+			err := dbRemoveInvalidatedBlock(dbTx, &hashes[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		delete(b.invalidatedBlocks, hash)
+	}
+	if node == nil {
+		return nil
+	}
+
+	for _, n := range nodes {
+		b.index.UnsetStatusFlags(n, statusValidateFailed|
+			statusInvalidAncestor)
+	}
+	if err := b.index.flushToDB(); err != nil {
+		return err
+	}
+
+	log.Infof("Block %v (height %d) has been reconsidered", hash,
+		node.height)
+	return b.activateBestChain()
+}
+
+// PreciousBlock treats the block identified by the passed hash as if it was
+// received before any other block with the same cumulative work, which
+// reorganizes the chain to it when it has as much work as the current best
+// chain.  Blocks with less work are left alone, while blocks that are known to
+// be invalid or whose branch is missing block data are refused.  Unlike
+// invalidated blocks, the preference is not persisted, so it is lost on
+// restarts.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) PreciousBlock(hash *chainhash.Hash) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	node := b.index.LookupNode(hash)
+	if node == nil {
+		return fmt.Errorf("block %s is not known", hash)
+	}
+	if b.isKnownInvalidChain(node) {
+		return fmt.Errorf("block %s is known to be invalid", hash)
+	}
+	if b.bestChain.Contains(node) ||
+		node.workSum.Cmp(b.bestChain.Tip().workSum) < 0 {
+
+		return nil
+	}
+	if !b.haveBranchData(node) {
+		return fmt.Errorf("block %s can't be connected since the data "+
+			"of some of its blocks is not available", hash)
+	}
+
+	detachNodes, attachNodes := b.getReorganizeNodes(node)
+	err := b.reorganizeChain(detachNodes, attachNodes)
+	if flushErr := b.index.flushToDB(); err == nil {
+		err = flushErr
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+)
+
+// MinBlocksToKeep is the number of the most recent main chain blocks that are
+// never pruned so reorganizations can still be handled and the node can serve
+// the recent blocks to its peers as advertised by BIP0159.
+const MinBlocksToKeep = 288
+
+// initPruneState loads the height of the first main chain block after the
+// highest one that was pruned.  A database that has been pruned can't be used
+// without pruning since it does not have all of the blocks.
+func (b *BlockChain) initPruneState() error {
+	err := b.db.View(func(dbTx database.Tx) error {
+		b.pruneHeight = dbFetchPruneHeight(dbTx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if b.pruneHeight == 0 {
+		return nil
+	}
+	if b.pruneTarget == 0 {
+		return fmt.Errorf("the blocks before height %d have been "+
+			"pruned, so the database must be rebuilt to disable "+
+			"pruning", b.pruneHeight)
+	}
+	return nil
+}
+
+// maybePruneBlocks deletes the oldest stored blocks when their total size
+// exceeds the prune target.  The most recent MinBlocksToKeep main chain blocks
+// are kept along with the blocks after the one the utxo set was last flushed
+// at, since they are needed to recover the utxo set after an unclean shutdown.
+// The pruned blocks remain in the block index, which keeps serving their
+// headers, and are marked as no longer having their data stored in the same
+// database transaction that deletes them.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) maybePruneBlocks() error {
+	if b.pruneTarget == 0 {
+		return nil
+	}
+
+	// Nothing to do when the blocks are within the prune target.
+	var size uint64
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		size, err = dbTx.BlockFilesSize()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if size <= b.pruneTarget {
+		return nil
+	}
+
+	keep := make([]chainhash.Hash, 0, MinBlocksToKeep+1)
+	for n := b.bestChain.Tip(); n != nil && len(keep) < MinBlocksToKeep; n = n.parent {
+		keep = append(keep, n.hash)
+	}
+
+	var pruned []chainhash.Hash
+	pruneHeight := b.pruneHeight
+	err = b.db.Update(func(dbTx database.Tx) error {
+		flushedHash := dbFetchUtxoStateConsistency(dbTx)
+		if flushedHash != nil {
+			node := b.index.LookupNode(flushedHash)
+			if next := b.bestChain.Next(node); next != nil {
+				keep = append(keep, next.hash)
+			}
+		}
+
+		var err error
+		pruned, err = dbTx.PruneBlocks(b.pruneTarget, keep)
+		if err != nil || len(pruned) == 0 {
+			return err
+		}
+
+		for i := range pruned {
+			node := b.index.LookupNode(&pruned[i])
+			if node == nil {
+				continue
+			}
+			status := b.index.NodeStatus(node) &^ statusDataStored
+			if err := dbStoreBlockNode(dbTx, node, status); err != nil {
+				return err
+			}
+			if b.bestChain.Contains(node) && node.height >= pruneHeight {
+				pruneHeight = node.height + 1
+			}
+		}
+		return dbPutPruneHeight(dbTx, pruneHeight)
+	})
+	if err != nil {
+		return err
+	}
+	if len(pruned) == 0 {
+		return nil
+	}
+
+	for i := range pruned {
+		if node := b.index.LookupNode(&pruned[i]); node != nil {
+			b.index.UnsetStatusFlags(node, statusDataStored)
+		}
+	}
+	b.pruneHeight = pruneHeight
+
+	log.Infof("Pruned %d blocks, blocks before height %d are no longer "+
+		"available", len(pruned), pruneHeight)
+	return nil
+}
+
+// PruneHeight returns the height of the first main chain block after the
+// highest one that was pruned, so the blocks before it might not be available.
+// It returns zero when no blocks have been pruned.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) PruneHeight() int32 {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	return b.pruneHeight
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database/ffldb"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// solvePruneTestBlock returns a block with only a coinbase transaction that
+// extends the passed block at the given height and satisfies the proof of
+// work limit of the passed chain parameters.
+func solvePruneTestBlock(prev *btcutil.Block, height int32, params *chaincfg.Params) (*btcutil.Block, error) {
+	sigScript, err := txscript.NewScriptBuilder().AddInt64(int64(height)).
+		AddData([]byte("prune")).Script()
+	if err != nil {
+		return nil, err
+	}
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: sigScript,
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(&wire.TxOut{
+		Value:    CalcBlockSubsidy(height, params),
+		PkScript: []byte{txscript.OP_TRUE},
+	})
+
+	txns := []*btcutil.Tx{btcutil.NewTx(coinbase)}
+	merkles := BuildMerkleTreeStore(txns, false)
+	msgBlock := wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:    1,
+			PrevBlock:  *prev.Hash(),
+			MerkleRoot: *merkles[len(merkles)-1],
+			Timestamp:  prev.MsgBlock().Header.Timestamp.Add(time.Minute),
+			Bits:       params.PowLimitBits,
+		},
+		Transactions: []*wire.MsgTx{coinbase},
+	}
+
+	target := CompactToBig(params.PowLimitBits)
+	for {
+		hash := msgBlock.Header.BlockHash()
+		if HashToBig(&hash).Cmp(target) <= 0 {
+			break
+		}
+		msgBlock.Header.Nonce++
+	}
+	block := btcutil.NewBlock(&msgBlock)
+	block.SetHeight(height)
+	return block, nil
+}
+
+// TestPruneBlocks ensures the oldest block files are deleted once the stored
+// blocks exceed the prune target, that the headers of the pruned blocks are
+// still served after a restart while their data is reported as missing, and
+// that a pruned database can't be used without pruning.
+func TestPruneBlocks(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chain, teardownFunc, err := chainSetup("pruneblocks", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Use small block files so the blocks are spread over many of them
+	// and prune everything that isn't required to be kept.
+	config := Config{
+		DB:          chain.db,
+		ChainParams: chain.chainParams,
+		TimeSource:  NewMedianTime(),
+		Prune:       1,
+	}
+	chain, err = New(&config)
+	if err != nil {
+		t.Fatalf("New: unexpected error: %v", err)
+	}
+	tip := btcutil.NewBlock(params.GenesisBlock)
+	tip.SetHeight(0)
+	blocks := []*btcutil.Block{tip}
+	ffldb.TstRunWithMaxBlockFileSize(chain.db, 4096, func() {
+		for i := 0; i < MinBlocksToKeep+100; i++ {
+			block, err := solvePruneTestBlock(tip, tip.Height()+1,
+				chain.chainParams)
+			if err != nil {
+				t.Fatalf("Failed to create block at height %d: %v",
+					tip.Height()+1, err)
+			}
+			isMainChain, isOrphan, err := chain.ProcessBlock(block,
+				BFNone)
+			if err != nil {
+				t.Fatalf("ProcessBlock fail on block at height "+
+					"%d: %v", block.Height(), err)
+			}
+			if !isMainChain || isOrphan {
+				t.Fatalf("block at height %d did not extend the "+
+					"main chain", block.Height())
+			}
+			blocks = append(blocks, block)
+			tip = block
+		}
+	})
+
+	// Ensure some blocks were pruned while the most recent ones were kept.
+	pruneHeight := chain.PruneHeight()
+	if pruneHeight == 0 {
+		t.Fatalf("no blocks were pruned")
+	}
+	if pruneHeight > tip.Height()-MinBlocksToKeep+1 {
+		t.Fatalf("pruned beyond the blocks to keep - got prune height "+
+			"%d with tip height %d", pruneHeight, tip.Height())
+	}
+
+	// Ensure the chain refuses to start without pruning.
+	config.Prune = 0
+	if _, err := New(&config); err == nil {
+		t.Fatalf("New: expected error for pruned database without " +
+			"pruning")
+	}
+
+	// Ensure the chain starts with pruning, still serves the headers of
+	// all blocks, and only reports the data of the pruned blocks as
+	// missing.
+	config.Prune = 1
+	chain, err = New(&config)
+	if err != nil {
+		t.Fatalf("New: unexpected error: %v", err)
+	}
+	if height := chain.PruneHeight(); height != pruneHeight {
+		t.Fatalf("unexpected prune height - got %d, want %d", height,
+			pruneHeight)
+	}
+	if best := chain.BestSnapshot(); best.Hash != *tip.Hash() {
+		t.Fatalf("unexpected best block - got %v, want %v", best.Hash,
+			tip.Hash())
+	}
+	for _, block := range blocks {
+		height := block.Height()
+		header, err := chain.FetchHeader(block.Hash())
+		if err != nil {
+			t.Fatalf("FetchHeader: unexpected error for block at "+
+				"height %d: %v", height, err)
+		}
+		if header.BlockHash() != *block.Hash() {
+			t.Fatalf("unexpected header for block at height %d",
+				height)
+		}
+
+		node := chain.bestChain.NodeByHeight(height)
+		haveData := chain.index.NodeStatus(node).HaveData()
+		if haveData != (height >= pruneHeight) {
+			t.Fatalf("unexpected data stored status for block at "+
+				"height %d - got %v", height, haveData)
+		}
+		_, err = chain.BlockByHash(block.Hash())
+		if (err == nil) != haveData {
+			t.Fatalf("BlockByHash: unexpected result for block at "+
+				"height %d - got %v", height, err)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// errInterruptRequested indicates that an operation was cancelled due to a
+// user-requested interrupt.
+var errInterruptRequested = errors.New("interrupt requested")
+
+// interruptRequested returns true when the provided channel has been closed.
+// This simplifies early shutdown slightly since the caller can just use an if
+// statement instead of a select.
+func interruptRequested(interrupted <-chan struct{}) bool {
+	select {
+	case <-interrupted:
+		return true
+	default:
+	}
+
+	return false
+}
+
+// -----------------------------------------------------------------------------
+// The legacy version 1 utxo set consists of an entry for each transaction which
+// contains a utxo serialized using a format that is highly optimized to reduce
+// space using domain specific compression algorithms.
+//
+// The serialized format is:
+//
+//   <version><height><header code><unspentness bitmap>[<compressed txouts>,...]
+//
+//   Field                Type     Size
+//   version              VLQ      variable
+//   block height         VLQ      variable
+//   header code          VLQ      variable
+//   unspentness bitmap   []byte   variable
+//   compressed txouts
+//     compressed amount  VLQ      variable
+//     compressed script  []byte   variable
+//
+// The serialized header code format is:
+//   bit 0 - containing transaction is a coinbase
+//   bit 1 - output zero is unspent
+//   bit 2 - output one is unspent
+//   bits 3-x - number of bytes in unspentness bitmap.  When both bits 1 and 2
+//     are unset, it encodes N-1 since there must be at least one unspent
+//     output.
+//
+// Example:
+// From tx in main blockchain:
+// Blk 113931, 4a16969aa4764dd7507fc1de7f0baa4850a246de90c45e59a3207f9a26b5036f
+//
+//    0185f90b0a011200e2ccd6ec7c6e2e581349c77e067385fa8236bf8a800900b8025be1b3efc63b0ad48e7f9f10e87544528d58
+//    <><----><><><------------------------------------------><-------------------------------------------->
+//     |    |  | \-------------------\            |                            |
+//  version |  \--------\       unspentness       |                    compressed txout 2
+//        height     header code          compressed txout 0
+//
+//  - version: 1
+//  - height: 113931
+//  - header code: 0x0a (output zero unspent, 1 byte in unspentness bitmap)
+//  - unspentness: [0x01] (bit 0 is set, so output 0+2 = 2 is unspent)
+//    NOTE: It's +2 since the first two outputs are encoded in the header code
+//  - compressed txout 0:
+//    - 0x12: VLQ-encoded compressed amount for 20000000 (0.2 BTC)
+//    - 0x00: special script type pay-to-pubkey-hash
+//    - 0xe2...8a: pubkey hash
+//  - compressed txout 2:
+//    - 0x8009: VLQ-encoded compressed amount for 15000000 (0.15 BTC)
+//    - 0x00: special script type pay-to-pubkey-hash
+//    - 0xb8...58: pubkey hash
+// -----------------------------------------------------------------------------
+
+// deserializeUtxoEntryV0 decodes a utxo entry from the passed serialized byte
+// slice according to the legacy version 1 format into a map of utxos keyed by
+// the output index within the transaction.  The map is necessary because the
+// previous format encoded all unspent outputs for a transaction using a single
+// entry, whereas the new format encodes each unspent output individually.
+//
+// The legacy format is described in detail above.
+func deserializeUtxoEntryV0(serialized []byte) (map[uint32]*UtxoEntry, error) {
+	// Deserialize the version.
+	//
+	// NOTE: Ignore version since it is no longer used in the new format.
+	_, bytesRead := deserializeVLQ(serialized)
+	offset := bytesRead
+	if offset >= len(serialized) {
+		return nil, errDeserialize("unexpected end of data after version")
+	}
+
+	// Deserialize the block height.
+	blockHeight, bytesRead := deserializeVLQ(serialized[offset:])
+	offset += bytesRead
+	if offset >= len(serialized) {
+		return nil, errDeserialize("unexpected end of data after height")
+	}
+
+	// Deserialize the header code.
+	code, bytesRead := deserializeVLQ(serialized[offset:])
+	offset += bytesRead
+	if offset >= len(serialized) {
+		return nil, errDeserialize("unexpected end of data after header")
+	}
+
+	// Decode the header code.
+	//
+	// Bit 0 indicates whether the containing transaction is a coinbase.
+	// Bit 1 indicates output 0 is unspent.
+	// Bit 2 indicates output 1 is unspent.
+	// Bits 3-x encodes the number of non-zero unspentness bitmap bytes that
+	// follow.  When both output 0 and 1 are spent, it encodes N-1.
+	isCoinBase := code&0x01 != 0
+	output0Unspent := code&0x02 != 0
+	output1Unspent := code&0x04 != 0
+	numBitmapBytes := code >> 3
+	if !output0Unspent && !output1Unspent {
+		numBitmapBytes++
+	}
+
+	// Ensure there are enough bytes left to deserialize the unspentness
+	// bitmap.
+	if uint64(len(serialized[offset:])) < numBitmapBytes {
+		return nil, errDeserialize("unexpected end of data for " +
+			"unspentness bitmap")
+	}
+
+	// Add sparse output for unspent outputs 0 and 1 as needed based on the
+	// details provided by the header code.
+	var outputIndexes []uint32
+	if output0Unspent {
+		outputIndexes = append(outputIndexes, 0)
+	}
+	if output1Unspent {
+		outputIndexes = append(outputIndexes, 1)
+	}
+
+	// Decode the unspentness bitmap adding a sparse output for each unspent
+	// output.
+	for i := uint32(0); i < uint32(numBitmapBytes); i++ {
+		unspentBits := serialized[offset]
+		for j := uint32(0); j < 8; j++ {
+			if unspentBits&0x01 != 0 {
+				// The first 2 outputs are encoded via the
+				// header code, so adjust the output number
+				// accordingly.
+				outputNum := 2 + i*8 + j
+				outputIndexes = append(outputIndexes, outputNum)
+			}
+			unspentBits >>= 1
+		}
+		offset++
+	}
+
+	// Map to hold all of the converted outputs.
+	entries := make(map[uint32]*UtxoEntry)
+
+	// All entries will need to potentially be marked as a coinbase.
+	var packedFlags txoFlags
+	if isCoinBase {
+		packedFlags |= tfCoinBase
+	}
+
+	// Decode and add all of the utxos.
+	for i, outputIndex := range outputIndexes {
+		// Decode the next utxo.
+		amount, pkScript, bytesRead, err := decodeCompressedTxOut(
+			serialized[offset:])
+		if err != nil {
+			return nil, errDeserialize(fmt.Sprintf("unable to "+
+				"decode utxo at index %d: %v", i, err))
+		}
+		offset += bytesRead
+
+		// Create a new utxo entry with the details deserialized above.
+		entries[outputIndex] = &UtxoEntry{
+			amount:      int64(amount),
+			pkScript:    pkScript,
+			blockHeight: int32(blockHeight),
+			packedFlags: packedFlags,
+		}
+	}
+
+	return entries, nil
+}
+
+// upgradeUtxoSetToV2 migrates the utxo set entries from version 1 to 2 in
+// batches.  It is guaranteed to be updated if this returns without failure.
+//
+// Interrupting the migration leaves the entries that were already migrated in
+// the version 2 bucket, so it picks up where it left off the next time it runs.
+func upgradeUtxoSetToV2(db database.DB, interrupt <-chan struct{}) error {
+	// Hardcoded bucket names so updates to the global values do not affect
+	// old upgrades.
+	var (
+		v1BucketName = []byte("utxoset")
+		v2BucketName = []byte("utxosetv2")
+	)
+
+	log.Infof("Upgrading utxo set to v2.  This will take a while...")
+	start := time.Now()
+
+	// Create the new utxo set bucket as needed.
+	err := db.Update(func(dbTx database.Tx) error {
+		_, err := dbTx.Metadata().CreateBucketIfNotExists(v2BucketName)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// doBatch contains the primary logic for upgrading the utxo set from
+	// version 1 to 2 in batches.  This is done because the utxo set can be
+	// huge and thus attempting to migrate in a single database transaction
+	// would result in massive memory usage and could potentially crash on
+	// many systems due to ulimits.
+	//
+	// It returns the number of utxos processed.
+	const maxUtxos = 200000
+	doBatch := func(dbTx database.Tx) (uint32, error) {
+		v1Bucket := dbTx.Metadata().Bucket(v1BucketName)
+		v2Bucket := dbTx.Metadata().Bucket(v2BucketName)
+		v1Cursor := v1Bucket.Cursor()
+
+		// Migrate utxos so long as the max number of utxos for this
+		// batch has not been exceeded.
+		var numUtxos uint32
+		for ok := v1Cursor.First(); ok && numUtxos < maxUtxos; ok =
+			v1Cursor.Next() {
+
+			// Old key was the transaction hash.
+			oldKey := v1Cursor.Key()
+			var txHash chainhash.Hash
+			copy(txHash[:], oldKey)
+
+			// Deserialize the old entry which included all utxos
+			// for the given transaction.
+			utxos, err := deserializeUtxoEntryV0(v1Cursor.Value())
+			if err != nil {
+				return 0, err
+			}
+
+			// Add the utxos for each output to the new bucket
+			// using the new format.
+			for txOutIdx, utxo := range utxos {
+				reserialized, err := serializeUtxoEntry(utxo)
+				if err != nil {
+					return 0, err
+				}
+
+				// Create the key for the output and store it.
+				key := outpointKey(wire.OutPoint{
+					Hash:  txHash,
+					Index: txOutIdx,
+				})
+				err = v2Bucket.Put(*key, reserialized)
+				// NOTE: The key is intentionally not recycled
+				// here since the database interface contract
+				// prohibits modifications.  It will be garbage
+				// collected normally when the database is done
+				// with it.
+				if err != nil {
+					return 0, err
+				}
+			}
+
+			// Remove old entry.
+			err = v1Bucket.Delete(oldKey)
+			if err != nil {
+				return 0, err
+			}
+
+			numUtxos += uint32(len(utxos))
+
+			if interruptRequested(interrupt) {
+				// No error here so the database transaction
+				// is not cancelled and therefore outstanding
+				// work is written to disk.
+				break
+			}
+		}
+
+		return numUtxos, nil
+	}
+
+	// Migrate all entries in batches for the reasons mentioned above.
+	var totalUtxos uint64
+	for {
+		var numUtxos uint32
+		err := db.Update(func(dbTx database.Tx) error {
+			var err error
+			numUtxos, err = doBatch(dbTx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if interruptRequested(interrupt) {
+			return errInterruptRequested
+		}
+
+		if numUtxos == 0 {
+			break
+		}
+
+		totalUtxos += uint64(numUtxos)
+		log.Infof("Migrated %d utxos (%d total)", numUtxos, totalUtxos)
+	}
+
+	// Remove the old bucket and update the utxo set version once it has
+	// been fully migrated.
+	err = db.Update(func(dbTx database.Tx) error {
+		err := dbTx.Metadata().DeleteBucket(v1BucketName)
+		if err != nil {
+			return err
+		}
+
+		return dbPutVersion(dbTx, utxoSetVersionKeyName, 2)
+	})
+	if err != nil {
+		return err
+	}
+
+	seconds := int64(time.Since(start) / time.Second)
+	log.Infof("Done upgrading utxo set.  Total utxos: %d in %d seconds",
+		totalUtxos, seconds)
+	return nil
+}
+
+// maybeCreateBlockHeaderIndex creates the block header index from the blocks in
+// the best chain when the database was created before the index existed.  The
+// blocks are marked as stored and fully validated, which is what older versions
+// assumed for the best chain on start up.  Side chain blocks stored by older
+// versions are not added since they can't be found without the index.
+func maybeCreateBlockHeaderIndex(db database.DB) error {
+	return db.Update(func(dbTx database.Tx) error {
+		// Nothing to do when the chain state has not been initialized
+		// yet or the index already exists.
+		meta := dbTx.Metadata()
+		serializedState := meta.Get(chainStateKeyName)
+		if serializedState == nil ||
+			meta.Bucket(blockHeaderIndexBucketName) != nil {
+
+			return nil
+		}
+		state, err := deserializeBestChainState(serializedState)
+		if err != nil {
+			return err
+		}
+
+		log.Infof("Creating block header index.  This might take a " +
+			"while...")
+		start := time.Now()
+		_, err = meta.CreateBucket(blockHeaderIndexBucketName)
+		if err != nil {
+			return err
+		}
+		var parent *blockNode
+		for height := int32(0); height <= int32(state.height); height++ {
+			header, err := dbFetchHeaderByHeight(dbTx, height)
+			if err != nil {
+				return err
+			}
+			node := newBlockNode(header, height)
+			node.parent = parent
+			err = dbStoreBlockNode(dbTx, node,
+				statusDataStored|statusValid)
+			if err != nil {
+				return err
+			}
+
+			// Only the hash of the parent is needed to store the
+			// next node, so don't keep the whole chain in memory.
+			node.parent = nil
+			parent = node
+		}
+
+		seconds := int64(time.Since(start) / time.Second)
+		log.Infof("Done creating block header index.  Total blocks: "+
+			"%d in %d seconds", state.height+1, seconds)
+		return nil
+	})
+}
+
+// maybeUpgradeDbBuckets checks the database version of the buckets used by this
+// package and performs any needed upgrades to bring them to the latest version.
+//
+// All buckets used by this package are guaranteed to be the latest version if
+// this function returns without error.
+func (b *BlockChain) maybeUpgradeDbBuckets(interrupt <-chan struct{}) error {
+	// Load or create bucket versions as needed.
+	var utxoSetVersion uint32
+	err := b.db.Update(func(dbTx database.Tx) error {
+		// Load the utxo set version from the database or create it and
+		// initialize it to version 1 if it doesn't exist.
+		var err error
+		utxoSetVersion, err = dbFetchOrCreateVersion(dbTx,
+			utxoSetVersionKeyName, 1)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Update the utxo set to v2 if needed.
+	if utxoSetVersion < 2 {
+		if err := upgradeUtxoSetToV2(b.db, interrupt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,331 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// TestDeserializeUtxoEntryV0 ensures deserializing unspent trasaction output
+// entries from the legacy version 1 format works as expected.
+func TestDeserializeUtxoEntryV0(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		entries    map[uint32]*UtxoEntry
+		serialized []byte
+	}{
+		// From tx in main blockchain:
+		// 0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098
+		{
+			name: "Only output 0, coinbase",
+			entries: map[uint32]*UtxoEntry{
+				0: {
+					amount:      5000000000,
+					pkScript:    hexToBytes("410496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52da7589379515d4e0a604f8141781e62294721166bf621e73a82cbf2342c858eeac"),
+					blockHeight: 1,
+					packedFlags: tfCoinBase,
+				},
+			},
+			serialized: hexToBytes("010103320496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52"),
+		},
+		// From tx in main blockchain:
+		// 8131ffb0a2c945ecaf9b9063e59558784f9c3a74741ce6ae2a18d0571dac15bb
+		{
+			name: "Only output 1, not coinbase",
+			entries: map[uint32]*UtxoEntry{
+				1: {
+					amount:      1000000,
+					pkScript:    hexToBytes("76a914ee8bd501094a7d5ca318da2506de35e1cb025ddc88ac"),
+					blockHeight: 100001,
+					packedFlags: 0,
+				},
+			},
+			serialized: hexToBytes("01858c21040700ee8bd501094a7d5ca318da2506de35e1cb025ddc"),
+		},
+		// Adapted from tx in main blockchain:
+		// df3f3f442d9699857f7f49de4ff0b5d0f3448bec31cdc7b5bf6d25f2abd637d5
+		{
+			name: "Only output 2, coinbase",
+			entries: map[uint32]*UtxoEntry{
+				2: {
+					amount:      100937281,
+					pkScript:    hexToBytes("76a914da33f77cee27c2a975ed5124d7e4f7f97513510188ac"),
+					blockHeight: 99004,
+					packedFlags: tfCoinBase,
+				},
+			},
+			serialized: hexToBytes("0185843c010182b095bf4100da33f77cee27c2a975ed5124d7e4f7f975135101"),
+		},
+		// Adapted from tx in main blockchain:
+		// 4a16969aa4764dd7507fc1de7f0baa4850a246de90c45e59a3207f9a26b5036f
+		{
+			name: "outputs 0 and 2 not coinbase",
+			entries: map[uint32]*UtxoEntry{
+				0: {
+					amount:      20000000,
+					pkScript:    hexToBytes("76a914e2ccd6ec7c6e2e581349c77e067385fa8236bf8a88ac"),
+					blockHeight: 113931,
+					packedFlags: 0,
+				},
+				2: {
+					amount:      15000000,
+					pkScript:    hexToBytes("76a914b8025be1b3efc63b0ad48e7f9f10e87544528d5888ac"),
+					blockHeight: 113931,
+					packedFlags: 0,
+				},
+			},
+			serialized: hexToBytes("0185f90b0a011200e2ccd6ec7c6e2e581349c77e067385fa8236bf8a800900b8025be1b3efc63b0ad48e7f9f10e87544528d58"),
+		},
+		// Adapted from tx in main blockchain:
+		// 1b02d1c8cfef60a189017b9a420c682cf4a0028175f2f563209e4ff61c8c3620
+		{
+			name: "Only output 22, not coinbase",
+			entries: map[uint32]*UtxoEntry{
+				22: {
+					amount:      366875659,
+					pkScript:    hexToBytes("a9141dd46a006572d820e448e12d2bbb38640bc718e687"),
+					blockHeight: 338156,
+					packedFlags: 0,
+				},
+			},
+			serialized: hexToBytes("0193d06c100000108ba5b9e763011dd46a006572d820e448e12d2bbb38640bc718e6"),
+		},
+	}
+
+	for i, test := range tests {
+		// Deserialize to map of utxos keyed by the output index.
+		entries, err := deserializeUtxoEntryV0(test.serialized)
+		if err != nil {
+			t.Errorf("deserializeUtxoEntryV0 #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+
+		// Ensure the deserialized entry has the same properties as the
+		// ones in the test entry.
+		if !reflect.DeepEqual(entries, test.entries) {
+			t.Errorf("deserializeUtxoEntryV0 #%d (%s) unexpected "+
+				"entries: got %v, want %v", i, test.name,
+				entries, test.entries)
+			continue
+		}
+	}
+}
+
+// TestDeserializeUtxoEntryV0Errors performs negative tests against
+// deserializing unspent transaction outputs in the legacy version 1 format to
+// ensure error paths work as expected.
+func TestDeserializeUtxoEntryV0Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		serialized []byte
+		errType    error
+	}{
+		{
+			name:       "no data after version",
+			serialized: hexToBytes("01"),
+			errType:    errDeserialize(""),
+		},
+		{
+			name:       "no data after block height",
+			serialized: hexToBytes("0101"),
+			errType:    errDeserialize(""),
+		},
+		{
+			name:       "no data after header code",
+			serialized: hexToBytes("010102"),
+			errType:    errDeserialize(""),
+		},
+		{
+			name:       "not enough bytes for unspentness bitmap",
+			serialized: hexToBytes("01017800"),
+			errType:    errDeserialize(""),
+		},
+		{
+			name:       "incomplete compressed txout",
+			serialized: hexToBytes("01010232"),
+			errType:    errDeserialize(""),
+		},
+	}
+
+	for _, test := range tests {
+		// Ensure the expected error type is returned and the returned
+		// entries are nil.
+		entries, err := deserializeUtxoEntryV0(test.serialized)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.errType) {
+			t.Errorf("deserializeUtxoEntryV0 (%s): expected error "+
+				"type does not match - got %T, want %T",
+				test.name, err, test.errType)
+			continue
+		}
+		if entries != nil {
+			t.Errorf("deserializeUtxoEntryV0 (%s): returned entries "+
+				"are not nil", test.name)
+			continue
+		}
+	}
+}
+
+// TestUpgradeUtxoSetToV2 ensures migrating the utxo set from the legacy version
+// 1 format works as expected, including when it is interrupted midway.
+func TestUpgradeUtxoSetToV2(t *testing.T) {
+	chain, teardown, err := chainSetup("upgradeutxoset",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardown()
+
+	// Store legacy entries for a couple of transactions in the version 1
+	// bucket and mark the utxo set as version 1.
+	v1BucketName := []byte("utxoset")
+	legacyEntries := map[chainhash.Hash][]byte{
+		*newHashFromStr("0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098"): hexToBytes("010103320496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52"),
+		*newHashFromStr("4a16969aa4764dd7507fc1de7f0baa4850a246de90c45e59a3207f9a26b5036f"): hexToBytes("0185f90b0a011200e2ccd6ec7c6e2e581349c77e067385fa8236bf8a800900b8025be1b3efc63b0ad48e7f9f10e87544528d58"),
+	}
+	err = chain.db.Update(func(dbTx database.Tx) error {
+		bucket, err := dbTx.Metadata().CreateBucket(v1BucketName)
+		if err != nil {
+			return err
+		}
+		for hash, serialized := range legacyEntries {
+			hash := hash
+			if err := bucket.Put(hash[:], serialized); err != nil {
+				return err
+			}
+		}
+		return dbPutVersion(dbTx, utxoSetVersionKeyName, 1)
+	})
+	if err != nil {
+		t.Fatalf("Failed to store legacy utxo set: %v", err)
+	}
+
+	// Ensure an interrupted upgrade reports the interruption and leaves
+	// the utxo set version alone.
+	interrupt := make(chan struct{})
+	close(interrupt)
+	err = upgradeUtxoSetToV2(chain.db, interrupt)
+	if err != errInterruptRequested {
+		t.Fatalf("upgradeUtxoSetToV2: unexpected error on interrupt - "+
+			"got %v, want %v", err, errInterruptRequested)
+	}
+	var version uint32
+	chain.db.View(func(dbTx database.Tx) error {
+		version = dbFetchVersion(dbTx, utxoSetVersionKeyName)
+		return nil
+	})
+	if version != 1 {
+		t.Fatalf("unexpected utxo set version after interrupt - got "+
+			"%d, want 1", version)
+	}
+
+	// Resume the upgrade and ensure it completes.
+	if err := chain.maybeUpgradeDbBuckets(nil); err != nil {
+		t.Fatalf("maybeUpgradeDbBuckets: unexpected error: %v", err)
+	}
+
+	// Ensure the version 1 bucket was removed, the version was updated and
+	// all of the utxos are available in the new format.
+	err = chain.db.View(func(dbTx database.Tx) error {
+		if dbTx.Metadata().Bucket(v1BucketName) != nil {
+			t.Errorf("version 1 utxo set bucket still exists")
+		}
+		version := dbFetchVersion(dbTx, utxoSetVersionKeyName)
+		if version != latestUtxoSetBucketVersion {
+			t.Errorf("unexpected utxo set version - got %d, want %d",
+				version, latestUtxoSetBucketVersion)
+		}
+
+		for hash, serialized := range legacyEntries {
+			want, err := deserializeUtxoEntryV0(serialized)
+			if err != nil {
+				return err
+			}
+			for txOutIdx, wantEntry := range want {
+				outpoint := wire.OutPoint{Hash: hash, Index: txOutIdx}
+				entry, err := dbFetchUtxoEntry(dbTx, outpoint)
+				if err != nil {
+					return err
+				}
+				if !reflect.DeepEqual(entry, wantEntry) {
+					t.Errorf("mismatched entry for %v - got "+
+						"%v, want %v", outpoint, entry,
+						wantEntry)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to check upgraded utxo set: %v", err)
+	}
+}
+
+// TestCreateBlockHeaderIndex ensures the block header index is created from the
+// best chain when a database created before it existed is loaded.
+func TestCreateBlockHeaderIndex(t *testing.T) {
+	blocks, err := loadBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("Error loading file: %v\n", err)
+	}
+
+	chain, teardown, err := chainSetup("createblockheaderindex",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardown()
+	chain.TstSetCoinbaseMaturity(1)
+	for i := 1; i < len(blocks); i++ {
+		_, _, err := chain.ProcessBlock(blocks[i], BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock fail on block %v: %v\n", i, err)
+		}
+	}
+
+	// Remove the index as if the database was created before it existed.
+	err = chain.db.Update(func(dbTx database.Tx) error {
+		return dbTx.Metadata().DeleteBucket(blockHeaderIndexBucketName)
+	})
+	if err != nil {
+		t.Fatalf("Failed to remove block header index: %v", err)
+	}
+
+	// Ensure the chain starts with the best chain blocks stored and fully
+	// validated.
+	chain, err = New(&Config{
+		DB:          chain.db,
+		ChainParams: chain.chainParams,
+		TimeSource:  NewMedianTime(),
+	})
+	if err != nil {
+		t.Fatalf("New: unexpected error: %v", err)
+	}
+	if height := chain.BestSnapshot().Height; height != 4 {
+		t.Fatalf("unexpected best chain height - got %d, want 4",
+			height)
+	}
+	for height := int32(0); height < int32(len(blocks)); height++ {
+		node := chain.bestChain.NodeByHeight(height)
+		if node == nil || node.hash != *blocks[height].Hash() {
+			t.Fatalf("unexpected block at height %d", height)
+		}
+		status := chain.index.NodeStatus(node)
+		if !status.HaveData() || !status.KnownValid() {
+			t.Fatalf("unexpected status for block at height %d - "+
+				"got %v", height, status)
+		}
+	}
+}
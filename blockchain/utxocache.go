@@ -0,0 +1,509 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// utxoFlushPeriodicInterval is the maximum amount of time the utxo
+	// cache is allowed to hold modifications before they are written to
+	// the database when flushing periodically.
+	utxoFlushPeriodicInterval = 5 * time.Minute
+
+	// utxoFlushBatchSize is the maximum number of entries that are written
+	// to the database in a single database transaction when flushing the
+	// utxo cache.  This is done because the cache can be huge and thus
+	// attempting to write it in a single database transaction would result
+	// in massive memory usage and could potentially crash on many systems
+	// due to ulimits.
+	utxoFlushBatchSize = 200000
+
+	// cachedEntryOverhead is the approximate number of bytes each entry in
+	// the utxo cache uses in addition to its public key script.  It
+	// accounts for the allocation of the entry itself along with the
+	// outpoint key, the pointer to the entry and the per element
+	// bookkeeping of the map they are stored in at its typical load.
+	cachedEntryOverhead = 48 + 56
+)
+
+// FlushMode is used to indicate the different urgency types for a flush of the
+// utxo cache.
+type FlushMode uint8
+
+const (
+	// FlushRequired is the flush mode that means a flush must be performed
+	// regardless of the cache state.  For example right before shutting
+	// down.
+	FlushRequired FlushMode = iota
+
+	// FlushPeriodic is the flush mode that means a flush can be performed
+	// when it would be almost needed.  This is used to periodically signal
+	// when no I/O heavy operations are expected soon, so there is time to
+	// flush.
+	FlushPeriodic
+
+	// FlushIfNeeded is the flush mode that means a flush must be performed
+	// only if the cache is exceeding a safety threshold very close to its
+	// maximum size.  This is used mostly internally in between operations
+	// that can increase the cache size.
+	FlushIfNeeded
+)
+
+// utxoCache is a cache of unspent transaction outputs that sits between the
+// utxo views used when connecting blocks and the utxo set in the database.  It
+// serves lookups for outputs that were recently created or loaded and absorbs
+// the outputs spent and created by connected blocks in memory, so the database
+// only needs to be updated when the cache is flushed.
+//
+// Each cached entry is in one of the following states:
+//  - unmodified: the entry matches the one in the database
+//  - modified: the entry differs from the one in the database, which might not
+//    have it at all
+//  - modified and fresh: the entry is not in the database, so it can simply be
+//    forgotten when it is spent before the next flush
+//  - modified and spent: the entry must be removed from the database
+//
+// The hash of the block the utxo set in the database was last flushed at is
+// stored along with it, which allows the modifications that were lost due to
+// an unclean shutdown to be recovered by replaying the blocks after it.
+type utxoCache struct {
+	db                  database.DB
+	maxTotalMemoryUsage uint64
+
+	// The following fields are protected by the mutex since entries are
+	// loaded into the cache by callers that only hold the chain lock for
+	// reads.
+	mtx              sync.Mutex
+	cachedEntries    map[wire.OutPoint]*UtxoEntry
+	totalEntryMemory uint64
+	lastFlushTime    time.Time
+}
+
+// newUtxoCache returns a new utxo cache backed by the passed database that
+// holds up to the passed number of bytes worth of entries before they are
+// flushed.  A maximum of zero causes the modifications to be flushed every
+// time the cache is flushed periodically.
+func newUtxoCache(db database.DB, maxTotalMemoryUsage uint64) *utxoCache {
+	return &utxoCache{
+		db:                  db,
+		maxTotalMemoryUsage: maxTotalMemoryUsage,
+		cachedEntries:       make(map[wire.OutPoint]*UtxoEntry),
+		lastFlushTime:       time.Now(),
+	}
+}
+
+// isFresh returns whether or not the output is known to not exist in the
+// database.  It is only used by entries in the utxo cache.
+func (entry *UtxoEntry) isFresh() bool {
+	return entry.packedFlags&tfFresh == tfFresh
+}
+
+// entryMemoryUsage returns the approximate number of bytes the passed entry
+// uses while it is in the cache.
+func entryMemoryUsage(entry *UtxoEntry) uint64 {
+	return cachedEntryOverhead + uint64(len(entry.pkScript))
+}
+
+// putEntry adds the passed entry to the cache, replacing any existing entry for
+// the outpoint, and updates the memory usage accordingly.
+//
+// This function MUST be called with the cache lock held.
+func (c *utxoCache) putEntry(outpoint wire.OutPoint, entry *UtxoEntry) {
+	if cached, ok := c.cachedEntries[outpoint]; ok {
+		c.totalEntryMemory -= entryMemoryUsage(cached)
+	}
+	c.cachedEntries[outpoint] = entry
+	c.totalEntryMemory += entryMemoryUsage(entry)
+}
+
+// removeEntry removes the entry for the passed outpoint from the cache, if
+// any, and updates the memory usage accordingly.
+//
+// This function MUST be called with the cache lock held.
+func (c *utxoCache) removeEntry(outpoint wire.OutPoint) {
+	if cached, ok := c.cachedEntries[outpoint]; ok {
+		c.totalEntryMemory -= entryMemoryUsage(cached)
+		delete(c.cachedEntries, outpoint)
+	}
+}
+
+// spendEntry marks the output identified by the passed outpoint as spent in the
+// cache.  Outputs that never made it to the database are simply forgotten,
+// while the others are replaced with a spent entry so they are removed from the
+// database on the next flush.
+//
+// This function MUST be called with the cache lock held.
+func (c *utxoCache) spendEntry(outpoint wire.OutPoint) {
+	if cached, ok := c.cachedEntries[outpoint]; ok && cached.isFresh() {
+		c.removeEntry(outpoint)
+		return
+	}
+
+	c.putEntry(outpoint, &UtxoEntry{packedFlags: tfSpent | tfModified})
+}
+
+// fetchEntries returns the unspent transaction outputs for the passed set of
+// outpoints from the point of view of the end of the main chain.  The entries
+// that are not already cached are loaded from the database and added to the
+// cache.  Outputs that are spent or otherwise don't exist result in a nil
+// entry in the returned map.
+//
+// The returned entries are copies, so the caller is free to modify them.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) fetchEntries(outpoints map[wire.OutPoint]struct{}) (map[wire.OutPoint]*UtxoEntry, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entries := make(map[wire.OutPoint]*UtxoEntry, len(outpoints))
+	var missing []wire.OutPoint
+	for outpoint := range outpoints {
+		cached, ok := c.cachedEntries[outpoint]
+		if !ok {
+			missing = append(missing, outpoint)
+			continue
+		}
+		if cached.IsSpent() {
+			entries[outpoint] = nil
+			continue
+		}
+
+		entry := cached.Clone()
+		entry.packedFlags &^= tfModified | tfFresh
+		entries[outpoint] = entry
+	}
+	if len(missing) == 0 {
+		return entries, nil
+	}
+
+	// Load the entries that are not cached from the database and add them
+	// to the cache.  Outputs that don't exist in the database are not
+	// cached.
+	err := c.db.View(func(dbTx database.Tx) error {
+		for _, outpoint := range missing {
+			entry, err := dbFetchUtxoEntry(dbTx, outpoint)
+			if err != nil {
+				return err
+			}
+			entries[outpoint] = entry
+			if entry != nil {
+				c.putEntry(outpoint, entry.Clone())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// fetchEntry returns the unspent transaction output for the passed outpoint
+// from the point of view of the end of the main chain.  A nil entry is
+// returned when the output is spent or otherwise doesn't exist.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) fetchEntry(outpoint wire.OutPoint) (*UtxoEntry, error) {
+	entries, err := c.fetchEntries(map[wire.OutPoint]struct{}{
+		outpoint: {},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries[outpoint], nil
+}
+
+// commit applies the modified entries in the passed view, which must represent
+// the end of the main chain, to the cache.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) commit(view *UtxoViewpoint) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for outpoint, entry := range view.entries {
+		if entry == nil || !entry.isModified() {
+			continue
+		}
+
+		if entry.IsSpent() {
+			c.spendEntry(outpoint)
+			continue
+		}
+
+		// Outputs that replace an entry which is not in the database
+		// are not in the database either.  Coinbase outputs are never
+		// considered fresh since duplicate coinbases overwrite the
+		// existing entries in the database.
+		cached := c.cachedEntries[outpoint]
+		newEntry := entry.Clone()
+		newEntry.packedFlags |= tfModified
+		if !entry.IsCoinBase() && (cached == nil || cached.isFresh()) {
+			newEntry.packedFlags |= tfFresh
+		}
+		c.putEntry(outpoint, newEntry)
+	}
+}
+
+// invalidate removes the cached entries for the modified entries in the passed
+// view.  It is used when the view was written to the database directly, which
+// is only allowed when the cache does not hold any modifications for the
+// entries.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) invalidate(view *UtxoViewpoint) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for outpoint, entry := range view.entries {
+		if entry == nil || !entry.isModified() {
+			continue
+		}
+
+		cached, ok := c.cachedEntries[outpoint]
+		if !ok {
+			continue
+		}
+		if cached.isModified() {
+			return AssertError(fmt.Sprintf("utxo cache holds "+
+				"unflushed modifications for %v", outpoint))
+		}
+		c.removeEntry(outpoint)
+	}
+
+	return nil
+}
+
+// replayBlock applies the outputs spent and created by the passed block to the
+// cache.  Unlike when committing a view, none of the outputs are considered
+// fresh since the database might contain modifications made after the last
+// time it was fully flushed, which makes replaying blocks idempotent.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) replayBlock(block *btcutil.Block) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for txIdx, tx := range block.Transactions() {
+		if txIdx != 0 {
+			for _, txIn := range tx.MsgTx().TxIn {
+				c.spendEntry(txIn.PreviousOutPoint)
+			}
+		}
+
+		isCoinBase := txIdx == 0
+		prevOut := wire.OutPoint{Hash: *tx.Hash()}
+		for txOutIdx, txOut := range tx.MsgTx().TxOut {
+			// Don't add provably unspendable outputs.
+			if txscript.IsUnspendable(txOut.PkScript) {
+				continue
+			}
+
+			entry := &UtxoEntry{
+				amount:      txOut.Value,
+				pkScript:    txOut.PkScript,
+				blockHeight: block.Height(),
+				packedFlags: tfModified,
+			}
+			if isCoinBase {
+				entry.packedFlags |= tfCoinBase
+			}
+			prevOut.Index = uint32(txOutIdx)
+			c.putEntry(prevOut, entry)
+		}
+	}
+}
+
+// modifiedEntries returns a snapshot of the entries in the cache that differ
+// from the ones in the database.  The entries must not be modified.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) modifiedEntries() map[wire.OutPoint]*UtxoEntry {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entries := make(map[wire.OutPoint]*UtxoEntry)
+	for outpoint, entry := range c.cachedEntries {
+		if entry.isModified() {
+			entries[outpoint] = entry
+		}
+	}
+	return entries
+}
+
+// flush writes the modified entries in the cache to the database depending on
+// the passed flush mode along with the hash of the block the utxo set is being
+// flushed at, which must be the end of the main chain.
+//
+// The cache is emptied when it exceeds its maximum size, otherwise the entries
+// remain cached so they can continue to serve lookups.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) flush(bestHash *chainhash.Hash, mode FlushMode) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	overLimit := c.totalEntryMemory >= c.maxTotalMemoryUsage
+	switch mode {
+	case FlushIfNeeded:
+		if !overLimit {
+			return nil
+		}
+
+	case FlushPeriodic:
+		if !overLimit &&
+			time.Since(c.lastFlushTime) < utxoFlushPeriodicInterval {
+
+			return nil
+		}
+	}
+
+	var modified []wire.OutPoint
+	for outpoint, entry := range c.cachedEntries {
+		if entry.isModified() {
+			modified = append(modified, outpoint)
+		}
+	}
+	log.Debugf("Flushing %d modified utxos of %d cached (%d bytes) at "+
+		"block %v", len(modified), len(c.cachedEntries),
+		c.totalEntryMemory, bestHash)
+
+	// Write the modified entries in batches.  The hash of the block the
+	// utxo set is flushed at is written along with the final batch, so it
+	// is not updated unless all of the entries have been written.
+	for {
+		batch := modified
+		if len(batch) > utxoFlushBatchSize {
+			batch = batch[:utxoFlushBatchSize]
+		}
+		modified = modified[len(batch):]
+
+		err := c.db.Update(func(dbTx database.Tx) error {
+			for _, outpoint := range batch {
+				entry := c.cachedEntries[outpoint]
+				err := dbPutUtxoEntry(dbTx, outpoint, entry)
+				if err != nil {
+					return err
+				}
+			}
+
+			if len(modified) != 0 {
+				return nil
+			}
+			return dbPutUtxoStateConsistency(dbTx, bestHash)
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(modified) == 0 {
+			break
+		}
+	}
+	c.lastFlushTime = time.Now()
+
+	// Empty the cache when it is too large.  Otherwise, remove the spent
+	// entries and mark the remaining entries as matching the database now
+	// that the modifications have been written.
+	if overLimit {
+		c.cachedEntries = make(map[wire.OutPoint]*UtxoEntry)
+		c.totalEntryMemory = 0
+		return nil
+	}
+	for outpoint, entry := range c.cachedEntries {
+		if entry.IsSpent() {
+			c.removeEntry(outpoint)
+			continue
+		}
+		entry.packedFlags &^= tfModified | tfFresh
+	}
+
+	return nil
+}
+
+// initUtxoCache ensures the utxo set in the database is consistent with the
+// end of the main chain.  When the chain was not shut down cleanly, the
+// modifications that were held in the utxo cache were lost, so the blocks after
+// the one the utxo set was last flushed at are replayed to recover them.
+func (b *BlockChain) initUtxoCache(interrupt <-chan struct{}) error {
+	// Databases created before the utxo cache existed do not have the
+	// hash of the block the utxo set was last flushed at since it was
+	// always updated along with the best chain state.
+	tip := b.bestChain.Tip()
+	var flushedHash *chainhash.Hash
+	err := b.db.Update(func(dbTx database.Tx) error {
+		flushedHash = dbFetchUtxoStateConsistency(dbTx)
+		if flushedHash != nil {
+			return nil
+		}
+		return dbPutUtxoStateConsistency(dbTx, &tip.hash)
+	})
+	if err != nil {
+		return err
+	}
+	if flushedHash == nil || *flushedHash == tip.hash {
+		return nil
+	}
+
+	node := b.index.LookupNode(flushedHash)
+	if node == nil || !b.bestChain.Contains(node) {
+		return AssertError(fmt.Sprintf("utxo set was last flushed at "+
+			"block %v which is not in the main chain", flushedHash))
+	}
+
+	log.Infof("Replaying %d blocks to recover the utxo set after an "+
+		"unclean shutdown", tip.height-node.height)
+	for n := b.bestChain.Next(node); n != nil; n = b.bestChain.Next(n) {
+		var block *btcutil.Block
+		err := b.db.View(func(dbTx database.Tx) error {
+			var err error
+			block, err = dbFetchBlockByNode(dbTx, n)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		b.utxoCache.replayBlock(block)
+		if err := b.utxoCache.flush(&n.hash, FlushIfNeeded); err != nil {
+			return err
+		}
+
+		// Keep the blocks that were replayed so far when interrupted.
+		if interruptRequested(interrupt) {
+			err := b.utxoCache.flush(&n.hash, FlushRequired)
+			if err != nil {
+				return err
+			}
+			return errInterruptRequested
+		}
+	}
+
+	return b.utxoCache.flush(&tip.hash, FlushRequired)
+}
+
+// FlushUtxoCache writes the modifications held in the utxo cache to the
+// database depending on the passed flush mode.  It must be called with
+// FlushRequired before shutting down, otherwise the blocks connected since
+// the last flush are replayed on the next start.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FlushUtxoCache(mode FlushMode) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	return b.utxoCache.flush(&b.bestChain.Tip().hash, mode)
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/database"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// TestUtxoCacheCommit ensures committing views to the utxo cache tracks which
+// entries are modified and which ones never made it to the database as
+// expected.
+func TestUtxoCacheCommit(t *testing.T) {
+	t.Parallel()
+
+	// The cache is never flushed, so it does not need a database.
+	cache := newUtxoCache(nil, 0)
+
+	pkScript := hexToBytes("76a914ee8bd501094a7d5ca318da2506de35e1cb025ddc88ac")
+	tx := btcutil.NewTx(&wire.MsgTx{
+		TxIn:  []*wire.TxIn{{PreviousOutPoint: wire.OutPoint{Index: 1}}},
+		TxOut: []*wire.TxOut{{Value: 1000, PkScript: pkScript}},
+	})
+	coinbase := btcutil.NewTx(&wire.MsgTx{
+		TxIn:  []*wire.TxIn{{PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex}}},
+		TxOut: []*wire.TxOut{{Value: 5000, PkScript: pkScript}},
+	})
+	txOut := wire.OutPoint{Hash: *tx.Hash()}
+	coinbaseOut := wire.OutPoint{Hash: *coinbase.Hash()}
+	unknownOut := tx.MsgTx().TxIn[0].PreviousOutPoint
+
+	// Create the outputs and ensure they are modified and that only the
+	// output which is not a coinbase is considered fresh.
+	view := NewUtxoViewpoint()
+	view.AddTxOuts(tx, 100)
+	view.AddTxOuts(coinbase, 100)
+	cache.commit(view)
+	view.commit()
+	entry := cache.cachedEntries[txOut]
+	if entry == nil || !entry.isModified() || !entry.isFresh() {
+		t.Fatalf("unexpected cached entry for created output: %+v", entry)
+	}
+	entry = cache.cachedEntries[coinbaseOut]
+	if entry == nil || !entry.isModified() || entry.isFresh() {
+		t.Fatalf("unexpected cached entry for created coinbase output: "+
+			"%+v", entry)
+	}
+	wantMemory := entryMemoryUsage(entry) * 2
+	if cache.totalEntryMemory != wantMemory {
+		t.Fatalf("unexpected memory usage - got %d, want %d",
+			cache.totalEntryMemory, wantMemory)
+	}
+
+	// Ensure fetched entries are copies that are neither modified nor
+	// fresh.
+	entries, err := cache.fetchEntries(map[wire.OutPoint]struct{}{
+		txOut: {},
+	})
+	if err != nil {
+		t.Fatalf("fetchEntries: unexpected error: %v", err)
+	}
+	fetched := entries[txOut]
+	if fetched == nil || fetched == cache.cachedEntries[txOut] ||
+		fetched.isModified() || fetched.isFresh() ||
+		fetched.Amount() != 1000 {
+
+		t.Fatalf("unexpected fetched entry: %+v", fetched)
+	}
+
+	// Spend all of the outputs along with one that is not cached and
+	// ensure the fresh output is forgotten while the others are kept as
+	// spent so they are removed from the database.
+	view.entries[txOut].Spend()
+	view.entries[coinbaseOut].Spend()
+	view.entries[unknownOut] = &UtxoEntry{}
+	view.entries[unknownOut].Spend()
+	cache.commit(view)
+	if _, ok := cache.cachedEntries[txOut]; ok {
+		t.Fatalf("spent fresh output is still cached")
+	}
+	for _, outpoint := range []wire.OutPoint{coinbaseOut, unknownOut} {
+		entry := cache.cachedEntries[outpoint]
+		if entry == nil || !entry.isModified() || !entry.IsSpent() {
+			t.Fatalf("unexpected cached entry for spent output "+
+				"%v: %+v", outpoint, entry)
+		}
+	}
+	wantMemory = cachedEntryOverhead * 2
+	if cache.totalEntryMemory != wantMemory {
+		t.Fatalf("unexpected memory usage - got %d, want %d",
+			cache.totalEntryMemory, wantMemory)
+	}
+
+	// Ensure spent outputs are reported as such without going to the
+	// database.
+	entry, err = cache.fetchEntry(coinbaseOut)
+	if err != nil {
+		t.Fatalf("fetchEntry: unexpected error: %v", err)
+	}
+	if entry != nil {
+		t.Fatalf("fetchEntry: unexpected entry for spent output: %+v",
+			entry)
+	}
+}
+
+// dbUtxoSet returns all of the entries in the utxo set in the database.
+func dbUtxoSet(db database.DB) (map[wire.OutPoint]*UtxoEntry, error) {
+	entries := make(map[wire.OutPoint]*UtxoEntry)
+	err := db.View(func(dbTx database.Tx) error {
+		utxoBucket := dbTx.Metadata().Bucket(utxoSetBucketName)
+		return utxoBucket.ForEach(func(k, v []byte) error {
+			outpoint, err := deserializeOutpointKey(k)
+			if err != nil {
+				return err
+			}
+			entry, err := deserializeUtxoEntry(v)
+			if err != nil {
+				return err
+			}
+			entries[outpoint] = entry
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// TestUtxoCacheRecovery ensures the utxo cache serves the unflushed state of the
+// utxo set, that blocks can be disconnected while it holds modifications and
+// that the utxo set in the database is recovered when the chain is not shut
+// down cleanly.
+func TestUtxoCacheRecovery(t *testing.T) {
+	blocks, err := loadBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("Error loading file: %v\n", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("utxocacherecovery",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Since we're not dealing with the real block chain, set the coinbase
+	// maturity to 1 and use a cache that is large enough to never be
+	// flushed on its own.
+	chain.TstSetCoinbaseMaturity(1)
+	chain.utxoCache = newUtxoCache(chain.db, 1<<30)
+
+	// The outputs of the genesis block are never added to the utxo set.
+	want := make(map[wire.OutPoint]*wire.TxOut)
+	for i := 1; i < len(blocks); i++ {
+		block := blocks[i]
+		_, _, err := chain.ProcessBlock(block, BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock fail on block %v: %v\n", i, err)
+		}
+
+		for txIdx, tx := range block.Transactions() {
+			if txIdx != 0 {
+				for _, txIn := range tx.MsgTx().TxIn {
+					delete(want, txIn.PreviousOutPoint)
+				}
+			}
+			for outIdx, txOut := range tx.MsgTx().TxOut {
+				outpoint := wire.OutPoint{
+					Hash:  *tx.Hash(),
+					Index: uint32(outIdx),
+				}
+				want[outpoint] = txOut
+			}
+		}
+	}
+
+	// checkUtxos ensures the passed utxos match the expected ones.
+	checkUtxos := func(desc string, got map[wire.OutPoint]*UtxoEntry) {
+		t.Helper()
+
+		if len(got) != len(want) {
+			t.Fatalf("%s: unexpected number of utxos - got %d, "+
+				"want %d", desc, len(got), len(want))
+		}
+		for outpoint, txOut := range want {
+			entry := got[outpoint]
+			if entry == nil || entry.Amount() != txOut.Value ||
+				!bytes.Equal(entry.PkScript(), txOut.PkScript) {
+
+				t.Fatalf("%s: mismatched utxo %v - got %+v, "+
+					"want %+v", desc, outpoint, entry, txOut)
+			}
+		}
+	}
+
+	// checkConsistency ensures the utxo set in the database was last
+	// flushed at the passed block.
+	checkConsistency := func(desc string, want *chainhash.Hash) {
+		t.Helper()
+
+		var hash *chainhash.Hash
+		chain.db.View(func(dbTx database.Tx) error {
+			hash = dbFetchUtxoStateConsistency(dbTx)
+			return nil
+		})
+		if hash == nil || *hash != *want {
+			t.Fatalf("%s: unexpected utxo state consistency - got "+
+				"%v, want %v", desc, hash, want)
+		}
+	}
+
+	// Ensure the database has not been updated while the chain serves the
+	// utxos from the cache.
+	dbUtxos, err := dbUtxoSet(chain.db)
+	if err != nil {
+		t.Fatalf("Failed to load utxo set: %v", err)
+	}
+	if len(dbUtxos) != 0 {
+		t.Fatalf("unexpected utxos in the database before flushing: %d",
+			len(dbUtxos))
+	}
+	checkConsistency("before flushing", blocks[0].Hash())
+	gotUtxos := make(map[wire.OutPoint]*UtxoEntry)
+	err = chain.ForEachUnspentOutput(func(outpoint wire.OutPoint, amount int64, pkScript []byte) error {
+		gotUtxos[outpoint] = &UtxoEntry{amount: amount, pkScript: pkScript}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachUnspentOutput: unexpected error: %v", err)
+	}
+	checkUtxos("ForEachUnspentOutput", gotUtxos)
+	for outpoint := range want {
+		entry, err := chain.FetchUtxoEntry(outpoint)
+		if err != nil {
+			t.Fatalf("FetchUtxoEntry: unexpected error: %v", err)
+		}
+		if entry == nil {
+			t.Fatalf("FetchUtxoEntry: missing entry for %v", outpoint)
+		}
+	}
+
+	// Disconnect the tip and connect it again, which requires flushing
+	// the cache, and ensure the utxo set in the database is updated.
+	tipHash := blocks[len(blocks)-1].Hash()
+	if err := chain.InvalidateBlock(tipHash); err != nil {
+		t.Fatalf("InvalidateBlock: unexpected error: %v", err)
+	}
+	checkConsistency("after disconnecting", blocks[len(blocks)-2].Hash())
+	if err := chain.ReconsiderBlock(tipHash); err != nil {
+		t.Fatalf("ReconsiderBlock: unexpected error: %v", err)
+	}
+	checkConsistency("after reconnecting", blocks[len(blocks)-2].Hash())
+
+	// Simulate an unclean shutdown by restarting the chain without
+	// flushing the cache and ensure the utxo set in the database is
+	// recovered.
+	chain, err = New(&Config{
+		DB:               chain.db,
+		ChainParams:      chain.chainParams,
+		TimeSource:       NewMedianTime(),
+		UtxoCacheMaxSize: 1 << 30,
+	})
+	if err != nil {
+		t.Fatalf("Failed to restart chain instance: %v", err)
+	}
+	checkConsistency("after recovery", tipHash)
+	dbUtxos, err = dbUtxoSet(chain.db)
+	if err != nil {
+		t.Fatalf("Failed to load utxo set: %v", err)
+	}
+	checkUtxos("after recovery", dbUtxos)
+}
@@ -87,10 +87,8 @@ func GetSigOpCost(tx *btcutil.Tx, isCoinBaseTx bool, utxoView *UtxoViewpoint,
 		msgTx := tx.MsgTx()
 		for txInIndex, txIn := range msgTx.TxIn {
 			// Ensure the referenced input transaction is available.
-			originTxHash := &txIn.PreviousOutPoint.Hash
-			originTxIndex := txIn.PreviousOutPoint.Index
-			txEntry := utxoView.LookupEntry(originTxHash)
-			if txEntry == nil || txEntry.IsOutputSpent(originTxIndex) {
+			utxo := utxoView.LookupEntry(txIn.PreviousOutPoint)
+			if utxo == nil || utxo.IsSpent() {
 				str := fmt.Sprintf("output %v referenced from "+
 					"transaction %s:%d either does not "+
 					"exist or has already been spent",
@@ -101,7 +99,7 @@ func GetSigOpCost(tx *btcutil.Tx, isCoinBaseTx bool, utxoView *UtxoViewpoint,
 
 			witness := txIn.Witness
 			sigScript := txIn.SignatureScript
-			pkScript := txEntry.PkScriptByIndex(originTxIndex)
+			pkScript := utxo.PkScript()
 			numSigOps += txscript.GetWitnessSigOpCount(sigScript, pkScript, witness)
 		}
 
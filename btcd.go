@@ -15,7 +15,9 @@ import (
 	"runtime/debug"
 	"runtime/pprof"
 
+	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/blockchain/indexers"
+	"github.com/btcsuite/btcd/data"
 	"github.com/btcsuite/btcd/database"
 	"github.com/btcsuite/btcd/limits"
 )
@@ -118,7 +120,7 @@ func btcdMain(serverChan chan<- *server) error {
 	// Drop indexes and exit if requested.
 	//
 	// NOTE: The order is important here because dropping the tx index also
-	// drops the address index since it relies on it.
+	// drops the address and address balance indexes since they rely on it.
 	if cfg.DropAddrIndex {
 		if err := indexers.DropAddrIndex(db, interrupt); err != nil {
 			btcdLog.Errorf("%v", err)
@@ -127,6 +129,14 @@ func btcdMain(serverChan chan<- *server) error {
 
 		return nil
 	}
+	if cfg.DropBalanceIndex {
+		if err := indexers.DropBalanceIndex(db, interrupt); err != nil {
+			btcdLog.Errorf("%v", err)
+			return err
+		}
+
+		return nil
+	}
 	if cfg.DropTxIndex {
 		if err := indexers.DropTxIndex(db, interrupt); err != nil {
 			btcdLog.Errorf("%v", err)
@@ -136,9 +146,24 @@ func btcdMain(serverChan chan<- *server) error {
 		return nil
 	}
 
+	// Load the balance repository used by the explorer when one has been
+	// configured.
+	balanceRepo, err := loadBalanceRepo()
+	if err != nil {
+		btcdLog.Errorf("%v", err)
+		return err
+	}
+	if balanceRepo != nil {
+		defer func() {
+			btcdLog.Infof("Gracefully shutting down the balance " +
+				"repository...")
+			balanceRepo.Close()
+		}()
+	}
+
 	// Create server and start it.
-	server, err := newServer(cfg.Listeners, db, activeNetParams.Params,
-		interrupt)
+	server, err := newServer(cfg.Listeners, db, balanceRepo,
+		activeNetParams.Params, interrupt)
 	if err != nil {
 		// TODO: this logging could do with some beautifying.
 		btcdLog.Errorf("Unable to start server on %v: %v",
@@ -150,6 +175,15 @@ func btcdMain(serverChan chan<- *server) error {
 		server.Stop()
 		server.WaitForShutdown()
 		srvrLog.Infof("Server shutdown complete")
+
+		// Write the utxo cache to the database now that no more blocks
+		// will be processed, so they don't need to be replayed on the
+		// next start.
+		btcdLog.Infof("Flushing the utxo cache to the database...")
+		err := server.chain.FlushUtxoCache(blockchain.FlushRequired)
+		if err != nil {
+			btcdLog.Errorf("Unable to flush the utxo cache: %v", err)
+		}
 	}()
 	server.Start()
 	if serverChan != nil {
@@ -288,6 +322,71 @@ func loadBlockDB() (database.DB, error) {
 	return db, nil
 }
 
+// loadBalanceRepo opens the balance repository for the configured backend and
+// returns a handle to it.  A nil repository is returned when no backend has
+// been configured which disables the explorer.  Remote repositories are put
+// behind a write-behind cache unless it has been disabled.
+func loadBalanceRepo() (data.IBalanceRepository, error) {
+	repo, err := loadBalanceBackend()
+	if err != nil || repo == nil || cfg.BalanceCacheMaxSize == 0 {
+		return repo, err
+	}
+	switch cfg.BalanceBackend {
+	case "leveldb", "sqlite":
+		return repo, nil
+	}
+
+	btcdLog.Infof("Using a %d MiB balance repository cache",
+		cfg.BalanceCacheMaxSize)
+	cache, err := data.NewCachedBalanceRepository(repo,
+		uint64(cfg.BalanceCacheMaxSize)*1024*1024, cfg.BalanceCacheFlush)
+	if err != nil {
+		repo.Close()
+		return nil, err
+	}
+	return cache, nil
+}
+
+// loadBalanceBackend opens the balance repository for the configured backend.
+func loadBalanceBackend() (data.IBalanceRepository, error) {
+	switch cfg.BalanceBackend {
+	case "leveldb":
+		btcdLog.Infof("Loading balance repository from '%s'",
+			cfg.BalanceDbPath)
+		err := os.MkdirAll(cfg.BalanceDbPath, 0700)
+		if err != nil {
+			return nil, err
+		}
+		return data.NewLevelDbBalanceRepository(cfg.BalanceDbPath)
+
+	case "dynamo":
+		btcdLog.Infof("Using dynamo balance repository table '%s' in "+
+			"region %s", cfg.BalanceTable, cfg.BalanceRegion)
+		return data.NewDynamoBalanceRepository(cfg.BalanceAccount,
+			cfg.BalanceKey, cfg.BalanceRegion, cfg.BalanceEndpoint,
+			cfg.BalanceTable)
+
+	case "azuretable":
+		btcdLog.Infof("Using azure balance repository table '%s' in "+
+			"account %s", cfg.BalanceTable, cfg.BalanceAccount)
+		tableRepo, err := data.NewAzureStorageTableRepository(
+			cfg.BalanceAccount, cfg.BalanceKey, cfg.BalanceEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		return data.NewAzureBalanceRepository(tableRepo,
+			cfg.BalanceTable)
+
+	case "postgres", "sqlite":
+		btcdLog.Infof("Using %s balance repository table '%s'",
+			cfg.BalanceBackend, cfg.BalanceTable)
+		return data.NewSqlBalanceRepository(cfg.BalanceBackend,
+			cfg.BalanceDSN, cfg.BalanceTable)
+	}
+
+	return nil, nil
+}
+
 func main() {
 	// Use all processor cores.
 	runtime.GOMAXPROCS(runtime.NumCPU())
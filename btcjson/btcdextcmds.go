@@ -72,6 +72,73 @@ func NewGenerateCmd(numBlocks uint32) *GenerateCmd {
 	}
 }
 
+// GetAddressBalanceCmd defines the getaddressbalance JSON-RPC command.  This
+// command is not a standard Bitcoin command.  It is an extension for btcd.
+type GetAddressBalanceCmd struct {
+	Address string
+	Height  *int32
+}
+
+// NewGetAddressBalanceCmd returns a new instance which can be used to issue a
+// getaddressbalance JSON-RPC command.  This command is not a standard Bitcoin
+// command.  It is an extension for btcd.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewGetAddressBalanceCmd(address string, height *int32) *GetAddressBalanceCmd {
+	return &GetAddressBalanceCmd{
+		Address: address,
+		Height:  height,
+	}
+}
+
+// DumpBalancesCmd defines the dumpbalances JSON-RPC command.  This command is
+// not a standard Bitcoin command.  It is an extension for btcd.
+type DumpBalancesCmd struct {
+	Filename    string
+	Format      *string  `jsonrpcdefault:"\"csv\"" jsonrpcusage:"\"csv|jsonl\""`
+	MinBalance  *float64 `jsonrpcdefault:"0"`
+	AddressType *string
+}
+
+// NewDumpBalancesCmd returns a new instance which can be used to issue a
+// dumpbalances JSON-RPC command.  This command is not a standard Bitcoin
+// command.  It is an extension for btcd.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewDumpBalancesCmd(filename string, format *string, minBalance *float64, addressType *string) *DumpBalancesCmd {
+	return &DumpBalancesCmd{
+		Filename:    filename,
+		Format:      format,
+		MinBalance:  minBalance,
+		AddressType: addressType,
+	}
+}
+
+// GetAddressBalanceHistoryCmd defines the getaddressbalancehistory JSON-RPC
+// command.  This command is not a standard Bitcoin command.  It is an extension
+// for btcd.
+type GetAddressBalanceHistoryCmd struct {
+	Address    string
+	FromHeight *int32 `jsonrpcdefault:"0"`
+	ToHeight   *int32
+}
+
+// NewGetAddressBalanceHistoryCmd returns a new instance which can be used to
+// issue a getaddressbalancehistory JSON-RPC command.  This command is not a
+// standard Bitcoin command.  It is an extension for btcd.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewGetAddressBalanceHistoryCmd(address string, fromHeight, toHeight *int32) *GetAddressBalanceHistoryCmd {
+	return &GetAddressBalanceHistoryCmd{
+		Address:    address,
+		FromHeight: fromHeight,
+		ToHeight:   toHeight,
+	}
+}
+
 // GetBestBlockCmd defines the getbestblock JSON-RPC command.
 type GetBestBlockCmd struct{}
 
@@ -111,6 +178,26 @@ func NewGetHeadersCmd(blockLocators []string, hashStop string) *GetHeadersCmd {
 	}
 }
 
+// ListTopBalancesCmd defines the listtopbalances JSON-RPC command.  This
+// command is not a standard Bitcoin command.  It is an extension for btcd.
+type ListTopBalancesCmd struct {
+	Count      int
+	MinBalance *float64 `jsonrpcdefault:"0"`
+}
+
+// NewListTopBalancesCmd returns a new instance which can be used to issue a
+// listtopbalances JSON-RPC command.  This command is not a standard Bitcoin
+// command.  It is an extension for btcd.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewListTopBalancesCmd(count int, minBalance *float64) *ListTopBalancesCmd {
+	return &ListTopBalancesCmd{
+		Count:      count,
+		MinBalance: minBalance,
+	}
+}
+
 // VersionCmd defines the version JSON-RPC command.
 //
 // NOTE: This is a btcsuite extension ported from
@@ -130,9 +217,13 @@ func init() {
 
 	MustRegisterCmd("debuglevel", (*DebugLevelCmd)(nil), flags)
 	MustRegisterCmd("node", (*NodeCmd)(nil), flags)
+	MustRegisterCmd("dumpbalances", (*DumpBalancesCmd)(nil), flags)
 	MustRegisterCmd("generate", (*GenerateCmd)(nil), flags)
+	MustRegisterCmd("getaddressbalance", (*GetAddressBalanceCmd)(nil), flags)
+	MustRegisterCmd("getaddressbalancehistory", (*GetAddressBalanceHistoryCmd)(nil), flags)
 	MustRegisterCmd("getbestblock", (*GetBestBlockCmd)(nil), flags)
 	MustRegisterCmd("getcurrentnet", (*GetCurrentNetCmd)(nil), flags)
 	MustRegisterCmd("getheaders", (*GetHeadersCmd)(nil), flags)
+	MustRegisterCmd("listtopbalances", (*ListTopBalancesCmd)(nil), flags)
 	MustRegisterCmd("version", (*VersionCmd)(nil), flags)
 }
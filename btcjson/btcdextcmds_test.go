@@ -114,6 +114,100 @@ func TestBtcdExtCmds(t *testing.T) {
 				NumBlocks: 1,
 			},
 		},
+		{
+			name: "dumpbalances",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("dumpbalances", "balances.csv")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewDumpBalancesCmd("balances.csv", nil,
+					nil, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"dumpbalances","params":["balances.csv"],"id":1}`,
+			unmarshalled: &btcjson.DumpBalancesCmd{
+				Filename:   "balances.csv",
+				Format:     btcjson.String("csv"),
+				MinBalance: btcjson.Float64(0),
+			},
+		},
+		{
+			name: "dumpbalances optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("dumpbalances", "balances.jsonl",
+					"jsonl", 0.5, "pubkeyhash")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewDumpBalancesCmd("balances.jsonl",
+					btcjson.String("jsonl"), btcjson.Float64(0.5),
+					btcjson.String("pubkeyhash"))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"dumpbalances","params":["balances.jsonl","jsonl",0.5,"pubkeyhash"],"id":1}`,
+			unmarshalled: &btcjson.DumpBalancesCmd{
+				Filename:    "balances.jsonl",
+				Format:      btcjson.String("jsonl"),
+				MinBalance:  btcjson.Float64(0.5),
+				AddressType: btcjson.String("pubkeyhash"),
+			},
+		},
+		{
+			name: "getaddressbalance",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getaddressbalance", "1Address")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetAddressBalanceCmd("1Address", nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getaddressbalance","params":["1Address"],"id":1}`,
+			unmarshalled: &btcjson.GetAddressBalanceCmd{
+				Address: "1Address",
+			},
+		},
+		{
+			name: "getaddressbalance optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getaddressbalance", "1Address", 100)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetAddressBalanceCmd("1Address",
+					btcjson.Int32(100))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getaddressbalance","params":["1Address",100],"id":1}`,
+			unmarshalled: &btcjson.GetAddressBalanceCmd{
+				Address: "1Address",
+				Height:  btcjson.Int32(100),
+			},
+		},
+		{
+			name: "getaddressbalancehistory",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getaddressbalancehistory", "1Address")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetAddressBalanceHistoryCmd("1Address",
+					nil, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getaddressbalancehistory","params":["1Address"],"id":1}`,
+			unmarshalled: &btcjson.GetAddressBalanceHistoryCmd{
+				Address:    "1Address",
+				FromHeight: btcjson.Int32(0),
+			},
+		},
+		{
+			name: "getaddressbalancehistory optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getaddressbalancehistory", "1Address", 10, 20)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetAddressBalanceHistoryCmd("1Address",
+					btcjson.Int32(10), btcjson.Int32(20))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getaddressbalancehistory","params":["1Address",10,20],"id":1}`,
+			unmarshalled: &btcjson.GetAddressBalanceHistoryCmd{
+				Address:    "1Address",
+				FromHeight: btcjson.Int32(10),
+				ToHeight:   btcjson.Int32(20),
+			},
+		},
 		{
 			name: "getbestblock",
 			newCmd: func() (interface{}, error) {
@@ -176,6 +270,35 @@ func TestBtcdExtCmds(t *testing.T) {
 				HashStop: "000000000000000000ba33b33e1fad70b69e234fc24414dd47113bff38f523f7",
 			},
 		},
+		{
+			name: "listtopbalances",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("listtopbalances", 10)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewListTopBalancesCmd(10, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"listtopbalances","params":[10],"id":1}`,
+			unmarshalled: &btcjson.ListTopBalancesCmd{
+				Count:      10,
+				MinBalance: btcjson.Float64(0),
+			},
+		},
+		{
+			name: "listtopbalances optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("listtopbalances", 10, 0.5)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewListTopBalancesCmd(10,
+					btcjson.Float64(0.5))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"listtopbalances","params":[10,0.5],"id":1}`,
+			unmarshalled: &btcjson.ListTopBalancesCmd{
+				Count:      10,
+				MinBalance: btcjson.Float64(0.5),
+			},
+		},
 		{
 			name: "version",
 			newCmd: func() (interface{}, error) {
@@ -5,6 +5,48 @@
 
 package btcjson
 
+// GetAddressBalanceResult models the data from the getaddressbalance command.
+type GetAddressBalanceResult struct {
+	Address     string  `json:"address"`
+	Balance     float64 `json:"balance"`
+	Unconfirmed float64 `json:"unconfirmed"`
+	Total       float64 `json:"total"`
+	Received    float64 `json:"received"`
+	Sent        float64 `json:"sent"`
+	TxCount     int64   `json:"txcount"`
+	FirstSeen   int32   `json:"firstseen"`
+	LastSeen    int32   `json:"lastseen"`
+	Hash        string  `json:"hash"`
+	Height      int32   `json:"height"`
+}
+
+// BalanceChangeResult models the objects returned by the
+// getaddressbalancehistory command.
+type BalanceChangeResult struct {
+	Height   int32   `json:"height"`
+	TxID     string  `json:"txid"`
+	Delta    float64 `json:"delta"`
+	Received float64 `json:"received"`
+	Sent     float64 `json:"sent"`
+	Balance  float64 `json:"balance"`
+}
+
+// DumpBalancesResult models the data from the dumpbalances command.
+type DumpBalancesResult struct {
+	Filename  string `json:"filename"`
+	Format    string `json:"format"`
+	Hash      string `json:"hash"`
+	Height    int32  `json:"height"`
+	Count     int64  `json:"count"`
+	Untracked int64  `json:"untracked"`
+}
+
+// TopBalanceResult models the objects returned by the listtopbalances command.
+type TopBalanceResult struct {
+	Address string  `json:"address"`
+	Balance float64 `json:"balance"`
+}
+
 // VersionResult models objects included in the version response.  In the actual
 // result, these objects are keyed by the program or API name.
 //
@@ -95,6 +95,19 @@ func NewNotifyReceivedCmd(addresses []string) *NotifyReceivedCmd {
 	}
 }
 
+// NotifyBalanceChangesCmd defines the notifybalancechanges JSON-RPC command.
+type NotifyBalanceChangesCmd struct {
+	Addresses []string
+}
+
+// NewNotifyBalanceChangesCmd returns a new instance which can be used to issue
+// a notifybalancechanges JSON-RPC command.
+func NewNotifyBalanceChangesCmd(addresses []string) *NotifyBalanceChangesCmd {
+	return &NotifyBalanceChangesCmd{
+		Addresses: addresses,
+	}
+}
+
 // OutPoint describes a transaction outpoint that will be marshalled to and
 // from JSON.
 type OutPoint struct {
@@ -160,6 +173,20 @@ func NewStopNotifyReceivedCmd(addresses []string) *StopNotifyReceivedCmd {
 	}
 }
 
+// StopNotifyBalanceChangesCmd defines the stopnotifybalancechanges JSON-RPC
+// command.
+type StopNotifyBalanceChangesCmd struct {
+	Addresses []string
+}
+
+// NewStopNotifyBalanceChangesCmd returns a new instance which can be used to
+// issue a stopnotifybalancechanges JSON-RPC command.
+func NewStopNotifyBalanceChangesCmd(addresses []string) *StopNotifyBalanceChangesCmd {
+	return &StopNotifyBalanceChangesCmd{
+		Addresses: addresses,
+	}
+}
+
 // StopNotifySpentCmd defines the stopnotifyspent JSON-RPC command.
 //
 // NOTE: Deprecated. Use LoadTxFilterCmd instead.
@@ -227,11 +254,13 @@ func init() {
 
 	MustRegisterCmd("authenticate", (*AuthenticateCmd)(nil), flags)
 	MustRegisterCmd("loadtxfilter", (*LoadTxFilterCmd)(nil), flags)
+	MustRegisterCmd("notifybalancechanges", (*NotifyBalanceChangesCmd)(nil), flags)
 	MustRegisterCmd("notifyblocks", (*NotifyBlocksCmd)(nil), flags)
 	MustRegisterCmd("notifynewtransactions", (*NotifyNewTransactionsCmd)(nil), flags)
 	MustRegisterCmd("notifyreceived", (*NotifyReceivedCmd)(nil), flags)
 	MustRegisterCmd("notifyspent", (*NotifySpentCmd)(nil), flags)
 	MustRegisterCmd("session", (*SessionCmd)(nil), flags)
+	MustRegisterCmd("stopnotifybalancechanges", (*StopNotifyBalanceChangesCmd)(nil), flags)
 	MustRegisterCmd("stopnotifyblocks", (*StopNotifyBlocksCmd)(nil), flags)
 	MustRegisterCmd("stopnotifynewtransactions", (*StopNotifyNewTransactionsCmd)(nil), flags)
 	MustRegisterCmd("stopnotifyspent", (*StopNotifySpentCmd)(nil), flags)
@@ -126,6 +126,32 @@ func TestChainSvrWsCmds(t *testing.T) {
 				Addresses: []string{"1Address"},
 			},
 		},
+		{
+			name: "notifybalancechanges",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("notifybalancechanges", []string{"1Address"})
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewNotifyBalanceChangesCmd([]string{"1Address"})
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"notifybalancechanges","params":[["1Address"]],"id":1}`,
+			unmarshalled: &btcjson.NotifyBalanceChangesCmd{
+				Addresses: []string{"1Address"},
+			},
+		},
+		{
+			name: "stopnotifybalancechanges",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("stopnotifybalancechanges", []string{"1Address"})
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewStopNotifyBalanceChangesCmd([]string{"1Address"})
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"stopnotifybalancechanges","params":[["1Address"]],"id":1}`,
+			unmarshalled: &btcjson.StopNotifyBalanceChangesCmd{
+				Addresses: []string{"1Address"},
+			},
+		},
 		{
 			name: "notifyspent",
 			newCmd: func() (interface{}, error) {
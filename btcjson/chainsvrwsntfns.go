@@ -75,6 +75,12 @@ const (
 	// from the chain server that inform a client that a transaction that
 	// matches the loaded filter was accepted by the mempool.
 	RelevantTxAcceptedNtfnMethod = "relevanttxaccepted"
+
+	// BalanceChangedNtfnMethod is the method used for notifications from
+	// the chain server that the balance of an address registered with
+	// notifybalancechanges changed because a block was connected or
+	// disconnected.
+	BalanceChangedNtfnMethod = "balancechanged"
 )
 
 // BlockConnectedNtfn defines the blockconnected JSON-RPC notification.
@@ -285,6 +291,31 @@ func NewRelevantTxAcceptedNtfn(txHex string) *RelevantTxAcceptedNtfn {
 	return &RelevantTxAcceptedNtfn{Transaction: txHex}
 }
 
+// BalanceChangedNtfn defines the balancechanged JSON-RPC notification.
+type BalanceChangedNtfn struct {
+	Address   string
+	OldValue  float64
+	NewValue  float64
+	Delta     float64
+	BlockHash string
+	Height    int32
+}
+
+// NewBalanceChangedNtfn returns a new instance which can be used to issue a
+// balancechanged JSON-RPC notification.
+func NewBalanceChangedNtfn(address string, oldValue, newValue, delta float64,
+	blockHash string, height int32) *BalanceChangedNtfn {
+
+	return &BalanceChangedNtfn{
+		Address:   address,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		Delta:     delta,
+		BlockHash: blockHash,
+		Height:    height,
+	}
+}
+
 func init() {
 	// The commands in this file are only usable by websockets and are
 	// notifications.
@@ -301,4 +332,5 @@ func init() {
 	MustRegisterCmd(TxAcceptedNtfnMethod, (*TxAcceptedNtfn)(nil), flags)
 	MustRegisterCmd(TxAcceptedVerboseNtfnMethod, (*TxAcceptedVerboseNtfn)(nil), flags)
 	MustRegisterCmd(RelevantTxAcceptedNtfnMethod, (*RelevantTxAcceptedNtfn)(nil), flags)
+	MustRegisterCmd(BalanceChangedNtfnMethod, (*BalanceChangedNtfn)(nil), flags)
 }
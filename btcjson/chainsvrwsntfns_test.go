@@ -225,6 +225,24 @@ func TestChainSvrWsNtfns(t *testing.T) {
 				Transaction: "001122",
 			},
 		},
+		{
+			name: "balancechanged",
+			newNtfn: func() (interface{}, error) {
+				return btcjson.NewCmd("balancechanged", "1Address", 1.5, 0.5, -1.0, "123", 100000)
+			},
+			staticNtfn: func() interface{} {
+				return btcjson.NewBalanceChangedNtfn("1Address", 1.5, 0.5, -1, "123", 100000)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"balancechanged","params":["1Address",1.5,0.5,-1,"123",100000],"id":null}`,
+			unmarshalled: &btcjson.BalanceChangedNtfn{
+				Address:   "1Address",
+				OldValue:  1.5,
+				NewValue:  0.5,
+				Delta:     -1,
+				BlockHash: "123",
+				Height:    100000,
+			},
+		},
 	}
 
 	t.Logf("Running %d tests", len(tests))
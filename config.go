@@ -24,6 +24,7 @@ import (
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/connmgr"
+	"github.com/btcsuite/btcd/data"
 	"github.com/btcsuite/btcd/database"
 	_ "github.com/btcsuite/btcd/database/ffldb"
 	"github.com/btcsuite/btcd/mempool"
@@ -59,19 +60,31 @@ const (
 	defaultMaxOrphanTransactions = 100
 	defaultMaxOrphanTxSize       = 100000
 	defaultSigCacheMaxSize       = 100000
+	defaultUtxoCacheMaxSizeMiB   = 250
+	pruneMinMiB                  = 550
 	sampleConfigFilename         = "sample-btcd.conf"
 	defaultTxIndex               = false
 	defaultAddrIndex             = false
+	defaultBalanceIndex          = false
+	defaultBalanceDbDirname      = "balances"
+	defaultBalanceDumpDirname    = "balancedumps"
+	defaultBalanceSqliteFilename = "balances.sqlite"
+	defaultBalanceRegion         = "us-east-2"
+	defaultBalanceTable          = "balance"
+	defaultBalanceCacheMaxSize   = 100
+	defaultBalanceCacheFlush     = time.Minute * 5
 )
 
 var (
-	defaultHomeDir     = btcutil.AppDataDir("btcd", false)
-	defaultConfigFile  = filepath.Join(defaultHomeDir, defaultConfigFilename)
-	defaultDataDir     = filepath.Join(defaultHomeDir, defaultDataDirname)
-	knownDbTypes       = database.SupportedDrivers()
-	defaultRPCKeyFile  = filepath.Join(defaultHomeDir, "rpc.key")
-	defaultRPCCertFile = filepath.Join(defaultHomeDir, "rpc.cert")
-	defaultLogDir      = filepath.Join(defaultHomeDir, defaultLogDirname)
+	defaultHomeDir       = btcutil.AppDataDir("btcd", false)
+	defaultConfigFile    = filepath.Join(defaultHomeDir, defaultConfigFilename)
+	defaultDataDir       = filepath.Join(defaultHomeDir, defaultDataDirname)
+	knownDbTypes         = database.SupportedDrivers()
+	defaultRPCKeyFile    = filepath.Join(defaultHomeDir, "rpc.key")
+	defaultRPCCertFile   = filepath.Join(defaultHomeDir, "rpc.cert")
+	defaultLogDir        = filepath.Join(defaultHomeDir, defaultLogDirname)
+	knownBalanceBackends = []string{"leveldb", "dynamo", "azuretable",
+		"postgres", "sqlite"}
 )
 
 // runServiceCommand is only set to a real function on Windows.  It is used
@@ -151,11 +164,26 @@ type config struct {
 	UserAgentComments    []string      `long:"uacomment" description:"Comment to add to the user agent -- See BIP 14 for more information."`
 	NoPeerBloomFilters   bool          `long:"nopeerbloomfilters" description:"Disable bloom filtering support"`
 	SigCacheMaxSize      uint          `long:"sigcachemaxsize" description:"The maximum number of entries in the signature verification cache"`
+	UtxoCacheMaxSizeMiB  uint          `long:"utxocachemaxsize" description:"The maximum size in MiB of the utxo cache"`
+	Prune                uint64        `long:"prune" description:"Delete the oldest blocks once the stored blocks exceed the specified size in MiB -- Must be at least 550, 0 disables pruning"`
 	BlocksOnly           bool          `long:"blocksonly" description:"Do not accept transactions from remote peers."`
 	TxIndex              bool          `long:"txindex" description:"Maintain a full hash-based transaction index which makes all transactions available via the getrawtransaction RPC"`
 	DropTxIndex          bool          `long:"droptxindex" description:"Deletes the hash-based transaction index from the database on start up and then exits."`
 	AddrIndex            bool          `long:"addrindex" description:"Maintain a full address-based transaction index which makes the searchrawtransactions RPC available"`
 	DropAddrIndex        bool          `long:"dropaddrindex" description:"Deletes the address-based transaction index from the database on start up and then exits."`
+	BalanceIndex         bool          `long:"balanceindex" description:"Maintain an index of the change in balance every block caused for each address, which feeds the balance explorer"`
+	DropBalanceIndex     bool          `long:"dropbalanceindex" description:"Deletes the address balance index from the database on start up and then exits."`
+	BalanceBackend       string        `long:"balancebackend" description:"Backend the explorer records address balances to {leveldb, dynamo, azuretable, postgres, sqlite} -- The explorer is disabled when not set"`
+	BalanceDbPath        string        `long:"balancedbpath" description:"Directory of the leveldb balance repository (default: balances in the data directory)"`
+	BalanceEndpoint      string        `long:"balanceendpoint" description:"Service endpoint of the dynamo or azuretable balance repository -- Useful for pointing at a local emulator, or emulator to use the local Azure storage emulator"`
+	BalanceRegion        string        `long:"balanceregion" description:"AWS region of the dynamo balance repository"`
+	BalanceAccount       string        `long:"balanceaccount" description:"Access key id (dynamo) or storage account name (azuretable) for the balance repository"`
+	BalanceKey           string        `long:"balancekey" default-mask:"-" description:"Secret access key (dynamo) or storage account key (azuretable) for the balance repository"`
+	BalanceDSN           string        `long:"balancedsn" default-mask:"-" description:"Connection string (postgres) or database file (sqlite) of the balance repository (default sqlite: balances.sqlite in the data directory)"`
+	BalanceTable         string        `long:"balancetable" description:"Table name of the dynamo, azuretable, postgres or sqlite balance repository"`
+	BalanceCacheMaxSize  uint          `long:"balancecachemaxsize" description:"The maximum size in MiB of the write-behind cache in front of the dynamo, azuretable or postgres balance repository -- 0 disables the cache"`
+	BalanceCacheFlush    time.Duration `long:"balancecacheflush" description:"How long balance changes may be held in the write-behind cache before they are written to the balance repository.  Valid time units are {s, m, h}"`
+	BalanceSeparateP2PK  bool          `long:"balanceseparatep2pk" description:"Record the balance of pay-to-pubkey outputs under the public key instead of combining it with the pay-to-pubkey-hash address of the key -- Changing this requires the balance repository to be rebuilt"`
 	RelayNonStd          bool          `long:"relaynonstd" description:"Relay non-standard transactions regardless of the default settings for the active network."`
 	RejectNonStd         bool          `long:"rejectnonstd" description:"Reject non-standard transactions regardless of the default settings for the active network."`
 	lookup               func(string) ([]net.IP, error)
@@ -420,9 +448,15 @@ func loadConfig() (*config, []string, error) {
 		BlockPrioritySize:    mempool.DefaultBlockPrioritySize,
 		MaxOrphanTxs:         defaultMaxOrphanTransactions,
 		SigCacheMaxSize:      defaultSigCacheMaxSize,
+		UtxoCacheMaxSizeMiB:  defaultUtxoCacheMaxSizeMiB,
 		Generate:             defaultGenerate,
 		TxIndex:              defaultTxIndex,
 		AddrIndex:            defaultAddrIndex,
+		BalanceIndex:         defaultBalanceIndex,
+		BalanceRegion:        defaultBalanceRegion,
+		BalanceTable:         defaultBalanceTable,
+		BalanceCacheMaxSize:  defaultBalanceCacheMaxSize,
+		BalanceCacheFlush:    defaultBalanceCacheFlush,
 	}
 
 	// Service options which are only added on Windows.
@@ -611,6 +645,70 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Validate the balance repository backend and the options it requires.
+	switch cfg.BalanceBackend {
+	case "":
+	case "leveldb":
+		if cfg.BalanceDbPath == "" {
+			cfg.BalanceDbPath = filepath.Join(cfg.DataDir,
+				defaultBalanceDbDirname)
+		}
+		cfg.BalanceDbPath = cleanAndExpandPath(cfg.BalanceDbPath)
+	case "dynamo", "azuretable":
+		emulator := cfg.BalanceBackend == "azuretable" &&
+			cfg.BalanceEndpoint == data.AzureEmulatorEndpoint
+		if !emulator && (cfg.BalanceAccount == "" || cfg.BalanceKey == "") {
+			str := "%s: The %s balance backend requires the " +
+				"--balanceaccount and --balancekey options"
+			err := fmt.Errorf(str, funcName, cfg.BalanceBackend)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		if cfg.BalanceTable == "" {
+			str := "%s: The %s balance backend requires a table name"
+			err := fmt.Errorf(str, funcName, cfg.BalanceBackend)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		if cfg.BalanceBackend == "dynamo" && cfg.BalanceRegion == "" {
+			str := "%s: The dynamo balance backend requires a region"
+			err := fmt.Errorf(str, funcName)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+	case "postgres", "sqlite":
+		if cfg.BalanceDSN == "" {
+			if cfg.BalanceBackend == "postgres" {
+				str := "%s: The postgres balance backend " +
+					"requires the --balancedsn option"
+				err := fmt.Errorf(str, funcName)
+				fmt.Fprintln(os.Stderr, err)
+				fmt.Fprintln(os.Stderr, usageMessage)
+				return nil, nil, err
+			}
+			cfg.BalanceDSN = filepath.Join(cfg.DataDir,
+				defaultBalanceSqliteFilename)
+		}
+		if cfg.BalanceTable == "" {
+			str := "%s: The %s balance backend requires a table name"
+			err := fmt.Errorf(str, funcName, cfg.BalanceBackend)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+	default:
+		str := "%s: The specified balance backend [%v] is invalid -- " +
+			"supported backends %v"
+		err := fmt.Errorf(str, funcName, cfg.BalanceBackend,
+			knownBalanceBackends)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Validate profile port number
 	if cfg.Profile != "" {
 		profilePort, err := strconv.Atoi(cfg.Profile)
@@ -756,6 +854,17 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Ensure the prune target leaves room for the blocks that are always
+	// kept.
+	if cfg.Prune != 0 && cfg.Prune < pruneMinMiB {
+		str := "%s: The prune option must be 0 or at least %d " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, pruneMinMiB, cfg.Prune)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Limit the max block size to a sane value.
 	if cfg.BlockMaxSize < blockMaxSizeMin || cfg.BlockMaxSize >
 		blockMaxSizeMax {
@@ -858,6 +967,72 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// --balanceindex and --dropbalanceindex do not mix.
+	if cfg.BalanceIndex && cfg.DropBalanceIndex {
+		err := fmt.Errorf("%s: the --balanceindex and "+
+			"--dropbalanceindex options may not be activated at "+
+			"the same time", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --balanceindex and --droptxindex do not mix.
+	if cfg.BalanceIndex && cfg.DropTxIndex {
+		err := fmt.Errorf("%s: the --balanceindex and --droptxindex "+
+			"options may not be activated at the same time "+
+			"because the address balance index relies on the "+
+			"transaction index",
+			funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --balancebackend and --droptxindex or --dropbalanceindex do not mix.
+	if cfg.BalanceBackend != "" && (cfg.DropTxIndex || cfg.DropBalanceIndex) {
+		err := fmt.Errorf("%s: the --balancebackend option may not be "+
+			"activated at the same time as the --droptxindex or "+
+			"--dropbalanceindex options because the balance "+
+			"explorer relies on the address balance index, which "+
+			"relies on the transaction index",
+			funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --prune and --txindex do not mix.
+	if cfg.Prune != 0 && cfg.TxIndex {
+		err := fmt.Errorf("%s: the --prune and --txindex options may "+
+			"not be activated at the same time because the "+
+			"transaction index requires all blocks", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --prune and --addrindex do not mix.
+	if cfg.Prune != 0 && cfg.AddrIndex {
+		err := fmt.Errorf("%s: the --prune and --addrindex options may "+
+			"not be activated at the same time because the "+
+			"address index requires all blocks", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --prune and --balanceindex or --balancebackend do not mix.
+	if cfg.Prune != 0 && (cfg.BalanceIndex || cfg.BalanceBackend != "") {
+		err := fmt.Errorf("%s: the --prune option may not be "+
+			"activated at the same time as the --balanceindex or "+
+			"--balancebackend options because they rely on the "+
+			"transaction index", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Check mining addresses are valid and saved parsed versions.
 	cfg.miningAddrs = make([]btcutil.Address, 0, len(cfg.MiningAddrs))
 	for _, strAddr := range cfg.MiningAddrs {
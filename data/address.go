@@ -0,0 +1,134 @@
+package data
+
+import (
+	"errors"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// NonstandardKey is the key the value of outputs with a script that
+	// does not pay to an address is recorded under.  Such outputs might
+	// still be spent.
+	NonstandardKey = reservedKeyPrefix + "nonstandard"
+
+	// UnspendableKey is the key the value of provably unspendable outputs,
+	// such as null data outputs, is recorded under.
+	UnspendableKey = reservedKeyPrefix + "unspendable"
+)
+
+// errUnsupportedAddressType is returned by AddressKey for address types the
+// balance repositories do not track.
+var errUnsupportedAddressType = errors.New("address type is not " +
+	"supported by the balance repository")
+
+// KeyPolicy defines the keys the value of transaction outputs is recorded under
+// in the balance repositories.  Every output script maps to exactly one key, so
+// the sum of all balances, including the ones recorded under NonstandardKey and
+// UnspendableKey, always equals the value of all unspent outputs.
+//
+// Changing the policy changes the keys of existing balances, so a repository
+// has to be rebuilt from scratch when it does.
+type KeyPolicy struct {
+	// ChainParams identifies which chain the addresses belong to.
+	ChainParams *chaincfg.Params
+
+	// SeparateP2PK records pay-to-pubkey outputs under the hex-encoded
+	// public key instead of the associated pay-to-pubkey-hash address.
+	// Both are spent by the same key, so they are combined by default.
+	SeparateP2PK bool
+}
+
+// AddressKey returns the key the balance of the passed address is stored
+// under in the balance repositories.
+func (p *KeyPolicy) AddressKey(addr btcutil.Address) (string, error) {
+	switch addr := addr.(type) {
+	case *btcutil.AddressPubKeyHash:
+		return addr.EncodeAddress(), nil
+
+	case *btcutil.AddressScriptHash:
+		return addr.EncodeAddress(), nil
+
+	case *btcutil.AddressPubKey:
+		if p.SeparateP2PK {
+			return addr.String(), nil
+		}
+		return addr.AddressPubKeyHash().EncodeAddress(), nil
+
+	case *btcutil.AddressWitnessScriptHash:
+		return addr.EncodeAddress(), nil
+
+	case *btcutil.AddressWitnessPubKeyHash:
+		return addr.EncodeAddress(), nil
+	}
+
+	return "", errUnsupportedAddressType
+}
+
+// ScriptKey returns the key the value of an output with the passed public key
+// script is recorded under.  Outputs paying to a single address are recorded
+// under the key of the address.  Bare multisig outputs are recorded under the
+// pay-to-script-hash address of the multisig script, so they are combined with
+// the balance of pay-to-script-hash outputs using the same script.  Provably
+// unspendable outputs are recorded under UnspendableKey and all others under
+// NonstandardKey.
+func (p *KeyPolicy) ScriptKey(pkScript []byte) string {
+	if txscript.IsUnspendable(pkScript) {
+		return UnspendableKey
+	}
+
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript,
+		p.ChainParams)
+	if err != nil {
+		return NonstandardKey
+	}
+	if class == txscript.MultiSigTy {
+		addr, err := btcutil.NewAddressScriptHash(pkScript,
+			p.ChainParams)
+		if err != nil {
+			return NonstandardKey
+		}
+		return addr.EncodeAddress()
+	}
+	if len(addrs) != 1 {
+		return NonstandardKey
+	}
+	key, err := p.AddressKey(addrs[0])
+	if err != nil {
+		return NonstandardKey
+	}
+	return key
+}
+
+// KeyClass returns the class of the output scripts whose value is recorded
+// under the passed key.  Pay-to-script-hash keys include the value of bare
+// multisig outputs.  UnspendableKey is reported as null data and NonstandardKey
+// as well as any other key that is not an address as non-standard.
+func (p *KeyPolicy) KeyClass(key string) txscript.ScriptClass {
+	switch key {
+	case NonstandardKey:
+		return txscript.NonStandardTy
+	case UnspendableKey:
+		return txscript.NullDataTy
+	}
+
+	addr, err := btcutil.DecodeAddress(key, p.ChainParams)
+	if err != nil {
+		return txscript.NonStandardTy
+	}
+	switch addr.(type) {
+	case *btcutil.AddressPubKeyHash:
+		return txscript.PubKeyHashTy
+	case *btcutil.AddressScriptHash:
+		return txscript.ScriptHashTy
+	case *btcutil.AddressPubKey:
+		return txscript.PubKeyTy
+	case *btcutil.AddressWitnessPubKeyHash:
+		return txscript.WitnessV0PubKeyHashTy
+	case *btcutil.AddressWitnessScriptHash:
+		return txscript.WitnessV0ScriptHashTy
+	}
+	return txscript.NonStandardTy
+}
@@ -0,0 +1,117 @@
+package data
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+)
+
+// hexToBytes converts the passed hex string into bytes and will panic if there
+// is an error.  This is only provided for the hard-coded constants so errors in
+// the source code can be detected.  It will only (and must only) be called with
+// hard-coded values.
+func hexToBytes(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex in source file: " + s)
+	}
+	return b
+}
+
+// TestScriptKey ensures the key policy records the value of every kind of
+// output script under the expected key.
+func TestScriptKey(t *testing.T) {
+	t.Parallel()
+
+	params := &chaincfg.MainNetParams
+	pubKey := hexToBytes("0411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b" +
+		"1482ecad7b148a6909a5cb2e0eaddfb84ccf9744464f82e160bfa9b8b64f9d4c0" +
+		"3f999b8643f656b412a3")
+	p2pk := hexToBytes("41" + hex.EncodeToString(pubKey) + "ac")
+
+	addrPubKey, err := btcutil.NewAddressPubKey(pubKey, params)
+	if err != nil {
+		t.Fatalf("NewAddressPubKey: unexpected error: %v", err)
+	}
+	p2pkh, err := txscript.PayToAddrScript(addrPubKey.AddressPubKeyHash())
+	if err != nil {
+		t.Fatalf("PayToAddrScript: unexpected error: %v", err)
+	}
+	multiSig, err := txscript.MultiSigScript(
+		[]*btcutil.AddressPubKey{addrPubKey}, 1)
+	if err != nil {
+		t.Fatalf("MultiSigScript: unexpected error: %v", err)
+	}
+	multiSigAddr, err := btcutil.NewAddressScriptHash(multiSig, params)
+	if err != nil {
+		t.Fatalf("NewAddressScriptHash: unexpected error: %v", err)
+	}
+	nullData, err := txscript.NullDataScript([]byte("data"))
+	if err != nil {
+		t.Fatalf("NullDataScript: unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		pkScript     []byte
+		separateP2PK bool
+		want         string
+	}{
+		{
+			name:     "p2pkh",
+			pkScript: p2pkh,
+			want:     "12cbQLTFMXRnSzktFkuoG3eHoMeFtpTu3S",
+		},
+		{
+			name:     "p2pk merged",
+			pkScript: p2pk,
+			want:     "12cbQLTFMXRnSzktFkuoG3eHoMeFtpTu3S",
+		},
+		{
+			name:         "p2pk separate",
+			pkScript:     p2pk,
+			separateP2PK: true,
+			want:         hex.EncodeToString(pubKey),
+		},
+		{
+			name:     "bare multisig",
+			pkScript: multiSig,
+			want:     multiSigAddr.EncodeAddress(),
+		},
+		{
+			name:     "null data",
+			pkScript: nullData,
+			want:     UnspendableKey,
+		},
+		{
+			name:     "unparsable",
+			pkScript: []byte{txscript.OP_DATA_2, 0x01},
+			want:     UnspendableKey,
+		},
+		{
+			name:     "nonstandard",
+			pkScript: []byte{txscript.OP_TRUE},
+			want:     NonstandardKey,
+		},
+		{
+			name:     "empty",
+			pkScript: nil,
+			want:     NonstandardKey,
+		},
+	}
+
+	for _, test := range tests {
+		policy := &KeyPolicy{
+			ChainParams:  params,
+			SeparateP2PK: test.separateP2PK,
+		}
+		got := policy.ScriptKey(test.pkScript)
+		if got != test.want {
+			t.Errorf("%s: mismatched key -- got %s, want %s",
+				test.name, got, test.want)
+		}
+	}
+}
@@ -1,60 +1,609 @@
 package data
 
 import (
+	"fmt"
+	"math"
+
 	"github.com/azure/azure-sdk-for-go/storage"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
 
 type AzureBalanceRepository struct {
 	tableRepository AzureStorageTableRepository
 	table           *storage.Table
+	historyTable    *storage.Table
 }
 
+// azureMaxConflictRetries is the number of times a balance update is retried
+// when the balance entity was changed since it was read.
+const azureMaxConflictRetries = 3
+
+// NewAzureBalanceRepository returns a balance repository backed by the table
+// with the passed name, creating it when it doesn't exist yet.
+//
+// Every address is its own partition, keyed by its public key with an empty row
+// key, so balances are read and written with point operations.  The balance
+// history is kept in a second table named after the first one with a history
+// suffix, where the history entities of an address share its partition, so
+// they are written in entity-group transactions.
 func NewAzureBalanceRepository(repository AzureStorageTableRepository, tableName string) (*AzureBalanceRepository, error) {
 	repo := new(AzureBalanceRepository)
 	repo.tableRepository = repository
 
-	table, _ := repo.tableRepository.Ensure(tableName)
-	if table == nil {
-		return nil, nil
+	table, err := repo.tableRepository.Ensure(tableName)
+	if err != nil {
+		return nil, err
 	}
 	repo.table = table
 
+	historyTable, err := repo.tableRepository.Ensure(tableName + "history")
+	if err != nil {
+		return nil, err
+	}
+	repo.historyTable = historyTable
+
 	return repo, nil
 }
 
 func (t *AzureBalanceRepository) Get(partitionKey string) (*Balance, error) {
-	entities, err := t.tableRepository.Get(partitionKey, "", t.table)
-
-	if err != nil || len(entities) == 0 {
+	entity, err := t.tableRepository.Get(partitionKey, "", t.table)
+	if err != nil || entity == nil {
 		return nil, err
 	}
 
-	prop := entities[0].Properties
-	balance := &Balance{
-		PublicKey: entities[0].PartitionKey,
-		Value:     prop["Value"].(int64),
+	return parseBalanceEntity(entity), nil
+}
+
+// Insert records the passed balance, replacing the existing balance of the
+// address, if any.
+func (t *AzureBalanceRepository) Insert(balance *Balance) error {
+	return t.Update(balance)
+}
+
+// Update records the passed balance.  The existing balance entity is merged
+// with the ETag it was read with, so the height of its last change is kept and
+// a concurrent change is never overwritten.
+func (t *AzureBalanceRepository) Update(balance *Balance) error {
+	props := balanceProps(balance)
+
+	return retryAzureConflicts(func() error {
+		entity, err := t.tableRepository.Get(balance.PublicKey, "",
+			t.table)
+		if err != nil {
+			return err
+		}
+		if entity == nil {
+			return t.tableRepository.Insert(balance.PublicKey, "",
+				props, t.table)
+		}
+		return t.tableRepository.Update(entity, props)
+	})
+}
+
+// ConnectBlock adds the passed balance changes to the stored balances, records
+// them in the history table and then records the passed block as the tip.
+func (t *AzureBalanceRepository) ConnectBlock(hash *chainhash.Hash, height int32, deltas map[string][]BalanceDelta) error {
+	return t.ConnectBlocks([]*BlockDeltas{{
+		Hash:   *hash,
+		Height: height,
+		Deltas: deltas,
+	}})
+}
+
+// ConnectBlocks adds the balance changes of all of the passed blocks to the
+// stored balances, records them in the history table and then records the last
+// block as the tip.
+//
+// Entity-group transactions can only span entities that share a partition key
+// while every address is its own partition, so the changes can't be written in
+// one transaction.  Instead, every entity remembers the height of the last
+// change it was updated with and entities that are already updated with some of
+// the connected changes are only updated with the later ones.  Tracking the
+// height of the last change rather than the one of the last block keeps an
+// address that isn't touched by the disconnected blocks of a reorganization
+// from skipping the changes of the blocks that replace them.  Replaying blocks
+// that were only partially applied before a crash therefore results in the same
+// balances as an atomic write, even when the blocks are replayed in different
+// batches.  Updates are merged with the ETag of the entity that was read so a
+// concurrent writer can't be silently overwritten, and they are retried from
+// the read when the entity was changed meanwhile, such as by an earlier attempt
+// whose response was lost.  History entities are keyed by height and position,
+// so writing them again is harmless.  The running balances they record are
+// rebuilt from the last history entity before the blocks when the entity was
+// already updated with some of them, since its value may include the changes of
+// later blocks as well.
+func (t *AzureBalanceRepository) ConnectBlocks(blocks []*BlockDeltas) error {
+	if len(blocks) == 0 {
+		return nil
 	}
 
-	return balance, nil
+	last := blocks[len(blocks)-1]
+	for publicKey, changes := range groupByKey(blocks) {
+		err := retryAzureConflicts(func() error {
+			return t.connectBalance(publicKey, changes)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.putTip(last.Hash.String(), last.Height)
 }
 
-func (t *AzureBalanceRepository) Insert(balance *Balance) error {
-	props := map[string]interface{}{
-		"Value": balance.Value,
+// connectBalance writes the history entities of the passed changes to the
+// balance of the passed public key and adds the ones that haven't been applied
+// yet to its balance entity.
+func (t *AzureBalanceRepository) connectBalance(publicKey string, changes []historyDelta) error {
+	entity, err := t.tableRepository.Get(publicKey, "", t.table)
+	if err != nil {
+		return err
+	}
+
+	// Determine the balance before the changes, taking into account that
+	// some of them might already have been applied before the previous
+	// shutdown, possibly in a different batch.
+	balance := &Balance{PublicKey: publicKey}
+	appliedHeight := int64(-1)
+	if entity != nil {
+		balance = parseBalanceEntity(entity)
+		if height, ok := entity.Properties["LastHeight"].(int64); ok {
+			appliedHeight = height
+		}
+	}
+	applied := changes
+	for len(applied) > 0 && int64(applied[len(applied)-1].height) > appliedHeight {
+		applied = applied[:len(applied)-1]
+	}
+	value := balance.Value
+	if len(applied) != 0 {
+		prev, err := t.queryHistory(publicKey, 0, changes[0].height-1, 1)
+		if err != nil {
+			return err
+		}
+		value = 0
+		if len(prev) != 0 {
+			entry, err := parseHistoryEntity(prev[0])
+			if err != nil {
+				return err
+			}
+			value = entry.Balance
+		}
 	}
 
-	return t.tableRepository.Insert(balance.PublicKey, "", props, t.table)
+	batch := newAzureBatch(t.historyTable)
+	for i := range changes {
+		change := &changes[i]
+		value += change.delta.Value
+		historyEntity := t.historyTable.GetEntityReference(publicKey,
+			historyRowKey(change.height, change.seq))
+		historyEntity.Properties = map[string]interface{}{
+			"Height":   int64(change.height),
+			"TxHash":   change.delta.TxHash.String(),
+			"Delta":    change.delta.Value,
+			"Received": change.delta.Received,
+			"Sent":     change.delta.Sent,
+			"Balance":  value,
+		}
+		if err := batch.insertOrReplace(historyEntity); err != nil {
+			return err
+		}
+	}
+	if err := batch.execute(); err != nil {
+		return err
+	}
+
+	if len(applied) == len(changes) {
+		return nil
+	}
+	for i := range changes[len(applied):] {
+		change := &changes[len(applied)+i]
+		balance.connect(change.height, []BalanceDelta{*change.delta})
+	}
+	props := balanceProps(balance)
+	props["LastHeight"] = int64(changes[len(changes)-1].height)
+	if entity == nil {
+		return t.tableRepository.Insert(publicKey, "", props, t.table)
+	}
+	return t.tableRepository.Update(entity, props)
 }
 
-func (t *AzureBalanceRepository) Update(balance *Balance) error {
+// DisconnectBlock reverses the passed balance changes, removes their history
+// entities and then records the parent of the block as the tip.  Like
+// ConnectBlocks, replaying a partially applied disconnect skips the entities
+// that were already updated.  The last seen height and the height of the last
+// change are restored from the history entities below the block, which are left
+// untouched.
+func (t *AzureBalanceRepository) DisconnectBlock(prevHash *chainhash.Hash, height int32, deltas map[string][]BalanceDelta) error {
+	for publicKey, addrDeltas := range deltas {
+		if len(addrDeltas) == 0 {
+			continue
+		}
+
+		err := retryAzureConflicts(func() error {
+			return t.disconnectBalance(publicKey, height,
+				addrDeltas)
+		})
+		if err != nil {
+			return err
+		}
+
+		// Only delete the history entities that still exist since
+		// deleting a missing entity fails the whole batch.
+		history, err := t.queryHistory(publicKey, height, height, 0)
+		if err != nil {
+			return err
+		}
+		batch := newAzureBatch(t.historyTable)
+		for _, entity := range history {
+			if err := batch.delete(entity); err != nil {
+				return err
+			}
+		}
+		if err := batch.execute(); err != nil {
+			return err
+		}
+	}
+
+	return t.putTip(prevHash.String(), height-1)
+}
+
+// disconnectBalance reverses the passed changes of the block at the passed
+// height in the balance entity of the passed public key unless that was done
+// already.
+func (t *AzureBalanceRepository) disconnectBalance(publicKey string, height int32, deltas []BalanceDelta) error {
+	entity, err := t.tableRepository.Get(publicKey, "", t.table)
+	if err != nil {
+		return err
+	}
+	balance := &Balance{PublicKey: publicKey}
+	if entity != nil {
+		balance = parseBalanceEntity(entity)
+		lastHeight, ok := entity.Properties["LastHeight"].(int64)
+		if ok && lastHeight < int64(height) {
+			return nil
+		}
+	}
+
+	prev, err := t.queryHistory(publicKey, 0, height-1, 1)
+	if err != nil {
+		return err
+	}
+	var prevLastSeen int32
+	prevHeight := int64(-1)
+	if len(prev) != 0 {
+		entry, err := parseHistoryEntity(prev[0])
+		if err != nil {
+			return err
+		}
+		prevLastSeen = entry.Height
+		prevHeight = int64(entry.Height)
+	}
+	balance.disconnect(deltas, prevLastSeen)
+	props := balanceProps(balance)
+	props["LastHeight"] = prevHeight
+	if entity == nil {
+		return t.tableRepository.Insert(publicKey, "", props, t.table)
+	}
+	return t.tableRepository.Update(entity, props)
+}
+
+// putTip records the passed block as the tip of the repository with a single
+// unconditional write.
+func (t *AzureBalanceRepository) putTip(tip string, height int32) error {
 	props := map[string]interface{}{
-		"Value": balance.Value,
+		"Hash":   tip,
+		"Height": int64(height),
+	}
+	return t.tableRepository.Put(tipKey, "", props, t.table)
+}
+
+func (t *AzureBalanceRepository) Tip() (*chainhash.Hash, int32, error) {
+	entity, err := t.tableRepository.Get(tipKey, "", t.table)
+	if err != nil || entity == nil {
+		return nil, 0, err
+	}
+
+	prop := entity.Properties
+	hashStr, ok := prop["Hash"].(string)
+	height, ok2 := prop["Height"].(int64)
+	if !ok || !ok2 {
+		return nil, 0, fmt.Errorf("corrupt balance repository tip %v",
+			prop)
+	}
+	hash, err := chainhash.NewHashFromStr(hashStr)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return hash, int32(height), nil
+}
+
+// TopBalances queries every entity with a large enough balance since table
+// storage has no way to order entities by a property.
+func (t *AzureBalanceRepository) TopBalances(count int, minValue int64) ([]*Balance, error) {
+	top := newTopBalances(count)
+	err := t.queryBalances(minValue, func(balance *Balance) error {
+		top.add(balance)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return top.sorted(), nil
+}
+
+// ForEachBalance queries every entity in the order of their partition keys.
+func (t *AzureBalanceRepository) ForEachBalance(fn func(balance *Balance) error) error {
+	return t.queryBalances(math.MinInt64, fn)
+}
+
+// CountBalances queries every entity with a large enough balance since table
+// storage has no way to count entities on the server.
+func (t *AzureBalanceRepository) CountBalances(minValue int64) (int64, error) {
+	var count int64
+	err := t.queryBalances(minValue, func(balance *Balance) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// queryBalances invokes the passed function with every balance of at least the
+// passed value, following the continuation tokens.  Reserved keys are excluded
+// by skipping the range of partition keys that start with the reserved prefix.
+func (t *AzureBalanceRepository) queryBalances(minValue int64, fn func(balance *Balance) error) error {
+	reservedEnd := string(reservedKeyPrefix[0] + 1)
+	options := storage.QueryOptions{
+		Filter: fmt.Sprintf("Value ge %dL and (PartitionKey lt '%s' or "+
+			"PartitionKey ge '%s')", minValue, reservedKeyPrefix,
+			reservedEnd),
+		Select: []string{"PartitionKey", "Value", "Received", "Sent",
+			"TxCount", "FirstSeen", "LastSeen"},
+	}
+	res, err := t.table.QueryEntities(30, storage.FullMetadata, &options)
+	if err != nil {
+		return err
+	}
+
+	for res != nil {
+		for _, entity := range res.Entities {
+			if _, ok := entity.Properties["Value"].(int64); !ok {
+				continue
+			}
+			if err := fn(parseBalanceEntity(entity)); err != nil {
+				return err
+			}
+		}
+		if res.NextLink == nil {
+			break
+		}
+		res, err = res.NextResults(nil)
+		if err != nil {
+			return err
+		}
 	}
 
-	entities, err := t.tableRepository.Get(balance.PublicKey, "", t.table)
+	return nil
+}
+
+// BalanceHistory queries the history entities of the passed public key.  Their
+// row keys sort newest first, so the result is reversed into chain order.
+func (t *AzureBalanceRepository) BalanceHistory(publicKey string, fromHeight, toHeight int32) ([]*BalanceHistoryEntry, error) {
+	if fromHeight > toHeight {
+		return nil, nil
+	}
+
+	history, err := t.queryHistory(publicKey, fromHeight, toHeight, 0)
 	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*BalanceHistoryEntry, len(history))
+	for i, entity := range history {
+		entry, err := parseHistoryEntity(entity)
+		if err != nil {
+			return nil, err
+		}
+		entries[len(history)-1-i] = entry
+	}
+	return entries, nil
+}
+
+// BalanceAtHeight queries the first history entity of the passed public key at
+// or below the passed height, which is the most recent one since their row
+// keys sort newest first.
+func (t *AzureBalanceRepository) BalanceAtHeight(publicKey string, height int32) (int64, error) {
+	history, err := t.queryHistory(publicKey, 0, height, 1)
+	if err != nil || len(history) == 0 {
+		return 0, err
+	}
+	entry, err := parseHistoryEntity(history[0])
+	if err != nil {
+		return 0, err
+	}
+	return entry.Balance, nil
+}
+
+// queryHistory returns the history entities of the passed public key in blocks
+// fromHeight through toHeight, newest first, following the continuation
+// tokens.  A non-zero limit stops the query once that many entities were
+// returned.
+func (t *AzureBalanceRepository) queryHistory(publicKey string, fromHeight, toHeight int32, limit int) ([]*storage.Entity, error) {
+	options := storage.QueryOptions{
+		Filter: fmt.Sprintf("PartitionKey eq %s and RowKey ge '%s' "+
+			"and RowKey le '%s'", odataString(publicKey),
+			historyRowKey(toHeight, math.MaxUint32),
+			historyRowKey(fromHeight, 0)),
+		Top: uint(limit),
+	}
+	res, err := t.historyTable.QueryEntities(30, storage.FullMetadata,
+		&options)
+	if err != nil {
+		return nil, err
+	}
+
+	var entities []*storage.Entity
+	for res != nil {
+		entities = append(entities, res.Entities...)
+		if res.NextLink == nil || (limit > 0 && len(entities) >= limit) {
+			break
+		}
+		res, err = res.NextResults(nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if limit > 0 && len(entities) > limit {
+		entities = entities[:limit]
+	}
+
+	return entities, nil
+}
+
+func (t *AzureBalanceRepository) Close() error {
+	return nil
+}
+
+// historyRowKey returns the row key of the history entity for the change with
+// the passed sequence number within the block at the passed height.  Table
+// storage only returns entities in ascending row key order, so both numbers are
+// inverted to have the most recent change sort first, which allows the balance
+// at a height to be looked up without reading the entire history.
+func historyRowKey(height int32, seq uint32) string {
+	return fmt.Sprintf("%010d%010d", math.MaxInt32-height,
+		math.MaxUint32-seq)
+}
+
+// parseHistoryEntity decodes the passed history entity.
+func parseHistoryEntity(entity *storage.Entity) (*BalanceHistoryEntry, error) {
+	prop := entity.Properties
+	txHashStr, ok := prop["TxHash"].(string)
+	height, ok2 := prop["Height"].(int64)
+	delta, ok3 := prop["Delta"].(int64)
+	balance, ok4 := prop["Balance"].(int64)
+	if !ok || !ok2 || !ok3 || !ok4 {
+		return nil, fmt.Errorf("corrupt balance history entity %s/%s",
+			entity.PartitionKey, entity.RowKey)
+	}
+	txHash, err := chainhash.NewHashFromStr(txHashStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BalanceHistoryEntry{
+		Height:   int32(height),
+		TxHash:   *txHash,
+		Delta:    delta,
+		Received: int64Prop(prop, "Received"),
+		Sent:     int64Prop(prop, "Sent"),
+		Balance:  balance,
+	}, nil
+}
+
+// balanceProps returns the properties the passed balance is stored with.
+func balanceProps(balance *Balance) map[string]interface{} {
+	return map[string]interface{}{
+		"Value":     balance.Value,
+		"Received":  balance.Received,
+		"Sent":      balance.Sent,
+		"TxCount":   balance.TxCount,
+		"FirstSeen": int64(balance.FirstSeen),
+		"LastSeen":  int64(balance.LastSeen),
+	}
+}
+
+// parseBalanceEntity decodes the passed balance entity.
+func parseBalanceEntity(entity *storage.Entity) *Balance {
+	prop := entity.Properties
+	return &Balance{
+		PublicKey: entity.PartitionKey,
+		Value:     int64Prop(prop, "Value"),
+		Received:  int64Prop(prop, "Received"),
+		Sent:      int64Prop(prop, "Sent"),
+		TxCount:   int64Prop(prop, "TxCount"),
+		FirstSeen: int32(int64Prop(prop, "FirstSeen")),
+		LastSeen:  int32(int64Prop(prop, "LastSeen")),
+	}
+}
+
+// int64Prop returns the passed 64-bit integer property.  Entities written
+// before a property was introduced lack it, so a missing property is treated
+// as zero.
+func int64Prop(props map[string]interface{}, name string) int64 {
+	value, _ := props[name].(int64)
+	return value
+}
+
+// retryAzureConflicts invokes the passed read-modify-write function and invokes
+// it again, which reads the entity again, when its write conflicted with a
+// change of the entity since it was read, up to azureMaxConflictRetries times.
+func retryAzureConflicts(fn func() error) error {
+	for i := 0; ; i++ {
+		err := fn()
+		if !isAzureConflict(err) || i == azureMaxConflictRetries {
+			return err
+		}
+	}
+}
+
+// azureMaxBatchSize is the maximum number of operations table storage accepts
+// in a single entity-group transaction.
+const azureMaxBatchSize = 100
+
+// azureBatch queues operations on entities of a single partition and executes
+// them in entity-group transactions of the maximum size.
+type azureBatch struct {
+	table *storage.Table
+	batch *storage.TableBatch
+	size  int
+}
+
+// newAzureBatch returns an empty batch for the passed table.
+func newAzureBatch(table *storage.Table) *azureBatch {
+	return &azureBatch{table: table}
+}
+
+// insertOrReplace queues the passed entity to be inserted or replaced.
+func (b *azureBatch) insertOrReplace(entity *storage.Entity) error {
+	if err := b.reserve(); err != nil {
+		return err
+	}
+	b.batch.InsertOrReplaceEntity(entity, true)
+	return nil
+}
+
+// delete queues the passed entity to be deleted regardless of its ETag.
+func (b *azureBatch) delete(entity *storage.Entity) error {
+	if err := b.reserve(); err != nil {
 		return err
 	}
+	b.batch.DeleteEntity(entity, true)
+	return nil
+}
 
-	return t.tableRepository.Update(entities[0], props)
+// reserve makes room for another operation, executing the queued ones first
+// when the batch is full.
+func (b *azureBatch) reserve() error {
+	if b.size == azureMaxBatchSize {
+		if err := b.execute(); err != nil {
+			return err
+		}
+	}
+	if b.batch == nil {
+		b.batch = b.table.NewBatch()
+	}
+	b.size++
+	return nil
+}
+
+// execute executes the queued operations, if any.
+func (b *azureBatch) execute() error {
+	if b.batch == nil {
+		return nil
+	}
+	err := b.batch.ExecuteBatch()
+	b.batch = nil
+	b.size = 0
+	return err
 }
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// azuriteEnvVar is the environment variable that enables the tests that run
+// against a local Azurite storage emulator, such as one started with
+// docker run -p 10002:10002 mcr.microsoft.com/azure-storage/azurite.
+const azuriteEnvVar = "BTCD_TEST_AZURITE"
+
+// newTestAzureBalanceRepo returns a balance repository backed by new tables in
+// the local storage emulator along with its table repository.  The test is
+// skipped unless the emulator tests are enabled.
+func newTestAzureBalanceRepo(t *testing.T) (*AzureBalanceRepository, AzureStorageTableRepository) {
+	t.Helper()
+
+	if os.Getenv(azuriteEnvVar) == "" {
+		t.Skipf("set %s=1 to run against a local Azurite emulator",
+			azuriteEnvVar)
+	}
+
+	tableRepo, err := NewAzureStorageTableRepository("", "",
+		AzureEmulatorEndpoint)
+	if err != nil {
+		t.Fatalf("NewAzureStorageTableRepository: unexpected error: %v",
+			err)
+	}
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		t.Fatalf("unable to generate table name: %v", err)
+	}
+	repo, err := NewAzureBalanceRepository(tableRepo,
+		"balance"+hex.EncodeToString(suffix[:]))
+	if err != nil {
+		t.Fatalf("NewAzureBalanceRepository: unexpected error: %v", err)
+	}
+	return repo, tableRepo
+}
+
+// TestAzureBalanceIteration ensures the azure repository iterates, counts and
+// ranks balances as expected.
+func TestAzureBalanceIteration(t *testing.T) {
+	t.Parallel()
+
+	repo, _ := newTestAzureBalanceRepo(t)
+	testBalanceIteration(t, repo)
+}
+
+// TestAzureBalanceStats ensures the azure repository tracks the totals of the
+// balances as expected.
+func TestAzureBalanceStats(t *testing.T) {
+	t.Parallel()
+
+	repo, _ := newTestAzureBalanceRepo(t)
+	testBalanceStats(t, repo)
+}
+
+// TestAzureBalanceReplay ensures the azure repository replays blocks it already
+// applied exactly.
+func TestAzureBalanceReplay(t *testing.T) {
+	t.Parallel()
+
+	repo, _ := newTestAzureBalanceRepo(t)
+	testBalanceReplay(t, repo)
+}
+
+// TestAzureBalanceReorg ensures the azure repository applies the blocks of a
+// reorganization to every address they touch.
+func TestAzureBalanceReorg(t *testing.T) {
+	t.Parallel()
+
+	repo, _ := newTestAzureBalanceRepo(t)
+	testBalanceReorg(t, repo)
+}
+
+// TestAzureCachedBalanceReorg ensures a balance cache in front of the azure
+// repository applies the blocks of a reorganization that replaces flushed
+// blocks to every address they touch.
+func TestAzureCachedBalanceReorg(t *testing.T) {
+	t.Parallel()
+
+	repo, _ := newTestAzureBalanceRepo(t)
+	testCachedBalanceReorg(t, repo)
+}
+
+// TestAzureBalanceRepository ensures the azure repository escapes keys, detects
+// concurrent changes with ETags, and replays partially applied blocks exactly.
+func TestAzureBalanceRepository(t *testing.T) {
+	t.Parallel()
+
+	repo, tableRepo := newTestAzureBalanceRepo(t)
+
+	// Keys are escaped rather than ending up in filters and paths as is.
+	for _, key := range []string{"a'b", "a' or PartitionKey ne '"} {
+		balance, err := repo.Get(key)
+		if err != nil || balance != nil {
+			t.Fatalf("Get(%q): unexpected result -- got %v, error %v",
+				key, balance, err)
+		}
+		history, err := repo.BalanceHistory(key, 0, 10)
+		if err != nil || len(history) != 0 {
+			t.Fatalf("BalanceHistory(%q): unexpected result -- got "+
+				"%v, error %v", key, history, err)
+		}
+	}
+
+	blocks := []*BlockDeltas{
+		{Hash: chainhash.Hash{1}, Height: 1, Deltas: map[string][]BalanceDelta{
+			"a": {{TxHash: chainhash.Hash{11}, Value: 50, Received: 50}},
+		}},
+		{Hash: chainhash.Hash{2}, Height: 2, Deltas: map[string][]BalanceDelta{
+			"a": {{TxHash: chainhash.Hash{21}, Value: -20, Sent: 20}},
+		}},
+	}
+	if err := repo.ConnectBlocks(blocks[:1]); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+
+	// Keep the entity as of the first block to ensure updating it after
+	// it was changed fails below.
+	stale, err := tableRepo.Get("a", "", repo.table)
+	if err != nil || stale == nil {
+		t.Fatalf("Get: unexpected result -- got %v, error %v", stale, err)
+	}
+
+	// Replaying the first block along with the second one, as happens
+	// when the tip wasn't recorded before a crash, only applies the
+	// second one.
+	if err := repo.ConnectBlocks(blocks); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+	want := &Balance{PublicKey: "a", Value: 30, Received: 50, Sent: 20,
+		TxCount: 2, FirstSeen: 1, LastSeen: 2}
+	balance, err := repo.Get("a")
+	if err != nil {
+		t.Fatalf("Get: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(balance, want) {
+		t.Fatalf("Get: mismatched balance -- got %+v, want %+v",
+			balance, want)
+	}
+
+	// Updating an entity that was changed since it was read fails rather
+	// than overwriting the change.
+	err = tableRepo.Update(stale, balanceProps(&Balance{PublicKey: "a"}))
+	if !isAzureConflict(err) {
+		t.Fatalf("Update: unexpected error for stale entity -- got %v, "+
+			"want a conflict", err)
+	}
+	hash, height, err := repo.Tip()
+	if err != nil || hash == nil || *hash != blocks[1].Hash || height != 2 {
+		t.Fatalf("Tip: unexpected tip -- got %v (%d), error %v", hash,
+			height, err)
+	}
+}
+
+// TestOdataString ensures strings are quoted and escaped for OData filters.
+func TestOdataString(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "", want: "''"},
+		{in: "1BoatSLRHtKNngkdXEeobR76b53LETtpyT", want: "'1BoatSLRHtKNngkdXEeobR76b53LETtpyT'"},
+		{in: "a'b", want: "'a''b'"},
+		{in: "' or '1' eq '1", want: "''' or ''1'' eq ''1'"},
+	}
+	for _, test := range tests {
+		if got := odataString(test.in); got != test.want {
+			t.Errorf("odataString(%q): got %s, want %s", test.in, got,
+				test.want)
+		}
+	}
+}
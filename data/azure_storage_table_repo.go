@@ -2,57 +2,97 @@ package data
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/azure/azure-sdk-for-go/storage"
 	"github.com/pkg/errors"
 )
 
+// AzureEmulatorEndpoint is the service endpoint that selects the local storage
+// emulator, such as Azurite, and its well-known development account.
+const AzureEmulatorEndpoint = "emulator"
+
 type AzureStorageTableRepository struct {
 	client storage.Client
 }
 
-func NewAzureStorageTableRepository(accountName, accountKey string) AzureStorageTableRepository {
-	client, _ := storage.NewBasicClient(accountName, accountKey)
+// NewAzureStorageTableRepository returns a table repository for the passed
+// storage account.  An empty service base URL selects the public Azure cloud
+// and AzureEmulatorEndpoint selects the storage emulator, in which case the
+// account name and key are ignored.  Otherwise it is the storage domain suffix,
+// such as core.chinacloudapi.cn, optionally prefixed with http:// to disable
+// TLS.
+func NewAzureStorageTableRepository(accountName, accountKey, serviceBaseURL string) (AzureStorageTableRepository, error) {
+	var client storage.Client
+	var err error
+	switch serviceBaseURL {
+	case "":
+		client, err = storage.NewBasicClient(accountName, accountKey)
+	case AzureEmulatorEndpoint:
+		client, err = storage.NewEmulatorClient()
+	default:
+		useHTTPS := !strings.HasPrefix(serviceBaseURL, "http://")
+		baseURL := strings.TrimPrefix(serviceBaseURL, "http://")
+		baseURL = strings.TrimPrefix(baseURL, "https://")
+		client, err = storage.NewClient(accountName, accountKey,
+			baseURL, storage.DefaultAPIVersion, useHTTPS)
+	}
+	if err != nil {
+		return AzureStorageTableRepository{}, err
+	}
 	client.HTTPClient.Transport = &http.Transport{DisableKeepAlives: true}
 
-	return AzureStorageTableRepository{client: client}
+	return AzureStorageTableRepository{client: client}, nil
 }
 
+// Ensure returns the table with the passed name, creating it when it doesn't
+// exist yet.
 func (t *AzureStorageTableRepository) Ensure(tableName string) (*storage.Table, error) {
 	tableClient := t.client.GetTableService()
 	table := tableClient.GetTableReference(tableName)
 
 	err := table.Create(30, storage.EmptyPayload, nil)
-	if err != nil {
-		return table, err
+	if err != nil && !isAzureStatus(err, http.StatusConflict) {
+		return nil, errors.Wrapf(err, "Error creating table %s",
+			tableName)
 	}
 
 	return table, nil
 }
 
-func (t *AzureStorageTableRepository) Get(partitionKey, rowKey string, table *storage.Table) ([]*storage.Entity, error) {
-	filter := "PartitionKey eq '" + partitionKey + "'"
-	if rowKey != "" {
-		filter += "and RowKey eq '" + rowKey + "'"
+// Get looks up the entity with the passed partition and row key with a point
+// query, which is the cheapest way to read a single entity.  The returned
+// entity carries its ETag, so it can be updated conditionally.  A nil entity is
+// returned when there is no such entity.
+func (t *AzureStorageTableRepository) Get(partitionKey, rowKey string, table *storage.Table) (*storage.Entity, error) {
+	// The keys are quoted in the request path, so quotes within them have
+	// to be escaped like in filters.
+	entity := table.GetEntityReference(odataEscape(partitionKey),
+		odataEscape(rowKey))
+	err := entity.Get(30, storage.FullMetadata, nil)
+	if isAzureStatus(err, http.StatusNotFound) {
+		return nil, nil
 	}
-
-	options := storage.QueryOptions{
-		Filter: filter,
-	}
-	res, err := table.QueryEntities(30, storage.FullMetadata, &options)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error getting entity")
 	}
+	entity.PartitionKey, entity.RowKey = partitionKey, rowKey
 
-	return res.Entities, nil
+	return entity, nil
 }
 
+// Insert inserts an entity with the passed keys and properties.  It fails with
+// a conflict when the entity exists already.
 func (t *AzureStorageTableRepository) Insert(partitionKey, rowKey string, props map[string]interface{}, table *storage.Table) error {
 	entity := table.GetEntityReference(partitionKey, rowKey)
 	entity.Properties = props
 	return entity.Insert(storage.EmptyPayload, nil)
 }
 
+// Update merges the passed properties into the passed entity, which must have
+// been read with Get.  The update is conditional on the ETag of the entity, so
+// it fails with a failed precondition when the entity was changed since it was
+// read rather than overwriting the change.
 func (t *AzureStorageTableRepository) Update(entity *storage.Entity, props map[string]interface{}) error {
 	entity.Properties = props
 	err := entity.Merge(false, nil)
@@ -63,3 +103,37 @@ func (t *AzureStorageTableRepository) Update(entity *storage.Entity, props map[s
 
 	return nil
 }
+
+// Put inserts or replaces the entity with the passed keys and properties
+// regardless of whether it exists or was changed.
+func (t *AzureStorageTableRepository) Put(partitionKey, rowKey string, props map[string]interface{}, table *storage.Table) error {
+	entity := table.GetEntityReference(partitionKey, rowKey)
+	entity.Properties = props
+	return entity.InsertOrReplace(nil)
+}
+
+// odataEscape escapes the passed string for use within a quoted string literal
+// of an OData filter or entity path.
+func odataEscape(s string) string {
+	return strings.Replace(s, "'", "''", -1)
+}
+
+// odataString returns the passed string as an OData string literal.
+func odataString(s string) string {
+	return "'" + odataEscape(s) + "'"
+}
+
+// isAzureStatus returns whether the passed error was returned by the storage
+// service with the passed HTTP status code.
+func isAzureStatus(err error, statusCode int) bool {
+	serviceErr, ok := errors.Cause(err).(storage.AzureStorageServiceError)
+	return ok && serviceErr.StatusCode == statusCode
+}
+
+// isAzureConflict returns whether the passed error was caused by a concurrent
+// change of an entity, which is either another insert of the same entity or a
+// change since the entity was read.
+func isAzureConflict(err error) bool {
+	return isAzureStatus(err, http.StatusConflict) ||
+		isAzureStatus(err, http.StatusPreconditionFailed)
+}
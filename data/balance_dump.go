@@ -0,0 +1,118 @@
+package data
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+const (
+	// DumpFormatCSV is the format of balance dumps with one comma
+	// separated line per balance preceded by a header line.
+	DumpFormatCSV = "csv"
+
+	// DumpFormatJSONL is the format of balance dumps with one JSON object
+	// per line and balance.
+	DumpFormatJSONL = "jsonl"
+)
+
+// dumpEntry is a single balance of a balance dump.  Values are in satoshi so
+// they can be summed up exactly.
+type dumpEntry struct {
+	Address   string `json:"address"`
+	Type      string `json:"type"`
+	Value     int64  `json:"value"`
+	Received  int64  `json:"received"`
+	Sent      int64  `json:"sent"`
+	TxCount   int64  `json:"txcount"`
+	FirstSeen int32  `json:"firstseen"`
+	LastSeen  int32  `json:"lastseen"`
+	Height    int32  `json:"height"`
+	Hash      string `json:"hash"`
+}
+
+// BalanceDumpWriter writes a snapshot of balances to a stream as CSV or JSON
+// Lines.  Every entry is tagged with the block the snapshot is taken at so
+// files of different snapshots can't be confused.
+type BalanceDumpWriter struct {
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	json   *json.Encoder
+	hash   string
+	height int32
+}
+
+// NewBalanceDumpWriter returns a writer that writes the balances of the
+// snapshot taken at the block with the passed hash and height to w in the
+// passed format, which must be DumpFormatCSV or DumpFormatJSONL.  Flush must be
+// called once all balances have been written.
+func NewBalanceDumpWriter(w io.Writer, format string, hash *chainhash.Hash, height int32) (*BalanceDumpWriter, error) {
+	dw := &BalanceDumpWriter{
+		buf:    bufio.NewWriter(w),
+		hash:   hash.String(),
+		height: height,
+	}
+	switch format {
+	case DumpFormatCSV:
+		dw.csv = csv.NewWriter(dw.buf)
+		err := dw.csv.Write([]string{"address", "type", "value",
+			"received", "sent", "txcount", "firstseen", "lastseen",
+			"height", "hash"})
+		if err != nil {
+			return nil, err
+		}
+
+	case DumpFormatJSONL:
+		dw.json = json.NewEncoder(dw.buf)
+
+	default:
+		return nil, fmt.Errorf("unknown balance dump format '%s'",
+			format)
+	}
+
+	return dw, nil
+}
+
+// Write writes the passed balance, whose address is of the passed script
+// class, along with its totals.  Values are written in satoshi.
+func (dw *BalanceDumpWriter) Write(balance *Balance, class string) error {
+	if dw.csv != nil {
+		return dw.csv.Write([]string{balance.PublicKey, class,
+			strconv.FormatInt(balance.Value, 10),
+			strconv.FormatInt(balance.Received, 10),
+			strconv.FormatInt(balance.Sent, 10),
+			strconv.FormatInt(balance.TxCount, 10),
+			strconv.FormatInt(int64(balance.FirstSeen), 10),
+			strconv.FormatInt(int64(balance.LastSeen), 10),
+			strconv.FormatInt(int64(dw.height), 10), dw.hash})
+	}
+
+	return dw.json.Encode(&dumpEntry{
+		Address:   balance.PublicKey,
+		Type:      class,
+		Value:     balance.Value,
+		Received:  balance.Received,
+		Sent:      balance.Sent,
+		TxCount:   balance.TxCount,
+		FirstSeen: balance.FirstSeen,
+		LastSeen:  balance.LastSeen,
+		Height:    dw.height,
+		Hash:      dw.hash,
+	})
+}
+
+// Flush writes any buffered balances to the underlying stream.
+func (dw *BalanceDumpWriter) Flush() error {
+	if dw.csv != nil {
+		dw.csv.Flush()
+		if err := dw.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return dw.buf.Flush()
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// TestBalanceDumpWriter ensures balance dumps are written in the expected
+// format.
+func TestBalanceDumpWriter(t *testing.T) {
+	t.Parallel()
+
+	hash := chaincfg.MainNetParams.GenesisHash
+	balance := &Balance{
+		PublicKey: "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa",
+		Value:     5000000000,
+		Received:  6000000000,
+		Sent:      1000000000,
+		TxCount:   3,
+		FirstSeen: 1,
+		LastSeen:  5,
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: DumpFormatCSV,
+			want: "address,type,value,received,sent,txcount," +
+				"firstseen,lastseen,height,hash\n" +
+				"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa,pubkeyhash," +
+				"5000000000,6000000000,1000000000,3,1,5,7," +
+				hash.String() + "\n",
+		},
+		{
+			format: DumpFormatJSONL,
+			want: `{"address":"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa",` +
+				`"type":"pubkeyhash","value":5000000000,` +
+				`"received":6000000000,"sent":1000000000,` +
+				`"txcount":3,"firstseen":1,"lastseen":5,"height":7,` +
+				`"hash":"` + hash.String() + `"}` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		w, err := NewBalanceDumpWriter(&buf, test.format, hash, 7)
+		if err != nil {
+			t.Fatalf("NewBalanceDumpWriter(%s): unexpected error: %v",
+				test.format, err)
+		}
+		err = w.Write(balance, "pubkeyhash")
+		if err != nil {
+			t.Fatalf("Write(%s): unexpected error: %v", test.format, err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Flush(%s): unexpected error: %v", test.format, err)
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("%s: mismatched dump -- got %q, want %q",
+				test.format, got, test.want)
+		}
+	}
+
+	if _, err := NewBalanceDumpWriter(nil, "xml", hash, 7); err == nil {
+		t.Errorf("NewBalanceDumpWriter: expected error for unknown format")
+	}
+}
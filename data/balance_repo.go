@@ -1,12 +1,220 @@
 package data
 
+import (
+	"errors"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// reservedKeyPrefix is the prefix of all keys the repositories store something
+// other than the balance of an address under.  Such keys can never collide with
+// an address since the underscore is not part of the base58 or bech32 alphabets
+// nor a hex digit.
+const reservedKeyPrefix = "_"
+
+// tipKey is the key the repositories record the hash and height of the last
+// block applied to them under.
+const tipKey = reservedKeyPrefix + "tip"
+
+// Balance is the balance of an address along with the totals of all
+// transactions that touched it.  The first and last seen heights are only
+// meaningful when TxCount is non-zero.
+//
+// Repositories populated before the totals were tracked only have them for the
+// transactions applied since, so they have to be rebuilt for exact totals.
 type Balance struct {
 	PublicKey string
 	Value     int64
+	Received  int64
+	Sent      int64
+	TxCount   int64
+	FirstSeen int32
+	LastSeen  int32
+}
+
+// connect adds the passed changes, which must be the ones of the block at the
+// passed height, to the balance and its totals.
+func (b *Balance) connect(height int32, deltas []BalanceDelta) {
+	if len(deltas) == 0 {
+		return
+	}
+	if b.TxCount == 0 {
+		b.FirstSeen = height
+	}
+	for i := range deltas {
+		b.Value += deltas[i].Value
+		b.Received += deltas[i].Received
+		b.Sent += deltas[i].Sent
+	}
+	b.TxCount += int64(len(deltas))
+	b.LastSeen = height
+}
+
+// IsUntracked returns whether the balance was recorded before the height of
+// the last change of each address was tracked, which means it is unknown
+// whether it changed after any given height.  Such balances have a value but
+// no last seen height.
+func (b *Balance) IsUntracked() bool {
+	return b.Value != 0 && b.LastSeen == 0
+}
+
+// disconnect reverses the passed changes, which must be the ones the balance
+// was last connected with, given the height of the block that touched the
+// balance before them.  The height is ignored when no transaction is left.
+func (b *Balance) disconnect(deltas []BalanceDelta, prevLastSeen int32) {
+	if len(deltas) == 0 {
+		return
+	}
+	for i := range deltas {
+		b.Value -= deltas[i].Value
+		b.Received -= deltas[i].Received
+		b.Sent -= deltas[i].Sent
+	}
+	b.TxCount -= int64(len(deltas))
+	b.LastSeen = prevLastSeen
+	if b.TxCount <= 0 {
+		b.TxCount, b.FirstSeen, b.LastSeen = 0, 0, 0
+	}
+}
+
+// BalanceDelta is the change in the balance of an address caused by a single
+// transaction.  Received and Sent are the values of the outputs the
+// transaction paid to and spent from the address, so Value, the net change,
+// is their difference.
+type BalanceDelta struct {
+	TxHash   chainhash.Hash
+	Value    int64
+	Received int64
+	Sent     int64
+}
+
+// BalanceHistoryEntry is a recorded change in the balance of an address along
+// with the running balance of the address right after the change.
+type BalanceHistoryEntry struct {
+	Height   int32
+	TxHash   chainhash.Hash
+	Delta    int64
+	Received int64
+	Sent     int64
+	Balance  int64
 }
 
 type IBalanceRepository interface {
 	Get(publicKey string) (*Balance, error)
 	Insert(balance *Balance) error
 	Update(balance *Balance) error
-}
\ No newline at end of file
+
+	// ConnectBlock adds the balance changes caused by the block with the
+	// passed hash and height to the stored balances, records them in the
+	// balance history of each address and records the block as the tip
+	// of the repository.  The changes are keyed by public key and must be
+	// in the order of the transactions of the block.
+	//
+	// The local repositories write all of the changes and the tip
+	// atomically, so a crash never leaves a block half applied.  The
+	// remote repositories can't write the changes to every address in a
+	// single transaction, so a crash may leave them with part of the
+	// changes of the blocks after their tip instead.  Connecting those
+	// blocks again, in the same or different batches, skips the changes
+	// that were already applied, which results in exactly the balances
+	// and history an atomic write would have.
+	ConnectBlock(hash *chainhash.Hash, height int32, deltas map[string][]BalanceDelta) error
+
+	// ConnectBlocks connects the passed blocks in order as if by
+	// ConnectBlock, but writes all of them at once, which is far more
+	// efficient when catching up a large number of blocks.  The blocks
+	// must be consecutive and the first one must extend the tip of the
+	// repository.
+	ConnectBlocks(blocks []*BlockDeltas) error
+
+	// DisconnectBlock reverses the passed balance changes, which must be
+	// the ones the block at the passed height was connected with, removes
+	// them from the balance history and records the parent of the block
+	// as the tip of the repository.  It provides the same guarantees as
+	// ConnectBlock.
+	DisconnectBlock(prevHash *chainhash.Hash, height int32, deltas map[string][]BalanceDelta) error
+
+	// Tip returns the hash and height of the last block recorded by
+	// ConnectBlock or DisconnectBlock.  A nil hash is returned when no
+	// block has been applied yet.
+	Tip() (*chainhash.Hash, int32, error)
+
+	// TopBalances returns up to count balances of at least minValue
+	// ordered from the largest to the smallest value.  Balances recorded
+	// under reserved keys, such as NonstandardKey, are not included.  Only
+	// the public key and value of the balances are guaranteed to be set.
+	TopBalances(count int, minValue int64) ([]*Balance, error)
+
+	// ForEachBalance invokes the passed function with every balance in
+	// the repository in no particular order.  Balances recorded under
+	// reserved keys are not included.  Iteration stops at the first error
+	// returned by the function, which is then returned.
+	ForEachBalance(fn func(balance *Balance) error) error
+
+	// CountBalances returns the number of balances of at least minValue.
+	// Like TopBalances, balances recorded under reserved keys are not
+	// counted.
+	CountBalances(minValue int64) (int64, error)
+
+	// BalanceHistory returns the recorded changes to the balance of the
+	// passed public key in blocks fromHeight through toHeight, inclusive,
+	// in chain order.
+	BalanceHistory(publicKey string, fromHeight, toHeight int32) ([]*BalanceHistoryEntry, error)
+
+	// BalanceAtHeight returns the balance of the passed public key right
+	// after the block at the passed height was connected.
+	BalanceAtHeight(publicKey string, height int32) (int64, error)
+
+	Close() error
+}
+
+// ErrUntrackedBalance is returned by BalanceAsOf for balances that were
+// recorded before the height of the last change of each address was tracked.
+// The balance repository has to be rebuilt before they can be looked up as of
+// past heights.
+var ErrUntrackedBalance = errors.New("the balance was recorded before the " +
+	"height of its last change was tracked, so the balance repository " +
+	"must be rebuilt to look it up as of past heights")
+
+// BalanceAsOf returns the passed current balance of an address, including its
+// totals, as it was right after the block at the passed height was connected.
+// The current balance is returned as is when the address hasn't been touched
+// since, which is the common case, and it is rebuilt from the balance history
+// of the address otherwise.  A nil balance is treated as an address that has
+// never been touched.  ErrUntrackedBalance is returned for untracked balances
+// since whether they were touched since is unknown.
+func BalanceAsOf(repo IBalanceRepository, publicKey string, current *Balance, height int32) (*Balance, error) {
+	if current == nil {
+		return &Balance{PublicKey: publicKey}, nil
+	}
+	if current.IsUntracked() {
+		return nil, ErrUntrackedBalance
+	}
+	if current.LastSeen <= height {
+		return current, nil
+	}
+
+	history, err := repo.BalanceHistory(publicKey, 0, height)
+	if err != nil {
+		return nil, err
+	}
+	balance := &Balance{PublicKey: publicKey}
+	for i, entry := range history {
+		if i == 0 {
+			balance.FirstSeen = entry.Height
+		}
+		balance.Value = entry.Balance
+		balance.Received += entry.Received
+		balance.Sent += entry.Sent
+		balance.TxCount++
+		balance.LastSeen = entry.Height
+	}
+	return balance, nil
+}
+
+// isReservedKey returns whether the passed key is a reserved key rather than
+// the public key of an address.
+func isReservedKey(key string) bool {
+	return strings.HasPrefix(key, reservedKeyPrefix)
+}
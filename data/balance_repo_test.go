@@ -0,0 +1,618 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/goleveldb/leveldb"
+)
+
+// testBalanceIteration ensures the passed empty repository iterates, counts and
+// ranks the balances connected to it while excluding reserved keys.
+func testBalanceIteration(t *testing.T, repo IBalanceRepository) {
+	t.Helper()
+
+	deltas1 := map[string][]BalanceDelta{
+		"a":            {{TxHash: chainhash.Hash{11}, Value: 5}},
+		"b":            {{TxHash: chainhash.Hash{12}, Value: 7}},
+		"c":            {{TxHash: chainhash.Hash{13}, Value: 7}},
+		NonstandardKey: {{TxHash: chainhash.Hash{14}, Value: 100}},
+	}
+	if err := repo.ConnectBlock(&chainhash.Hash{1}, 1, deltas1); err != nil {
+		t.Fatalf("ConnectBlock: unexpected error: %v", err)
+	}
+	deltas2 := map[string][]BalanceDelta{
+		"a": {
+			{TxHash: chainhash.Hash{21}, Value: -5},
+			{TxHash: chainhash.Hash{22}, Value: 1},
+		},
+		"b": {{TxHash: chainhash.Hash{22}, Value: -7}},
+		"d": {{TxHash: chainhash.Hash{22}, Value: 9}},
+	}
+	if err := repo.ConnectBlock(&chainhash.Hash{2}, 2, deltas2); err != nil {
+		t.Fatalf("ConnectBlock: unexpected error: %v", err)
+	}
+
+	checkBalances := func(want map[string]int64) {
+		t.Helper()
+
+		got := make(map[string]int64)
+		err := repo.ForEachBalance(func(balance *Balance) error {
+			got[balance.PublicKey] = balance.Value
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ForEachBalance: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("ForEachBalance: mismatched balances -- got %v, "+
+				"want %v", got, want)
+		}
+	}
+	checkCount := func(minValue, want int64) {
+		t.Helper()
+
+		count, err := repo.CountBalances(minValue)
+		if err != nil {
+			t.Fatalf("CountBalances: unexpected error: %v", err)
+		}
+		if count != want {
+			t.Fatalf("CountBalances(%d): mismatched count -- got %d, "+
+				"want %d", minValue, count, want)
+		}
+	}
+	checkTop := func(count int, minValue int64, want ...Balance) {
+		t.Helper()
+
+		top, err := repo.TopBalances(count, minValue)
+		if err != nil {
+			t.Fatalf("TopBalances: unexpected error: %v", err)
+		}
+		// Only the public key and value are guaranteed to be set.
+		got := make([]Balance, 0, len(top))
+		for _, balance := range top {
+			got = append(got, Balance{
+				PublicKey: balance.PublicKey,
+				Value:     balance.Value,
+			})
+		}
+		if len(want) == 0 {
+			want = []Balance{}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("TopBalances(%d, %d): mismatched balances -- "+
+				"got %v, want %v", count, minValue, got, want)
+		}
+	}
+
+	bal := func(publicKey string, value int64) Balance {
+		return Balance{PublicKey: publicKey, Value: value}
+	}
+
+	checkBalances(map[string]int64{"a": 1, "b": 0, "c": 7, "d": 9})
+	checkCount(math.MinInt64, 4)
+	checkCount(1, 3)
+	checkCount(8, 1)
+	checkCount(10, 0)
+	checkTop(10, 1, bal("d", 9), bal("c", 7), bal("a", 1))
+	checkTop(2, math.MinInt64, bal("d", 9), bal("c", 7))
+	checkTop(0, math.MinInt64)
+
+	// Ties are broken by the public key.
+	err := repo.Insert(&Balance{PublicKey: "e", Value: 7})
+	if err != nil {
+		t.Fatalf("Insert: unexpected error: %v", err)
+	}
+	checkTop(2, 7, bal("d", 9), bal("c", 7))
+	checkTop(3, 7, bal("d", 9), bal("c", 7), bal("e", 7))
+
+	// Updating a balance moves it in the ranking.
+	err = repo.Update(&Balance{PublicKey: "e", Value: 10})
+	if err != nil {
+		t.Fatalf("Update: unexpected error: %v", err)
+	}
+	checkTop(2, 7, bal("e", 10), bal("d", 9))
+	checkCount(7, 3)
+
+	// Disconnecting a block restores the previous ranking.
+	if err := repo.DisconnectBlock(&chainhash.Hash{1}, 2, deltas2); err != nil {
+		t.Fatalf("DisconnectBlock: unexpected error: %v", err)
+	}
+	checkBalances(map[string]int64{"a": 5, "b": 7, "c": 7, "d": 0, "e": 10})
+	checkTop(3, 1, bal("e", 10), bal("b", 7), bal("c", 7))
+	checkCount(1, 4)
+
+	// Iteration stops at the first error.
+	errStop := errors.New("stop")
+	var visited int
+	err = repo.ForEachBalance(func(balance *Balance) error {
+		visited++
+		return errStop
+	})
+	if err != errStop || visited != 1 {
+		t.Fatalf("ForEachBalance: unexpected result -- got error %v "+
+			"after %d balances, want %v after 1", err, visited, errStop)
+	}
+}
+
+// TestMemoryBalanceIteration ensures the in-memory repository iterates, counts
+// and ranks balances as expected.
+func TestMemoryBalanceIteration(t *testing.T) {
+	t.Parallel()
+
+	testBalanceIteration(t, NewMemoryBalanceRepository())
+}
+
+// TestLevelDbBalanceIteration ensures the leveldb repository iterates, counts
+// and ranks balances as expected, including when its value index has to be
+// built for balances written before the index existed.
+func TestLevelDbBalanceIteration(t *testing.T) {
+	t.Parallel()
+
+	path, err := ioutil.TempDir("", "balances")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+
+	repo, err := NewLevelDbBalanceRepository(path)
+	if err != nil {
+		t.Fatalf("unable to open balance repository: %v", err)
+	}
+	testBalanceIteration(t, repo)
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close: unexpected error: %v", err)
+	}
+
+	// Remove the value index and add a balance the way repositories did
+	// before the index was introduced, then ensure it's rebuilt.
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		t.Fatalf("unable to open database: %v", err)
+	}
+	batch := new(leveldb.Batch)
+	iter := db.NewIterator(valueIndexRange(math.MinInt64), nil)
+	for iter.Next() {
+		batch.Delete(iter.Key())
+	}
+	iter.Release()
+	batch.Delete([]byte(valueIndexReadyKey))
+	legacy := make([]byte, binary.MaxVarintLen64)
+	binary.PutVarint(legacy, 8)
+	batch.Put([]byte("f"), legacy)
+	if err := db.Write(batch, nil); err != nil {
+		t.Fatalf("Write: unexpected error: %v", err)
+	}
+	db.Close()
+
+	repo, err = NewLevelDbBalanceRepository(path)
+	if err != nil {
+		t.Fatalf("unable to reopen balance repository: %v", err)
+	}
+	defer repo.Close()
+	top, err := repo.TopBalances(2, 1)
+	if err != nil {
+		t.Fatalf("TopBalances: unexpected error: %v", err)
+	}
+	want := []*Balance{{PublicKey: "e", Value: 10}, {PublicKey: "f", Value: 8}}
+	if !reflect.DeepEqual(top, want) {
+		t.Fatalf("TopBalances: mismatched balances after rebuilding "+
+			"the value index -- got %v, want %v", top, want)
+	}
+	count, err := repo.CountBalances(1)
+	if err != nil {
+		t.Fatalf("CountBalances: unexpected error: %v", err)
+	}
+	if count != 5 {
+		t.Fatalf("CountBalances: mismatched count after rebuilding "+
+			"the value index -- got %d, want 5", count)
+	}
+
+	// Balances written before the totals were tracked only hold the value
+	// followed by zero padding.
+	balance, err := repo.Get("f")
+	if err != nil {
+		t.Fatalf("Get: unexpected error: %v", err)
+	}
+	if want := (&Balance{PublicKey: "f", Value: 8}); !reflect.DeepEqual(balance, want) {
+		t.Fatalf("Get: mismatched legacy balance -- got %+v, want %+v",
+			balance, want)
+	}
+}
+
+// testBalanceStats ensures the passed empty repository tracks the totals,
+// transaction counts and first and last seen heights of the balances connected
+// to it, reverses them on disconnect and rebuilds them as of earlier heights.
+func testBalanceStats(t *testing.T, repo IBalanceRepository) {
+	t.Helper()
+
+	deltas1 := map[string][]BalanceDelta{
+		"a": {{TxHash: chainhash.Hash{11}, Value: 50, Received: 50}},
+	}
+	if err := repo.ConnectBlock(&chainhash.Hash{1}, 1, deltas1); err != nil {
+		t.Fatalf("ConnectBlock: unexpected error: %v", err)
+	}
+	deltas2 := map[string][]BalanceDelta{
+		"a": {{TxHash: chainhash.Hash{21}, Value: -30, Received: 20, Sent: 50}},
+		"b": {{TxHash: chainhash.Hash{21}, Value: 30, Received: 30}},
+	}
+	deltas3 := map[string][]BalanceDelta{
+		"a": {
+			{TxHash: chainhash.Hash{31}, Value: 0, Received: 5, Sent: 5},
+			{TxHash: chainhash.Hash{32}, Value: 10, Received: 10},
+		},
+	}
+	err := repo.ConnectBlocks([]*BlockDeltas{
+		{Hash: chainhash.Hash{2}, Height: 2, Deltas: deltas2},
+		{Hash: chainhash.Hash{3}, Height: 3, Deltas: deltas3},
+	})
+	if err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+
+	checkBalance := func(want *Balance) {
+		t.Helper()
+
+		got, err := repo.Get(want.PublicKey)
+		if err != nil {
+			t.Fatalf("Get: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Get: mismatched balance -- got %+v, want %+v",
+				got, want)
+		}
+	}
+	checkAsOf := func(height int32, want *Balance) {
+		t.Helper()
+
+		current, err := repo.Get(want.PublicKey)
+		if err != nil {
+			t.Fatalf("Get: unexpected error: %v", err)
+		}
+		got, err := BalanceAsOf(repo, want.PublicKey, current, height)
+		if err != nil {
+			t.Fatalf("BalanceAsOf: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("BalanceAsOf(%d): mismatched balance -- got %+v, "+
+				"want %+v", height, got, want)
+		}
+	}
+
+	a3 := &Balance{PublicKey: "a", Value: 30, Received: 85, Sent: 55,
+		TxCount: 4, FirstSeen: 1, LastSeen: 3}
+	a2 := &Balance{PublicKey: "a", Value: 20, Received: 70, Sent: 50,
+		TxCount: 2, FirstSeen: 1, LastSeen: 2}
+	a1 := &Balance{PublicKey: "a", Value: 50, Received: 50, TxCount: 1,
+		FirstSeen: 1, LastSeen: 1}
+	b2 := &Balance{PublicKey: "b", Value: 30, Received: 30, TxCount: 1,
+		FirstSeen: 2, LastSeen: 2}
+	checkBalance(a3)
+	checkBalance(b2)
+	checkAsOf(3, a3)
+	checkAsOf(2, a2)
+	checkAsOf(1, a1)
+
+	// Balances recorded before the last seen height was tracked can't be
+	// looked up as of past heights.
+	untracked := &Balance{PublicKey: "c", Value: 5}
+	_, err = BalanceAsOf(repo, untracked.PublicKey, untracked, 3)
+	if err != ErrUntrackedBalance {
+		t.Fatalf("BalanceAsOf: mismatched error for untracked balance "+
+			"-- got %v, want %v", err, ErrUntrackedBalance)
+	}
+	checkAsOf(3, b2)
+	checkAsOf(1, &Balance{PublicKey: "b"})
+	checkAsOf(3, &Balance{PublicKey: "c"})
+
+	history, err := repo.BalanceHistory("a", 2, 2)
+	if err != nil {
+		t.Fatalf("BalanceHistory: unexpected error: %v", err)
+	}
+	wantHistory := []*BalanceHistoryEntry{{Height: 2,
+		TxHash: chainhash.Hash{21}, Delta: -30, Received: 20, Sent: 50,
+		Balance: 20}}
+	if !reflect.DeepEqual(history, wantHistory) {
+		t.Fatalf("BalanceHistory: mismatched history -- got %+v, want %+v",
+			history, wantHistory)
+	}
+
+	// Disconnecting restores the totals and the last seen height, and
+	// clears the first and last seen heights of balances that are left
+	// without transactions.
+	if err := repo.DisconnectBlock(&chainhash.Hash{2}, 3, deltas3); err != nil {
+		t.Fatalf("DisconnectBlock: unexpected error: %v", err)
+	}
+	checkBalance(a2)
+	checkBalance(b2)
+	if err := repo.DisconnectBlock(&chainhash.Hash{1}, 2, deltas2); err != nil {
+		t.Fatalf("DisconnectBlock: unexpected error: %v", err)
+	}
+	checkBalance(a1)
+	checkBalance(&Balance{PublicKey: "b"})
+}
+
+// TestMemoryBalanceStats ensures the in-memory repository tracks the totals of
+// the balances as expected.
+func TestMemoryBalanceStats(t *testing.T) {
+	t.Parallel()
+
+	testBalanceStats(t, NewMemoryBalanceRepository())
+}
+
+// TestLevelDbBalanceStats ensures the leveldb repository tracks the totals of
+// the balances as expected.
+func TestLevelDbBalanceStats(t *testing.T) {
+	t.Parallel()
+
+	path, err := ioutil.TempDir("", "balances")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+
+	repo, err := NewLevelDbBalanceRepository(path)
+	if err != nil {
+		t.Fatalf("unable to open balance repository: %v", err)
+	}
+	defer repo.Close()
+
+	testBalanceStats(t, repo)
+}
+
+// testBalanceReplay ensures the passed empty remote repository results in the
+// exact balances and history when blocks it already applied are connected
+// again in different batches, as happens when it crashes before recording its
+// tip.
+func testBalanceReplay(t *testing.T, repo IBalanceRepository) {
+	t.Helper()
+
+	blocks := []*BlockDeltas{
+		{Hash: chainhash.Hash{1}, Height: 1, Deltas: map[string][]BalanceDelta{
+			"a": {{TxHash: chainhash.Hash{11}, Value: 50, Received: 50}},
+		}},
+		{Hash: chainhash.Hash{2}, Height: 2, Deltas: map[string][]BalanceDelta{
+			"a": {{TxHash: chainhash.Hash{21}, Value: -20, Sent: 20}},
+			"b": {{TxHash: chainhash.Hash{21}, Value: 20, Received: 20}},
+		}},
+		{Hash: chainhash.Hash{3}, Height: 3, Deltas: map[string][]BalanceDelta{
+			"a": {{TxHash: chainhash.Hash{31}, Value: 5, Received: 5}},
+		}},
+		{Hash: chainhash.Hash{4}, Height: 4, Deltas: map[string][]BalanceDelta{
+			"b": {{TxHash: chainhash.Hash{41}, Value: 7, Received: 7}},
+		}},
+	}
+	if err := repo.ConnectBlocks(blocks); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+	if err := repo.ConnectBlocks(blocks[:2]); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+	if err := repo.ConnectBlocks(blocks[2:]); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+
+	checkBalance := func(want *Balance) {
+		t.Helper()
+
+		got, err := repo.Get(want.PublicKey)
+		if err != nil {
+			t.Fatalf("Get: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Get: mismatched balance -- got %+v, want %+v",
+				got, want)
+		}
+	}
+	checkHistory := func(publicKey string, want []*BalanceHistoryEntry) {
+		t.Helper()
+
+		got, err := repo.BalanceHistory(publicKey, 0, 10)
+		if err != nil {
+			t.Fatalf("BalanceHistory: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("BalanceHistory(%s): mismatched history -- got "+
+				"%+v, want %+v", publicKey, got, want)
+		}
+	}
+
+	checkBalance(&Balance{PublicKey: "a", Value: 35, Received: 55,
+		Sent: 20, TxCount: 3, FirstSeen: 1, LastSeen: 3})
+	checkBalance(&Balance{PublicKey: "b", Value: 27, Received: 27,
+		TxCount: 2, FirstSeen: 2, LastSeen: 4})
+	checkHistory("a", []*BalanceHistoryEntry{
+		{Height: 1, TxHash: chainhash.Hash{11}, Delta: 50, Received: 50,
+			Balance: 50},
+		{Height: 2, TxHash: chainhash.Hash{21}, Delta: -20, Sent: 20,
+			Balance: 30},
+		{Height: 3, TxHash: chainhash.Hash{31}, Delta: 5, Received: 5,
+			Balance: 35},
+	})
+	checkHistory("b", []*BalanceHistoryEntry{
+		{Height: 2, TxHash: chainhash.Hash{21}, Delta: 20, Received: 20,
+			Balance: 20},
+		{Height: 4, TxHash: chainhash.Hash{41}, Delta: 7, Received: 7,
+			Balance: 27},
+	})
+	hash, height, err := repo.Tip()
+	if err != nil || hash == nil || *hash != blocks[3].Hash || height != 4 {
+		t.Fatalf("Tip: unexpected tip -- got %v (%d), error %v", hash,
+			height, err)
+	}
+}
+
+// testBalanceReorg ensures the passed empty repository applies the blocks that
+// replace the disconnected blocks of a reorganization to every address they
+// touch, including the ones whose last change is below the disconnected blocks
+// and the ones that have no change left after them.
+func testBalanceReorg(t *testing.T, repo IBalanceRepository) {
+	t.Helper()
+
+	blocks := []*BlockDeltas{
+		{Hash: chainhash.Hash{1}, Height: 1, Deltas: map[string][]BalanceDelta{
+			"a": {{TxHash: chainhash.Hash{11}, Value: 50, Received: 50}},
+		}},
+		{Hash: chainhash.Hash{2}, Height: 2, Deltas: map[string][]BalanceDelta{
+			"a": {{TxHash: chainhash.Hash{21}, Value: -20, Sent: 20}},
+			"b": {{TxHash: chainhash.Hash{21}, Value: 20, Received: 20}},
+		}},
+		{Hash: chainhash.Hash{3}, Height: 3, Deltas: map[string][]BalanceDelta{
+			"b": {{TxHash: chainhash.Hash{31}, Value: 5, Received: 5}},
+		}},
+		{Hash: chainhash.Hash{4}, Height: 4, Deltas: map[string][]BalanceDelta{
+			"b": {{TxHash: chainhash.Hash{41}, Value: 7, Received: 7}},
+		}},
+	}
+	if err := repo.ConnectBlocks(blocks); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+
+	checkBalance := func(want *Balance) {
+		t.Helper()
+
+		got, err := repo.Get(want.PublicKey)
+		if err != nil {
+			t.Fatalf("Get: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Get: mismatched balance -- got %+v, want %+v",
+				got, want)
+		}
+	}
+	checkHistory := func(publicKey string, want []*BalanceHistoryEntry) {
+		t.Helper()
+
+		got, err := repo.BalanceHistory(publicKey, 0, 10)
+		if err != nil {
+			t.Fatalf("BalanceHistory: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("BalanceHistory(%s): mismatched history -- got "+
+				"%+v, want %+v", publicKey, got, want)
+		}
+	}
+	checkTip := func(want *BlockDeltas) {
+		t.Helper()
+
+		hash, height, err := repo.Tip()
+		if err != nil || hash == nil || *hash != want.Hash ||
+			height != want.Height {
+
+			t.Fatalf("Tip: unexpected tip -- got %v (%d), error %v",
+				hash, height, err)
+		}
+	}
+
+	// Replace the last block of the batch with one that touches an address
+	// whose last change is below it.
+	err := repo.DisconnectBlock(&blocks[2].Hash, 4, blocks[3].Deltas)
+	if err != nil {
+		t.Fatalf("DisconnectBlock: unexpected error: %v", err)
+	}
+	block4 := &BlockDeltas{Hash: chainhash.Hash{4, 1}, Height: 4,
+		Deltas: map[string][]BalanceDelta{
+			"a": {{TxHash: chainhash.Hash{42}, Value: -10, Sent: 10}},
+			"c": {{TxHash: chainhash.Hash{42}, Value: 10, Received: 10}},
+		}}
+	if err := repo.ConnectBlocks([]*BlockDeltas{block4}); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+
+	checkBalance(&Balance{PublicKey: "a", Value: 20, Received: 50,
+		Sent: 30, TxCount: 3, FirstSeen: 1, LastSeen: 4})
+	checkBalance(&Balance{PublicKey: "b", Value: 25, Received: 25,
+		TxCount: 2, FirstSeen: 2, LastSeen: 3})
+	checkHistory("a", []*BalanceHistoryEntry{
+		{Height: 1, TxHash: chainhash.Hash{11}, Delta: 50, Received: 50,
+			Balance: 50},
+		{Height: 2, TxHash: chainhash.Hash{21}, Delta: -20, Sent: 20,
+			Balance: 30},
+		{Height: 4, TxHash: chainhash.Hash{42}, Delta: -10, Sent: 10,
+			Balance: 20},
+	})
+	checkTip(block4)
+
+	// Replace the last two blocks with one that touches an address whose
+	// only change is in the top disconnected block.
+	err = repo.DisconnectBlock(&blocks[2].Hash, 4, block4.Deltas)
+	if err != nil {
+		t.Fatalf("DisconnectBlock: unexpected error: %v", err)
+	}
+	err = repo.DisconnectBlock(&blocks[1].Hash, 3, blocks[2].Deltas)
+	if err != nil {
+		t.Fatalf("DisconnectBlock: unexpected error: %v", err)
+	}
+	block3 := &BlockDeltas{Hash: chainhash.Hash{3, 1}, Height: 3,
+		Deltas: map[string][]BalanceDelta{
+			"c": {{TxHash: chainhash.Hash{32}, Value: 4, Received: 4}},
+		}}
+	if err := repo.ConnectBlocks([]*BlockDeltas{block3}); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+
+	checkBalance(&Balance{PublicKey: "a", Value: 30, Received: 50,
+		Sent: 20, TxCount: 2, FirstSeen: 1, LastSeen: 2})
+	checkBalance(&Balance{PublicKey: "b", Value: 20, Received: 20,
+		TxCount: 1, FirstSeen: 2, LastSeen: 2})
+	checkBalance(&Balance{PublicKey: "c", Value: 4, Received: 4,
+		TxCount: 1, FirstSeen: 3, LastSeen: 3})
+	checkHistory("c", []*BalanceHistoryEntry{
+		{Height: 3, TxHash: chainhash.Hash{32}, Delta: 4, Received: 4,
+			Balance: 4},
+	})
+	for _, test := range []struct {
+		publicKey string
+		height    int32
+		want      int64
+	}{
+		{"a", 4, 30},
+		{"b", 4, 20},
+		{"c", 2, 0},
+		{"c", 4, 4},
+	} {
+		got, err := repo.BalanceAtHeight(test.publicKey, test.height)
+		if err != nil {
+			t.Fatalf("BalanceAtHeight: unexpected error: %v", err)
+		}
+		if got != test.want {
+			t.Fatalf("BalanceAtHeight(%s, %d): got %d, want %d",
+				test.publicKey, test.height, got, test.want)
+		}
+	}
+	checkTip(block3)
+}
+
+// TestMemoryBalanceReorg ensures the in-memory repository applies the blocks
+// of a reorganization as expected.
+func TestMemoryBalanceReorg(t *testing.T) {
+	t.Parallel()
+
+	testBalanceReorg(t, NewMemoryBalanceRepository())
+}
+
+// TestLevelDbBalanceReorg ensures the leveldb repository applies the blocks of
+// a reorganization as expected.
+func TestLevelDbBalanceReorg(t *testing.T) {
+	t.Parallel()
+
+	path, err := ioutil.TempDir("", "balances")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+
+	repo, err := NewLevelDbBalanceRepository(path)
+	if err != nil {
+		t.Fatalf("unable to open balance repository: %v", err)
+	}
+	defer repo.Close()
+
+	testBalanceReorg(t, repo)
+}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
+)
+
+// BlockDeltas holds the balance changes caused by a single block.
+type BlockDeltas struct {
+	Hash   chainhash.Hash
+	Height int32
+
+	// Deltas holds the changes keyed by balance key and in the order of
+	// the transactions of the block.
+	Deltas map[string][]BalanceDelta
+}
+
+// BlockDeltas calculates the change in balance every transaction of the passed
+// block causes for each key it touches.  The changes are keyed by balance key
+// and in the order of the transactions of the block.  Transactions that neither
+// pay to nor spend from a key are omitted for it, while ones that pay back
+// exactly what they spend are included with a net change of zero.  Zero value
+// outputs don't touch a key.  Since every output is recorded
+// under some key, the changes of a block always add up to the value it added to
+// the utxo set.  The spent slice must contain the outputs spent by the block in
+// the order they are referenced by the inputs of the non-coinbase transactions
+// as returned by FetchSpendJournal.
+func (p *KeyPolicy) BlockDeltas(block *btcutil.Block, spent []blockchain.SpentTxOut) (map[string][]BalanceDelta, error) {
+	deltas := make(map[string][]BalanceDelta)
+
+	var spentIdx int
+	for txIdx, tx := range block.Transactions() {
+		msgTx := tx.MsgTx()
+		keyMap := make(map[string]*BalanceDelta)
+		keyDelta := func(key string) *BalanceDelta {
+			delta, ok := keyMap[key]
+			if !ok {
+				delta = &BalanceDelta{TxHash: *tx.Hash()}
+				keyMap[key] = delta
+			}
+			return delta
+		}
+
+		// Coinbases do not reference any inputs.
+		if txIdx != 0 {
+			for i, txIn := range msgTx.TxIn {
+				if spentIdx >= len(spent) {
+					return nil, fmt.Errorf("missing spent "+
+						"output %v for input %d of "+
+						"transaction %v in block %v",
+						&txIn.PreviousOutPoint, i,
+						tx.Hash(), block.Hash())
+				}
+				stxo := &spent[spentIdx]
+				spentIdx++
+
+				if stxo.Amount == 0 {
+					continue
+				}
+				delta := keyDelta(p.ScriptKey(stxo.PkScript))
+				delta.Sent += stxo.Amount
+				delta.Value -= stxo.Amount
+			}
+		}
+
+		for _, txOut := range msgTx.TxOut {
+			if txOut.Value == 0 {
+				continue
+			}
+			delta := keyDelta(p.ScriptKey(txOut.PkScript))
+			delta.Received += txOut.Value
+			delta.Value += txOut.Value
+		}
+
+		for key, delta := range keyMap {
+			deltas[key] = append(deltas[key], *delta)
+		}
+	}
+
+	return deltas, nil
+}
+
+// historyDelta is a balance change along with the height and sequence number
+// it is recorded under in the balance history.
+type historyDelta struct {
+	height int32
+	seq    uint32
+	delta  *BalanceDelta
+}
+
+// groupByKey returns the balance changes of the passed blocks grouped by key in
+// chain order.
+func groupByKey(blocks []*BlockDeltas) map[string][]historyDelta {
+	changes := make(map[string][]historyDelta)
+	for _, block := range blocks {
+		for key, deltas := range block.Deltas {
+			for i := range deltas {
+				changes[key] = append(changes[key], historyDelta{
+					height: block.Height,
+					seq:    uint32(i),
+					delta:  &deltas[i],
+				})
+			}
+		}
+	}
+	return changes
+}
@@ -0,0 +1,560 @@
+package data
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+const (
+	// cachedBalanceSize is the approximate number of bytes a cached balance
+	// occupies, including its key and the map entry it is stored in.
+	cachedBalanceSize = 160
+
+	// pendingDeltaSize is the approximate number of bytes a queued balance
+	// change occupies, including its key and share of the block it is
+	// queued with.
+	pendingDeltaSize = 128
+)
+
+// CachedBalanceRepository is a write-behind cache in front of another balance
+// repository, which is intended for remote repositories where every write is a
+// round trip.  Connected blocks are queued in memory and written to the
+// underlying repository together with ConnectBlocks, which coalesces the
+// changes to every address across all of them, once the cache exceeds its
+// maximum size, once the flush interval has passed, or when the cache is
+// closed.  Balances that are read are kept in memory until the cache runs out
+// of room, so repeatedly read balances don't cause round trips either.
+//
+// The tip of the underlying repository only advances when the queued blocks
+// are flushed, so after a crash it is the last flushed block and the blocks
+// that were only queued are applied again as usual.  Reads reflect the queued
+// blocks, however, so the cache behaves like the underlying repository with
+// all of them connected.
+//
+// Like the database cache, all flushing is performed opportunistically when a
+// block is connected, before operations that need the underlying repository to
+// be current, and when the cache is closed.
+type CachedBalanceRepository struct {
+	repo          IBalanceRepository
+	maxSize       uint64
+	flushInterval time.Duration
+
+	// mtx protects all of the following fields.
+	mtx sync.Mutex
+
+	// flushedHash and flushedHeight are the tip of the underlying
+	// repository.  The hash is nil when no block has been applied to it.
+	flushedHash   *chainhash.Hash
+	flushedHeight int32
+
+	// pending holds the connected blocks that have not been flushed yet in
+	// chain order, while pendingSize is their approximate size in bytes.
+	pending     []*BlockDeltas
+	pendingSize uint64
+
+	// balances holds the cached balances of the addresses, which include
+	// the changes of the pending blocks.
+	balances map[string]*Balance
+
+	// lastFlush is the time the cache was last flushed and flushFailed
+	// is whether or not the last attempt to flush it failed, in which
+	// case the underlying repository may hold part of the pending blocks.
+	lastFlush   time.Time
+	flushFailed bool
+}
+
+// Ensure CachedBalanceRepository implements the IBalanceRepository interface.
+var _ IBalanceRepository = (*CachedBalanceRepository)(nil)
+
+// NewCachedBalanceRepository returns a write-behind cache in front of the
+// passed balance repository that is flushed once it exceeds maxSize bytes or
+// once flushInterval has passed since the last flush.  The cache takes over the
+// repository, which is closed along with it.
+func NewCachedBalanceRepository(repo IBalanceRepository, maxSize uint64, flushInterval time.Duration) (*CachedBalanceRepository, error) {
+	hash, height, err := repo.Tip()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CachedBalanceRepository{
+		repo:          repo,
+		maxSize:       maxSize,
+		flushInterval: flushInterval,
+		flushedHash:   hash,
+		flushedHeight: height,
+		balances:      make(map[string]*Balance),
+		lastFlush:     time.Now(),
+	}, nil
+}
+
+// Get returns the cached balance of the passed public key.  Balances that are
+// not cached yet are loaded from the underlying repository and brought up to
+// date with the pending blocks.
+func (c *CachedBalanceRepository) Get(publicKey string) (*Balance, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	balance, err := c.cachedBalance(publicKey)
+	if err != nil || balance == nil {
+		return nil, err
+	}
+	balanceCopy := *balance
+	return &balanceCopy, nil
+}
+
+// cachedBalance returns the cached balance of the passed public key, loading it
+// when needed.  The returned balance must not be modified.  It returns nil when
+// the address has no balance.
+//
+// This function MUST be called with the cache lock held.
+func (c *CachedBalanceRepository) cachedBalance(publicKey string) (*Balance, error) {
+	if balance, ok := c.balances[publicKey]; ok {
+		return balance, nil
+	}
+
+	if err := c.recoverFlush(); err != nil {
+		return nil, err
+	}
+	balance, err := c.repo.Get(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range c.pending {
+		deltas := block.Deltas[publicKey]
+		if len(deltas) == 0 {
+			continue
+		}
+		if balance == nil {
+			balance = &Balance{PublicKey: publicKey}
+		}
+		balance.connect(block.Height, deltas)
+	}
+	if balance == nil {
+		return nil, nil
+	}
+
+	c.evict(cachedBalanceSize)
+	c.balances[publicKey] = balance
+	return balance, nil
+}
+
+// evict evicts cached balances until there is room for the passed number of
+// bytes or no balance is left.  Arbitrary balances are evicted, which is cheap
+// since map iteration starts at a random entry.
+//
+// This function MUST be called with the cache lock held.
+func (c *CachedBalanceRepository) evict(needed uint64) {
+	for key := range c.balances {
+		if c.size()+needed <= c.maxSize {
+			break
+		}
+		delete(c.balances, key)
+	}
+}
+
+// Insert flushes the pending blocks and then inserts the passed balance into
+// the underlying repository.
+func (c *CachedBalanceRepository) Insert(balance *Balance) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err := c.flush(); err != nil {
+		return err
+	}
+	delete(c.balances, balance.PublicKey)
+	return c.repo.Insert(balance)
+}
+
+// Update flushes the pending blocks and then updates the passed balance in the
+// underlying repository.
+func (c *CachedBalanceRepository) Update(balance *Balance) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err := c.flush(); err != nil {
+		return err
+	}
+	delete(c.balances, balance.PublicKey)
+	return c.repo.Update(balance)
+}
+
+// ConnectBlock queues the passed block to be written to the underlying
+// repository.
+func (c *CachedBalanceRepository) ConnectBlock(hash *chainhash.Hash, height int32, deltas map[string][]BalanceDelta) error {
+	return c.ConnectBlocks([]*BlockDeltas{{
+		Hash:   *hash,
+		Height: height,
+		Deltas: deltas,
+	}})
+}
+
+// ConnectBlocks queues the passed blocks to be written to the underlying
+// repository and flushes the cache when it exceeds its maximum size or the
+// flush interval has passed.
+//
+// The blocks are queued even when the flush fails, in which case they are
+// reflected by the cache and the error is returned.  Blocks that are already
+// queued are skipped, so failed calls can simply be retried, which retries the
+// flush.  The tip of the underlying repository remains the last block that was
+// flushed successfully, so recovering from it is exact.
+func (c *CachedBalanceRepository) ConnectBlocks(blocks []*BlockDeltas) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	_, tipHeight := c.tip()
+	for _, block := range blocks {
+		if block.Height <= tipHeight {
+			if !c.isQueued(block) {
+				return fmt.Errorf("block %v (height %d) does not "+
+					"extend the balance cache tip (height %d)",
+					block.Hash, block.Height, tipHeight)
+			}
+			continue
+		}
+
+		var numDeltas int
+		for publicKey, deltas := range block.Deltas {
+			if len(deltas) == 0 {
+				continue
+			}
+			if balance, ok := c.balances[publicKey]; ok {
+				balance.connect(block.Height, deltas)
+			}
+			numDeltas += len(deltas)
+		}
+		c.pending = append(c.pending, block)
+		c.pendingSize += uint64(numDeltas) * pendingDeltaSize
+		tipHeight = block.Height
+	}
+
+	// Queued blocks take precedence over cached balances, so the cache is
+	// only flushed once they fill it on their own.
+	c.evict(0)
+	if !c.needsFlush() {
+		return nil
+	}
+	return c.flush()
+}
+
+// isQueued returns whether or not the passed block is one of the pending
+// blocks.
+//
+// This function MUST be called with the cache lock held.
+func (c *CachedBalanceRepository) isQueued(block *BlockDeltas) bool {
+	if len(c.pending) == 0 {
+		return false
+	}
+	i := int(block.Height - c.pending[0].Height)
+	return i >= 0 && i < len(c.pending) && c.pending[i].Hash == block.Hash
+}
+
+// DisconnectBlock reverses the passed balance changes.  A pending block is
+// simply dropped from the cache, while a block that was flushed already is
+// disconnected from the underlying repository.
+func (c *CachedBalanceRepository) DisconnectBlock(prevHash *chainhash.Hash, height int32, deltas map[string][]BalanceDelta) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err := c.recoverFlush(); err != nil {
+		return err
+	}
+
+	if len(c.pending) == 0 {
+		err := c.repo.DisconnectBlock(prevHash, height, deltas)
+		if err != nil {
+			return err
+		}
+		for publicKey := range deltas {
+			delete(c.balances, publicKey)
+		}
+		prevHashCopy := *prevHash
+		c.flushedHash, c.flushedHeight = &prevHashCopy, height-1
+		return nil
+	}
+
+	last := c.pending[len(c.pending)-1]
+	if last.Height != height {
+		return fmt.Errorf("block at height %d is not the balance "+
+			"cache tip (height %d)", height, last.Height)
+	}
+
+	var numDeltas int
+	for _, deltas := range last.Deltas {
+		numDeltas += len(deltas)
+	}
+	c.pending[len(c.pending)-1] = nil
+	c.pending = c.pending[:len(c.pending)-1]
+	c.pendingSize -= uint64(numDeltas) * pendingDeltaSize
+
+	// The balances of the addresses the block touched are reloaded since
+	// restoring their last seen height requires their history.  Like the
+	// underlying repositories, addresses that are left without any
+	// transactions keep an empty balance.
+	for publicKey := range last.Deltas {
+		delete(c.balances, publicKey)
+		balance, err := c.cachedBalance(publicKey)
+		if err != nil {
+			return err
+		}
+		if balance == nil {
+			c.evict(cachedBalanceSize)
+			c.balances[publicKey] = &Balance{PublicKey: publicKey}
+		}
+	}
+	return nil
+}
+
+// Tip returns the last pending block or the tip of the underlying repository
+// when there is none.
+func (c *CachedBalanceRepository) Tip() (*chainhash.Hash, int32, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	hash, height := c.tip()
+	if hash == nil {
+		return nil, 0, nil
+	}
+	hashCopy := *hash
+	return &hashCopy, height, nil
+}
+
+// tip returns the last pending block or the tip of the underlying repository
+// when there is none.
+//
+// This function MUST be called with the cache lock held.
+func (c *CachedBalanceRepository) tip() (*chainhash.Hash, int32) {
+	if len(c.pending) == 0 {
+		return c.flushedHash, c.flushedHeight
+	}
+	last := c.pending[len(c.pending)-1]
+	return &last.Hash, last.Height
+}
+
+// TopBalances flushes the pending blocks and then ranks the balances of the
+// underlying repository.
+func (c *CachedBalanceRepository) TopBalances(count int, minValue int64) ([]*Balance, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+	return c.repo.TopBalances(count, minValue)
+}
+
+// ForEachBalance flushes the pending blocks and then iterates the balances of
+// the underlying repository.  The cache is not locked during the iteration, so
+// the passed function may access it, such as to look up past balances.
+func (c *CachedBalanceRepository) ForEachBalance(fn func(balance *Balance) error) error {
+	c.mtx.Lock()
+	err := c.flush()
+	c.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.repo.ForEachBalance(fn)
+}
+
+// CountBalances flushes the pending blocks and then counts the balances of the
+// underlying repository.
+func (c *CachedBalanceRepository) CountBalances(minValue int64) (int64, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err := c.flush(); err != nil {
+		return 0, err
+	}
+	return c.repo.CountBalances(minValue)
+}
+
+// BalanceHistory combines the history recorded by the underlying repository
+// with the changes of the pending blocks.
+func (c *CachedBalanceRepository) BalanceHistory(publicKey string, fromHeight, toHeight int32) ([]*BalanceHistoryEntry, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if fromHeight > toHeight {
+		return nil, nil
+	}
+	if err := c.recoverFlush(); err != nil {
+		return nil, err
+	}
+
+	var entries []*BalanceHistoryEntry
+	if fromHeight <= c.flushedHeight {
+		flushedTo := toHeight
+		if flushedTo > c.flushedHeight {
+			flushedTo = c.flushedHeight
+		}
+		var err error
+		entries, err = c.repo.BalanceHistory(publicKey, fromHeight,
+			flushedTo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if toHeight <= c.flushedHeight || !c.touchedByPending(publicKey) {
+		return entries, nil
+	}
+
+	value, err := c.flushedValue(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range c.pending {
+		if block.Height > toHeight {
+			break
+		}
+		for _, delta := range block.Deltas[publicKey] {
+			value += delta.Value
+			if block.Height < fromHeight {
+				continue
+			}
+			entries = append(entries, &BalanceHistoryEntry{
+				Height:   block.Height,
+				TxHash:   delta.TxHash,
+				Delta:    delta.Value,
+				Received: delta.Received,
+				Sent:     delta.Sent,
+				Balance:  value,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// BalanceAtHeight returns the balance of the passed public key as of the passed
+// height, taking the pending blocks into account.
+func (c *CachedBalanceRepository) BalanceAtHeight(publicKey string, height int32) (int64, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err := c.recoverFlush(); err != nil {
+		return 0, err
+	}
+	if height <= c.flushedHeight || !c.touchedByPending(publicKey) {
+		return c.repo.BalanceAtHeight(publicKey, height)
+	}
+
+	value, err := c.flushedValue(publicKey)
+	if err != nil {
+		return 0, err
+	}
+	for _, block := range c.pending {
+		if block.Height > height {
+			break
+		}
+		for _, delta := range block.Deltas[publicKey] {
+			value += delta.Value
+		}
+	}
+	return value, nil
+}
+
+// touchedByPending returns whether or not any of the pending blocks changes the
+// balance of the passed public key.
+//
+// This function MUST be called with the cache lock held.
+func (c *CachedBalanceRepository) touchedByPending(publicKey string) bool {
+	for _, block := range c.pending {
+		if len(block.Deltas[publicKey]) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// flushedValue returns the balance value of the passed public key in the
+// underlying repository, which is the one as of the flushed tip.
+//
+// This function MUST be called with the cache lock held.
+func (c *CachedBalanceRepository) flushedValue(publicKey string) (int64, error) {
+	balance, err := c.repo.Get(publicKey)
+	if err != nil || balance == nil {
+		return 0, err
+	}
+	return balance.Value, nil
+}
+
+// Flush writes the pending blocks to the underlying repository.
+func (c *CachedBalanceRepository) Flush() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.flush()
+}
+
+// flush writes the pending blocks to the underlying repository with a single
+// ConnectBlocks call.  The pending blocks are kept when it fails so the flush
+// can be retried.  The cached balances stay valid either way.
+//
+// This function MUST be called with the cache lock held.
+func (c *CachedBalanceRepository) flush() error {
+	if len(c.pending) == 0 {
+		c.lastFlush = time.Now()
+		return nil
+	}
+
+	if err := c.repo.ConnectBlocks(c.pending); err != nil {
+		c.flushFailed = true
+		return err
+	}
+
+	last := c.pending[len(c.pending)-1]
+	c.flushedHash, c.flushedHeight = &last.Hash, last.Height
+	c.pending = nil
+	c.pendingSize = 0
+	c.flushFailed = false
+	c.lastFlush = time.Now()
+	return nil
+}
+
+// recoverFlush retries the last flush when it failed.  The underlying
+// repository may hold part of the pending blocks after a failed flush, so they
+// have to be written completely before its balances can be combined with the
+// pending blocks or any of them can be disconnected.
+//
+// This function MUST be called with the cache lock held.
+func (c *CachedBalanceRepository) recoverFlush() error {
+	if !c.flushFailed {
+		return nil
+	}
+	return c.flush()
+}
+
+// needsFlush returns whether or not the cache needs to be flushed based on its
+// size and how much time has elapsed since it was last flushed.  A failed flush
+// is retried every time so an unavailable repository is reported rather than
+// pending blocks piling up.
+//
+// This function MUST be called with the cache lock held.
+func (c *CachedBalanceRepository) needsFlush() bool {
+	if len(c.pending) == 0 {
+		return false
+	}
+	return c.flushFailed || time.Since(c.lastFlush) > c.flushInterval ||
+		c.size() > c.maxSize
+}
+
+// size returns the approximate size of the cache in bytes.
+//
+// This function MUST be called with the cache lock held.
+func (c *CachedBalanceRepository) size() uint64 {
+	return c.pendingSize + uint64(len(c.balances))*cachedBalanceSize
+}
+
+// Close flushes the pending blocks and closes the underlying repository, which
+// happens even when the flush fails.
+func (c *CachedBalanceRepository) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	flushErr := c.flush()
+	if err := c.repo.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
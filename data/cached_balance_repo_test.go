@@ -0,0 +1,317 @@
+package data
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// flakyBalanceRepository wraps a balance repository to count the ConnectBlocks
+// calls made to it and to fail them on demand.  Like the remote repositories,
+// a failing call may have connected part of the passed blocks, and blocks the
+// repository already holds are skipped when they are passed again.
+type flakyBalanceRepository struct {
+	IBalanceRepository
+	connects int
+	fail     bool
+}
+
+// ConnectBlocks counts the call and connects the passed blocks the repository
+// does not hold yet.  Only the first of them is connected when the repository
+// is set to fail.
+func (r *flakyBalanceRepository) ConnectBlocks(blocks []*BlockDeltas) error {
+	r.connects++
+
+	_, tipHeight, err := r.Tip()
+	if err != nil {
+		return err
+	}
+	for len(blocks) != 0 && blocks[0].Height <= tipHeight {
+		blocks = blocks[1:]
+	}
+	if !r.fail {
+		return r.IBalanceRepository.ConnectBlocks(blocks)
+	}
+	if err := r.IBalanceRepository.ConnectBlocks(blocks[:1]); err != nil {
+		return err
+	}
+	return errors.New("connection reset")
+}
+
+// newTestBalanceCache returns a cache with the passed maximum size and flush
+// interval in front of a flaky in-memory repository.
+func newTestBalanceCache(t *testing.T, maxSize uint64, flushInterval time.Duration) (*CachedBalanceRepository, *flakyBalanceRepository) {
+	t.Helper()
+
+	backend := &flakyBalanceRepository{
+		IBalanceRepository: NewMemoryBalanceRepository(),
+	}
+	cache, err := NewCachedBalanceRepository(backend, maxSize, flushInterval)
+	if err != nil {
+		t.Fatalf("NewCachedBalanceRepository: unexpected error: %v", err)
+	}
+	return cache, backend
+}
+
+// TestCachedBalanceIteration ensures the balance cache iterates, counts and
+// ranks balances as expected.
+func TestCachedBalanceIteration(t *testing.T) {
+	t.Parallel()
+
+	cache, _ := newTestBalanceCache(t, 1<<20, time.Hour)
+	testBalanceIteration(t, cache)
+
+	// The cache may be accessed while iterating, which is done to look up
+	// past balances when they are dumped.  Untracked balances are dumped as
+	// is, so they are skipped.
+	err := cache.ForEachBalance(func(balance *Balance) error {
+		if balance.IsUntracked() {
+			return nil
+		}
+		_, err := BalanceAsOf(cache, balance.PublicKey, balance, 0)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ForEachBalance: unexpected error: %v", err)
+	}
+}
+
+// TestCachedBalanceStats ensures the balance cache tracks the totals of the
+// balances as expected while the blocks are pending.
+func TestCachedBalanceStats(t *testing.T) {
+	t.Parallel()
+
+	cache, backend := newTestBalanceCache(t, 1<<20, time.Hour)
+	testBalanceStats(t, cache)
+	if backend.connects != 0 {
+		t.Fatalf("ConnectBlocks: unexpected flush -- got %d calls, want 0",
+			backend.connects)
+	}
+}
+
+// TestCachedBalanceFlush ensures the balance cache coalesces the pending blocks
+// into a single write, flushes them on its size and time thresholds and on
+// close, and recovers exactly from failed flushes.
+func TestCachedBalanceFlush(t *testing.T) {
+	t.Parallel()
+
+	blocks := []*BlockDeltas{
+		{Hash: chainhash.Hash{1}, Height: 1, Deltas: map[string][]BalanceDelta{
+			"a": {{TxHash: chainhash.Hash{11}, Value: 50, Received: 50}},
+		}},
+		{Hash: chainhash.Hash{2}, Height: 2, Deltas: map[string][]BalanceDelta{
+			"a": {{TxHash: chainhash.Hash{21}, Value: -20, Sent: 20}},
+			"b": {{TxHash: chainhash.Hash{21}, Value: 20, Received: 20}},
+		}},
+		{Hash: chainhash.Hash{3}, Height: 3, Deltas: map[string][]BalanceDelta{
+			"b": {{TxHash: chainhash.Hash{31}, Value: 5, Received: 5}},
+		}},
+	}
+	a2 := &Balance{PublicKey: "a", Value: 30, Received: 50, Sent: 20,
+		TxCount: 2, FirstSeen: 1, LastSeen: 2}
+	b3 := &Balance{PublicKey: "b", Value: 25, Received: 25, TxCount: 2,
+		FirstSeen: 2, LastSeen: 3}
+
+	checkTip := func(repo IBalanceRepository, wantHash *chainhash.Hash, wantHeight int32) {
+		t.Helper()
+
+		hash, height, err := repo.Tip()
+		if err != nil {
+			t.Fatalf("Tip: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(hash, wantHash) || height != wantHeight {
+			t.Fatalf("Tip: mismatched tip -- got %v (%d), want %v (%d)",
+				hash, height, wantHash, wantHeight)
+		}
+	}
+	checkBalance := func(repo IBalanceRepository, want *Balance) {
+		t.Helper()
+
+		got, err := repo.Get(want.PublicKey)
+		if err != nil {
+			t.Fatalf("Get: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Get: mismatched balance -- got %+v, want %+v",
+				got, want)
+		}
+	}
+	checkConnects := func(backend *flakyBalanceRepository, want int) {
+		t.Helper()
+
+		if backend.connects != want {
+			t.Fatalf("ConnectBlocks: mismatched number of calls -- "+
+				"got %d, want %d", backend.connects, want)
+		}
+	}
+
+	// Pending blocks are reflected by the cache but not written to the
+	// underlying repository until the cache is flushed.
+	cache, backend := newTestBalanceCache(t, 1<<20, time.Hour)
+	if err := cache.ConnectBlocks(blocks[:2]); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+	if err := cache.ConnectBlocks(blocks[2:]); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+	checkConnects(backend, 0)
+	checkTip(cache, &chainhash.Hash{3}, 3)
+	checkTip(backend, nil, 0)
+	checkBalance(cache, a2)
+	checkBalance(cache, b3)
+	value, err := cache.BalanceAtHeight("b", 2)
+	if err != nil {
+		t.Fatalf("BalanceAtHeight: unexpected error: %v", err)
+	}
+	if value != 20 {
+		t.Fatalf("BalanceAtHeight: mismatched value -- got %d, want 20",
+			value)
+	}
+	history, err := cache.BalanceHistory("b", 3, 10)
+	if err != nil {
+		t.Fatalf("BalanceHistory: unexpected error: %v", err)
+	}
+	wantHistory := []*BalanceHistoryEntry{{Height: 3,
+		TxHash: chainhash.Hash{31}, Delta: 5, Received: 5, Balance: 25}}
+	if !reflect.DeepEqual(history, wantHistory) {
+		t.Fatalf("BalanceHistory: mismatched history -- got %+v, want %+v",
+			history, wantHistory)
+	}
+
+	// Connecting queued blocks again is a no-op.
+	if err := cache.ConnectBlocks(blocks[1:]); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+	checkBalance(cache, b3)
+
+	// Disconnecting a pending block drops it from the cache.
+	if err := cache.DisconnectBlock(&chainhash.Hash{2}, 3, blocks[2].Deltas); err != nil {
+		t.Fatalf("DisconnectBlock: unexpected error: %v", err)
+	}
+	checkTip(cache, &chainhash.Hash{2}, 2)
+	checkBalance(cache, &Balance{PublicKey: "b", Value: 20, Received: 20,
+		TxCount: 1, FirstSeen: 2, LastSeen: 2})
+	checkConnects(backend, 0)
+
+	// Flushing writes all pending blocks with a single call.
+	if err := cache.Flush(); err != nil {
+		t.Fatalf("Flush: unexpected error: %v", err)
+	}
+	checkConnects(backend, 1)
+	checkTip(backend, &chainhash.Hash{2}, 2)
+	checkBalance(backend, a2)
+
+	// Disconnecting a flushed block disconnects it from the underlying
+	// repository.
+	if err := cache.DisconnectBlock(&chainhash.Hash{1}, 2, blocks[1].Deltas); err != nil {
+		t.Fatalf("DisconnectBlock: unexpected error: %v", err)
+	}
+	checkTip(cache, &chainhash.Hash{1}, 1)
+	checkTip(backend, &chainhash.Hash{1}, 1)
+	a1 := &Balance{PublicKey: "a", Value: 50, Received: 50, TxCount: 1,
+		FirstSeen: 1, LastSeen: 1}
+	checkBalance(cache, a1)
+	checkBalance(backend, a1)
+
+	// A cache that exceeds its maximum size is flushed.
+	cache, backend = newTestBalanceCache(t, pendingDeltaSize*2, time.Hour)
+	if err := cache.ConnectBlocks(blocks[:1]); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+	checkConnects(backend, 0)
+	if err := cache.ConnectBlocks(blocks[1:2]); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+	checkConnects(backend, 1)
+	checkTip(backend, &chainhash.Hash{2}, 2)
+
+	// A cache is flushed once the flush interval has passed.
+	cache, backend = newTestBalanceCache(t, 1<<20, 0)
+	if err := cache.ConnectBlocks(blocks[:1]); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+	checkConnects(backend, 1)
+	checkTip(backend, &chainhash.Hash{1}, 1)
+
+	// A failed flush keeps the pending blocks and is retried by the next
+	// call, which leaves the underlying repository exact even though it
+	// applied part of the failed batch.
+	cache, backend = newTestBalanceCache(t, 1<<20, time.Hour)
+	if err := cache.ConnectBlocks(blocks); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+	backend.fail = true
+	if err := cache.Flush(); err == nil {
+		t.Fatal("Flush: did not receive expected error")
+	}
+	checkTip(cache, &chainhash.Hash{3}, 3)
+	checkTip(backend, &chainhash.Hash{1}, 1)
+	backend.fail = false
+	checkBalance(cache, b3)
+	checkConnects(backend, 2)
+	checkTip(backend, &chainhash.Hash{3}, 3)
+	checkBalance(backend, a2)
+	checkBalance(backend, b3)
+
+	// Closing the cache flushes the pending blocks.
+	cache, backend = newTestBalanceCache(t, 1<<20, time.Hour)
+	if err := cache.ConnectBlocks(blocks); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close: unexpected error: %v", err)
+	}
+	checkConnects(backend, 1)
+	checkTip(backend, &chainhash.Hash{3}, 3)
+	checkBalance(backend, b3)
+}
+
+// testCachedBalanceReorg ensures a balance cache that flushes every batch of
+// blocks it is passed in front of the passed empty repository applies the
+// blocks of a reorganization as expected, both to the cached balances and to
+// the ones of the repository.
+func testCachedBalanceReorg(t *testing.T, repo IBalanceRepository) {
+	t.Helper()
+
+	// A maximum size of zero flushes the pending blocks on every call, so
+	// the batch of blocks that is connected first is written with a single
+	// ConnectBlocks call before a block of it is disconnected again.
+	cache, err := NewCachedBalanceRepository(repo, 0, time.Hour)
+	if err != nil {
+		t.Fatalf("NewCachedBalanceRepository: unexpected error: %v", err)
+	}
+	testBalanceReorg(t, cache)
+
+	for _, publicKey := range []string{"a", "b", "c"} {
+		want, err := cache.Get(publicKey)
+		if err != nil {
+			t.Fatalf("Get: unexpected error: %v", err)
+		}
+		got, err := repo.Get(publicKey)
+		if err != nil {
+			t.Fatalf("Get: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Get: mismatched flushed balance -- got %+v, "+
+				"want %+v", got, want)
+		}
+	}
+}
+
+// TestCachedBalanceReorg ensures the balance cache applies the blocks of a
+// reorganization that replaces flushed blocks as expected.
+func TestCachedBalanceReorg(t *testing.T) {
+	t.Parallel()
+
+	backend := &flakyBalanceRepository{
+		IBalanceRepository: NewMemoryBalanceRepository(),
+	}
+	testCachedBalanceReorg(t, backend)
+	if backend.connects != 3 {
+		t.Fatalf("ConnectBlocks: unexpected flushes -- got %d calls, "+
+			"want 3", backend.connects)
+	}
+}
@@ -1,35 +1,167 @@
 package data
 
 import (
+	"hash/fnv"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+const (
+	// dynamoMaxBatchWrite is the maximum number of requests DynamoDB
+	// accepts in a single BatchWriteItem call.
+	dynamoMaxBatchWrite = 25
+
+	// dynamoRetryDelay is the delay before the first retry of batch write
+	// requests DynamoDB left unprocessed.
+	dynamoRetryDelay = time.Millisecond * 50
+
+	// dynamoValueIndexName is the name of the global secondary index that
+	// orders the balances by value.
+	dynamoValueIndexName = "ValueIndex"
+
+	// dynamoValueIndexShards is the number of partitions the balances are
+	// spread across in the value index.  A single partition would have
+	// to absorb every balance update, which exceeds the write capacity of
+	// a partition while catching up.
+	dynamoValueIndexShards = 16
+
+	// dynamoValueIndexReadyKey marks that every balance item has been
+	// assigned a value index shard.
+	dynamoValueIndexReadyKey = reservedKeyPrefix + "vready"
 )
 
 type DynamoBalanceRepository struct {
-	db        *dynamodb.DynamoDB
-	tableName string
+	db               *dynamodb.DynamoDB
+	tableName        string
+	historyTableName string
 }
 
-func NewDynamoBalanceRepository(clientId, clientSecret, tableName string) (*DynamoBalanceRepository, error) {
+// NewDynamoBalanceRepository returns a balance repository backed by the passed
+// DynamoDB table.  An empty endpoint selects the default AWS endpoint for the
+// region, while a non-empty one allows a local DynamoDB stand-in to be used.
+//
+// The balance history is kept in a second table named after the first one with
+// a History suffix.  It must have a string partition key named PublicKey and a
+// number sort key named Seq.
+//
+// The balance table must have a global secondary index named ValueIndex with a
+// number partition key named Shard and a number sort key named Value.  Balance
+// items written before the index was introduced are assigned a shard the first
+// time the repository is opened.
+func NewDynamoBalanceRepository(clientId, clientSecret, region, endpoint, tableName string) (*DynamoBalanceRepository, error) {
 	repo := new(DynamoBalanceRepository)
 	repo.tableName = tableName
+	repo.historyTableName = tableName + "History"
 
-	//TODO update settings
 	sessionConfig := &aws.Config{
 		Credentials: credentials.NewStaticCredentials(clientId, clientSecret, ""),
-		Region:      aws.String(endpoints.UsEast2RegionID),
-		Endpoint:    aws.String("http://localhost:8000"),
+		Region:      aws.String(region),
+	}
+	if endpoint != "" {
+		sessionConfig.Endpoint = aws.String(endpoint)
+	}
+	sess, err := session.NewSession(sessionConfig)
+	if err != nil {
+		return nil, err
+	}
+	repo.db = dynamodb.New(sess)
+
+	if err := repo.ensureValueIndex(); err != nil {
+		return nil, err
 	}
-	repo.db = dynamodb.New(session.Must(session.NewSession(sessionConfig)))
 
 	return repo, nil
 }
 
+// ensureValueIndex assigns a value index shard to every balance item that lacks
+// one unless all of them are already known to have one.
+func (t *DynamoBalanceRepository) ensureValueIndex() error {
+	input := &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PublicKey": {
+				S: aws.String(dynamoValueIndexReadyKey),
+			},
+		},
+		TableName:      aws.String(t.tableName),
+		ConsistentRead: aws.Bool(true),
+	}
+	result, err := t.db.GetItem(input)
+	if err != nil {
+		return err
+	}
+	if len(result.Item) != 0 {
+		return nil
+	}
+
+	scanInput := &dynamodb.ScanInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#P": aws.String("PublicKey"),
+			"#S": aws.String("Shard"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":r": {
+				S: aws.String(reservedKeyPrefix),
+			},
+		},
+		FilterExpression: aws.String("attribute_not_exists(#S) AND " +
+			"NOT begins_with(#P, :r)"),
+		TableName: aws.String(t.tableName),
+	}
+	var updateErr error
+	err = t.db.ScanPages(scanInput, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			publicKey := aws.StringValue(item["PublicKey"].S)
+			updateInput := &dynamodb.UpdateItemInput{
+				ExpressionAttributeNames: map[string]*string{
+					"#S": aws.String("Shard"),
+				},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":s": valueIndexShard(publicKey),
+				},
+				Key: map[string]*dynamodb.AttributeValue{
+					"PublicKey": {
+						S: aws.String(publicKey),
+					},
+				},
+				ReturnValues:     aws.String("NONE"),
+				TableName:        aws.String(t.tableName),
+				UpdateExpression: aws.String("SET #S = :s"),
+			}
+			if _, updateErr = t.db.UpdateItem(updateInput); updateErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if updateErr != nil {
+		return updateErr
+	}
+
+	putInput := &dynamodb.PutItemInput{
+		Item: map[string]*dynamodb.AttributeValue{
+			"PublicKey": {
+				S: aws.String(dynamoValueIndexReadyKey),
+			},
+		},
+		ReturnConsumedCapacity: aws.String("NONE"),
+		TableName:              aws.String(t.tableName),
+	}
+	_, err = t.db.PutItem(putInput)
+	return err
+}
+
 func (t *DynamoBalanceRepository) Get(publicKey string) (*Balance, error) {
 	input := &dynamodb.GetItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
@@ -45,18 +177,7 @@ func (t *DynamoBalanceRepository) Get(publicKey string) (*Balance, error) {
 		return nil, err
 	}
 
-	prop := result.Item
-	value, err := strconv.ParseInt(*prop["Value"].N, 10, 64)
-	if err != nil {
-		return nil, err
-	}
-
-	balance := &Balance{
-		PublicKey: *prop["PublicKey"].S,
-		Value:     value,
-	}
-
-	return balance, nil
+	return parseBalanceItem(result.Item)
 }
 
 func (t *DynamoBalanceRepository) Insert(balance *Balance) error {
@@ -65,13 +186,19 @@ func (t *DynamoBalanceRepository) Insert(balance *Balance) error {
 			"PublicKey": {
 				S: aws.String(balance.PublicKey),
 			},
-			"Value": {
-				N: aws.String(strconv.FormatInt(balance.Value, 10)),
-			},
+			"Value":     numberAttr(balance.Value),
+			"Received":  numberAttr(balance.Received),
+			"Sent":      numberAttr(balance.Sent),
+			"TxCount":   numberAttr(balance.TxCount),
+			"FirstSeen": numberAttr(int64(balance.FirstSeen)),
+			"LastSeen":  numberAttr(int64(balance.LastSeen)),
 		},
 		ReturnConsumedCapacity: aws.String("NONE"),
 		TableName:              aws.String(t.tableName),
 	}
+	if !isReservedKey(balance.PublicKey) {
+		input.Item["Shard"] = valueIndexShard(balance.PublicKey)
+	}
 	_, err := t.db.PutItem(input)
 	return err
 }
@@ -80,11 +207,19 @@ func (t *DynamoBalanceRepository) Update(balance *Balance) error {
 	input := &dynamodb.UpdateItemInput{
 		ExpressionAttributeNames: map[string]*string{
 			"#V": aws.String("Value"),
+			"#R": aws.String("Received"),
+			"#D": aws.String("Sent"),
+			"#N": aws.String("TxCount"),
+			"#F": aws.String("FirstSeen"),
+			"#L": aws.String("LastSeen"),
 		},
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":v": {
-				N: aws.String(strconv.FormatInt(balance.Value, 10)),
-			},
+			":v": numberAttr(balance.Value),
+			":r": numberAttr(balance.Received),
+			":d": numberAttr(balance.Sent),
+			":n": numberAttr(balance.TxCount),
+			":f": numberAttr(int64(balance.FirstSeen)),
+			":l": numberAttr(int64(balance.LastSeen)),
 		},
 		Key: map[string]*dynamodb.AttributeValue{
 			"PublicKey": {
@@ -93,9 +228,688 @@ func (t *DynamoBalanceRepository) Update(balance *Balance) error {
 		},
 		ReturnValues:     aws.String("NONE"),
 		TableName:        aws.String(t.tableName),
-		UpdateExpression: aws.String("SET #V = :v"),
+		UpdateExpression: aws.String("SET #V = :v, #R = :r, #D = :d, #N = :n, #F = :f, #L = :l"),
 	}
+	setShard(input, balance.PublicKey)
 
 	_, err := t.db.UpdateItem(input)
 	return err
 }
+
+// ConnectBlock adds the passed balance changes to the stored balances, records
+// them in the history table and then records the passed block as the tip.
+func (t *DynamoBalanceRepository) ConnectBlock(hash *chainhash.Hash, height int32, deltas map[string][]BalanceDelta) error {
+	return t.ConnectBlocks([]*BlockDeltas{{
+		Hash:   *hash,
+		Height: height,
+		Deltas: deltas,
+	}})
+}
+
+// ConnectBlocks adds the balance changes of all of the passed blocks to the
+// stored balances, records them in the history table and then records the last
+// block as the tip.
+//
+// DynamoDB transactions are limited to a small number of items, far fewer than
+// the addresses a single block can touch, so the changes can't be written in
+// one transaction.  Instead, every item remembers the height of the last change
+// it was updated with and each change is an atomic ADD that is conditional on
+// the item not already being updated with one of the connected changes.
+// Tracking the height of the last change rather than the one of the last block
+// keeps an address that isn't touched by the disconnected blocks of a
+// reorganization from skipping the changes of the blocks that replace them.
+// Replaying blocks that were only partially applied before a crash therefore
+// skips the changes the items were already updated with, which results in the
+// same balances as an atomic write, even when the blocks are replayed in
+// different batches.  History items are keyed by height and position, so
+// writing them again is harmless.  The running balances they record are rebuilt
+// from the last history item before the blocks when the item was already
+// updated with some of them, since its value may include the changes of later
+// blocks as well.
+func (t *DynamoBalanceRepository) ConnectBlocks(blocks []*BlockDeltas) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	last := blocks[len(blocks)-1]
+	for publicKey, changes := range groupByKey(blocks) {
+		// The item may already be updated with some of the changes
+		// when they were applied in a different batch before, in which
+		// case only the later changes are added.
+		var value int64
+		var skipped bool
+		for remaining := changes; len(remaining) > 0; {
+			totals := &Balance{TxCount: int64(len(remaining))}
+			for i := range remaining {
+				totals.Value += remaining[i].delta.Value
+				totals.Received += remaining[i].delta.Received
+				totals.Sent += remaining[i].delta.Sent
+			}
+			lastHeight := remaining[len(remaining)-1].height
+			input := t.balanceUpdate(publicKey, totals, lastHeight)
+			input.ExpressionAttributeValues[":f"] =
+				numberAttr(int64(remaining[0].height))
+			input.ExpressionAttributeValues[":l"] =
+				numberAttr(int64(lastHeight))
+			input.UpdateExpression = aws.String(*input.UpdateExpression +
+				", #F = if_not_exists(#F, :f), #L = :l")
+			var height int32
+			var applied bool
+			var err error
+			value, height, applied, err = t.updateBalance(input,
+				"attribute_not_exists(#H) OR #H < :c",
+				remaining[0].height)
+			if err != nil {
+				return err
+			}
+			if !applied && len(remaining) == len(changes) {
+				skipped = true
+			}
+			for len(remaining) > 0 && remaining[0].height <= height {
+				remaining = remaining[1:]
+			}
+		}
+
+		// Rebuild the running balance from the balance before the
+		// blocks.
+		if skipped {
+			prev, err := t.lastHistoryItem(publicKey,
+				changes[0].height-1)
+			if err != nil {
+				return err
+			}
+			value = 0
+			if prev != nil {
+				value = prev.Balance
+			}
+		} else {
+			for i := range changes {
+				value -= changes[i].delta.Value
+			}
+		}
+		requests := make([]*dynamodb.WriteRequest, 0, len(changes))
+		for i := range changes {
+			change := &changes[i]
+			value += change.delta.Value
+			item := historyItemKey(publicKey, change.height,
+				change.seq)
+			item["Height"] = &dynamodb.AttributeValue{
+				N: aws.String(strconv.FormatInt(int64(change.height), 10)),
+			}
+			item["TxHash"] = &dynamodb.AttributeValue{
+				S: aws.String(change.delta.TxHash.String()),
+			}
+			item["Delta"] = &dynamodb.AttributeValue{
+				N: aws.String(strconv.FormatInt(change.delta.Value, 10)),
+			}
+			item["Balance"] = &dynamodb.AttributeValue{
+				N: aws.String(strconv.FormatInt(value, 10)),
+			}
+			item["Received"] = numberAttr(change.delta.Received)
+			item["Sent"] = numberAttr(change.delta.Sent)
+			requests = append(requests, &dynamodb.WriteRequest{
+				PutRequest: &dynamodb.PutRequest{Item: item},
+			})
+		}
+		if err := t.writeHistory(requests); err != nil {
+			return err
+		}
+	}
+
+	return t.putTip(last.Hash.String(), last.Height)
+}
+
+// DisconnectBlock reverses the passed balance changes, removes their history
+// items and then records the parent of the block as the tip.  Like
+// ConnectBlocks, replaying a partially applied disconnect skips the items that
+// were already updated.  The last seen height and the height of the last
+// change are restored from the history items below the block, which are left
+// untouched.
+func (t *DynamoBalanceRepository) DisconnectBlock(prevHash *chainhash.Hash, height int32, deltas map[string][]BalanceDelta) error {
+	for publicKey, addrDeltas := range deltas {
+		if len(addrDeltas) == 0 {
+			continue
+		}
+
+		totals := &Balance{TxCount: -int64(len(addrDeltas))}
+		for i := range addrDeltas {
+			totals.Value -= addrDeltas[i].Value
+			totals.Received -= addrDeltas[i].Received
+			totals.Sent -= addrDeltas[i].Sent
+		}
+		prev, err := t.lastHistoryItem(publicKey, height-1)
+		if err != nil {
+			return err
+		}
+		prevHeight := int32(-1)
+		if prev != nil {
+			prevHeight = prev.Height
+		}
+		input := t.balanceUpdate(publicKey, totals, prevHeight)
+		update := *input.UpdateExpression
+		if prev != nil {
+			input.ExpressionAttributeValues[":l"] =
+				numberAttr(int64(prev.Height))
+			update += ", #L = :l"
+		} else {
+			update += " REMOVE #F, #L"
+		}
+		input.UpdateExpression = aws.String(update)
+		_, _, _, err = t.updateBalance(input,
+			"attribute_not_exists(#H) OR #H >= :c", height)
+		if err != nil {
+			return err
+		}
+
+		requests := make([]*dynamodb.WriteRequest, 0, len(addrDeltas))
+		for i := range addrDeltas {
+			key := historyItemKey(publicKey, height, uint32(i))
+			requests = append(requests, &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{Key: key},
+			})
+		}
+		if err := t.writeHistory(requests); err != nil {
+			return err
+		}
+	}
+
+	return t.putTip(prevHash.String(), height-1)
+}
+
+// balanceUpdate returns an update that atomically adds the value, totals and
+// transaction count of the passed balance to the ones of the passed public key
+// and records the height of the last change the balance is updated with.
+// Callers may extend the SET clause, which ends the update expression, and may
+// refer to the first and last seen heights as #F and #L.
+func (t *DynamoBalanceRepository) balanceUpdate(publicKey string, totals *Balance, height int32) *dynamodb.UpdateItemInput {
+	return &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#V": aws.String("Value"),
+			"#R": aws.String("Received"),
+			"#D": aws.String("Sent"),
+			"#N": aws.String("TxCount"),
+			"#F": aws.String("FirstSeen"),
+			"#L": aws.String("LastSeen"),
+			"#H": aws.String("LastHeight"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v": numberAttr(totals.Value),
+			":r": numberAttr(totals.Received),
+			":d": numberAttr(totals.Sent),
+			":n": numberAttr(totals.TxCount),
+			":h": numberAttr(int64(height)),
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"PublicKey": {
+				S: aws.String(publicKey),
+			},
+		},
+		ReturnValues:     aws.String("UPDATED_NEW"),
+		TableName:        aws.String(t.tableName),
+		UpdateExpression: aws.String("ADD #V :v, #R :r, #D :d, #N :n SET #H = :h"),
+	}
+}
+
+// updateBalance applies the passed balance update when the passed condition,
+// which can refer to the height of the last change the balance is updated with
+// as #H and to the passed condition height as :c, holds.  The resulting balance
+// value and the height of its last change are returned either way along with
+// whether or not the update was applied.
+func (t *DynamoBalanceRepository) updateBalance(input *dynamodb.UpdateItemInput, condition string, conditionHeight int32) (int64, int32, bool, error) {
+	input.ConditionExpression = aws.String(condition)
+	input.ExpressionAttributeValues[":c"] = numberAttr(int64(conditionHeight))
+	setShard(input, aws.StringValue(input.Key["PublicKey"].S))
+
+	result, err := t.db.UpdateItem(input)
+	if isConditionalCheckFailed(err) {
+		// Already applied before the previous shutdown, so the stored
+		// balance is the resulting one.
+		getInput := &dynamodb.GetItemInput{
+			Key:            input.Key,
+			TableName:      aws.String(t.tableName),
+			ConsistentRead: aws.Bool(true),
+		}
+		getResult, err := t.db.GetItem(getInput)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		value, height, err := parseValueHeight(getResult.Item)
+		return value, height, false, err
+	}
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	value, height, err := parseValueHeight(result.Attributes)
+	return value, height, true, err
+}
+
+// parseValueHeight returns the balance value of the passed item along with the
+// height of the last change it is updated with.
+func parseValueHeight(item map[string]*dynamodb.AttributeValue) (int64, int32, error) {
+	value, err := parseNumber(item["Value"])
+	if err != nil {
+		return 0, 0, err
+	}
+	height, err := parseNumber(item["LastHeight"])
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, int32(height), nil
+}
+
+// setShard extends the passed update to also assign the value index shard of
+// the passed public key.  Balances recorded under reserved keys are not
+// indexed.
+func setShard(input *dynamodb.UpdateItemInput, publicKey string) {
+	if isReservedKey(publicKey) {
+		return
+	}
+	input.ExpressionAttributeNames["#S"] = aws.String("Shard")
+	input.ExpressionAttributeValues[":s"] = valueIndexShard(publicKey)
+	update := aws.StringValue(input.UpdateExpression)
+	if strings.Contains(update, "SET ") {
+		update = strings.Replace(update, "SET ", "SET #S = :s, ", 1)
+	} else {
+		update += " SET #S = :s"
+	}
+	input.UpdateExpression = aws.String(update)
+}
+
+// putTip records the passed block as the tip of the repository.
+func (t *DynamoBalanceRepository) putTip(tip string, height int32) error {
+	input := &dynamodb.PutItemInput{
+		Item: map[string]*dynamodb.AttributeValue{
+			"PublicKey": {
+				S: aws.String(tipKey),
+			},
+			"Hash": {
+				S: aws.String(tip),
+			},
+			"Height": {
+				N: aws.String(strconv.FormatInt(int64(height), 10)),
+			},
+		},
+		ReturnConsumedCapacity: aws.String("NONE"),
+		TableName:              aws.String(t.tableName),
+	}
+	_, err := t.db.PutItem(input)
+	return err
+}
+
+// writeHistory writes the passed requests to the history table in batches of
+// the maximum size DynamoDB allows.  Requests left unprocessed because of
+// throttling are retried with an increasing delay.
+func (t *DynamoBalanceRepository) writeHistory(requests []*dynamodb.WriteRequest) error {
+	delay := dynamoRetryDelay
+	for len(requests) > 0 {
+		n := len(requests)
+		if n > dynamoMaxBatchWrite {
+			n = dynamoMaxBatchWrite
+		}
+		input := &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{
+				t.historyTableName: requests[:n],
+			},
+		}
+		result, err := t.db.BatchWriteItem(input)
+		if err != nil {
+			return err
+		}
+
+		unprocessed := result.UnprocessedItems[t.historyTableName]
+		requests = append(unprocessed, requests[n:]...)
+		if len(unprocessed) != 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	return nil
+}
+
+func (t *DynamoBalanceRepository) Tip() (*chainhash.Hash, int32, error) {
+	input := &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"PublicKey": {
+				S: aws.String(tipKey),
+			},
+		},
+		TableName:      aws.String(t.tableName),
+		ConsistentRead: aws.Bool(true),
+	}
+
+	result, err := t.db.GetItem(input)
+	if err != nil || len(result.Item) == 0 {
+		return nil, 0, err
+	}
+
+	prop := result.Item
+	hash, err := chainhash.NewHashFromStr(aws.StringValue(prop["Hash"].S))
+	if err != nil {
+		return nil, 0, err
+	}
+	height, err := strconv.ParseInt(aws.StringValue(prop["Height"].N), 10, 32)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return hash, int32(height), nil
+}
+
+// TopBalances queries every shard of the value index from the largest value
+// down, so only the returned balances and the ones tied with the smallest of
+// them are read from each shard.
+func (t *DynamoBalanceRepository) TopBalances(count int, minValue int64) ([]*Balance, error) {
+	top := newTopBalances(count)
+	var parseErr error
+	for shard := 0; shard < dynamoValueIndexShards; shard++ {
+		input := t.valueIndexQuery(shard, minValue)
+		input.ScanIndexForward = aws.Bool(false)
+		err := t.db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				value, err := parseNumber(item["Value"])
+				if err != nil {
+					parseErr = err
+					return false
+				}
+				if top.done(value) {
+					return false
+				}
+				top.add(&Balance{
+					PublicKey: aws.StringValue(item["PublicKey"].S),
+					Value:     value,
+				})
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if parseErr != nil {
+			return nil, parseErr
+		}
+	}
+
+	return top.sorted(), nil
+}
+
+// ForEachBalance scans the whole table, skipping the items of reserved keys.
+func (t *DynamoBalanceRepository) ForEachBalance(fn func(balance *Balance) error) error {
+	input := &dynamodb.ScanInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#P": aws.String("PublicKey"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":r": {
+				S: aws.String(reservedKeyPrefix),
+			},
+		},
+		FilterExpression: aws.String("NOT begins_with(#P, :r)"),
+		TableName:        aws.String(t.tableName),
+	}
+
+	var fnErr error
+	err := t.db.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var balance *Balance
+			balance, fnErr = parseBalanceItem(item)
+			if fnErr == nil {
+				fnErr = fn(balance)
+			}
+			if fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// CountBalances counts the value index entries of every shard at or above the
+// passed value.
+func (t *DynamoBalanceRepository) CountBalances(minValue int64) (int64, error) {
+	var count int64
+	for shard := 0; shard < dynamoValueIndexShards; shard++ {
+		input := t.valueIndexQuery(shard, minValue)
+		input.Select = aws.String(dynamodb.SelectCount)
+		err := t.db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			count += aws.Int64Value(page.Count)
+			return true
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+// valueIndexQuery returns a query of the entries of the passed value index
+// shard at or above the passed value.
+func (t *DynamoBalanceRepository) valueIndexQuery(shard int, minValue int64) *dynamodb.QueryInput {
+	return &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#S": aws.String("Shard"),
+			"#V": aws.String("Value"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":s": {
+				N: aws.String(strconv.Itoa(shard)),
+			},
+			":m": {
+				N: aws.String(strconv.FormatInt(minValue, 10)),
+			},
+		},
+		IndexName:              aws.String(dynamoValueIndexName),
+		KeyConditionExpression: aws.String("#S = :s AND #V >= :m"),
+		TableName:              aws.String(t.tableName),
+	}
+}
+
+// BalanceHistory queries the history items of the passed public key, which are
+// sorted by height and position within the block.
+func (t *DynamoBalanceRepository) BalanceHistory(publicKey string, fromHeight, toHeight int32) ([]*BalanceHistoryEntry, error) {
+	if fromHeight > toHeight {
+		return nil, nil
+	}
+
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#P": aws.String("PublicKey"),
+			"#S": aws.String("Seq"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":p": {
+				S: aws.String(publicKey),
+			},
+			":a": historyItemSeq(fromHeight, 0),
+			":b": historyItemSeq(toHeight, math.MaxUint32),
+		},
+		KeyConditionExpression: aws.String("#P = :p AND #S BETWEEN :a AND :b"),
+		TableName:              aws.String(t.historyTableName),
+	}
+
+	var entries []*BalanceHistoryEntry
+	var parseErr error
+	err := t.db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			entry, err := parseHistoryItem(item)
+			if err != nil {
+				parseErr = err
+				return false
+			}
+			entries = append(entries, entry)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	return entries, nil
+}
+
+// BalanceAtHeight queries the last history item of the passed public key at or
+// below the passed height.
+func (t *DynamoBalanceRepository) BalanceAtHeight(publicKey string, height int32) (int64, error) {
+	entry, err := t.lastHistoryItem(publicKey, height)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+	return entry.Balance, nil
+}
+
+// lastHistoryItem queries the last history item of the passed public key at or
+// below the passed height.  It returns nil when there is none.
+func (t *DynamoBalanceRepository) lastHistoryItem(publicKey string, height int32) (*BalanceHistoryEntry, error) {
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#P": aws.String("PublicKey"),
+			"#S": aws.String("Seq"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":p": {
+				S: aws.String(publicKey),
+			},
+			":b": historyItemSeq(height, math.MaxUint32),
+		},
+		KeyConditionExpression: aws.String("#P = :p AND #S <= :b"),
+		Limit:                  aws.Int64(1),
+		ScanIndexForward:       aws.Bool(false),
+		TableName:              aws.String(t.historyTableName),
+	}
+
+	result, err := t.db.Query(input)
+	if err != nil || len(result.Items) == 0 {
+		return nil, err
+	}
+	return parseHistoryItem(result.Items[0])
+}
+
+func (t *DynamoBalanceRepository) Close() error {
+	return nil
+}
+
+// valueIndexShard returns the value index shard of the passed public key.
+func valueIndexShard(publicKey string) *dynamodb.AttributeValue {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(publicKey))
+	shard := hasher.Sum32() % dynamoValueIndexShards
+	return &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatUint(uint64(shard), 10)),
+	}
+}
+
+// historyItemSeq returns the sort key of the history item for the change with
+// the passed sequence number within the block at the passed height.  The height
+// makes up the upper 32 bits so the items sort in chain order.
+func historyItemSeq(height int32, seq uint32) *dynamodb.AttributeValue {
+	value := int64(height)<<32 | int64(seq)
+	return &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(value, 10)),
+	}
+}
+
+// historyItemKey returns the primary key of the history item of the passed
+// public key for the change with the passed sequence number within the block at
+// the passed height.
+func historyItemKey(publicKey string, height int32, seq uint32) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"PublicKey": {
+			S: aws.String(publicKey),
+		},
+		"Seq": historyItemSeq(height, seq),
+	}
+}
+
+// parseHistoryItem decodes the passed history item.
+func parseHistoryItem(item map[string]*dynamodb.AttributeValue) (*BalanceHistoryEntry, error) {
+	height, err := parseNumber(item["Height"])
+	if err != nil {
+		return nil, err
+	}
+	txHash, err := chainhash.NewHashFromStr(aws.StringValue(item["TxHash"].S))
+	if err != nil {
+		return nil, err
+	}
+	delta, err := parseNumber(item["Delta"])
+	if err != nil {
+		return nil, err
+	}
+	balance, err := parseNumber(item["Balance"])
+	if err != nil {
+		return nil, err
+	}
+	received, err := parseNumber(item["Received"])
+	if err != nil {
+		return nil, err
+	}
+	sent, err := parseNumber(item["Sent"])
+	if err != nil {
+		return nil, err
+	}
+
+	return &BalanceHistoryEntry{
+		Height:   int32(height),
+		TxHash:   *txHash,
+		Delta:    delta,
+		Received: received,
+		Sent:     sent,
+		Balance:  balance,
+	}, nil
+}
+
+// parseBalanceItem decodes the passed balance item.  Items written before the
+// totals were tracked lack them, so they decode as zero.
+func parseBalanceItem(item map[string]*dynamodb.AttributeValue) (*Balance, error) {
+	var fields [6]int64
+	for i, name := range []string{"Value", "Received", "Sent", "TxCount",
+		"FirstSeen", "LastSeen"} {
+
+		var err error
+		fields[i], err = parseNumber(item[name])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Balance{
+		PublicKey: aws.StringValue(item["PublicKey"].S),
+		Value:     fields[0],
+		Received:  fields[1],
+		Sent:      fields[2],
+		TxCount:   fields[3],
+		FirstSeen: int32(fields[4]),
+		LastSeen:  int32(fields[5]),
+	}, nil
+}
+
+// numberAttr returns a number attribute of the passed value.
+func numberAttr(value int64) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(value, 10)),
+	}
+}
+
+// parseNumber returns the value of the passed number attribute.  A missing
+// attribute is treated as zero.
+func parseNumber(attr *dynamodb.AttributeValue) (int64, error) {
+	if attr == nil {
+		return 0, nil
+	}
+	return strconv.ParseInt(aws.StringValue(attr.N), 10, 64)
+}
+
+// isConditionalCheckFailed returns whether or not the passed error is the
+// result of the condition expression of a write not being met.
+func isConditionalCheckFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
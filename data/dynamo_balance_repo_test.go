@@ -0,0 +1,142 @@
+package data
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// dynamoLocalEnvVar is the environment variable that holds the endpoint of a
+// local DynamoDB stand-in to run the tests against, such as one started with
+// docker run -p 8000:8000 amazon/dynamodb-local, in which case it is set to
+// http://localhost:8000.
+const dynamoLocalEnvVar = "BTCD_TEST_DYNAMODB"
+
+// newTestDynamoBalanceRepo returns a balance repository backed by new tables in
+// the local DynamoDB stand-in.  The test is skipped unless its endpoint is set.
+func newTestDynamoBalanceRepo(t *testing.T) *DynamoBalanceRepository {
+	t.Helper()
+
+	endpoint := os.Getenv(dynamoLocalEnvVar)
+	if endpoint == "" {
+		t.Skipf("set %s to the endpoint of a local DynamoDB to run "+
+			"against it", dynamoLocalEnvVar)
+	}
+
+	const region = "us-east-1"
+	sess, err := session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+		Region:      aws.String(region),
+		Endpoint:    aws.String(endpoint),
+	})
+	if err != nil {
+		t.Fatalf("unable to create session: %v", err)
+	}
+	db := dynamodb.New(sess)
+
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		t.Fatalf("unable to generate table name: %v", err)
+	}
+	tableName := "balance" + hex.EncodeToString(suffix[:])
+
+	throughput := &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(5),
+		WriteCapacityUnits: aws.Int64(5),
+	}
+	attr := func(name, attrType string) *dynamodb.AttributeDefinition {
+		return &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(name),
+			AttributeType: aws.String(attrType),
+		}
+	}
+	key := func(name, keyType string) *dynamodb.KeySchemaElement {
+		return &dynamodb.KeySchemaElement{
+			AttributeName: aws.String(name),
+			KeyType:       aws.String(keyType),
+		}
+	}
+	tables := []*dynamodb.CreateTableInput{{
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			attr("PublicKey", dynamodb.ScalarAttributeTypeS),
+			attr("Shard", dynamodb.ScalarAttributeTypeN),
+			attr("Value", dynamodb.ScalarAttributeTypeN),
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			key("PublicKey", dynamodb.KeyTypeHash),
+		},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{{
+			IndexName: aws.String(dynamoValueIndexName),
+			KeySchema: []*dynamodb.KeySchemaElement{
+				key("Shard", dynamodb.KeyTypeHash),
+				key("Value", dynamodb.KeyTypeRange),
+			},
+			Projection: &dynamodb.Projection{
+				ProjectionType: aws.String(dynamodb.ProjectionTypeAll),
+			},
+			ProvisionedThroughput: throughput,
+		}},
+		ProvisionedThroughput: throughput,
+		TableName:             aws.String(tableName),
+	}, {
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			attr("PublicKey", dynamodb.ScalarAttributeTypeS),
+			attr("Seq", dynamodb.ScalarAttributeTypeN),
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			key("PublicKey", dynamodb.KeyTypeHash),
+			key("Seq", dynamodb.KeyTypeRange),
+		},
+		ProvisionedThroughput: throughput,
+		TableName:             aws.String(tableName + "History"),
+	}}
+	for _, input := range tables {
+		if _, err := db.CreateTable(input); err != nil {
+			t.Fatalf("CreateTable: unexpected error: %v", err)
+		}
+		err := db.WaitUntilTableExists(&dynamodb.DescribeTableInput{
+			TableName: input.TableName,
+		})
+		if err != nil {
+			t.Fatalf("WaitUntilTableExists: unexpected error: %v", err)
+		}
+	}
+
+	repo, err := NewDynamoBalanceRepository("test", "test", region,
+		endpoint, tableName)
+	if err != nil {
+		t.Fatalf("NewDynamoBalanceRepository: unexpected error: %v", err)
+	}
+	return repo
+}
+
+// TestDynamoBalanceReplay ensures the dynamo repository replays blocks it
+// already applied exactly.
+func TestDynamoBalanceReplay(t *testing.T) {
+	t.Parallel()
+
+	testBalanceReplay(t, newTestDynamoBalanceRepo(t))
+}
+
+// TestDynamoBalanceReorg ensures the dynamo repository applies the blocks of a
+// reorganization to every address they touch.
+func TestDynamoBalanceReorg(t *testing.T) {
+	t.Parallel()
+
+	testBalanceReorg(t, newTestDynamoBalanceRepo(t))
+}
+
+// TestDynamoCachedBalanceReorg ensures a balance cache in front of the dynamo
+// repository applies the blocks of a reorganization that replaces flushed
+// blocks to every address they touch.
+func TestDynamoCachedBalanceReorg(t *testing.T) {
+	t.Parallel()
+
+	testCachedBalanceReorg(t, newTestDynamoBalanceRepo(t))
+}
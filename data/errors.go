@@ -0,0 +1,53 @@
+package data
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/azure/azure-sdk-for-go/storage"
+	"github.com/pkg/errors"
+)
+
+// IsTransientError returns whether or not the passed error returned by a
+// balance repository is likely to be temporary, such as a network failure or
+// the backend throttling requests, which means the operation may succeed when
+// it is retried.
+func IsTransientError(err error) bool {
+	switch err := errors.Cause(err).(type) {
+	case nil:
+		return false
+
+	case net.Error:
+		return err.Temporary() || err.Timeout()
+
+	case awserr.Error:
+		switch err.Code() {
+		case "RequestError", "ThrottlingException",
+			"RequestLimitExceeded", "InternalServerError",
+			"ServiceUnavailable",
+			dynamodb.ErrCodeProvisionedThroughputExceededException:
+
+			return true
+		}
+
+	case storage.AzureStorageServiceError:
+		switch err.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusServiceUnavailable:
+
+			return true
+		}
+
+	default:
+		for _, dialect := range sqlDialects {
+			if dialect.isTransientError(err) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
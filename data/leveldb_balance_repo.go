@@ -3,14 +3,21 @@ package data
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
 
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/goleveldb/leveldb"
+	"github.com/btcsuite/goleveldb/leveldb/util"
 )
 
 type LevelDbBalanceRepository struct {
 	db *leveldb.DB
 }
 
+// NewLevelDbBalanceRepository opens the balance repository at the passed path,
+// creating it when it doesn't exist yet.  The value index of repositories
+// created before it was introduced is built on the first open.
 func NewLevelDbBalanceRepository(path string) (*LevelDbBalanceRepository, error) {
 	db, err := leveldb.OpenFile(path, nil)
 
@@ -18,9 +25,41 @@ func NewLevelDbBalanceRepository(path string) (*LevelDbBalanceRepository, error)
 		return nil, err
 	}
 
-	return &LevelDbBalanceRepository{
+	repo := &LevelDbBalanceRepository{
 		db: db,
-	}, nil
+	}
+	if err := repo.ensureValueIndex(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+// ensureValueIndex builds the value index from the stored balances unless it is
+// already known to cover all of them.
+func (t *LevelDbBalanceRepository) ensureValueIndex() error {
+	ready, err := t.db.Has([]byte(valueIndexReadyKey), nil)
+	if err != nil || ready {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	err = t.ForEachBalance(func(balance *Balance) error {
+		batch.Put(valueIndexKey(balance.PublicKey, balance.Value), nil)
+		if batch.Len() < valueIndexBatchSize {
+			return nil
+		}
+		err := t.db.Write(batch, nil)
+		batch.Reset()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	batch.Put([]byte(valueIndexReadyKey), nil)
+
+	return t.db.Write(batch, nil)
 }
 
 func (t *LevelDbBalanceRepository) Get(publicKey string) (*Balance, error) {
@@ -33,25 +72,502 @@ func (t *LevelDbBalanceRepository) Get(publicKey string) (*Balance, error) {
 		return nil, err
 	}
 
-	value, err := binary.ReadVarint(bytes.NewReader(data))
+	return deserializeBalance(publicKey, data)
+}
+
+func (t *LevelDbBalanceRepository) Insert(balance *Balance) error {
+	prev, err := t.Get(balance.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	putBalance(batch, prev, balance)
+	return t.db.Write(batch, nil)
+}
+
+func (t *LevelDbBalanceRepository) Update(balance *Balance) error {
+	return t.Insert(balance)
+}
+
+// ConnectBlock adds the passed balance changes to the stored balances, records
+// them in the balance history and records the passed block as the tip in a
+// single leveldb batch, which leveldb guarantees to write atomically.
+func (t *LevelDbBalanceRepository) ConnectBlock(hash *chainhash.Hash, height int32, deltas map[string][]BalanceDelta) error {
+	return t.ConnectBlocks([]*BlockDeltas{{
+		Hash:   *hash,
+		Height: height,
+		Deltas: deltas,
+	}})
+}
+
+// ConnectBlocks connects all of the passed blocks in a single leveldb batch.
+// The running balances are tracked in memory across the blocks, so every
+// balance is only read and written once.
+func (t *LevelDbBalanceRepository) ConnectBlocks(blocks []*BlockDeltas) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	batch := new(leveldb.Batch)
+	balances := make(map[string]*Balance)
+	prevs := make(map[string]*Balance)
+	for _, block := range blocks {
+		for publicKey, addrDeltas := range block.Deltas {
+			if len(addrDeltas) == 0 {
+				continue
+			}
+
+			balance, ok := balances[publicKey]
+			if !ok {
+				prev, err := t.Get(publicKey)
+				if err != nil {
+					return err
+				}
+				balance = &Balance{PublicKey: publicKey}
+				if prev != nil {
+					*balance = *prev
+				}
+				balances[publicKey] = balance
+				prevs[publicKey] = prev
+			}
+			value := balance.Value
+			for i := range addrDeltas {
+				delta := &addrDeltas[i]
+				value += delta.Value
+				key := historyKey(publicKey, block.Height, uint32(i))
+				batch.Put(key, serializeHistoryEntry(delta, value))
+			}
+			balance.connect(block.Height, addrDeltas)
+		}
+	}
+	for publicKey, balance := range balances {
+		putBalance(batch, prevs[publicKey], balance)
+	}
+	tip := blocks[len(blocks)-1]
+	batch.Put([]byte(tipKey), serializeTip(&tip.Hash, tip.Height))
+
+	return t.db.Write(batch, nil)
+}
+
+// DisconnectBlock reverses the passed balance changes, removes every history
+// entry recorded for the block and records its parent as the tip in a single
+// leveldb batch.
+func (t *LevelDbBalanceRepository) DisconnectBlock(prevHash *chainhash.Hash, height int32, deltas map[string][]BalanceDelta) error {
+	batch := new(leveldb.Batch)
+	for publicKey, addrDeltas := range deltas {
+		if len(addrDeltas) == 0 {
+			continue
+		}
+
+		prev, err := t.Get(publicKey)
+		if err != nil {
+			return err
+		}
+		prevLastSeen, err := t.lastSeen(publicKey, height-1)
+		if err != nil {
+			return err
+		}
+		balance := &Balance{PublicKey: publicKey}
+		if prev != nil {
+			*balance = *prev
+		}
+		balance.disconnect(addrDeltas, prevLastSeen)
+		putBalance(batch, prev, balance)
+
+		iter := t.db.NewIterator(util.BytesPrefix(
+			historyHeightPrefix(publicKey, height)), nil)
+		for iter.Next() {
+			batch.Delete(iter.Key())
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+	batch.Put([]byte(tipKey), serializeTip(prevHash, height-1))
+
+	return t.db.Write(batch, nil)
+}
+
+func (t *LevelDbBalanceRepository) Tip() (*chainhash.Hash, int32, error) {
+	tip, err := t.db.Get([]byte(tipKey), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(tip) != chainhash.HashSize+4 {
+		return nil, 0, fmt.Errorf("corrupt balance repository tip %x",
+			tip)
+	}
+
+	var hash chainhash.Hash
+	copy(hash[:], tip)
+	height := int32(binary.LittleEndian.Uint32(tip[chainhash.HashSize:]))
+	return &hash, height, nil
+}
+
+// TopBalances walks the value index from the largest value down, so only the
+// returned balances and the ones tied with the smallest of them are read.
+func (t *LevelDbBalanceRepository) TopBalances(count int, minValue int64) ([]*Balance, error) {
+	top := newTopBalances(count)
+	iter := t.db.NewIterator(valueIndexRange(minValue), nil)
+	defer iter.Release()
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		balance, err := deserializeValueIndexKey(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		if top.done(balance.Value) {
+			break
+		}
+		top.add(balance)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	return top.sorted(), nil
+}
+
+// ForEachBalance iterates the balances in the order of their public keys,
+// skipping the range of reserved keys.
+func (t *LevelDbBalanceRepository) ForEachBalance(fn func(balance *Balance) error) error {
+	for _, balanceRange := range balanceRanges {
+		iter := t.db.NewIterator(balanceRange, nil)
+		for iter.Next() {
+			balance, err := deserializeBalance(string(iter.Key()),
+				iter.Value())
+			if err == nil {
+				err = fn(balance)
+			}
+			if err != nil {
+				iter.Release()
+				return err
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CountBalances counts the entries of the value index at or above the passed
+// value, which only requires reading their keys.
+func (t *LevelDbBalanceRepository) CountBalances(minValue int64) (int64, error) {
+	iter := t.db.NewIterator(valueIndexRange(minValue), nil)
+	defer iter.Release()
+
+	var count int64
+	for iter.Next() {
+		count++
+	}
+	return count, iter.Error()
+}
+
+// BalanceHistory iterates the history entries of the passed public key, which
+// are keyed by height so they are stored in chain order.
+func (t *LevelDbBalanceRepository) BalanceHistory(publicKey string, fromHeight, toHeight int32) ([]*BalanceHistoryEntry, error) {
+	if fromHeight > toHeight {
+		return nil, nil
+	}
+
+	historyRange := &util.Range{
+		Start: historyHeightPrefix(publicKey, fromHeight),
+		Limit: historyHeightLimit(publicKey, toHeight),
+	}
+	iter := t.db.NewIterator(historyRange, nil)
+	defer iter.Release()
+
+	var entries []*BalanceHistoryEntry
+	for iter.Next() {
+		entry, err := deserializeHistoryEntry(publicKey, iter.Key(),
+			iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// BalanceAtHeight returns the running balance of the last history entry of the
+// passed public key at or below the passed height.
+func (t *LevelDbBalanceRepository) BalanceAtHeight(publicKey string, height int32) (int64, error) {
+	historyRange := &util.Range{
+		Start: historyHeightPrefix(publicKey, 0),
+		Limit: historyHeightLimit(publicKey, height),
+	}
+	iter := t.db.NewIterator(historyRange, nil)
+	defer iter.Release()
+
+	if !iter.Last() {
+		return 0, iter.Error()
+	}
+	entry, err := deserializeHistoryEntry(publicKey, iter.Key(),
+		iter.Value())
+	if err != nil {
+		return 0, err
+	}
+	return entry.Balance, nil
+}
+
+// lastSeen returns the height of the last history entry of the passed public
+// key at or below the passed height, or zero when there is none.
+func (t *LevelDbBalanceRepository) lastSeen(publicKey string, height int32) (int32, error) {
+	historyRange := &util.Range{
+		Start: historyHeightPrefix(publicKey, 0),
+		Limit: historyHeightLimit(publicKey, height),
+	}
+	iter := t.db.NewIterator(historyRange, nil)
+	defer iter.Release()
+
+	if !iter.Last() {
+		return 0, iter.Error()
+	}
+	entry, err := deserializeHistoryEntry(publicKey, iter.Key(),
+		iter.Value())
+	if err != nil {
+		return 0, err
+	}
+	return entry.Height, nil
+}
+
+func (t *LevelDbBalanceRepository) Close() error {
+	return t.db.Close()
+}
+
+// serializeBalance returns the passed balance as it is stored in the database.
+// It consists of the varint encoded value, received and sent totals,
+// transaction count and first and last seen heights.
+func serializeBalance(balance *Balance) []byte {
+	buf := make([]byte, 6*binary.MaxVarintLen64)
+	offset := binary.PutVarint(buf, balance.Value)
+	offset += binary.PutVarint(buf[offset:], balance.Received)
+	offset += binary.PutVarint(buf[offset:], balance.Sent)
+	offset += binary.PutVarint(buf[offset:], balance.TxCount)
+	offset += binary.PutVarint(buf[offset:], int64(balance.FirstSeen))
+	offset += binary.PutVarint(buf[offset:], int64(balance.LastSeen))
+	return buf[:offset]
+}
+
+// deserializeBalance decodes the passed serialized balance of the passed public
+// key.  Balances written before the totals were tracked only hold the value
+// followed by zero padding, so fields past the end decode as zero, just like
+// the padding does.
+func deserializeBalance(publicKey string, serialized []byte) (*Balance, error) {
+	r := bytes.NewReader(serialized)
+	value, err := binary.ReadVarint(r)
 	if err != nil {
 		return nil, err
 	}
+	var fields [5]int64
+	for i := range fields {
+		fields[i], err = binary.ReadVarint(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	balance := &Balance{
+	return &Balance{
 		PublicKey: publicKey,
 		Value:     value,
+		Received:  fields[0],
+		Sent:      fields[1],
+		TxCount:   fields[2],
+		FirstSeen: int32(fields[3]),
+		LastSeen:  int32(fields[4]),
+	}, nil
+}
+
+// putBalance adds the writes that store the passed balance to the passed batch.
+// This includes moving the value index entry of the balance from its previous
+// value, which must be passed as nil when there is none.  Balances recorded
+// under reserved keys are not indexed.
+func putBalance(batch *leveldb.Batch, prev, balance *Balance) {
+	publicKey := balance.PublicKey
+	batch.Put([]byte(publicKey), serializeBalance(balance))
+	if isReservedKey(publicKey) {
+		return
 	}
+	if prev != nil {
+		batch.Delete(valueIndexKey(publicKey, prev.Value))
+	}
+	batch.Put(valueIndexKey(publicKey, balance.Value), nil)
+}
 
-	return balance, nil
+// serializeTip returns the serialized hash and height of the passed tip as it
+// is stored in the database.
+func serializeTip(hash *chainhash.Hash, height int32) []byte {
+	tip := make([]byte, chainhash.HashSize+4)
+	copy(tip, hash[:])
+	binary.LittleEndian.PutUint32(tip[chainhash.HashSize:], uint32(height))
+	return tip
 }
 
-func (t *LevelDbBalanceRepository) Insert(balance *Balance) error {
-	buf := make([]byte, binary.MaxVarintLen64)
-	binary.PutVarint(buf, balance.Value)
-	return t.db.Put([]byte(balance.PublicKey), buf, nil)
+// historyKeyPrefix is the prefix of the keys of all history entries.  Like the
+// tip key, it can never collide with an address.
+const historyKeyPrefix = reservedKeyPrefix + "h/"
+
+// balanceRanges are the key ranges balances are stored in, which are all keys
+// before and after the range of reserved keys.
+var balanceRanges = []*util.Range{
+	{Limit: []byte(reservedKeyPrefix)},
+	{Start: []byte{reservedKeyPrefix[0] + 1}},
 }
 
-func (t *LevelDbBalanceRepository) Update(balance *Balance) error {
-	return t.Insert(balance)
+const (
+	// valueIndexKeyPrefix is the prefix of the keys of the value index,
+	// which orders the balances by value so the largest ones can be found
+	// without reading all of them.  The value index entries have no value
+	// since the key holds everything.
+	valueIndexKeyPrefix = reservedKeyPrefix + "v/"
+
+	// valueIndexReadyKey marks that the value index covers all balances.
+	valueIndexReadyKey = reservedKeyPrefix + "vready"
+
+	// valueIndexBatchSize is the number of value index entries written at
+	// once while the index is built.
+	valueIndexBatchSize = 10000
+)
+
+// valueIndexValuePrefix returns the prefix of the value index keys of all
+// balances of the passed value.  The value is serialized big-endian with the
+// sign bit flipped so negative values sort before positive ones.
+func valueIndexValuePrefix(value int64) []byte {
+	key := make([]byte, len(valueIndexKeyPrefix)+8)
+	offset := copy(key, valueIndexKeyPrefix)
+	binary.BigEndian.PutUint64(key[offset:], uint64(value)^1<<63)
+	return key
+}
+
+// valueIndexKey returns the value index key of the balance of the passed public
+// key and value.
+func valueIndexKey(publicKey string, value int64) []byte {
+	return append(valueIndexValuePrefix(value), publicKey...)
+}
+
+// valueIndexRange returns the range of the value index keys of all balances of
+// at least the passed value.
+func valueIndexRange(minValue int64) *util.Range {
+	return &util.Range{
+		Start: valueIndexValuePrefix(minValue),
+		Limit: util.BytesPrefix([]byte(valueIndexKeyPrefix)).Limit,
+	}
+}
+
+// deserializeValueIndexKey decodes the balance the passed value index key is
+// for.
+func deserializeValueIndexKey(key []byte) (*Balance, error) {
+	offset := len(valueIndexKeyPrefix)
+	if len(key) <= offset+8 {
+		return nil, fmt.Errorf("corrupt balance value index key %x", key)
+	}
+
+	return &Balance{
+		PublicKey: string(key[offset+8:]),
+		Value:     int64(binary.BigEndian.Uint64(key[offset:]) ^ 1<<63),
+	}, nil
+}
+
+// historyHeightPrefix returns the prefix of the keys of the history entries of
+// the passed public key at the passed height.  The key is made up of the
+// public key followed by a separator that is not part of any address alphabet,
+// so the entries of one address are never mixed up with the ones of another
+// address the first one is a prefix of, and the big-endian height so the
+// entries sort in chain order.
+func historyHeightPrefix(publicKey string, height int32) []byte {
+	key := make([]byte, len(historyKeyPrefix)+len(publicKey)+5)
+	offset := copy(key, historyKeyPrefix)
+	offset += copy(key[offset:], publicKey)
+	key[offset] = '/'
+	binary.BigEndian.PutUint32(key[offset+1:], uint32(height))
+	return key
+}
+
+// historyHeightLimit returns the key right after the keys of all history
+// entries of the passed public key at or below the passed height.
+func historyHeightLimit(publicKey string, height int32) []byte {
+	// Heights are serialized as unsigned, so the key still sorts after
+	// all others when the height wraps around.
+	return historyHeightPrefix(publicKey, height+1)
+}
+
+// historyKey returns the key of the history entry of the passed public key for
+// the change with the passed sequence number within the block at the passed
+// height.
+func historyKey(publicKey string, height int32, seq uint32) []byte {
+	prefix := historyHeightPrefix(publicKey, height)
+	key := make([]byte, len(prefix)+4)
+	copy(key, prefix)
+	binary.BigEndian.PutUint32(key[len(prefix):], seq)
+	return key
+}
+
+// serializeHistoryEntry returns the serialized history entry for the passed
+// change and resulting running balance.  It consists of the transaction hash
+// followed by the varint encoded change, balance and received and sent
+// amounts.
+func serializeHistoryEntry(delta *BalanceDelta, balance int64) []byte {
+	buf := make([]byte, chainhash.HashSize+4*binary.MaxVarintLen64)
+	offset := copy(buf, delta.TxHash[:])
+	offset += binary.PutVarint(buf[offset:], delta.Value)
+	offset += binary.PutVarint(buf[offset:], balance)
+	offset += binary.PutVarint(buf[offset:], delta.Received)
+	offset += binary.PutVarint(buf[offset:], delta.Sent)
+	return buf[:offset]
+}
+
+// deserializeHistoryEntry decodes the history entry of the passed public key
+// stored under the passed key.
+func deserializeHistoryEntry(publicKey string, key, serialized []byte) (*BalanceHistoryEntry, error) {
+	heightOffset := len(historyKeyPrefix) + len(publicKey) + 1
+	if len(key) != heightOffset+8 || len(serialized) < chainhash.HashSize {
+		return nil, fmt.Errorf("corrupt balance history entry %x", key)
+	}
+
+	entry := &BalanceHistoryEntry{
+		Height: int32(binary.BigEndian.Uint32(key[heightOffset:])),
+	}
+	copy(entry.TxHash[:], serialized)
+	delta, n := binary.Varint(serialized[chainhash.HashSize:])
+	if n <= 0 {
+		return nil, fmt.Errorf("corrupt balance history entry %x", key)
+	}
+	balance, m := binary.Varint(serialized[chainhash.HashSize+n:])
+	if m <= 0 {
+		return nil, fmt.Errorf("corrupt balance history entry %x", key)
+	}
+	entry.Delta = delta
+	entry.Balance = balance
+
+	// Entries written before the received and sent amounts were tracked
+	// end with the balance.
+	offset := chainhash.HashSize + n + m
+	if offset == len(serialized) {
+		return entry, nil
+	}
+	received, n := binary.Varint(serialized[offset:])
+	if n <= 0 {
+		return nil, fmt.Errorf("corrupt balance history entry %x", key)
+	}
+	sent, m := binary.Varint(serialized[offset+n:])
+	if m <= 0 {
+		return nil, fmt.Errorf("corrupt balance history entry %x", key)
+	}
+	entry.Received = received
+	entry.Sent = sent
+	return entry, nil
 }
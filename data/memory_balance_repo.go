@@ -0,0 +1,236 @@
+package data
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// MemoryBalanceRepository is a balance repository that keeps everything in
+// memory.  It is intended for tests and tools that don't need the balances to
+// outlive the process.
+type MemoryBalanceRepository struct {
+	mtx       sync.RWMutex
+	balances  map[string]*Balance
+	history   map[string][]*BalanceHistoryEntry
+	tipHash   *chainhash.Hash
+	tipHeight int32
+}
+
+// Ensure MemoryBalanceRepository implements the IBalanceRepository interface.
+var _ IBalanceRepository = (*MemoryBalanceRepository)(nil)
+
+// NewMemoryBalanceRepository returns a new, empty in-memory balance repository.
+func NewMemoryBalanceRepository() *MemoryBalanceRepository {
+	return &MemoryBalanceRepository{
+		balances: make(map[string]*Balance),
+		history:  make(map[string][]*BalanceHistoryEntry),
+	}
+}
+
+func (t *MemoryBalanceRepository) Get(publicKey string) (*Balance, error) {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	balance, ok := t.balances[publicKey]
+	if !ok {
+		return nil, nil
+	}
+	balanceCopy := *balance
+	return &balanceCopy, nil
+}
+
+func (t *MemoryBalanceRepository) Insert(balance *Balance) error {
+	t.mtx.Lock()
+	balanceCopy := *balance
+	t.balances[balance.PublicKey] = &balanceCopy
+	t.mtx.Unlock()
+	return nil
+}
+
+func (t *MemoryBalanceRepository) Update(balance *Balance) error {
+	return t.Insert(balance)
+}
+
+func (t *MemoryBalanceRepository) ConnectBlock(hash *chainhash.Hash, height int32, deltas map[string][]BalanceDelta) error {
+	return t.ConnectBlocks([]*BlockDeltas{{
+		Hash:   *hash,
+		Height: height,
+		Deltas: deltas,
+	}})
+}
+
+func (t *MemoryBalanceRepository) ConnectBlocks(blocks []*BlockDeltas) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for _, block := range blocks {
+		for publicKey, addrDeltas := range block.Deltas {
+			if len(addrDeltas) == 0 {
+				continue
+			}
+
+			balance, ok := t.balances[publicKey]
+			if !ok {
+				balance = &Balance{PublicKey: publicKey}
+				t.balances[publicKey] = balance
+			}
+			value := balance.Value
+			for i := range addrDeltas {
+				delta := &addrDeltas[i]
+				value += delta.Value
+				t.history[publicKey] = append(t.history[publicKey],
+					&BalanceHistoryEntry{
+						Height:   block.Height,
+						TxHash:   delta.TxHash,
+						Delta:    delta.Value,
+						Received: delta.Received,
+						Sent:     delta.Sent,
+						Balance:  value,
+					})
+			}
+			balance.connect(block.Height, addrDeltas)
+		}
+	}
+	tip := blocks[len(blocks)-1]
+	tipHash := tip.Hash
+	t.tipHash, t.tipHeight = &tipHash, tip.Height
+
+	return nil
+}
+
+func (t *MemoryBalanceRepository) DisconnectBlock(prevHash *chainhash.Hash, height int32, deltas map[string][]BalanceDelta) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for publicKey, addrDeltas := range deltas {
+		if len(addrDeltas) == 0 {
+			continue
+		}
+
+		history := t.history[publicKey]
+		for len(history) > 0 && history[len(history)-1].Height >= height {
+			history = history[:len(history)-1]
+		}
+		var prevLastSeen int32
+		if len(history) == 0 {
+			delete(t.history, publicKey)
+		} else {
+			t.history[publicKey] = history
+			prevLastSeen = history[len(history)-1].Height
+		}
+
+		balance, ok := t.balances[publicKey]
+		if !ok {
+			balance = &Balance{PublicKey: publicKey}
+			t.balances[publicKey] = balance
+		}
+		balance.disconnect(addrDeltas, prevLastSeen)
+	}
+	tipHash := *prevHash
+	t.tipHash, t.tipHeight = &tipHash, height-1
+
+	return nil
+}
+
+func (t *MemoryBalanceRepository) Tip() (*chainhash.Hash, int32, error) {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	if t.tipHash == nil {
+		return nil, 0, nil
+	}
+	tipHash := *t.tipHash
+	return &tipHash, t.tipHeight, nil
+}
+
+func (t *MemoryBalanceRepository) TopBalances(count int, minValue int64) ([]*Balance, error) {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	top := newTopBalances(count)
+	for publicKey, balance := range t.balances {
+		if isReservedKey(publicKey) || balance.Value < minValue {
+			continue
+		}
+		balanceCopy := *balance
+		top.add(&balanceCopy)
+	}
+	return top.sorted(), nil
+}
+
+// ForEachBalance invokes the passed function with the balances in the order of
+// their public keys.  The balances are copied first, so the function may modify
+// the repository.
+func (t *MemoryBalanceRepository) ForEachBalance(fn func(balance *Balance) error) error {
+	t.mtx.RLock()
+	balances := make([]*Balance, 0, len(t.balances))
+	for publicKey, balance := range t.balances {
+		if isReservedKey(publicKey) {
+			continue
+		}
+		balanceCopy := *balance
+		balances = append(balances, &balanceCopy)
+	}
+	t.mtx.RUnlock()
+
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].PublicKey < balances[j].PublicKey
+	})
+	for _, balance := range balances {
+		if err := fn(balance); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *MemoryBalanceRepository) CountBalances(minValue int64) (int64, error) {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	var count int64
+	for publicKey, balance := range t.balances {
+		if !isReservedKey(publicKey) && balance.Value >= minValue {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (t *MemoryBalanceRepository) BalanceHistory(publicKey string, fromHeight, toHeight int32) ([]*BalanceHistoryEntry, error) {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	var entries []*BalanceHistoryEntry
+	for _, entry := range t.history[publicKey] {
+		if entry.Height < fromHeight || entry.Height > toHeight {
+			continue
+		}
+		entryCopy := *entry
+		entries = append(entries, &entryCopy)
+	}
+	return entries, nil
+}
+
+func (t *MemoryBalanceRepository) BalanceAtHeight(publicKey string, height int32) (int64, error) {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	history := t.history[publicKey]
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Height <= height {
+			return history[i].Balance, nil
+		}
+	}
+	return 0, nil
+}
+
+func (t *MemoryBalanceRepository) Close() error {
+	return nil
+}
@@ -0,0 +1,636 @@
+package data
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lib/pq"
+)
+
+// sqlForEachPageSize is the number of balances ForEachBalance reads at once.
+const sqlForEachPageSize = 10000
+
+// sqlDialect describes how a SQL database supported by the balance repository
+// differs from the others.
+type sqlDialect struct {
+	// driverName is the name of the database/sql driver of the dialect.
+	driverName string
+
+	// keyType is the column type of public keys.  Public keys must be
+	// compared byte-wise like the other repositories do, so both the
+	// ranking of tied balances and the iteration order match theirs.
+	keyType string
+
+	// blobType is the column type of hashes.
+	blobType string
+
+	// numberedParams is whether query parameters are numbered, as in $1,
+	// rather than question marks.
+	numberedParams bool
+
+	// singleConn is whether the database only supports a single writer,
+	// in which case all access is serialized over a single connection.
+	singleConn bool
+
+	// isTransientError returns whether the passed error of the driver is
+	// likely to be temporary.  It is used by IsTransientError.
+	isTransientError func(err error) bool
+}
+
+// sqlDialects are the supported SQL dialects keyed by name.  The sqlite dialect
+// is registered by sql_sqlite.go since its driver requires cgo.
+var sqlDialects = map[string]*sqlDialect{
+	"postgres": {
+		driverName:     "postgres",
+		keyType:        `TEXT COLLATE "C"`,
+		blobType:       "BYTEA",
+		numberedParams: true,
+		isTransientError: func(err error) bool {
+			pqErr, ok := err.(*pq.Error)
+			if !ok {
+				return false
+			}
+
+			// Connection failures, serialization failures and
+			// deadlocks, running out of resources and the server
+			// shutting down.
+			switch pqErr.Code.Class() {
+			case "08", "40", "53", "57":
+				return true
+			}
+			return false
+		},
+	},
+}
+
+// rebind converts the question mark parameters of the passed query to the ones
+// of the dialect.
+func (d *sqlDialect) rebind(query string) string {
+	if !d.numberedParams {
+		return query
+	}
+
+	var buf bytes.Buffer
+	var n int
+	for _, r := range query {
+		if r != '?' {
+			buf.WriteRune(r)
+			continue
+		}
+		n++
+		buf.WriteByte('$')
+		buf.WriteString(strconv.Itoa(n))
+	}
+	return buf.String()
+}
+
+// sqlMigrations are the statements that upgrade the schema of the balance
+// repository, where the statements at index i upgrade it from version i to
+// version i+1.  Migrations that have been released must never be changed, so
+// schema changes are made by appending new ones.
+//
+// The statements refer to the tables through the {balance}, {history} and
+// {tip} placeholders and to the dialect specific column types through the
+// {key} and {blob} placeholders.
+var sqlMigrations = [][]string{
+	// Version 1 creates the balance, history and tip tables along with the
+	// value index that ranks the balances.
+	{
+		`CREATE TABLE {balance} (
+			public_key {key} PRIMARY KEY,
+			value BIGINT NOT NULL,
+			received BIGINT NOT NULL,
+			sent BIGINT NOT NULL,
+			tx_count BIGINT NOT NULL,
+			first_seen INTEGER NOT NULL,
+			last_seen INTEGER NOT NULL
+		)`,
+		`CREATE INDEX {balance}_value ON {balance} (value DESC, public_key)`,
+		`CREATE TABLE {history} (
+			public_key {key} NOT NULL,
+			height INTEGER NOT NULL,
+			seq INTEGER NOT NULL,
+			tx_hash {blob} NOT NULL,
+			delta BIGINT NOT NULL,
+			received BIGINT NOT NULL,
+			sent BIGINT NOT NULL,
+			balance BIGINT NOT NULL,
+			PRIMARY KEY (public_key, height, seq)
+		)`,
+		`CREATE TABLE {tip} (
+			id INTEGER PRIMARY KEY,
+			hash {blob} NOT NULL,
+			height INTEGER NOT NULL
+		)`,
+	},
+}
+
+// sqlTableNameRegexp matches the table names the repository accepts, which are
+// used in queries as is.
+var sqlTableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sqlQuerier is implemented by both databases and transactions.
+type sqlQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// SqlBalanceRepository is a balance repository backed by a SQL database, which
+// allows the balances to be queried with SQL directly.
+//
+// Every block is applied in a single transaction that also records it in the
+// tip table, so the repository is always consistent with its tip.  Balances
+// are updated with upserts that add the changes to the stored totals, while
+// the changes themselves are recorded in the history table along with the
+// running balance.
+type SqlBalanceRepository struct {
+	db       *sql.DB
+	dialect  *sqlDialect
+	replacer *strings.Replacer
+}
+
+// Ensure SqlBalanceRepository implements the IBalanceRepository interface.
+var _ IBalanceRepository = (*SqlBalanceRepository)(nil)
+
+// NewSqlBalanceRepository opens the balance repository in the database of the
+// passed dialect, either postgres or sqlite, identified by the passed data
+// source name, which is a connection string for postgres and a file name for
+// sqlite.  The balances are kept in the passed table, while the history and
+// tip are kept in tables named after it with a _history and _tip suffix.
+//
+// The tables are created when they don't exist yet and migrated to the latest
+// schema otherwise.  The schema version is recorded in a fourth table with a
+// _version suffix.
+//
+// The sqlite dialect is only available when built with cgo enabled.
+func NewSqlBalanceRepository(dialectName, dataSource, tableName string) (*SqlBalanceRepository, error) {
+	dialect, ok := sqlDialects[dialectName]
+	if !ok {
+		return nil, fmt.Errorf("unsupported SQL dialect %q", dialectName)
+	}
+	if !sqlTableNameRegexp.MatchString(tableName) {
+		return nil, fmt.Errorf("invalid balance table name %q", tableName)
+	}
+
+	db, err := sql.Open(dialect.driverName, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	if dialect.singleConn {
+		db.SetMaxOpenConns(1)
+	}
+
+	repo := &SqlBalanceRepository{
+		db:      db,
+		dialect: dialect,
+		replacer: strings.NewReplacer(
+			"{balance}", tableName,
+			"{history}", tableName+"_history",
+			"{tip}", tableName+"_tip",
+			"{version}", tableName+"_version",
+			"{key}", dialect.keyType,
+			"{blob}", dialect.blobType,
+		),
+	}
+	if err := repo.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+// query returns the passed query with the table and column type placeholders
+// filled in and the parameters converted for the dialect.
+func (t *SqlBalanceRepository) query(query string) string {
+	return t.dialect.rebind(t.replacer.Replace(query))
+}
+
+// inTx runs the passed function in a transaction, which is committed when the
+// function succeeds and rolled back otherwise.
+func (t *SqlBalanceRepository) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrate applies the migrations the schema of the repository is missing in a
+// single transaction.
+func (t *SqlBalanceRepository) migrate() error {
+	return t.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(t.query(`CREATE TABLE IF NOT EXISTS {version} (
+			version INTEGER NOT NULL
+		)`))
+		if err != nil {
+			return err
+		}
+
+		var version int
+		err = tx.QueryRow(t.query(`SELECT version FROM {version}`)).
+			Scan(&version)
+		if err == sql.ErrNoRows {
+			_, err = tx.Exec(t.query(
+				`INSERT INTO {version} (version) VALUES (0)`))
+		}
+		if err != nil {
+			return err
+		}
+		if version > len(sqlMigrations) {
+			return fmt.Errorf("balance repository schema version "+
+				"%d is newer than the latest supported version "+
+				"%d", version, len(sqlMigrations))
+		}
+		if version == len(sqlMigrations) {
+			return nil
+		}
+
+		for _, migration := range sqlMigrations[version:] {
+			for _, stmt := range migration {
+				if _, err := tx.Exec(t.query(stmt)); err != nil {
+					return err
+				}
+			}
+		}
+		_, err = tx.Exec(t.query(`UPDATE {version} SET version = ?`),
+			len(sqlMigrations))
+		return err
+	})
+}
+
+func (t *SqlBalanceRepository) Get(publicKey string) (*Balance, error) {
+	balance := &Balance{PublicKey: publicKey}
+	err := t.db.QueryRow(t.query(`SELECT value, received, sent, tx_count,
+		first_seen, last_seen FROM {balance} WHERE public_key = ?`),
+		publicKey).Scan(&balance.Value, &balance.Received,
+		&balance.Sent, &balance.TxCount, &balance.FirstSeen,
+		&balance.LastSeen)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return balance, nil
+}
+
+func (t *SqlBalanceRepository) Insert(balance *Balance) error {
+	_, err := t.db.Exec(t.query(`INSERT INTO {balance} (public_key, value,
+		received, sent, tx_count, first_seen, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (public_key) DO UPDATE SET
+			value = excluded.value,
+			received = excluded.received,
+			sent = excluded.sent,
+			tx_count = excluded.tx_count,
+			first_seen = excluded.first_seen,
+			last_seen = excluded.last_seen`),
+		balance.PublicKey, balance.Value, balance.Received,
+		balance.Sent, balance.TxCount, balance.FirstSeen,
+		balance.LastSeen)
+	return err
+}
+
+func (t *SqlBalanceRepository) Update(balance *Balance) error {
+	return t.Insert(balance)
+}
+
+// ConnectBlock adds the passed balance changes to the stored balances, records
+// them in the balance history and records the passed block as the tip in a
+// single transaction.
+func (t *SqlBalanceRepository) ConnectBlock(hash *chainhash.Hash, height int32, deltas map[string][]BalanceDelta) error {
+	return t.ConnectBlocks([]*BlockDeltas{{
+		Hash:   *hash,
+		Height: height,
+		Deltas: deltas,
+	}})
+}
+
+// ConnectBlocks connects all of the passed blocks in a single transaction.  The
+// changes to each balance across all of the blocks are added with a single
+// upsert, which returns the resulting balance the running balances of the
+// history are derived from.
+//
+// Blocks at or below the tip are skipped, so connecting blocks again after the
+// commit succeeded but the acknowledgement was lost leaves the repository
+// unchanged.
+func (t *SqlBalanceRepository) ConnectBlocks(blocks []*BlockDeltas) error {
+	return t.inTx(func(tx *sql.Tx) error {
+		tipHash, tipHeight, err := t.tip(tx)
+		if err != nil {
+			return err
+		}
+		for tipHash != nil && len(blocks) > 0 &&
+			blocks[0].Height <= tipHeight {
+
+			blocks = blocks[1:]
+		}
+		if len(blocks) == 0 {
+			return nil
+		}
+
+		upsert, err := tx.Prepare(t.query(`INSERT INTO {balance}
+			(public_key, value, received, sent, tx_count,
+			first_seen, last_seen) VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (public_key) DO UPDATE SET
+				value = {balance}.value + excluded.value,
+				received = {balance}.received + excluded.received,
+				sent = {balance}.sent + excluded.sent,
+				first_seen = CASE WHEN {balance}.tx_count = 0
+					THEN excluded.first_seen
+					ELSE {balance}.first_seen END,
+				tx_count = {balance}.tx_count + excluded.tx_count,
+				last_seen = excluded.last_seen
+			RETURNING value`))
+		if err != nil {
+			return err
+		}
+		defer upsert.Close()
+		insertHistory, err := tx.Prepare(t.query(`INSERT INTO {history}
+			(public_key, height, seq, tx_hash, delta, received,
+			sent, balance) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`))
+		if err != nil {
+			return err
+		}
+		defer insertHistory.Close()
+
+		for publicKey, changes := range groupByKey(blocks) {
+			totals := &Balance{TxCount: int64(len(changes))}
+			for i := range changes {
+				totals.Value += changes[i].delta.Value
+				totals.Received += changes[i].delta.Received
+				totals.Sent += changes[i].delta.Sent
+			}
+			var value int64
+			err := upsert.QueryRow(publicKey, totals.Value,
+				totals.Received, totals.Sent, totals.TxCount,
+				changes[0].height,
+				changes[len(changes)-1].height).Scan(&value)
+			if err != nil {
+				return err
+			}
+
+			// Rebuild the running balance from the balance before
+			// the blocks.
+			value -= totals.Value
+			for i := range changes {
+				change := &changes[i]
+				value += change.delta.Value
+				_, err := insertHistory.Exec(publicKey,
+					change.height, change.seq,
+					change.delta.TxHash[:], change.delta.Value,
+					change.delta.Received, change.delta.Sent,
+					value)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		last := blocks[len(blocks)-1]
+		return t.putTip(tx, &last.Hash, last.Height)
+	})
+}
+
+// DisconnectBlock reverses the passed balance changes, removes the history of
+// the block and records its parent as the tip in a single transaction.  The
+// last seen height is restored from the history below the block.
+func (t *SqlBalanceRepository) DisconnectBlock(prevHash *chainhash.Hash, height int32, deltas map[string][]BalanceDelta) error {
+	return t.inTx(func(tx *sql.Tx) error {
+		for publicKey, addrDeltas := range deltas {
+			if len(addrDeltas) == 0 {
+				continue
+			}
+
+			var prevLastSeen sql.NullInt64
+			err := tx.QueryRow(t.query(`SELECT MAX(height) FROM
+				{history} WHERE public_key = ? AND height < ?`),
+				publicKey, height).Scan(&prevLastSeen)
+			if err != nil {
+				return err
+			}
+
+			totals := &Balance{TxCount: int64(len(addrDeltas))}
+			for i := range addrDeltas {
+				totals.Value += addrDeltas[i].Value
+				totals.Received += addrDeltas[i].Received
+				totals.Sent += addrDeltas[i].Sent
+			}
+			_, err = tx.Exec(t.query(`UPDATE {balance} SET
+				value = value - ?,
+				received = received - ?,
+				sent = sent - ?,
+				tx_count = CASE WHEN tx_count > ?
+					THEN tx_count - ? ELSE 0 END,
+				first_seen = CASE WHEN tx_count > ?
+					THEN first_seen ELSE 0 END,
+				last_seen = CASE WHEN tx_count > ?
+					THEN ? ELSE 0 END
+				WHERE public_key = ?`), totals.Value,
+				totals.Received, totals.Sent, totals.TxCount,
+				totals.TxCount, totals.TxCount, totals.TxCount,
+				prevLastSeen.Int64, publicKey)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(t.query(`DELETE FROM {history}
+				WHERE public_key = ? AND height = ?`),
+				publicKey, height)
+			if err != nil {
+				return err
+			}
+		}
+
+		return t.putTip(tx, prevHash, height-1)
+	})
+}
+
+func (t *SqlBalanceRepository) Tip() (*chainhash.Hash, int32, error) {
+	return t.tip(t.db)
+}
+
+// tip returns the tip recorded in the tip table using the passed database or
+// transaction.
+func (t *SqlBalanceRepository) tip(q sqlQuerier) (*chainhash.Hash, int32, error) {
+	var serializedHash []byte
+	var height int32
+	err := q.QueryRow(t.query(`SELECT hash, height FROM {tip}
+		WHERE id = 0`)).Scan(&serializedHash, &height)
+	if err == sql.ErrNoRows {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hash, err := chainhash.NewHash(serializedHash)
+	if err != nil {
+		return nil, 0, fmt.Errorf("corrupt balance repository tip: %v",
+			err)
+	}
+	return hash, height, nil
+}
+
+// putTip records the passed block as the tip in the passed transaction.
+func (t *SqlBalanceRepository) putTip(tx *sql.Tx, hash *chainhash.Hash, height int32) error {
+	_, err := tx.Exec(t.query(`INSERT INTO {tip} (id, hash, height)
+		VALUES (0, ?, ?) ON CONFLICT (id) DO UPDATE SET
+			hash = excluded.hash,
+			height = excluded.height`), hash[:], height)
+	return err
+}
+
+// TopBalances reads the largest balances from the value index.
+func (t *SqlBalanceRepository) TopBalances(count int, minValue int64) ([]*Balance, error) {
+	top := make([]*Balance, 0, count)
+	if count <= 0 {
+		return top, nil
+	}
+
+	rows, err := t.db.Query(t.query(`SELECT public_key, value
+		FROM {balance} WHERE value >= ? AND substr(public_key, 1, 1) <> ?
+		ORDER BY value DESC, public_key LIMIT ?`), minValue,
+		reservedKeyPrefix, count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		balance := new(Balance)
+		err := rows.Scan(&balance.PublicKey, &balance.Value)
+		if err != nil {
+			return nil, err
+		}
+		top = append(top, balance)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return top, nil
+}
+
+// ForEachBalance iterates the balances in the order of their public keys,
+// skipping reserved keys.  The balances are read in pages, so no query is open
+// while the passed function runs and it may access the repository.
+func (t *SqlBalanceRepository) ForEachBalance(fn func(balance *Balance) error) error {
+	var after string
+	for {
+		balances, err := t.balancePage(after)
+		if err != nil {
+			return err
+		}
+		for _, balance := range balances {
+			if err := fn(balance); err != nil {
+				return err
+			}
+		}
+		if len(balances) < sqlForEachPageSize {
+			return nil
+		}
+		after = balances[len(balances)-1].PublicKey
+	}
+}
+
+// balancePage returns the next page of balances whose public keys follow the
+// passed one, skipping reserved keys.
+func (t *SqlBalanceRepository) balancePage(after string) ([]*Balance, error) {
+	rows, err := t.db.Query(t.query(`SELECT public_key, value, received,
+		sent, tx_count, first_seen, last_seen FROM {balance}
+		WHERE public_key > ? AND substr(public_key, 1, 1) <> ?
+		ORDER BY public_key LIMIT ?`), after, reservedKeyPrefix,
+		sqlForEachPageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var balances []*Balance
+	for rows.Next() {
+		balance := new(Balance)
+		err := rows.Scan(&balance.PublicKey, &balance.Value,
+			&balance.Received, &balance.Sent, &balance.TxCount,
+			&balance.FirstSeen, &balance.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, balance)
+	}
+
+	return balances, rows.Err()
+}
+
+func (t *SqlBalanceRepository) CountBalances(minValue int64) (int64, error) {
+	var count int64
+	err := t.db.QueryRow(t.query(`SELECT COUNT(*) FROM {balance}
+		WHERE value >= ? AND substr(public_key, 1, 1) <> ?`), minValue,
+		reservedKeyPrefix).Scan(&count)
+	return count, err
+}
+
+func (t *SqlBalanceRepository) BalanceHistory(publicKey string, fromHeight, toHeight int32) ([]*BalanceHistoryEntry, error) {
+	if fromHeight > toHeight {
+		return nil, nil
+	}
+
+	rows, err := t.db.Query(t.query(`SELECT height, tx_hash, delta,
+		received, sent, balance FROM {history}
+		WHERE public_key = ? AND height >= ? AND height <= ?
+		ORDER BY height, seq`), publicKey, fromHeight, toHeight)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*BalanceHistoryEntry
+	for rows.Next() {
+		entry := new(BalanceHistoryEntry)
+		var txHash []byte
+		err := rows.Scan(&entry.Height, &txHash, &entry.Delta,
+			&entry.Received, &entry.Sent, &entry.Balance)
+		if err != nil {
+			return nil, err
+		}
+		if err := entry.TxHash.SetBytes(txHash); err != nil {
+			return nil, fmt.Errorf("corrupt balance history entry "+
+				"of %s at height %d: %v", publicKey,
+				entry.Height, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// BalanceAtHeight returns the running balance of the last history entry of the
+// passed public key at or below the passed height.
+func (t *SqlBalanceRepository) BalanceAtHeight(publicKey string, height int32) (int64, error) {
+	var balance int64
+	err := t.db.QueryRow(t.query(`SELECT balance FROM {history}
+		WHERE public_key = ? AND height <= ?
+		ORDER BY height DESC, seq DESC LIMIT 1`), publicKey, height).
+		Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return balance, err
+}
+
+func (t *SqlBalanceRepository) Close() error {
+	return t.db.Close()
+}
@@ -0,0 +1,229 @@
+// +build cgo
+
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// newTestSqliteBalanceRepo returns a sqlite balance repository in a new
+// temporary directory along with a function that closes it and removes the
+// directory.
+func newTestSqliteBalanceRepo(t *testing.T) (*SqlBalanceRepository, string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "sqlbalances")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	path := filepath.Join(dir, "balances.sqlite")
+	repo, err := NewSqlBalanceRepository("sqlite", path, "balance")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unable to open balance repository: %v", err)
+	}
+	return repo, path, func() {
+		repo.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// TestSqlBalanceIteration ensures the sql repository iterates, counts and ranks
+// balances as expected.
+func TestSqlBalanceIteration(t *testing.T) {
+	t.Parallel()
+
+	repo, _, teardown := newTestSqliteBalanceRepo(t)
+	defer teardown()
+	testBalanceIteration(t, repo)
+}
+
+// TestSqlBalanceStats ensures the sql repository tracks the totals of the
+// balances as expected.
+func TestSqlBalanceStats(t *testing.T) {
+	t.Parallel()
+
+	repo, _, teardown := newTestSqliteBalanceRepo(t)
+	defer teardown()
+	testBalanceStats(t, repo)
+}
+
+// TestSqlBalanceReorg ensures the sql repository applies the blocks of a
+// reorganization as expected.
+func TestSqlBalanceReorg(t *testing.T) {
+	t.Parallel()
+
+	repo, _, teardown := newTestSqliteBalanceRepo(t)
+	defer teardown()
+	testBalanceReorg(t, repo)
+}
+
+// TestSqlBalanceRepository ensures the sql repository records its tip, skips
+// blocks it already holds, pages through balances, and migrates its schema.
+func TestSqlBalanceRepository(t *testing.T) {
+	t.Parallel()
+
+	repo, path, teardown := newTestSqliteBalanceRepo(t)
+	defer teardown()
+
+	hash, height, err := repo.Tip()
+	if err != nil || hash != nil || height != 0 {
+		t.Fatalf("Tip: unexpected tip of empty repository -- got %v "+
+			"(%d), error %v", hash, height, err)
+	}
+
+	blocks := []*BlockDeltas{
+		{Hash: chainhash.Hash{1}, Height: 1, Deltas: map[string][]BalanceDelta{
+			"a": {{TxHash: chainhash.Hash{11}, Value: 50, Received: 50}},
+		}},
+		{Hash: chainhash.Hash{2}, Height: 2, Deltas: map[string][]BalanceDelta{
+			"a": {{TxHash: chainhash.Hash{21}, Value: -20, Sent: 20}},
+			"b": {{TxHash: chainhash.Hash{21}, Value: 20, Received: 20}},
+		}},
+	}
+	if err := repo.ConnectBlocks(blocks); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+
+	// Connecting blocks the repository already holds again, as happens
+	// when the acknowledgement of a commit is lost, only applies the new
+	// ones.
+	blocks = append(blocks, &BlockDeltas{Hash: chainhash.Hash{3}, Height: 3,
+		Deltas: map[string][]BalanceDelta{
+			"b": {{TxHash: chainhash.Hash{31}, Value: 5, Received: 5}},
+		}})
+	if err := repo.ConnectBlocks(blocks); err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
+	}
+	checkBalance := func(want *Balance) {
+		t.Helper()
+
+		got, err := repo.Get(want.PublicKey)
+		if err != nil {
+			t.Fatalf("Get: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Get: mismatched balance -- got %+v, want %+v",
+				got, want)
+		}
+	}
+	checkBalance(&Balance{PublicKey: "a", Value: 30, Received: 50,
+		Sent: 20, TxCount: 2, FirstSeen: 1, LastSeen: 2})
+	checkBalance(&Balance{PublicKey: "b", Value: 25, Received: 25,
+		TxCount: 2, FirstSeen: 2, LastSeen: 3})
+	history, err := repo.BalanceHistory("b", 0, 3)
+	if err != nil {
+		t.Fatalf("BalanceHistory: unexpected error: %v", err)
+	}
+	wantHistory := []*BalanceHistoryEntry{
+		{Height: 2, TxHash: chainhash.Hash{21}, Delta: 20, Received: 20,
+			Balance: 20},
+		{Height: 3, TxHash: chainhash.Hash{31}, Delta: 5, Received: 5,
+			Balance: 25},
+	}
+	if !reflect.DeepEqual(history, wantHistory) {
+		t.Fatalf("BalanceHistory: mismatched history -- got %+v, want %+v",
+			history, wantHistory)
+	}
+
+	// Iteration spans several pages.  The balances are inserted in a
+	// single transaction since sqlite syncs every commit.
+	err = repo.inTx(func(tx *sql.Tx) error {
+		for i := 0; i < sqlForEachPageSize+1; i++ {
+			_, err := tx.Exec("INSERT INTO balance VALUES "+
+				"(?, 0, 0, 0, 0, 0, 0)", fmt.Sprintf("c%05d", i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unable to insert balances: %v", err)
+	}
+	var visited int
+	var last string
+	err = repo.ForEachBalance(func(balance *Balance) error {
+		if balance.PublicKey <= last {
+			t.Fatalf("ForEachBalance: balance %q visited after %q",
+				balance.PublicKey, last)
+		}
+		last = balance.PublicKey
+		visited++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachBalance: unexpected error: %v", err)
+	}
+	if visited != sqlForEachPageSize+3 {
+		t.Fatalf("ForEachBalance: mismatched number of balances -- got "+
+			"%d, want %d", visited, sqlForEachPageSize+3)
+	}
+
+	// The tip survives reopening the repository, which leaves the schema
+	// untouched.
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close: unexpected error: %v", err)
+	}
+	repo, err = NewSqlBalanceRepository("sqlite", path, "balance")
+	if err != nil {
+		t.Fatalf("unable to reopen balance repository: %v", err)
+	}
+	hash, height, err = repo.Tip()
+	if err != nil || *hash != (chainhash.Hash{3}) || height != 3 {
+		t.Fatalf("Tip: unexpected tip after reopening -- got %v (%d), "+
+			"error %v", hash, height, err)
+	}
+	repo.Close()
+
+	// Repositories with a newer schema than the supported one are
+	// rejected.
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("unable to open database: %v", err)
+	}
+	_, err = db.Exec("UPDATE balance_version SET version = ?",
+		len(sqlMigrations)+1)
+	db.Close()
+	if err != nil {
+		t.Fatalf("unable to update schema version: %v", err)
+	}
+	repo, err = NewSqlBalanceRepository("sqlite", path, "balance")
+	if err == nil {
+		repo.Close()
+		t.Fatal("NewSqlBalanceRepository: did not receive expected " +
+			"error for newer schema")
+	}
+
+	// Table names are used in queries as is, so they are validated.
+	_, err = NewSqlBalanceRepository("sqlite", path, "balance; DROP")
+	if err == nil {
+		t.Fatal("NewSqlBalanceRepository: did not receive expected " +
+			"error for invalid table name")
+	}
+}
+
+// TestSqlDialectRebind ensures query parameters are converted to the ones of
+// each dialect.
+func TestSqlDialectRebind(t *testing.T) {
+	t.Parallel()
+
+	query := "SELECT a FROM t WHERE b = ? AND c > ?"
+	if got := sqlDialects["sqlite"].rebind(query); got != query {
+		t.Fatalf("rebind: mismatched sqlite query -- got %q, want %q",
+			got, query)
+	}
+	want := "SELECT a FROM t WHERE b = $1 AND c > $2"
+	if got := sqlDialects["postgres"].rebind(query); got != want {
+		t.Fatalf("rebind: mismatched postgres query -- got %q, want %q",
+			got, want)
+	}
+}
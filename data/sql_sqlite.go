@@ -0,0 +1,24 @@
+// +build cgo
+
+package data
+
+import (
+	"github.com/mattn/go-sqlite3"
+)
+
+// The sqlite dialect is only registered when cgo is enabled since its driver
+// wraps the sqlite C library.  Importing the driver registers it with
+// database/sql.
+func init() {
+	sqlDialects["sqlite"] = &sqlDialect{
+		driverName: "sqlite3",
+		keyType:    "TEXT",
+		blobType:   "BLOB",
+		singleConn: true,
+		isTransientError: func(err error) bool {
+			sqliteErr, ok := err.(sqlite3.Error)
+			return ok && (sqliteErr.Code == sqlite3.ErrBusy ||
+				sqliteErr.Code == sqlite3.ErrLocked)
+		},
+	}
+}
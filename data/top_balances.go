@@ -0,0 +1,93 @@
+package data
+
+import (
+	"container/heap"
+)
+
+// topBalances keeps track of the largest balances added to it up to a limit.
+// It is implemented as a min-heap so the smallest balance kept is the first to
+// be evicted when a larger one is added.  Ties are broken by the public key so
+// the results are deterministic.
+type topBalances struct {
+	limit    int
+	balances []*Balance
+}
+
+// newTopBalances returns a collector for the passed number of largest balances.
+func newTopBalances(limit int) *topBalances {
+	return &topBalances{limit: limit}
+}
+
+// Len returns the number of balances kept.  It is part of heap.Interface.
+func (t *topBalances) Len() int {
+	return len(t.balances)
+}
+
+// Less returns whether the balance at index i ranks below the one at index j.
+// It is part of heap.Interface.
+func (t *topBalances) Less(i, j int) bool {
+	return balanceRanksBelow(t.balances[i], t.balances[j])
+}
+
+// Swap swaps the balances at the passed indices.  It is part of
+// heap.Interface.
+func (t *topBalances) Swap(i, j int) {
+	t.balances[i], t.balances[j] = t.balances[j], t.balances[i]
+}
+
+// Push appends the passed balance.  It is part of heap.Interface.
+func (t *topBalances) Push(x interface{}) {
+	t.balances = append(t.balances, x.(*Balance))
+}
+
+// Pop removes and returns the last balance.  It is part of heap.Interface.
+func (t *topBalances) Pop() interface{} {
+	n := len(t.balances)
+	balance := t.balances[n-1]
+	t.balances = t.balances[:n-1]
+	return balance
+}
+
+// add considers the passed balance for inclusion in the largest balances.
+func (t *topBalances) add(balance *Balance) {
+	if t.limit <= 0 {
+		return
+	}
+	if len(t.balances) < t.limit {
+		heap.Push(t, balance)
+		return
+	}
+	if balanceRanksBelow(t.balances[0], balance) {
+		t.balances[0] = balance
+		heap.Fix(t, 0)
+	}
+}
+
+// done returns whether no balance of the passed value or less can be added
+// anymore, which allows sources that produce balances from the largest to the
+// smallest to stop early.
+func (t *topBalances) done(value int64) bool {
+	if t.limit <= 0 {
+		return true
+	}
+	return len(t.balances) == t.limit && t.balances[0].Value > value
+}
+
+// sorted returns the kept balances ordered from the largest to the smallest.
+// The collector is empty afterwards.
+func (t *topBalances) sorted() []*Balance {
+	sorted := make([]*Balance, len(t.balances))
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = heap.Pop(t).(*Balance)
+	}
+	return sorted
+}
+
+// balanceRanksBelow returns whether balance a ranks below balance b in a list
+// of the largest balances.
+func balanceRanksBelow(a, b *Balance) bool {
+	if a.Value != b.Value {
+		return a.Value < b.Value
+	}
+	return a.PublicKey > b.PublicKey
+}
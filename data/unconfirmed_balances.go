@@ -0,0 +1,119 @@
+package data
+
+import (
+	"sync"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
+)
+
+// UnconfirmedBalances is a memory-only overlay of the balance changes caused by
+// the transactions in the memory pool.  It is kept up to date by the memory
+// pool as transactions are added and removed, which happens when they are
+// mined, evicted or double spent by a block, so the overlay only ever reflects
+// the transactions that are still unconfirmed.
+type UnconfirmedBalances struct {
+	// policy defines the keys the balance changes are recorded under.  It
+	// is set when the instance is created and can't be changed afterwards.
+	policy *KeyPolicy
+
+	// The following fields are protected by mtx.
+	//
+	// The balances field holds the net unconfirmed change of every key
+	// touched by a transaction in the memory pool.
+	//
+	// The deltasByTx field holds the changes each transaction caused so
+	// they can be reverted efficiently once it is removed.
+	mtx        sync.RWMutex
+	balances   map[string]int64
+	deltasByTx map[chainhash.Hash]map[string]int64
+}
+
+// NewUnconfirmedBalances returns a new, empty overlay that records balance
+// changes under the keys defined by the passed policy.
+func NewUnconfirmedBalances(policy *KeyPolicy) *UnconfirmedBalances {
+	return &UnconfirmedBalances{
+		policy:     policy,
+		balances:   make(map[string]int64),
+		deltasByTx: make(map[chainhash.Hash]map[string]int64),
+	}
+}
+
+// AddUnconfirmedTx adds the balance changes caused by the passed transaction
+// to the overlay.  Adding a transaction that is already part of the overlay
+// has no effect.
+//
+// NOTE: This transaction MUST have already been validated by the memory pool
+// before calling this function with it and have all of the inputs available in
+// the provided utxo view.  Inputs that are missing from the view are ignored.
+//
+// This function is safe for concurrent access.
+func (u *UnconfirmedBalances) AddUnconfirmedTx(tx *btcutil.Tx, utxoView *blockchain.UtxoViewpoint) {
+	keyMap := make(map[string]int64)
+	for _, txIn := range tx.MsgTx().TxIn {
+		entry := utxoView.LookupEntry(txIn.PreviousOutPoint)
+		if entry == nil {
+			continue
+		}
+		keyMap[u.policy.ScriptKey(entry.PkScript())] -= entry.Amount()
+	}
+	for _, txOut := range tx.MsgTx().TxOut {
+		keyMap[u.policy.ScriptKey(txOut.PkScript)] += txOut.Value
+	}
+	for key, value := range keyMap {
+		if value == 0 {
+			delete(keyMap, key)
+		}
+	}
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	if _, exists := u.deltasByTx[*tx.Hash()]; exists {
+		return
+	}
+	u.deltasByTx[*tx.Hash()] = keyMap
+	for key, value := range keyMap {
+		u.addBalance(key, value)
+	}
+}
+
+// RemoveUnconfirmedTx reverts the balance changes caused by the transaction
+// with the passed hash.  Removing a transaction that is not part of the overlay
+// has no effect.
+//
+// This function is safe for concurrent access.
+func (u *UnconfirmedBalances) RemoveUnconfirmedTx(hash *chainhash.Hash) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	for key, value := range u.deltasByTx[*hash] {
+		u.addBalance(key, -value)
+	}
+	delete(u.deltasByTx, *hash)
+}
+
+// addBalance adds the passed value to the unconfirmed balance of the passed key
+// and removes the entry once it no longer changes the confirmed balance.
+//
+// This function MUST be called with the lock held (for writes).
+func (u *UnconfirmedBalances) addBalance(key string, value int64) {
+	balance := u.balances[key] + value
+	if balance == 0 {
+		delete(u.balances, key)
+		return
+	}
+	u.balances[key] = balance
+}
+
+// Balance returns the net change the transactions in the memory pool cause to
+// the balance of the passed key.
+//
+// This function is safe for concurrent access.
+func (u *UnconfirmedBalances) Balance(publicKey string) int64 {
+	u.mtx.RLock()
+	defer u.mtx.RUnlock()
+
+	return u.balances[publicKey]
+}
@@ -0,0 +1,96 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// TestUnconfirmedBalances ensures the overlay tracks the net balance changes of
+// chains of unconfirmed transactions and reverts them once they are removed.
+func TestUnconfirmedBalances(t *testing.T) {
+	t.Parallel()
+
+	params := &chaincfg.MainNetParams
+	keys := make([]string, 3)
+	scripts := make([][]byte, 3)
+	for i := range keys {
+		addr, err := btcutil.NewAddressScriptHashFromHash(
+			[]byte{byte(i), 19: 0}, params)
+		if err != nil {
+			t.Fatalf("NewAddressScriptHashFromHash: unexpected "+
+				"error: %v", err)
+		}
+		keys[i] = addr.EncodeAddress()
+		scripts[i], err = txscript.PayToAddrScript(addr)
+		if err != nil {
+			t.Fatalf("PayToAddrScript: unexpected error: %v", err)
+		}
+	}
+
+	// funding is a confirmed transaction paying 10 to the first key.
+	funding := wire.NewMsgTx(1)
+	funding.AddTxIn(&wire.TxIn{})
+	funding.AddTxOut(wire.NewTxOut(10, scripts[0]))
+	fundingTx := btcutil.NewTx(funding)
+
+	// tx1 pays 6 to the second key and 3 back to the first key.
+	msgTx1 := wire.NewMsgTx(1)
+	msgTx1.AddTxIn(wire.NewTxIn(wire.NewOutPoint(fundingTx.Hash(), 0),
+		nil, nil))
+	msgTx1.AddTxOut(wire.NewTxOut(6, scripts[1]))
+	msgTx1.AddTxOut(wire.NewTxOut(3, scripts[0]))
+	tx1 := btcutil.NewTx(msgTx1)
+
+	// tx2 spends the unconfirmed output of tx1 to the third key.
+	msgTx2 := wire.NewMsgTx(1)
+	msgTx2.AddTxIn(wire.NewTxIn(wire.NewOutPoint(tx1.Hash(), 0), nil, nil))
+	msgTx2.AddTxOut(wire.NewTxOut(6, scripts[2]))
+	tx2 := btcutil.NewTx(msgTx2)
+
+	view := blockchain.NewUtxoViewpoint()
+	view.AddTxOuts(fundingTx, 1)
+	view.AddTxOuts(tx1, 0x7fffffff)
+
+	overlay := NewUnconfirmedBalances(&KeyPolicy{ChainParams: params})
+	checkBalances := func(want ...int64) {
+		t.Helper()
+		for i, key := range keys {
+			if got := overlay.Balance(key); got != want[i] {
+				t.Fatalf("Balance: mismatched balance for key %d "+
+					"-- got %d, want %d", i, got, want[i])
+			}
+		}
+	}
+
+	overlay.AddUnconfirmedTx(tx1, view)
+	checkBalances(-7, 6, 0)
+
+	// Adding a transaction twice must not count it twice.
+	overlay.AddUnconfirmedTx(tx1, view)
+	checkBalances(-7, 6, 0)
+
+	overlay.AddUnconfirmedTx(tx2, view)
+	checkBalances(-7, 0, 6)
+
+	// Removing the parent, such as when it is mined, must leave the
+	// changes of its child in place.
+	overlay.RemoveUnconfirmedTx(tx1.Hash())
+	checkBalances(0, -6, 6)
+
+	overlay.RemoveUnconfirmedTx(tx2.Hash())
+	checkBalances(0, 0, 0)
+	if len(overlay.balances) != 0 || len(overlay.deltasByTx) != 0 {
+		t.Fatalf("overlay not empty after removing all transactions: "+
+			"%d balances, %d transactions", len(overlay.balances),
+			len(overlay.deltasByTx))
+	}
+
+	// Removing an unknown transaction has no effect.
+	overlay.RemoveUnconfirmedTx(tx1.Hash())
+	checkBalances(0, 0, 0)
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2015-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/data"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// auditBalancesCmd defines the configuration options for the auditbalances
+// command.
+type auditBalancesCmd struct {
+	balanceRepoOptions
+	MaxMismatches int `long:"maxmismatches" description:"Maximum number of mismatched balances to report"`
+}
+
+var (
+	// auditBalancesCfg defines the configuration options for the command.
+	auditBalancesCfg = auditBalancesCmd{
+		balanceRepoOptions: defaultBalanceRepoOptions(),
+		MaxMismatches:      100,
+	}
+)
+
+// Execute is the main entry point for the command.  It's invoked by the parser.
+func (cmd *auditBalancesCmd) Execute(args []string) error {
+	// Setup the global config options and ensure they are valid.
+	if err := setupGlobalConfig(); err != nil {
+		return err
+	}
+
+	// Load the block database and the chain state built from it.
+	db, err := loadBlockDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: activeNetParams,
+		TimeSource:  blockchain.NewMedianTime(),
+	})
+	if err != nil {
+		return err
+	}
+	best := chain.BestSnapshot()
+
+	balanceRepo, err := cmd.loadBalanceRepo(false)
+	if err != nil {
+		return err
+	}
+	defer balanceRepo.Close()
+
+	// The balances only match the utxo set when both are as of the same
+	// block.
+	tipHash, tipHeight, err := balanceRepo.Tip()
+	if err != nil {
+		return err
+	}
+	if tipHash == nil || !tipHash.IsEqual(&best.Hash) {
+		log.Warnf("Balance repository tip %v (height %d) does not "+
+			"match the best chain tip %v (height %d) -- mismatches "+
+			"are expected", tipHash, tipHeight, best.Hash,
+			best.Height)
+	}
+
+	// Aggregate the utxo set by the same keys the explorer records the
+	// balances under.
+	log.Infof("Aggregating the utxo set as of block %v (height %d)...",
+		best.Hash, best.Height)
+	policy := cmd.keyPolicy()
+	expected := make(map[string]int64)
+	var utxoSupply int64
+	var numUtxos int
+	err = chain.ForEachUnspentOutput(func(outpoint wire.OutPoint, amount int64, pkScript []byte) error {
+		expected[policy.ScriptKey(pkScript)] += amount
+		utxoSupply += amount
+		numUtxos++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("Aggregated %d unspent outputs into %d balances", numUtxos,
+		len(expected))
+
+	// Load every balance in the repository, including the ones recorded
+	// under reserved keys.
+	log.Infof("Loading balances...")
+	recorded := make(map[string]int64, len(expected))
+	err = balanceRepo.ForEachBalance(func(balance *data.Balance) error {
+		recorded[balance.PublicKey] = balance.Value
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{data.NonstandardKey, data.UnspendableKey} {
+		balance, err := balanceRepo.Get(key)
+		if err != nil {
+			return err
+		}
+		if balance != nil {
+			recorded[key] = balance.Value
+		}
+	}
+	log.Infof("Loaded %d balances", len(recorded))
+
+	// Provably unspendable outputs are never added to the utxo set, so the
+	// value the explorer recorded for them can't be checked.
+	unspendable := recorded[data.UnspendableKey]
+	delete(recorded, data.UnspendableKey)
+
+	// Diff the balances in a deterministic order.
+	keys := make([]string, 0, len(expected)+len(recorded))
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	for key := range recorded {
+		if _, ok := expected[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var numMismatches int
+	var recordedSupply int64
+	for _, key := range keys {
+		recordedSupply += recorded[key]
+		if recorded[key] == expected[key] {
+			continue
+		}
+		numMismatches++
+		if numMismatches <= cmd.MaxMismatches {
+			log.Infof("Mismatched balance for %s: recorded %v, utxo "+
+				"set %v", key, btcutil.Amount(recorded[key]),
+				btcutil.Amount(expected[key]))
+		}
+	}
+
+	// The sum of the subsidies is an upper bound of the supply since the
+	// genesis coinbase can't be spent, duplicate coinbases overwrote
+	// earlier ones and miners are free to claim less than allowed.
+	var subsidySum int64
+	for height := int32(0); height <= best.Height; height++ {
+		subsidySum += blockchain.CalcBlockSubsidy(height, activeNetParams)
+	}
+
+	log.Infof("Utxo set supply:         %v", btcutil.Amount(utxoSupply))
+	log.Infof("Recorded supply:         %v", btcutil.Amount(recordedSupply))
+	log.Infof("Recorded unspendable:    %v", btcutil.Amount(unspendable))
+	log.Infof("Expected subsidy sum:    %v", btcutil.Amount(subsidySum))
+	log.Infof("Unclaimed or lost value: %v", btcutil.Amount(subsidySum-
+		utxoSupply-unspendable))
+	log.Infof("Mismatched balances:     %d", numMismatches)
+
+	if numMismatches != 0 {
+		return errors.New("the balance repository does not match the " +
+			"utxo set")
+	}
+	return nil
+}
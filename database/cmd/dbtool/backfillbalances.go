@@ -0,0 +1,294 @@
+// Copyright (c) 2015-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/data"
+)
+
+// backfillBalancesCmd defines the configuration options for the
+// backfillbalances command.
+type backfillBalancesCmd struct {
+	balanceRepoOptions
+	Workers   int   `long:"workers" description:"Number of workers that load blocks and compute their balance changes in parallel"`
+	BatchSize int   `long:"batchsize" description:"Number of blocks whose balance changes are aggregated and written to the balance repository at once"`
+	EndHeight int32 `long:"endheight" description:"Height of the last block to backfill (default: the best chain tip)"`
+}
+
+var (
+	// backfillBalancesCfg defines the configuration options for the
+	// command.
+	backfillBalancesCfg = backfillBalancesCmd{
+		balanceRepoOptions: defaultBalanceRepoOptions(),
+		Workers:            runtime.NumCPU(),
+		BatchSize:          2000,
+	}
+
+	// errBackfillInterrupted is returned when the backfill is interrupted
+	// by the user.
+	errBackfillInterrupted = errors.New("backfill interrupted")
+)
+
+// balanceBackfiller computes the balance changes of a range of main chain
+// blocks in parallel and connects them to the balance repository in batches.
+type balanceBackfiller struct {
+	chain     *blockchain.BlockChain
+	repo      data.IBalanceRepository
+	policy    *data.KeyPolicy
+	workers   int
+	batchSize int
+	quit      chan struct{}
+}
+
+// blockDeltas loads the main chain block at the passed height along with the
+// outputs it spends and returns the balance changes it causes.
+func (bf *balanceBackfiller) blockDeltas(height int32) (*data.BlockDeltas, error) {
+	block, err := bf.chain.BlockByHeight(height)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load block at height %d: %v",
+			height, err)
+	}
+	spent, err := bf.chain.FetchSpendJournal(block)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load spent outputs of block "+
+			"%v (height %d): %v", block.Hash(), height, err)
+	}
+	deltas, err := bf.policy.BlockDeltas(block, spent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.BlockDeltas{
+		Hash:   *block.Hash(),
+		Height: height,
+		Deltas: deltas,
+	}, nil
+}
+
+// loadBatch computes the balance changes of the blocks in the passed height
+// range using all workers and returns them in height order.
+func (bf *balanceBackfiller) loadBatch(start, end int32) ([]*data.BlockDeltas, error) {
+	blocks := make([]*data.BlockDeltas, end-start+1)
+	heights := make(chan int32)
+	errChan := make(chan error, bf.workers)
+
+	var wg sync.WaitGroup
+	wg.Add(bf.workers)
+	for i := 0; i < bf.workers; i++ {
+		go func() {
+			defer wg.Done()
+			for height := range heights {
+				deltas, err := bf.blockDeltas(height)
+				if err != nil {
+					errChan <- err
+					return
+				}
+				blocks[height-start] = deltas
+			}
+		}()
+	}
+
+	// Hand out the heights until they are exhausted, a worker fails or
+	// the backfill is interrupted.
+	var err error
+out:
+	for height := start; height <= end; height++ {
+		select {
+		case heights <- height:
+		case err = <-errChan:
+			break out
+		case <-bf.quit:
+			err = errBackfillInterrupted
+			break out
+		}
+	}
+	close(heights)
+	wg.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case err := <-errChan:
+		return nil, err
+	default:
+	}
+	return blocks, nil
+}
+
+// run connects the blocks from the start height through the end height to the
+// balance repository.  The balance changes of the next batch are computed while
+// the current batch is written so the workers and the repository are kept busy
+// at the same time.
+func (bf *balanceBackfiller) run(start, end int32) error {
+	type batchResult struct {
+		blocks []*data.BlockDeltas
+		err    error
+	}
+	batches := make(chan batchResult, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(batches)
+		for batchStart := start; batchStart <= end; {
+			batchEnd := batchStart + int32(bf.batchSize) - 1
+			if batchEnd > end || batchEnd < batchStart {
+				batchEnd = end
+			}
+			blocks, err := bf.loadBatch(batchStart, batchEnd)
+			select {
+			case batches <- batchResult{blocks, err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+			batchStart = batchEnd + 1
+		}
+	}()
+
+	startTime := time.Now()
+	var numBlocks, numChanges int64
+	for batch := range batches {
+		if batch.err != nil {
+			return batch.err
+		}
+
+		// The batch is written even when the backfill was interrupted
+		// in the meantime since it was computed completely.
+		if err := bf.repo.ConnectBlocks(batch.blocks); err != nil {
+			return err
+		}
+
+		numBlocks += int64(len(batch.blocks))
+		for _, block := range batch.blocks {
+			numChanges += int64(len(block.Deltas))
+		}
+		last := batch.blocks[len(batch.blocks)-1]
+		elapsed := time.Since(startTime) / time.Second * time.Second
+		log.Infof("Backfilled %d blocks (%d balance updates) in %s, "+
+			"height %d (%.1f%%)", numBlocks, numChanges, elapsed,
+			last.Height,
+			float64(last.Height-start+1)*100/float64(end-start+1))
+
+		select {
+		case <-bf.quit:
+			return errBackfillInterrupted
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Execute is the main entry point for the command.  It's invoked by the parser.
+func (cmd *backfillBalancesCmd) Execute(args []string) error {
+	// Setup the global config options and ensure they are valid.
+	if err := setupGlobalConfig(); err != nil {
+		return err
+	}
+	if cmd.Workers < 1 {
+		return errors.New("the number of workers must be at least 1")
+	}
+	if cmd.BatchSize < 1 {
+		return errors.New("the batch size must be at least 1")
+	}
+
+	// Load the block database and the chain state built from it.
+	db, err := loadBlockDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: activeNetParams,
+		TimeSource:  blockchain.NewMedianTime(),
+	})
+	if err != nil {
+		return err
+	}
+	best := chain.BestSnapshot()
+
+	balanceRepo, err := cmd.loadBalanceRepo(true)
+	if err != nil {
+		return err
+	}
+	defer balanceRepo.Close()
+
+	// Resume after the tip of the repository.  Orphaned blocks can only be
+	// disconnected with the help of the transaction index, so unwinding
+	// them is left to the explorer.
+	tipHash, tipHeight, err := balanceRepo.Tip()
+	if err != nil {
+		return err
+	}
+	if tipHash == nil {
+		tipHash, tipHeight = activeNetParams.GenesisHash, 0
+	}
+	if !chain.MainChainHasBlock(tipHash) {
+		return fmt.Errorf("balance repository tip %v (height %d) is not "+
+			"in the main chain -- start btcd with the explorer "+
+			"enabled to remove the orphaned blocks first", tipHash,
+			tipHeight)
+	}
+	endHeight := best.Height
+	if cmd.EndHeight != 0 {
+		if cmd.EndHeight < 0 || cmd.EndHeight > best.Height {
+			return fmt.Errorf("end height %d is outside of the main "+
+				"chain (best height %d)", cmd.EndHeight,
+				best.Height)
+		}
+		endHeight = cmd.EndHeight
+	}
+	if tipHeight >= endHeight {
+		log.Infof("Balance repository is already at height %d",
+			tipHeight)
+		return nil
+	}
+
+	// Stop after the batch that is being written on Ctrl+C so the
+	// repository is left at a consistent tip.
+	quit := make(chan struct{})
+	addInterruptHandler(func() {
+		log.Infof("Finishing the current batch...")
+		close(quit)
+	})
+
+	log.Infof("Backfilling balance repository from height %d to %d with "+
+		"%d workers", tipHeight+1, endHeight, cmd.Workers)
+	backfiller := &balanceBackfiller{
+		chain:     chain,
+		repo:      balanceRepo,
+		policy:    cmd.keyPolicy(),
+		workers:   cmd.Workers,
+		batchSize: cmd.BatchSize,
+		quit:      quit,
+	}
+	err = backfiller.run(tipHeight+1, endHeight)
+	if err == errBackfillInterrupted {
+		tipHash, tipHeight, err = balanceRepo.Tip()
+		if err != nil {
+			return err
+		}
+		log.Infof("Backfill interrupted at block %v (height %d)",
+			tipHash, tipHeight)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Balance repository backfilled to height %d", endHeight)
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2015-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/btcsuite/btcd/data"
+)
+
+// balanceRepoOptions defines the configuration options shared by the commands
+// that operate on the balance repository of the explorer.
+type balanceRepoOptions struct {
+	Backend      string `long:"balancebackend" description:"Backend of the balance repository {leveldb, dynamo, azuretable, postgres, sqlite}"`
+	DbPath       string `long:"balancedbpath" description:"Directory of the leveldb balance repository (default: balances in the data directory)"`
+	Endpoint     string `long:"balanceendpoint" description:"Service endpoint of the dynamo or azuretable balance repository -- emulator selects the local Azure storage emulator"`
+	Region       string `long:"balanceregion" description:"AWS region of the dynamo balance repository"`
+	Account      string `long:"balanceaccount" description:"Access key id (dynamo) or storage account name (azuretable) for the balance repository"`
+	Key          string `long:"balancekey" default-mask:"-" description:"Secret access key (dynamo) or storage account key (azuretable) for the balance repository"`
+	DSN          string `long:"balancedsn" default-mask:"-" description:"Connection string (postgres) or database file (sqlite) of the balance repository (default sqlite: balances.sqlite in the data directory)"`
+	Table        string `long:"balancetable" description:"Table name of the dynamo, azuretable, postgres or sqlite balance repository"`
+	SeparateP2PK bool   `long:"balanceseparatep2pk" description:"The balance repository records pay-to-pubkey outputs under the public key"`
+}
+
+// defaultBalanceRepoOptions returns the default balance repository options.
+func defaultBalanceRepoOptions() balanceRepoOptions {
+	return balanceRepoOptions{
+		Backend: "leveldb",
+		Region:  "us-east-2",
+		Table:   "balance",
+	}
+}
+
+// keyPolicy returns the policy the balance repository records balances under.
+func (opts *balanceRepoOptions) keyPolicy() *data.KeyPolicy {
+	return &data.KeyPolicy{
+		ChainParams:  activeNetParams,
+		SeparateP2PK: opts.SeparateP2PK,
+	}
+}
+
+// loadBalanceRepo opens the balance repository selected by the options.  The
+// leveldb and sqlite repositories are only created when create is set.
+func (opts *balanceRepoOptions) loadBalanceRepo(create bool) (data.IBalanceRepository, error) {
+	switch opts.Backend {
+	case "leveldb":
+		dbPath := opts.DbPath
+		if dbPath == "" {
+			dbPath = filepath.Join(cfg.DataDir, "balances")
+		}
+		if !create && !fileExists(dbPath) {
+			return nil, fmt.Errorf("balance repository '%s' does "+
+				"not exist", dbPath)
+		}
+		log.Infof("Loading balance repository from '%s'", dbPath)
+		return data.NewLevelDbBalanceRepository(dbPath)
+
+	case "dynamo":
+		return data.NewDynamoBalanceRepository(opts.Account, opts.Key,
+			opts.Region, opts.Endpoint, opts.Table)
+
+	case "azuretable":
+		tableRepo, err := data.NewAzureStorageTableRepository(
+			opts.Account, opts.Key, opts.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return data.NewAzureBalanceRepository(tableRepo, opts.Table)
+
+	case "postgres":
+		return data.NewSqlBalanceRepository(opts.Backend, opts.DSN,
+			opts.Table)
+
+	case "sqlite":
+		dsn := opts.DSN
+		if dsn == "" {
+			dsn = filepath.Join(cfg.DataDir, "balances.sqlite")
+		}
+		if !create && !fileExists(dsn) {
+			return nil, fmt.Errorf("balance repository '%s' does "+
+				"not exist", dsn)
+		}
+		log.Infof("Loading balance repository from '%s'", dsn)
+		return data.NewSqlBalanceRepository(opts.Backend, dsn,
+			opts.Table)
+	}
+
+	return nil, fmt.Errorf("unknown balance backend '%s'", opts.Backend)
+}
@@ -89,6 +89,20 @@ func realMain() error {
 	parser.AddCommand("fetchblockregion",
 		"Fetch the specified block region from the database", "",
 		&blockRegionCfg)
+	parser.AddCommand("auditbalances",
+		"Cross-check the balance repository against the utxo set",
+		"Aggregate the utxo set by the same keys the balance explorer "+
+			"records balances under, diff the result against "+
+			"every balance in the balance repository and report "+
+			"the total supply.  The node must not be running.",
+		&auditBalancesCfg)
+	parser.AddCommand("backfillbalances",
+		"Backfill the balance repository from the stored blocks",
+		"Compute the balance changes of the main chain blocks after "+
+			"the tip of the balance repository in parallel workers, "+
+			"resolving their inputs via the spend journal, and "+
+			"write them to the repository in large batches.  The "+
+			"node must not be running.", &backfillBalancesCfg)
 
 	// Parse command line and invoke the Execute function for the specified
 	// command.
@@ -15,6 +15,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
@@ -307,6 +310,29 @@ func (s *blockStore) deleteFile(fileNum uint32) error {
 	return nil
 }
 
+// pruneFile closes the block file for the passed flat file number if it is open
+// and removes it.  It must not be the current write file.
+func (s *blockStore) pruneFile(fileNum uint32) error {
+	s.obfMutex.Lock()
+	if blockFile, ok := s.openBlockFiles[fileNum]; ok {
+		s.lruMutex.Lock()
+		s.openBlocksLRU.Remove(s.fileNumToLRUElem[fileNum])
+		delete(s.fileNumToLRUElem, fileNum)
+		s.lruMutex.Unlock()
+
+		// Close the file under the write lock for the file in case any
+		// readers are currently reading from it so it's not closed out
+		// from under them.
+		blockFile.Lock()
+		_ = blockFile.file.Close()
+		blockFile.Unlock()
+		delete(s.openBlockFiles, fileNum)
+	}
+	s.obfMutex.Unlock()
+
+	return s.deleteFileFunc(fileNum)
+}
+
 // blockFile attempts to return an existing file handle for the passed flat file
 // number if it is already open as well as marking it as most recently used.  It
 // will also open the file when it's not already open subject to the rules
@@ -712,23 +738,48 @@ func (s *blockStore) handleRollback(oldBlockFileNum, oldBlockOffset uint32) {
 	}
 }
 
+// blockFileNums returns the numbers of all of the flat block files in the
+// database directory in ascending order.
+func blockFileNums(dbPath string) ([]uint32, error) {
+	filePaths, err := filepath.Glob(filepath.Join(dbPath, "*.fdb"))
+	if err != nil {
+		return nil, err
+	}
+
+	fileNums := make([]uint32, 0, len(filePaths))
+	for _, filePath := range filePaths {
+		fileName := strings.TrimSuffix(filepath.Base(filePath), ".fdb")
+		fileNum, err := strconv.ParseUint(fileName, 10, 32)
+		if err != nil {
+			continue
+		}
+		fileNums = append(fileNums, uint32(fileNum))
+	}
+	sort.Slice(fileNums, func(i, j int) bool {
+		return fileNums[i] < fileNums[j]
+	})
+	return fileNums, nil
+}
+
 // scanBlockFiles searches the database directory for all flat block files to
 // find the end of the most recent file.  This position is considered the
 // current write cursor which is also stored in the metadata.  Thus, it is used
 // to detect unexpected shutdowns in the middle of writes so the block files
 // can be reconciled.
+//
+// NOTE: The oldest files no longer exist when blocks have been pruned, so the
+// files are not necessarily numbered from zero.
 func scanBlockFiles(dbPath string) (int, uint32) {
 	lastFile := -1
 	fileLen := uint32(0)
-	for i := 0; ; i++ {
-		filePath := blockFilePath(dbPath, uint32(i))
-		st, err := os.Stat(filePath)
-		if err != nil {
-			break
+	fileNums, err := blockFileNums(dbPath)
+	if err == nil && len(fileNums) > 0 {
+		fileNum := fileNums[len(fileNums)-1]
+		st, err := os.Stat(blockFilePath(dbPath, fileNum))
+		if err == nil {
+			lastFile = int(fileNum)
+			fileLen = uint32(st.Size())
 		}
-		lastFile = i
-
-		fileLen = uint32(st.Size())
 	}
 
 	log.Tracef("Scan found latest block file #%d with length %d", lastFile,
@@ -966,6 +966,9 @@ type transaction struct {
 	pendingBlocks    map[chainhash.Hash]int
 	pendingBlockData []pendingBlock
 
+	// Block files that need to be deleted on commit.
+	pendingPrunes []uint32
+
 	// Keys that need to be stored or deleted on commit.
 	pendingKeys   *treap.Mutable
 	pendingRemove *treap.Mutable
@@ -1627,6 +1630,152 @@ func (tx *transaction) FetchBlockRegions(regions []database.BlockRegion) ([][]by
 	return blockRegions, nil
 }
 
+// blockFileSizes returns the numbers of all of the flat block files in
+// ascending order along with their sizes.
+func (tx *transaction) blockFileSizes() ([]uint32, []uint64, error) {
+	fileNums, err := blockFileNums(tx.db.store.basePath)
+	if err != nil {
+		str := "failed to list block files"
+		return nil, nil, makeDbErr(database.ErrDriverSpecific, str, err)
+	}
+
+	sizes := make([]uint64, len(fileNums))
+	for i, fileNum := range fileNums {
+		filePath := blockFilePath(tx.db.store.basePath, fileNum)
+		st, err := os.Stat(filePath)
+		if err != nil {
+			str := fmt.Sprintf("failed to stat file %q: %v",
+				filePath, err)
+			return nil, nil, makeDbErr(database.ErrDriverSpecific,
+				str, err)
+		}
+		sizes[i] = uint64(st.Size())
+	}
+
+	return fileNums, sizes, nil
+}
+
+// BlockFilesSize returns the total number of bytes used by the flat files that
+// house the blocks.
+//
+// Returns the following errors as required by the interface contract:
+//   - ErrTxClosed if the transaction has already been closed
+//
+// This function is part of the database.Tx interface implementation.
+func (tx *transaction) BlockFilesSize() (uint64, error) {
+	// Ensure transaction state is valid.
+	if err := tx.checkClosed(); err != nil {
+		return 0, err
+	}
+
+	_, sizes, err := tx.blockFileSizes()
+	if err != nil {
+		return 0, err
+	}
+
+	var totalSize uint64
+	for _, size := range sizes {
+		totalSize += size
+	}
+	return totalSize, nil
+}
+
+// PruneBlocks deletes the oldest flat block files until the total number of
+// bytes used by them is no more than the provided target size and returns the
+// hashes of the blocks they contained.  The current write file and the files
+// starting with the oldest one that contains any of the provided blocks to keep
+// are never deleted.
+//
+// The files are deleted once the transaction is committed.
+//
+// Returns the following errors as required by the interface contract:
+//   - ErrTxNotWritable if attempted against a read-only transaction
+//   - ErrTxClosed if the transaction has already been closed
+//
+// This function is part of the database.Tx interface implementation.
+func (tx *transaction) PruneBlocks(targetSize uint64, keep []chainhash.Hash) ([]chainhash.Hash, error) {
+	// Ensure transaction state is valid.
+	if err := tx.checkClosed(); err != nil {
+		return nil, err
+	}
+
+	// Ensure the transaction is writable.
+	if !tx.writable {
+		str := "prune blocks requires a writable database transaction"
+		return nil, makeDbErr(database.ErrTxNotWritable, str, nil)
+	}
+
+	// Nothing to do when the files are within the target size.
+	fileNums, sizes, err := tx.blockFileSizes()
+	if err != nil {
+		return nil, err
+	}
+	var totalSize uint64
+	for _, size := range sizes {
+		totalSize += size
+	}
+	if totalSize <= targetSize {
+		return nil, nil
+	}
+
+	// Determine the first file that must not be deleted.  Blocks that are
+	// pending to be written on commit go to the current write file.
+	wc := tx.db.store.writeCursor
+	wc.RLock()
+	keepFileNum := wc.curFileNum
+	wc.RUnlock()
+	for i := range keep {
+		blockRow := tx.blockIdxBucket.Get(keep[i][:])
+		if blockRow == nil {
+			continue
+		}
+		location := deserializeBlockLoc(blockRow)
+		if location.blockFileNum < keepFileNum {
+			keepFileNum = location.blockFileNum
+		}
+	}
+
+	// Choose the oldest files to delete until the remaining ones are
+	// within the target size.
+	pruneFiles := make(map[uint32]struct{})
+	for i, fileNum := range fileNums {
+		if totalSize <= targetSize || fileNum >= keepFileNum {
+			break
+		}
+		pruneFiles[fileNum] = struct{}{}
+		totalSize -= sizes[i]
+	}
+	if len(pruneFiles) == 0 {
+		return nil, nil
+	}
+
+	// Remove the blocks in the files from the block index.
+	var pruned []chainhash.Hash
+	cursor := tx.blockIdxBucket.Cursor()
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		location := deserializeBlockLoc(cursor.Value())
+		if _, ok := pruneFiles[location.blockFileNum]; !ok {
+			continue
+		}
+
+		var hash chainhash.Hash
+		copy(hash[:], cursor.Key())
+		pruned = append(pruned, hash)
+	}
+	for i := range pruned {
+		if err := tx.blockIdxBucket.Delete(pruned[i][:]); err != nil {
+			return nil, err
+		}
+	}
+
+	for fileNum := range pruneFiles {
+		tx.pendingPrunes = append(tx.pendingPrunes, fileNum)
+	}
+	log.Debugf("Pruning %d blocks in %d block files", len(pruned),
+		len(pruneFiles))
+	return pruned, nil
+}
+
 // close marks the transaction closed then releases any pending data, the
 // underlying snapshot, the transaction read lock, and the write lock when the
 // transaction is writable.
@@ -1636,6 +1785,7 @@ func (tx *transaction) close() {
 	// Clear pending blocks that would have been written on commit.
 	tx.pendingBlocks = nil
 	tx.pendingBlockData = nil
+	tx.pendingPrunes = nil
 
 	// Clear pending keys that would have been written or deleted on commit.
 	tx.pendingKeys = nil
@@ -1758,7 +1908,25 @@ func (tx *transaction) Commit() error {
 	}
 
 	// Write pending data.  The function will rollback if any errors occur.
-	return tx.writePendingAndCommit()
+	if err := tx.writePendingAndCommit(); err != nil {
+		return err
+	}
+
+	// Delete the pruned block files.  The database cache is flushed first
+	// so the block index never refers to deleted files after an unexpected
+	// shutdown.
+	if len(tx.pendingPrunes) == 0 {
+		return nil
+	}
+	if err := tx.db.cache.flush(); err != nil {
+		return err
+	}
+	for _, fileNum := range tx.pendingPrunes {
+		if err := tx.db.store.pruneFile(fileNum); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Rollback undoes all changes that have been made to the root bucket and all of
@@ -3,10 +3,11 @@
 // license that can be found in the LICENSE file.
 
 /*
-This test file is part of the ffldb package rather than than the ffldb_test
-package so it can bridge access to the internals to properly test cases which
-are either not possible or can't reliably be tested via the public interface.
-The functions are only exported while the tests are being run.
+This file is part of the ffldb package rather than a test file so it can bridge
+access to the internals to properly test cases which are either not possible or
+can't reliably be tested via the public interface, both in this package and in
+packages that build on the database, such as the block pruning in blockchain.
+The functions should only be used by tests.
 */
 
 package ffldb
@@ -18,6 +18,7 @@ import (
 	"testing"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/database"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
@@ -704,3 +705,147 @@ func TestFailureScenarios(t *testing.T) {
 	// Test various corruption scenarios.
 	testCorruption(tc)
 }
+
+// TestPruneBlocks ensures pruning blocks deletes the oldest block files down to
+// the target size, never deletes the files that contain the blocks to keep and
+// leaves a database that can be reopened.
+func TestPruneBlocks(t *testing.T) {
+	// Create a new database to run tests against.
+	dbPath := filepath.Join(os.TempDir(), "ffldb-pruneblocks")
+	_ = os.RemoveAll(dbPath)
+	idb, err := database.Create(dbType, dbPath, blockDataNet)
+	if err != nil {
+		t.Fatalf("Failed to create test database (%s) %v", dbType, err)
+	}
+	defer os.RemoveAll(dbPath)
+	defer func() {
+		idb.Close()
+	}()
+
+	// Change the maximum file size to a small value to force multiple flat
+	// files with the test data set.
+	idb.(*db).store.maxBlockFileSize = 2048
+
+	blocks, err := loadBlocks(t, blockDataFile, blockDataNet)
+	if err != nil {
+		t.Fatalf("loadBlocks: Unexpected error: %v", err)
+	}
+	for _, block := range blocks {
+		err := idb.Update(func(tx database.Tx) error {
+			return tx.StoreBlock(block)
+		})
+		if err != nil {
+			t.Fatalf("StoreBlock: unexpected error: %v", err)
+		}
+	}
+
+	// Ensure pruning requires a writable transaction.
+	err = idb.View(func(tx database.Tx) error {
+		_, err := tx.PruneBlocks(0, nil)
+		return err
+	})
+	if !checkDbError(t, "PruneBlocks", err, database.ErrTxNotWritable) {
+		return
+	}
+
+	// Ensure nothing is pruned when the oldest file contains a block that
+	// must be kept.
+	var pruned []chainhash.Hash
+	err = idb.Update(func(tx database.Tx) error {
+		var err error
+		pruned, err = tx.PruneBlocks(0, []chainhash.Hash{*blocks[0].Hash()})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("PruneBlocks: unexpected error: %v", err)
+	}
+	if len(pruned) != 0 {
+		t.Fatalf("PruneBlocks: unexpected pruned blocks: %v", pruned)
+	}
+
+	// Prune down to a target that requires deleting most of the files
+	// while keeping the most recent blocks.
+	const targetSize = 10000
+	keepBlocks := blocks[len(blocks)-20:]
+	keep := make([]chainhash.Hash, 0, len(keepBlocks))
+	for _, block := range keepBlocks {
+		keep = append(keep, *block.Hash())
+	}
+	err = idb.Update(func(tx database.Tx) error {
+		var err error
+		pruned, err = tx.PruneBlocks(targetSize, keep)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("PruneBlocks: unexpected error: %v", err)
+	}
+	if !containsHash(pruned, blocks[0].Hash()) {
+		t.Fatalf("PruneBlocks: oldest block was not pruned")
+	}
+
+	// checkBlocks ensures the pruned blocks no longer exist while the
+	// others are still available.
+	checkBlocks := func(desc string) {
+		t.Helper()
+
+		err := idb.View(func(tx database.Tx) error {
+			for _, block := range blocks {
+				hash := block.Hash()
+				exists, err := tx.HasBlock(hash)
+				if err != nil {
+					return err
+				}
+				if exists == containsHash(pruned, hash) {
+					return fmt.Errorf("unexpected existence of "+
+						"block %v - got %v", hash, exists)
+				}
+				if !exists {
+					continue
+				}
+				if _, err := tx.FetchBlock(hash); err != nil {
+					return err
+				}
+			}
+			for _, block := range keepBlocks {
+				if containsHash(pruned, block.Hash()) {
+					return fmt.Errorf("block %v to keep was "+
+						"pruned", block.Hash())
+				}
+			}
+
+			size, err := tx.BlockFilesSize()
+			if err != nil {
+				return err
+			}
+			if size > targetSize {
+				return fmt.Errorf("unexpected block files size "+
+					"- got %d, want at most %d", size,
+					targetSize)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", desc, err)
+		}
+	}
+	checkBlocks("after pruning")
+
+	// Ensure the database is still usable after reopening it.
+	idb.Close()
+	idb, err = database.Open(dbType, dbPath, blockDataNet)
+	if err != nil {
+		t.Fatalf("Failed to reopen test database: %v", err)
+	}
+	checkBlocks("after reopening")
+}
+
+// containsHash returns whether or not the passed hashes contain the passed
+// hash.
+func containsHash(hashes []chainhash.Hash, hash *chainhash.Hash) bool {
+	for i := range hashes {
+		if hashes[i] == *hash {
+			return true
+		}
+	}
+	return false
+}
@@ -389,6 +389,35 @@ type Tx interface {
 	// implementations.
 	FetchBlockRegions(regions []BlockRegion) ([][]byte, error)
 
+	// BlockFilesSize returns the total number of bytes used to store the
+	// blocks.
+	//
+	// The interface contract guarantees at least the following errors will
+	// be returned (other implementation-specific errors are possible):
+	//   - ErrTxClosed if the transaction has already been closed
+	//
+	// Other errors are possible depending on the implementation.
+	BlockFilesSize() (uint64, error)
+
+	// PruneBlocks deletes the oldest blocks until the total number of bytes
+	// used to store the blocks is no more than the provided target size
+	// and returns the hashes of the deleted blocks.  The blocks with the
+	// provided hashes to keep, and any blocks stored after them, are never
+	// deleted.  Since the blocks are deleted in batches that depend on the
+	// implementation, the target size is not a hard limit.
+	//
+	// The blocks are only removed from the storage once the transaction is
+	// committed, however they no longer exist from the viewpoint of the
+	// transaction.
+	//
+	// The interface contract guarantees at least the following errors will
+	// be returned (other implementation-specific errors are possible):
+	//   - ErrTxNotWritable if attempted against a read-only transaction
+	//   - ErrTxClosed if the transaction has already been closed
+	//
+	// Other errors are possible depending on the implementation.
+	PruneBlocks(targetSize uint64, keep []chainhash.Hash) ([]chainhash.Hash, error)
+
 	// ******************************************************************
 	// Methods related to both atomic metadata storage and block storage.
 	// ******************************************************************
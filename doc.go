@@ -114,6 +114,10 @@ Application Options:
       --nopeerbloomfilters  Disable bloom filtering support.
       --sigcachemaxsize=    The maximum number of entries in the signature
                             verification cache.
+      --utxocachemaxsize=   The maximum size in MiB of the utxo cache (250)
+      --prune=              Delete the oldest blocks once the stored blocks
+                            exceed the specified size in MiB -- Must be at
+                            least 550, 0 disables pruning
       --blocksonly          Do not accept transactions from remote peers.
       --relaynonstd         Relay non-standard transactions regardless of the
                             default settings for the active network.
@@ -0,0 +1,366 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/blockchain/indexers"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/data"
+	"github.com/btcsuite/btcd/database"
+	_ "github.com/btcsuite/btcd/database/ffldb"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// opTrueRedeemScript returns a redeem script that can be satisfied without a
+// signature.  The passed number is pushed and dropped first, so every number
+// results in a different pay-to-script-hash address that can be spent by
+// simply pushing the redeem script.
+func opTrueRedeemScript(n int64) []byte {
+	script, err := txscript.NewScriptBuilder().AddInt64(n).
+		AddOp(txscript.OP_DROP).AddOp(txscript.OP_TRUE).Script()
+	if err != nil {
+		panic(err)
+	}
+	return script
+}
+
+// testAddress is an address the explorer tests pay to along with the key its
+// balance is recorded under.  Spending its outputs requires the signature
+// script, which is nil for addresses that are only ever paid to.
+type testAddress struct {
+	key             string
+	pkScript        []byte
+	signatureScript []byte
+}
+
+// newOpTrueAddress returns the spendable pay-to-script-hash address of the
+// redeem script returned by opTrueRedeemScript for the passed number.
+func newOpTrueAddress(n int64, params *chaincfg.Params) testAddress {
+	redeemScript := opTrueRedeemScript(n)
+	addr, err := btcutil.NewAddressScriptHash(redeemScript, params)
+	if err != nil {
+		panic(err)
+	}
+	sigScript, err := txscript.NewScriptBuilder().AddData(redeemScript).
+		Script()
+	if err != nil {
+		panic(err)
+	}
+	return newTestAddress(addr, sigScript)
+}
+
+// newTestAddress returns the test address for the passed address, which is
+// spent with the passed signature script.
+func newTestAddress(addr btcutil.Address, sigScript []byte) testAddress {
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		panic(err)
+	}
+	return testAddress{
+		key:             addr.EncodeAddress(),
+		pkScript:        pkScript,
+		signatureScript: sigScript,
+	}
+}
+
+// testOutput is an unspent output created by the explorer test generator.
+type testOutput struct {
+	outPoint wire.OutPoint
+	amount   int64
+	addr     testAddress
+}
+
+// explorerTestGenerator builds chains of valid blocks for the explorer tests in
+// the same spirit as the generator of the fullblocktests package.  The blocks
+// are built on top of the regression test network genesis block with a
+// coinbase maturity of one, so coinbase outputs can be spent right away.
+type explorerTestGenerator struct {
+	params       *chaincfg.Params
+	tip          *wire.MsgBlock
+	tipHeight    int32
+	blocksByName map[string]*wire.MsgBlock
+	blockHeights map[string]int32
+}
+
+// newExplorerTestGenerator returns a generator with the genesis block as the
+// tip.
+func newExplorerTestGenerator() *explorerTestGenerator {
+	params := chaincfg.RegressionNetParams
+	params.CoinbaseMaturity = 1
+	genesis := params.GenesisBlock
+	return &explorerTestGenerator{
+		params:       &params,
+		tip:          genesis,
+		blocksByName: map[string]*wire.MsgBlock{"genesis": genesis},
+		blockHeights: map[string]int32{"genesis": 0},
+	}
+}
+
+// setTip makes the block with the passed name the block the next one extends.
+func (g *explorerTestGenerator) setTip(name string) {
+	g.tip = g.blocksByName[name]
+	g.tipHeight = g.blockHeights[name]
+}
+
+// coinbaseOut returns the output of the coinbase of the block with the passed
+// name.
+func (g *explorerTestGenerator) coinbaseOut(name string, addr testAddress) testOutput {
+	coinbase := g.blocksByName[name].Transactions[0]
+	return testOutput{
+		outPoint: wire.OutPoint{Hash: coinbase.TxHash()},
+		amount:   coinbase.TxOut[0].Value,
+		addr:     addr,
+	}
+}
+
+// spendTx returns a transaction that spends the passed outputs to the passed
+// payees without paying a fee, along with the outputs it creates.  The payees
+// are paid in order and any remaining value is paid to the change address.
+func spendTx(inputs []testOutput, change testAddress, payees ...testOutput) (*wire.MsgTx, []testOutput) {
+	tx := wire.NewMsgTx(1)
+	var remaining int64
+	for _, input := range inputs {
+		tx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: input.outPoint,
+			SignatureScript:  input.addr.signatureScript,
+			Sequence:         wire.MaxTxInSequenceNum,
+		})
+		remaining += input.amount
+	}
+	for _, payee := range payees {
+		tx.AddTxOut(wire.NewTxOut(payee.amount, payee.addr.pkScript))
+		remaining -= payee.amount
+	}
+	if remaining != 0 {
+		tx.AddTxOut(wire.NewTxOut(remaining, change.pkScript))
+	}
+
+	txHash := tx.TxHash()
+	outputs := make([]testOutput, len(tx.TxOut))
+	for i, txOut := range tx.TxOut {
+		outputs[i] = testOutput{
+			outPoint: wire.OutPoint{Hash: txHash, Index: uint32(i)},
+			amount:   txOut.Value,
+		}
+		if i < len(payees) {
+			outputs[i].addr = payees[i].addr
+		} else {
+			outputs[i].addr = change
+		}
+	}
+	return tx, outputs
+}
+
+// nextBlock builds and solves a block named name that extends the current tip,
+// pays the subsidy to the passed address and includes the passed transactions,
+// which must not pay any fees.  The block becomes the new tip.
+func (g *explorerTestGenerator) nextBlock(name string, coinbaseAddr testAddress, txns ...*wire.MsgTx) *btcutil.Block {
+	height := g.tipHeight + 1
+	coinbaseScript, err := txscript.NewScriptBuilder().
+		AddInt64(int64(height)).AddInt64(0).Script()
+	if err != nil {
+		panic(err)
+	}
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: coinbaseScript,
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(wire.NewTxOut(blockchain.CalcBlockSubsidy(height,
+		g.params), coinbaseAddr.pkScript))
+
+	msgBlock := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:   1,
+			PrevBlock: g.tip.BlockHash(),
+			Timestamp: g.tip.Header.Timestamp.Add(time.Second),
+			Bits:      g.params.PowLimitBits,
+		},
+		Transactions: append([]*wire.MsgTx{coinbase}, txns...),
+	}
+	block := btcutil.NewBlock(msgBlock)
+	merkles := blockchain.BuildMerkleTreeStore(block.Transactions(), false)
+	msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+
+	// The proof of work limit of the regression test network is so high
+	// that only a couple of nonces have to be tried.
+	target := blockchain.CompactToBig(msgBlock.Header.Bits)
+	for {
+		hash := msgBlock.Header.BlockHash()
+		if blockchain.HashToBig(&hash).Cmp(target) <= 0 {
+			break
+		}
+		msgBlock.Header.Nonce++
+	}
+
+	g.blocksByName[name] = msgBlock
+	g.blockHeights[name] = height
+	g.tip = msgBlock
+	g.tipHeight = height
+	return btcutil.NewBlock(msgBlock)
+}
+
+// mockPeerNotifier is a PeerNotifier that records the balance change
+// notifications of the explorer and ignores everything else.
+type mockPeerNotifier struct {
+	connected    []chainhash.Hash
+	disconnected []chainhash.Hash
+}
+
+// Ensure mockPeerNotifier implements the PeerNotifier interface.
+var _ PeerNotifier = (*mockPeerNotifier)(nil)
+
+func (n *mockPeerNotifier) AnnounceNewTransactions(newTxs []*mempool.TxDesc) {}
+
+func (n *mockPeerNotifier) UpdatePeerHeights(latestBlkHash *chainhash.Hash, latestHeight int32, updateSource *peer.Peer) {
+}
+
+func (n *mockPeerNotifier) RelayInventory(invVect *wire.InvVect, data interface{}) {}
+
+func (n *mockPeerNotifier) TransactionConfirmed(tx *btcutil.Tx) {}
+
+func (n *mockPeerNotifier) BalancesChanged(blockHash *chainhash.Hash, height int32, deltas map[string][]data.BalanceDelta, connected bool) {
+	if connected {
+		n.connected = append(n.connected, *blockHash)
+	} else {
+		n.disconnected = append(n.disconnected, *blockHash)
+	}
+}
+
+// newTestIndexManager returns an index manager that maintains the passed
+// address balance index along with the transaction index it requires.
+func newTestIndexManager(db database.DB, balanceIndex *indexers.BalanceIndex) *indexers.Manager {
+	return indexers.NewManager(db, []indexers.Indexer{
+		indexers.NewTxIndex(db), balanceIndex,
+	})
+}
+
+// explorerHarness is a sync manager that only runs the balance explorer on top
+// of a real chain instance, which is fed the blocks of a test generator.
+type explorerHarness struct {
+	sm       *SyncManager
+	notifier *mockPeerNotifier
+	teardown func()
+}
+
+// newExplorerHarness returns a harness that records the balances of the chain
+// built with the passed parameters to the passed repository.
+func newExplorerHarness(params *chaincfg.Params, balanceRepo data.IBalanceRepository) (*explorerHarness, error) {
+	dbPath, err := ioutil.TempDir("", "explorer")
+	if err != nil {
+		return nil, err
+	}
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "blocks"),
+		params.Net)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		return nil, err
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+
+	h := &explorerHarness{
+		notifier: new(mockPeerNotifier),
+		teardown: teardown,
+	}
+	balanceIndex := indexers.NewBalanceIndex(db,
+		&data.KeyPolicy{ChainParams: params})
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:           db,
+		ChainParams:  params,
+		TimeSource:   blockchain.NewMedianTime(),
+		IndexManager: newTestIndexManager(db, balanceIndex),
+	})
+	if err != nil {
+		teardown()
+		return nil, err
+	}
+	chain.Subscribe(func(notification *blockchain.Notification) {
+		block, ok := notification.Data.(*btcutil.Block)
+		if !ok {
+			return
+		}
+		switch notification.Type {
+		case blockchain.NTBlockConnected:
+			h.sm.exploreConnectedBlock(block)
+		case blockchain.NTBlockDisconnected:
+			h.sm.exploreDisconnectedBlock(block)
+		}
+	})
+
+	h.sm = &SyncManager{
+		peerNotifier: h.notifier,
+		chain:        chain,
+		chainParams:  params,
+		quit:         make(chan struct{}),
+		balanceRepo:  balanceRepo,
+		balanceIndex: balanceIndex,
+	}
+	return h, nil
+}
+
+// processBlock processes the passed block and returns an error if it isn't
+// accepted.
+func (h *explorerHarness) processBlock(block *btcutil.Block) error {
+	_, isOrphan, err := h.sm.chain.ProcessBlock(block,
+		blockchain.BFNone)
+	if err != nil {
+		return fmt.Errorf("block %v rejected: %v", block.Hash(), err)
+	}
+	if isOrphan {
+		return fmt.Errorf("block %v is an orphan", block.Hash())
+	}
+	return nil
+}
+
+// mockBalanceRepo is an in-memory balance repository whose block updates can be
+// made to fail.
+type mockBalanceRepo struct {
+	*data.MemoryBalanceRepository
+
+	// updateErr is returned by every block update while it is set.
+	updateErr error
+}
+
+// Ensure mockBalanceRepo implements the IBalanceRepository interface.
+var _ data.IBalanceRepository = (*mockBalanceRepo)(nil)
+
+func (r *mockBalanceRepo) ConnectBlock(hash *chainhash.Hash, height int32, deltas map[string][]data.BalanceDelta) error {
+	if r.updateErr != nil {
+		return r.updateErr
+	}
+	return r.MemoryBalanceRepository.ConnectBlock(hash, height, deltas)
+}
+
+func (r *mockBalanceRepo) ConnectBlocks(blocks []*data.BlockDeltas) error {
+	if r.updateErr != nil {
+		return r.updateErr
+	}
+	return r.MemoryBalanceRepository.ConnectBlocks(blocks)
+}
+
+func (r *mockBalanceRepo) DisconnectBlock(prevHash *chainhash.Hash, height int32, deltas map[string][]data.BalanceDelta) error {
+	if r.updateErr != nil {
+		return r.updateErr
+	}
+	return r.MemoryBalanceRepository.DisconnectBlock(prevHash, height,
+		deltas)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"fmt"
+)
+
+// ExplorerErrorCode identifies a kind of error encountered by the balance
+// explorer.
+type ExplorerErrorCode int
+
+// These constants are used to identify a specific ExplorerError.
+const (
+	// ErrLoadBlock indicates a block that has to be applied to or unwound
+	// from the balance repository could not be loaded.
+	ErrLoadBlock ExplorerErrorCode = iota
+
+	// ErrLoadBalanceChanges indicates the balance changes caused by a block
+	// could not be loaded from the address balance index.
+	ErrLoadBalanceChanges
+
+	// ErrBalanceRepo indicates the balance repository failed to read or
+	// write balances.
+	ErrBalanceRepo
+)
+
+// Map of ExplorerErrorCode values back to their constant names for pretty
+// printing.
+var explorerErrorCodeStrings = map[ExplorerErrorCode]string{
+	ErrLoadBlock:          "ErrLoadBlock",
+	ErrLoadBalanceChanges: "ErrLoadBalanceChanges",
+	ErrBalanceRepo:        "ErrBalanceRepo",
+}
+
+// String returns the ExplorerErrorCode as a human-readable name.
+func (e ExplorerErrorCode) String() string {
+	if s := explorerErrorCodeStrings[e]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown ExplorerErrorCode (%d)", int(e))
+}
+
+// ExplorerError identifies an error encountered by the balance explorer while
+// applying a block to or unwinding a block from the balance repository.  The
+// caller can use type assertions to determine if an error is an ExplorerError
+// and access the ErrorCode field to ascertain the specific reason for the
+// failure.  The Err field holds the underlying error, if any.
+type ExplorerError struct {
+	ErrorCode   ExplorerErrorCode // Describes the kind of error
+	Description string            // Human readable description of the issue
+	Err         error             // Underlying error
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e ExplorerError) Error() string {
+	if e.Err != nil {
+		return e.Description + ": " + e.Err.Error()
+	}
+	return e.Description
+}
+
+// explorerError creates an ExplorerError given a set of arguments.
+func explorerError(c ExplorerErrorCode, desc string, err error) ExplorerError {
+	return ExplorerError{ErrorCode: c, Description: desc, Err: err}
+}
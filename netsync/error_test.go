@@ -0,0 +1,68 @@
+// Copyright (c) 2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestExplorerErrorCodeStringer tests the stringized output for the
+// ExplorerErrorCode type.
+func TestExplorerErrorCodeStringer(t *testing.T) {
+	tests := []struct {
+		in   ExplorerErrorCode
+		want string
+	}{
+		{ErrLoadBlock, "ErrLoadBlock"},
+		{ErrLoadBalanceChanges, "ErrLoadBalanceChanges"},
+		{ErrBalanceRepo, "ErrBalanceRepo"},
+		{0xffff, "Unknown ExplorerErrorCode (65535)"},
+	}
+
+	// Detect additional error codes that don't have the stringer added.
+	if len(tests)-1 != len(explorerErrorCodeStrings) {
+		t.Errorf("It appears an error code was added without adding an " +
+			"associated stringer test")
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+			continue
+		}
+	}
+}
+
+// TestExplorerError tests the error output for the ExplorerError type.
+func TestExplorerError(t *testing.T) {
+	tests := []struct {
+		in   ExplorerError
+		want string
+	}{
+		{
+			explorerError(ErrLoadBlock, "missing block", nil),
+			"missing block",
+		},
+		{
+			explorerError(ErrBalanceRepo, "unable to write",
+				errors.New("timeout")),
+			"unable to write: timeout",
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := test.in.Error()
+		if result != test.want {
+			t.Errorf("Error #%d\n got: %s want: %s", i, result,
+				test.want)
+			continue
+		}
+	}
+}
@@ -2,233 +2,336 @@ package netsync
 
 import (
 	"errors"
-	"reflect"
+	"fmt"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/data"
-	"github.com/btcsuite/btcd/txscript"
-	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
-	"github.com/btcsuite/btcd/blockchain"
 )
 
-type keyValue struct {
-	key   string
-	value int64
+const (
+	// explorerRetryDelay is how long the explorer stays halted after the
+	// balance repository failed with a transient error before it tries to
+	// catch up with the main chain again.  It doubles on every consecutive
+	// transient failure up to explorerResumeInterval.
+	explorerRetryDelay = time.Second
+
+	// explorerResumeInterval is how long the explorer stays halted after
+	// any other failure before it tries to catch the balance repository up
+	// with the main chain again.
+	explorerResumeInterval = time.Minute * 5
+)
+
+// errInterruptRequested indicates that an operation was cancelled due to the
+// sync manager shutting down.
+var errInterruptRequested = errors.New("interrupt requested")
+
+// interruptRequested returns true when the channel passed to it is either
+// closed or has data available.
+func interruptRequested(interrupted <-chan struct{}) bool {
+	select {
+	case <-interrupted:
+		return true
+	default:
+	}
+
+	return false
 }
 
-func (sm *SyncManager) explore(blockMsg *blockMsg) {
-	txs := make(map[chainhash.Hash]*btcutil.Tx)
-	addrMap := make(map[string]int64)
-	
-	for _, tx := range blockMsg.block.Transactions() {
-		txs[*tx.Hash()] = tx
-	}
-
-	for _, tx := range blockMsg.block.Transactions() {
-		msgTx := tx.MsgTx()
-
-		// explore input transactions
-		for i, txIn := range msgTx.TxIn {
-			prevOut := txIn.PreviousOutPoint
-			if prevOut.Hash.String() == "0000000000000000000000000000000000000000000000000000000000000000" {
-				continue
-			}
-
-			var originPkScript []byte
-			var originValue int64
-			var internalOriginTx *wire.TxOut = nil
-
-			internalTx := txs[prevOut.Hash]
-			if internalTx != nil {
-				internalOriginTx = internalTx.MsgTx().TxOut[prevOut.Index]
-			}
-
-			if internalOriginTx != nil {
-				originValue = internalOriginTx.Value
-				originPkScript = internalOriginTx.PkScript
-
-				log.Debugf("Internal transaction %+v, PrevOut: %+v", internalOriginTx, prevOut)
-			} else {
-				entry, err := sm.chain.FetchUtxoEntry(&prevOut.Hash)
-				if err != nil {
-					log.Error(err)
-				}
-
-				if entry == nil {
-					log.Infof("TxIn #%d %+v", i, txIn)
-					log.Infof("Tx %+v", tx)
-					log.Infof("Block height %+v", blockMsg.block.Height())
-					panic("aaaaaaaaaaaaaaa")
-				}
-
-				log.Errorf("Entry %+v, PrevOut: %+v, Tx: %+v", entry, prevOut, tx.Hash())
-
-				originValue = entry.AmountByIndex(prevOut.Index)
-				originPkScript = entry.PkScriptByIndex(prevOut.Index)
-
-				//	var pkScript []byte
-				//	var value int64
-				//	var err error
-				//	count := 0
-				//
-				//out:
-				//	for {
-				//		pkScript, value, err = sm.fetchInput(prevOut)
-				//		if err == nil {
-				//			break out
-				//		}
-				//		log.Error(err)
-				//
-				//		if count == 3 {
-				//			panic(err)
-				//		}
-				//		count ++
-				//		log.Warnf("Retrying %d", count)
-				//	}
-				//
-				//	log.Debugf("PkScript %+v, Value %+v, PrevOut: %+v, Tx: %+v", pkScript, value, prevOut, tx.Hash())
-				//
-				//	originPkScript = pkScript
-				//	originValue = value
-			}
-
-			_, addresses, _, _ := txscript.ExtractPkScriptAddrs(originPkScript, sm.chainParams)
-
-			if len(addresses) != 1 {
-				log.Warnf("Number of inputs %d, Inputs: %+v, PrevOut: %+v", len(addresses), addresses, &prevOut)
-				continue
-			}
-
-			pubKey, err := convertToPubKey(addresses[0])
-			if err != nil {
-				log.Infof("TxOut %+v, Type: %+v", addresses[0], reflect.TypeOf(addresses[0]))
-				log.Error(err)
-				continue
-			}
-
-			log.Debugf("TxIn #%d, PrevOut: %+v, Address: %+v, OriginalValue: %d", i, &prevOut, addresses[0], originValue)
-			addrMap[pubKey] -= originValue
-		}
+// balanceRepoTip returns the hash and height of the last block applied to the
+// balance repository.  It is loaded from the repository the first time and
+// tracked in memory afterwards.  The genesis block is treated as the tip of an
+// empty repository since its coinbase can never be spent.
+func (sm *SyncManager) balanceRepoTip() (*chainhash.Hash, int32, error) {
+	if sm.balanceTipHash != nil {
+		return sm.balanceTipHash, sm.balanceTipHeight, nil
+	}
 
-		// explore output transactions
-		for i, txOut := range msgTx.TxOut {
-			_, addresses, _, _ := txscript.ExtractPkScriptAddrs(txOut.PkScript, sm.chainParams)
-
-			if len(addresses) != 1 {
-				log.Warnf("Number of outputs %d, Outputs: %+v, Hash: %+v, Tx#: %d, Value: %+v", len(addresses), addresses, tx.Hash(), i, txOut.Value)
-				continue
-			}
-
-			pubKey, err := convertToPubKey(addresses[0])
-			if err != nil {
-				log.Infof("TxOut %+v, Type: %+v", addresses[0], reflect.TypeOf(addresses[0]))
-				log.Error(err)
-				continue
-			}
-			addrMap[pubKey] += txOut.Value
-		}
+	hash, height, err := sm.balanceRepo.Tip()
+	if err != nil {
+		return nil, 0, explorerError(ErrBalanceRepo, "unable to "+
+			"load balance repository tip", err)
+	}
+	if hash == nil {
+		hash, height = sm.chainParams.GenesisHash, 0
+	}
+	sm.balanceTipHash, sm.balanceTipHeight = hash, height
+	return hash, height, nil
+}
+
+// updateBalanceRepo invokes the passed balance repository update, which records
+// the passed block as the new tip, and tracks the tip on success.  The tracked
+// tip is discarded on failure so it is reloaded from the repository when the
+// explorer resumes since it is unknown whether or not the write made it.
+// Retrying the update then is safe since the repositories either apply the
+// changes atomically or skip the ones already applied for the tip.
+func (sm *SyncManager) updateBalanceRepo(update func() error, tipHash *chainhash.Hash, tipHeight int32) error {
+	if err := update(); err != nil {
+		sm.balanceTipHash = nil
+		str := fmt.Sprintf("unable to apply balance changes for tip "+
+			"%v (height %d)", tipHash, tipHeight)
+		return explorerError(ErrBalanceRepo, str, err)
 	}
 
-	sm.writeTxs(addrMap)
+	sm.balanceTipHash, sm.balanceTipHeight = tipHash, tipHeight
+	sm.explorerRetryDelay = 0
+	return nil
 }
 
-func (sm *SyncManager) fetchInput(prevOut wire.OutPoint) (pkScript []byte, value int64, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = r.(error)
-		}
-	}()
+// blockDeltas loads the balance changes caused by the block with the passed
+// hash and height from the address balance index.
+func (sm *SyncManager) blockDeltas(hash *chainhash.Hash, height int32) (map[string][]data.BalanceDelta, error) {
+	deltas, err := sm.balanceIndex.BlockDeltas(hash)
+	if err != nil {
+		str := fmt.Sprintf("unable to load balance changes for block "+
+			"%v (height %d)", hash, height)
+		return nil, explorerError(ErrLoadBalanceChanges, str, err)
+	}
+	return deltas, nil
+}
 
-	var entry *blockchain.UtxoEntry
-	entry, err = sm.chain.FetchUtxoEntry(&prevOut.Hash)
+// connectBalanceBlock applies the balance changes caused by the passed main
+// chain block to the balance repository.  The parent of the block must be the
+// tip of the repository.
+func (sm *SyncManager) connectBalanceBlock(block *btcutil.Block) error {
+	hash, height := block.Hash(), block.Height()
+	deltas, err := sm.blockDeltas(hash, height)
 	if err != nil {
-		return
+		return err
+	}
+	err = sm.updateBalanceRepo(func() error {
+		return sm.balanceRepo.ConnectBlock(hash, height, deltas)
+	}, hash, height)
+	if err != nil {
+		return err
 	}
 
-	value = entry.AmountByIndex(prevOut.Index)
-	pkScript = entry.PkScriptByIndex(prevOut.Index)
-	return
+	sm.peerNotifier.BalancesChanged(hash, height, deltas, true)
+	return nil
 }
 
-func convertToPubKey(addr btcutil.Address) (string, error) {
-	switch addr := addr.(type) {
-	case *btcutil.AddressPubKeyHash:
-		return addr.EncodeAddress(), nil
+// disconnectBalanceBlock reverses the balance changes caused by the block with
+// the passed hash, parent hash and height, which must be the tip of the balance
+// repository.
+func (sm *SyncManager) disconnectBalanceBlock(hash, prevHash *chainhash.Hash, height int32) error {
+	deltas, err := sm.blockDeltas(hash, height)
+	if err != nil {
+		return err
+	}
+	err = sm.updateBalanceRepo(func() error {
+		return sm.balanceRepo.DisconnectBlock(prevHash, height, deltas)
+	}, prevHash, height-1)
+	if err != nil {
+		return err
+	}
 
-	case *btcutil.AddressScriptHash:
-		//log.Infof("AddressScriptHash %+v", addr)
-		return addr.EncodeAddress(), nil
+	sm.peerNotifier.BalancesChanged(hash, height, deltas, false)
+	return nil
+}
 
-	case *btcutil.AddressPubKey:
-		return addr.AddressPubKeyHash().String(), nil
+// catchUpBalanceRepo brings the balance repository in line with the current
+// best chain.  Blocks applied to the repository that are no longer part of the
+// main chain, which happens when the node stops before the explorer is notified
+// about a reorganize, are unwound first and then every main chain block after
+// the repository tip is applied in order.  This mirrors how the index manager
+// catches up lagging optional indexes.  The catch up is cancelled once the
+// passed channel is closed.
+func (sm *SyncManager) catchUpBalanceRepo(interrupt <-chan struct{}) error {
+	hash, height, err := sm.balanceRepoTip()
+	if err != nil {
+		return err
+	}
 
-	case *btcutil.AddressWitnessScriptHash:
-		log.Infof("AddressWitnessScriptHash %+v", addr)
-		return addr.EncodeAddress(), nil
+	// Loop until the tip is a block that exists in the main chain.  The
+	// balance index keeps the changes of blocks that are no longer in the
+	// main chain, so only the header is needed to unwind them.
+	initialHeight := height
+	for !sm.chain.MainChainHasBlock(hash) {
+		header, err := sm.chain.FetchHeader(hash)
+		if err != nil {
+			str := fmt.Sprintf("unable to load orphaned block %v "+
+				"(height %d)", hash, height)
+			return explorerError(ErrLoadBlock, str, err)
+		}
+		err = sm.disconnectBalanceBlock(hash, &header.PrevBlock,
+			height)
+		if err != nil {
+			return err
+		}
+		hash, height = sm.balanceTipHash, sm.balanceTipHeight
 
-	case *btcutil.AddressWitnessPubKeyHash:
-		log.Infof("AddressWitnessPubKeyHash %+v", addr)
-		return addr.EncodeAddress(), nil
+		if interruptRequested(interrupt) {
+			return errInterruptRequested
+		}
+	}
+	if initialHeight != height {
+		log.Infof("Removed %d orphaned blocks from the balance "+
+			"repository (heights %d to %d)", initialHeight-height,
+			height+1, initialHeight)
 	}
 
-	errUnsupportedAddressType := errors.New("address type is not supported " +
-		"by the address index")
-	return "", errUnsupportedAddressType
-}
+	// Nothing more to do if the repository is caught up.
+	best := sm.chain.BestSnapshot()
+	if height >= best.Height {
+		return nil
+	}
 
-func (sm *SyncManager) writeTxs(addressMap map[string]int64) {
-	done := make(chan bool)
+	log.Infof("Catching up balance repository from height %d to %d",
+		height, best.Height)
+	progressLogger := newBlockProgressLogger("Explored", log)
+	for height := height + 1; height <= best.Height; height++ {
+		block, err := sm.chain.BlockByHeight(height)
+		if err != nil {
+			str := fmt.Sprintf("unable to load block at height %d",
+				height)
+			return explorerError(ErrLoadBlock, str, err)
+		}
+		if err := sm.connectBalanceBlock(block); err != nil {
+			return err
+		}
+		progressLogger.LogBlockHeight(block)
 
-	amount := 0
-	for k := range addressMap {
-		if addressMap[k] == 0 {
-			continue
+		if interruptRequested(interrupt) {
+			return errInterruptRequested
 		}
+	}
+
+	log.Infof("Balance repository caught up to height %d", best.Height)
+	return nil
+}
 
-		pair := &keyValue{
-			key:   k,
-			value: addressMap[k],
+// isTransientExplorerError returns whether or not the passed error that halted
+// the explorer was caused by a balance repository failure that is likely to be
+// temporary.
+func isTransientExplorerError(err error) bool {
+	if eerr, ok := err.(ExplorerError); ok {
+		err = eerr.Err
+	}
+	return data.IsTransientError(err)
+}
+
+// haltExplorer stops the explorer from applying any further blocks to the
+// balance repository because of the passed error.  Block processing continues
+// unaffected.  The explorer tries to catch up again once the resume delay has
+// passed, which starts at explorerRetryDelay and doubles for consecutive
+// transient errors and is explorerResumeInterval for any other error.  The
+// error is reported by ExplorerHaltErr in the meantime.
+//
+// The block handler is never blocked while waiting.  Instead, a timer asks it
+// to resume the explorer once the delay has passed.
+func (sm *SyncManager) haltExplorer(err error) {
+	delay := explorerResumeInterval
+	if isTransientExplorerError(err) {
+		delay = sm.explorerRetryDelay
+		if delay == 0 {
+			delay = explorerRetryDelay
+		}
+		if delay > explorerResumeInterval {
+			delay = explorerResumeInterval
 		}
+		sm.explorerRetryDelay = delay * 2
+	}
+
+	sm.explorerMtx.Lock()
+	sm.explorerErr = err
+	sm.explorerHaltTime = time.Now()
+	sm.explorerResumeDelay = delay
+	sm.explorerMtx.Unlock()
+
+	log.Errorf("Balance explorer halted, address balances will not be "+
+		"updated until it resumes in %v: %v", delay, err)
+
+	time.AfterFunc(delay, sm.requestExplorerResume)
+}
+
+// requestExplorerResume asks the block handler to resume the halted explorer.
+// The request is dropped when the message channel is full, in which case the
+// explorer resumes as part of processing the next block instead.
+func (sm *SyncManager) requestExplorerResume() {
+	select {
+	case sm.msgChan <- resumeExplorerMsg{}:
+	default:
+	}
+}
 
-		amount++
-		go sm.writeTx(done, pair)
+// resumeExplorer catches the balance repository up with the main chain when the
+// explorer is halted and its resume delay has passed.
+//
+// This function MUST be called from the block handler.
+func (sm *SyncManager) resumeExplorer() {
+	if sm.balanceRepo == nil || !sm.explorerActive() {
+		return
 	}
 
-	for i := 0; i < amount; i++ {
-		<-done
+	err := sm.catchUpBalanceRepo(sm.quit)
+	if err != nil && err != errInterruptRequested {
+		sm.haltExplorer(err)
 	}
 }
 
-func (sm *SyncManager) writeTx(c chan bool, item *keyValue) {
-	entry, err := sm.balanceRepo.Get(item.key)
-	if err != nil {
-		log.Error(err)
-		panic("WWWWWW")
+// explorerActive returns whether or not the explorer should process a block.
+// A halted explorer is resumed once its resume delay has passed since it was
+// halted, in which case it catches up with the main chain as part of
+// processing the block.
+func (sm *SyncManager) explorerActive() bool {
+	sm.explorerMtx.Lock()
+	defer sm.explorerMtx.Unlock()
+
+	if sm.explorerErr == nil {
+		return true
+	}
+	if time.Since(sm.explorerHaltTime) < sm.explorerResumeDelay {
+		return false
 	}
 
-	if entry == nil {
-		bEntry := new(data.Balance)
-		bEntry.PublicKey = item.key
-		bEntry.Value = item.value
+	log.Infof("Resuming balance explorer")
+	sm.explorerErr = nil
+	return true
+}
 
-		err := sm.balanceRepo.Insert(bEntry)
-		if err != nil {
-			log.Error(err)
-			c <- false
-			panic("WWWWWW")
-		}
-		c <- true
+// exploreConnectedBlock applies the balance changes caused by the passed block,
+// which has just been connected to the end of the main chain, to the balance
+// repository.  The repository is caught up with the main chain instead when
+// its tip is not the parent of the block, such as after a previous failure.
+func (sm *SyncManager) exploreConnectedBlock(block *btcutil.Block) {
+	if !sm.explorerActive() {
 		return
 	}
 
-	entry.Value += item.value
+	tipHash, _, err := sm.balanceRepoTip()
+	if err == nil {
+		if tipHash.IsEqual(&block.MsgBlock().Header.PrevBlock) {
+			err = sm.connectBalanceBlock(block)
+		} else {
+			err = sm.catchUpBalanceRepo(sm.quit)
+		}
+	}
+	if err != nil && err != errInterruptRequested {
+		sm.haltExplorer(err)
+	}
+}
 
-	err = sm.balanceRepo.Update(entry)
-	if err != nil {
-		log.Error(err)
-		c <- false
-		panic("WWWWWW")
+// exploreDisconnectedBlock reverses the balance changes caused by the passed
+// block, which has just been disconnected from the end of the main chain.  The
+// repository is caught up with the main chain instead when its tip is not the
+// block.
+func (sm *SyncManager) exploreDisconnectedBlock(block *btcutil.Block) {
+	if !sm.explorerActive() {
+		return
+	}
+
+	tipHash, _, err := sm.balanceRepoTip()
+	if err == nil {
+		if tipHash.IsEqual(block.Hash()) {
+			err = sm.disconnectBalanceBlock(block.Hash(),
+				&block.MsgBlock().Header.PrevBlock,
+				block.Height())
+		} else {
+			err = sm.catchUpBalanceRepo(sm.quit)
+		}
+	}
+	if err != nil && err != errInterruptRequested {
+		sm.haltExplorer(err)
 	}
-	c <- true
 }
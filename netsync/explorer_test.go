@@ -1,52 +1,888 @@
 package netsync
 
 import (
-	"fmt"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/blockchain/indexers"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/data"
+	"github.com/btcsuite/btcd/database"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
 )
 
-func TestWriteTx(t *testing.T) {
-	balanceRepo, err := data.NewLevelDbBalanceRepository("D:\\LevelDB-test")
+// TestConnectDisconnectBlock ensures connecting and disconnecting blocks keeps
+// the balances, the balance history and the tip of the leveldb balance
+// repository in line.
+func TestConnectDisconnectBlock(t *testing.T) {
+	path, err := ioutil.TempDir("", "balances")
 	if err != nil {
-		panic(err)
+		t.Fatalf("unable to create temp dir: %v", err)
 	}
+	defer os.RemoveAll(path)
 
-	sm := SyncManager{
-		balanceRepo: balanceRepo,
+	balanceRepo, err := data.NewLevelDbBalanceRepository(path)
+	if err != nil {
+		t.Fatalf("unable to open balance repository: %v", err)
 	}
+	defer balanceRepo.Close()
 
-	done := make(chan bool)
-	go sm.writeTx(done, &keyValue{
-		key:   "1",
-		value: 1,
-	})
+	deltas1 := map[string][]data.BalanceDelta{
+		"1": {{TxHash: chainhash.Hash{11}, Value: 5}},
+		"2": {{TxHash: chainhash.Hash{12}, Value: 7}},
+	}
+	err = balanceRepo.ConnectBlock(&chainhash.Hash{1}, 1, deltas1)
+	if err != nil {
+		t.Fatalf("ConnectBlock: unexpected error: %v", err)
+	}
+	deltas2 := map[string][]data.BalanceDelta{
+		"1": {
+			{TxHash: chainhash.Hash{21}, Value: -2},
+			{TxHash: chainhash.Hash{22}, Value: 4},
+		},
+		"3": {{TxHash: chainhash.Hash{22}, Value: 1}},
+	}
+	err = balanceRepo.ConnectBlock(&chainhash.Hash{2}, 2, deltas2)
+	if err != nil {
+		t.Fatalf("ConnectBlock: unexpected error: %v", err)
+	}
 
-	<-done
+	checkBalances := func(want map[string]int64) {
+		t.Helper()
+		for key, value := range want {
+			entry, err := balanceRepo.Get(key)
+			if err != nil {
+				t.Fatalf("Get: unexpected error: %v", err)
+			}
+			if entry == nil || entry.Value != value {
+				t.Fatalf("Get: mismatched balance for %s -- "+
+					"got %+v, want %d", key, entry, value)
+			}
+		}
+	}
+	checkBalances(map[string]int64{"1": 7, "2": 7, "3": 1})
+
+	history, err := balanceRepo.BalanceHistory("1", 0, 2)
+	if err != nil {
+		t.Fatalf("BalanceHistory: unexpected error: %v", err)
+	}
+	wantHistory := []*data.BalanceHistoryEntry{
+		{Height: 1, TxHash: chainhash.Hash{11}, Delta: 5, Balance: 5},
+		{Height: 2, TxHash: chainhash.Hash{21}, Delta: -2, Balance: 3},
+		{Height: 2, TxHash: chainhash.Hash{22}, Delta: 4, Balance: 7},
+	}
+	if !reflect.DeepEqual(history, wantHistory) {
+		t.Fatalf("BalanceHistory: mismatched history -- got %+v, "+
+			"want %+v", history, wantHistory)
+	}
+	history, err = balanceRepo.BalanceHistory("1", 2, 2)
+	if err != nil {
+		t.Fatalf("BalanceHistory: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(history, wantHistory[1:]) {
+		t.Fatalf("BalanceHistory: mismatched history -- got %+v, "+
+			"want %+v", history, wantHistory[1:])
+	}
+
+	atHeightTests := []struct {
+		key    string
+		height int32
+		want   int64
+	}{
+		{key: "1", height: 0, want: 0},
+		{key: "1", height: 1, want: 5},
+		{key: "1", height: 2, want: 7},
+		{key: "1", height: 100, want: 7},
+		{key: "3", height: 1, want: 0},
+		{key: "4", height: 2, want: 0},
+	}
+	for _, test := range atHeightTests {
+		value, err := balanceRepo.BalanceAtHeight(test.key, test.height)
+		if err != nil {
+			t.Fatalf("BalanceAtHeight: unexpected error: %v", err)
+		}
+		if value != test.want {
+			t.Fatalf("BalanceAtHeight: mismatched balance for %s "+
+				"at height %d -- got %d, want %d", test.key,
+				test.height, value, test.want)
+		}
+	}
+
+	// Disconnecting the second block must restore the balances and remove
+	// its history entries.
+	err = balanceRepo.DisconnectBlock(&chainhash.Hash{1}, 2, deltas2)
+	if err != nil {
+		t.Fatalf("DisconnectBlock: unexpected error: %v", err)
+	}
+	checkBalances(map[string]int64{"1": 5, "2": 7, "3": 0})
+	history, err = balanceRepo.BalanceHistory("1", 0, 2)
+	if err != nil {
+		t.Fatalf("BalanceHistory: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(history, wantHistory[:1]) {
+		t.Fatalf("BalanceHistory: mismatched history -- got %+v, "+
+			"want %+v", history, wantHistory[:1])
+	}
+	tipHash, tipHeight, err := balanceRepo.Tip()
+	if err != nil {
+		t.Fatalf("Tip: unexpected error: %v", err)
+	}
+	if !tipHash.IsEqual(&chainhash.Hash{1}) || tipHeight != 1 {
+		t.Fatalf("Tip: mismatched tip -- got %v (height %d), want %v "+
+			"(height %d)", tipHash, tipHeight, chainhash.Hash{1}, 1)
+	}
 }
 
-func BenchmarkWriteTxs(b *testing.B) {
-	path := fmt.Sprintf("D:\\LevelDB-test\\%d", b.N)
+// TestConnectBlocks ensures connecting a batch of blocks at once results in the
+// same balances, history and tip as connecting them one by one.
+func TestConnectBlocks(t *testing.T) {
+	path, err := ioutil.TempDir("", "balances")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+
 	balanceRepo, err := data.NewLevelDbBalanceRepository(path)
 	if err != nil {
-		panic(err)
+		t.Fatalf("unable to open balance repository: %v", err)
 	}
+	defer balanceRepo.Close()
 
-	sm := SyncManager{
-		balanceRepo: balanceRepo,
+	err = balanceRepo.ConnectBlocks([]*data.BlockDeltas{
+		{
+			Hash:   chainhash.Hash{1},
+			Height: 1,
+			Deltas: map[string][]data.BalanceDelta{
+				"1": {{TxHash: chainhash.Hash{11}, Value: 5}},
+			},
+		},
+		{
+			Hash:   chainhash.Hash{2},
+			Height: 2,
+			Deltas: map[string][]data.BalanceDelta{
+				"1": {
+					{TxHash: chainhash.Hash{21}, Value: -2},
+					{TxHash: chainhash.Hash{22}, Value: 4},
+				},
+				"2": {{TxHash: chainhash.Hash{22}, Value: 1}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ConnectBlocks: unexpected error: %v", err)
 	}
 
-	addressMap := make(map[string]int64)
-	for i := 1; i <= 1; i++ {
-		addressMap[strconv.Itoa(i)] = int64(i)
+	for key, value := range map[string]int64{"1": 7, "2": 1} {
+		entry, err := balanceRepo.Get(key)
+		if err != nil {
+			t.Fatalf("Get: unexpected error: %v", err)
+		}
+		if entry == nil || entry.Value != value {
+			t.Fatalf("Get: mismatched balance for %s -- got %+v, "+
+				"want %d", key, entry, value)
+		}
 	}
 
-	b.ResetTimer()
-	fmt.Println(addressMap)
+	history, err := balanceRepo.BalanceHistory("1", 0, 2)
+	if err != nil {
+		t.Fatalf("BalanceHistory: unexpected error: %v", err)
+	}
+	wantHistory := []*data.BalanceHistoryEntry{
+		{Height: 1, TxHash: chainhash.Hash{11}, Delta: 5, Balance: 5},
+		{Height: 2, TxHash: chainhash.Hash{21}, Delta: -2, Balance: 3},
+		{Height: 2, TxHash: chainhash.Hash{22}, Delta: 4, Balance: 7},
+	}
+	if !reflect.DeepEqual(history, wantHistory) {
+		t.Fatalf("BalanceHistory: mismatched history -- got %+v, "+
+			"want %+v", history, wantHistory)
+	}
+
+	tipHash, tipHeight, err := balanceRepo.Tip()
+	if err != nil {
+		t.Fatalf("Tip: unexpected error: %v", err)
+	}
+	if !tipHash.IsEqual(&chainhash.Hash{2}) || tipHeight != 2 {
+		t.Fatalf("Tip: mismatched tip -- got %v (height %d), want %v "+
+			"(height %d)", tipHash, tipHeight, chainhash.Hash{2}, 2)
+	}
+}
+
+// checkExploredBalances ensures the non-zero balances in the passed repository,
+// including the ones recorded under reserved keys, are exactly the passed ones
+// and that its tip is the best block of the chain.
+func checkExploredBalances(t *testing.T, name string, h *explorerHarness, want map[string]int64) {
+	t.Helper()
 
+	repo := h.sm.balanceRepo
+	got := make(map[string]int64)
+	err := repo.ForEachBalance(func(balance *data.Balance) error {
+		if balance.Value != 0 {
+			got[balance.PublicKey] = balance.Value
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%s: ForEachBalance: unexpected error: %v", name, err)
+	}
+	for _, key := range []string{data.NonstandardKey, data.UnspendableKey} {
+		balance, err := repo.Get(key)
+		if err != nil {
+			t.Fatalf("%s: Get: unexpected error: %v", name, err)
+		}
+		if balance != nil && balance.Value != 0 {
+			got[key] = balance.Value
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s: mismatched balances -- got %v, want %v", name,
+			got, want)
+	}
+
+	best := h.sm.chain.BestSnapshot()
+	tipHash, tipHeight, err := repo.Tip()
+	if err != nil {
+		t.Fatalf("%s: Tip: unexpected error: %v", name, err)
+	}
+	if tipHash == nil || *tipHash != best.Hash || tipHeight != best.Height {
+		t.Fatalf("%s: mismatched tip -- got %v (height %d), want %v "+
+			"(height %d)", name, tipHash, tipHeight, best.Hash,
+			best.Height)
+	}
+}
+
+// TestExplore ensures the explorer records the exact balances of chains of
+// generated blocks as they are connected to and disconnected from a real chain
+// instance.
+func TestExplore(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	subsidy := blockchain.CalcBlockSubsidy(1, params)
+	a := newOpTrueAddress(1, params)
+	b := newOpTrueAddress(2, params)
+	c := newOpTrueAddress(3, params)
+	p2pkhAddr, err := btcutil.NewAddressPubKeyHash(bytes.Repeat([]byte{1},
+		20), params)
+	if err != nil {
+		t.Fatalf("NewAddressPubKeyHash: unexpected error: %v", err)
+	}
+	p2pkh := newTestAddress(p2pkhAddr, nil)
+	p2wpkhAddr, err := btcutil.NewAddressWitnessPubKeyHash(
+		bytes.Repeat([]byte{2}, 20), params)
+	if err != nil {
+		t.Fatalf("NewAddressWitnessPubKeyHash: unexpected error: %v", err)
+	}
+	p2wpkh := newTestAddress(p2wpkhAddr, nil)
+	p2wshAddr, err := btcutil.NewAddressWitnessScriptHash(
+		bytes.Repeat([]byte{3}, 32), params)
+	if err != nil {
+		t.Fatalf("NewAddressWitnessScriptHash: unexpected error: %v", err)
+	}
+	p2wsh := newTestAddress(p2wshAddr, nil)
+	nullData, err := txscript.NullDataScript([]byte("explorer"))
+	if err != nil {
+		t.Fatalf("NullDataScript: unexpected error: %v", err)
+	}
+	unspendable := testAddress{key: data.UnspendableKey, pkScript: nullData}
+
+	// pay returns an output paying the passed amount to the passed address
+	// for use as a payee of spendTx.
+	pay := func(addr testAddress, amount int64) testOutput {
+		return testOutput{addr: addr, amount: amount}
+	}
+
+	tests := []struct {
+		name string
+
+		// blocks builds the blocks to process in order.
+		blocks func(g *explorerTestGenerator) []*btcutil.Block
+
+		// want holds the expected non-zero balances.
+		want map[string]int64
+
+		// wantStats holds the expected balances, including their
+		// totals, of some of the addresses.
+		wantStats []*data.Balance
+
+		// disconnected holds the names of the blocks the explorer is
+		// expected to disconnect in order.
+		disconnected []string
+	}{
+		{
+			name: "coinbase",
+			blocks: func(g *explorerTestGenerator) []*btcutil.Block {
+				return []*btcutil.Block{
+					g.nextBlock("b1", a),
+					g.nextBlock("b2", a),
+					g.nextBlock("b3", b),
+				}
+			},
+			want: map[string]int64{a.key: 2 * subsidy, b.key: subsidy},
+		},
+		{
+			name: "intra-block spends",
+			blocks: func(g *explorerTestGenerator) []*btcutil.Block {
+				b1 := g.nextBlock("b1", a)
+				tx1, outs := spendTx([]testOutput{g.coinbaseOut("b1", a)},
+					a, pay(b, 30e8))
+				tx2, _ := spendTx(outs[:1], c)
+				b2 := g.nextBlock("b2", c, tx1, tx2)
+				return []*btcutil.Block{b1, b2}
+			},
+			want: map[string]int64{
+				a.key: subsidy - 30e8,
+				c.key: subsidy + 30e8,
+			},
+			wantStats: []*data.Balance{{
+				PublicKey: b.key,
+				Received:  30e8,
+				Sent:      30e8,
+				TxCount:   2,
+				FirstSeen: 2,
+				LastSeen:  2,
+			}},
+		},
+		{
+			name: "multi-output transactions",
+			blocks: func(g *explorerTestGenerator) []*btcutil.Block {
+				b1 := g.nextBlock("b1", a)
+				tx, _ := spendTx([]testOutput{g.coinbaseOut("b1", a)},
+					a, pay(b, 10e8), pay(c, 15e8),
+					pay(p2pkh, 5e8), pay(unspendable, 1))
+				b2 := g.nextBlock("b2", b, tx)
+				return []*btcutil.Block{b1, b2}
+			},
+			want: map[string]int64{
+				a.key:               subsidy - 30e8 - 1,
+				b.key:               subsidy + 10e8,
+				c.key:               15e8,
+				p2pkh.key:           5e8,
+				data.UnspendableKey: 1,
+			},
+		},
+		{
+			name: "witness outputs",
+			blocks: func(g *explorerTestGenerator) []*btcutil.Block {
+				b1 := g.nextBlock("b1", a)
+				tx, _ := spendTx([]testOutput{g.coinbaseOut("b1", a)},
+					a, pay(p2wpkh, 7e8), pay(p2wsh, 11e8))
+				b2 := g.nextBlock("b2", b, tx)
+				return []*btcutil.Block{b1, b2}
+			},
+			want: map[string]int64{
+				a.key:      subsidy - 18e8,
+				b.key:      subsidy,
+				p2wpkh.key: 7e8,
+				p2wsh.key:  11e8,
+			},
+		},
+		{
+			// The main chain b1 -> b2 -> b3 is reorganized onto
+			// the side chain b1 -> b2a -> b3a -> b4a.  The
+			// disconnected b2 contains an intra-block spend while
+			// b3a spends the same output as b2.
+			name: "reorg",
+			blocks: func(g *explorerTestGenerator) []*btcutil.Block {
+				b1 := g.nextBlock("b1", a)
+				tx1, outs := spendTx([]testOutput{g.coinbaseOut("b1", a)},
+					a, pay(b, 20e8))
+				tx2, _ := spendTx(outs[:1], c)
+				b2 := g.nextBlock("b2", a, tx1, tx2)
+				b3 := g.nextBlock("b3", a)
+
+				g.setTip("b1")
+				b2a := g.nextBlock("b2a", c)
+				tx3, _ := spendTx([]testOutput{g.coinbaseOut("b1", a)},
+					b)
+				b3a := g.nextBlock("b3a", c, tx3)
+				b4a := g.nextBlock("b4a", c)
+				return []*btcutil.Block{b1, b2, b3, b2a, b3a, b4a}
+			},
+			want: map[string]int64{
+				b.key: subsidy,
+				c.key: 3 * subsidy,
+			},
+			wantStats: []*data.Balance{{
+				PublicKey: a.key,
+				Received:  subsidy,
+				Sent:      subsidy,
+				TxCount:   2,
+				FirstSeen: 1,
+				LastSeen:  3,
+			}, {
+				PublicKey: b.key,
+				Value:     subsidy,
+				Received:  subsidy,
+				TxCount:   1,
+				FirstSeen: 3,
+				LastSeen:  3,
+			}, {
+				PublicKey: c.key,
+				Value:     3 * subsidy,
+				Received:  3 * subsidy,
+				TxCount:   3,
+				FirstSeen: 2,
+				LastSeen:  4,
+			}},
+			disconnected: []string{"b3", "b2"},
+		},
+	}
+
+	for _, test := range tests {
+		g := newExplorerTestGenerator()
+		h, err := newExplorerHarness(g.params,
+			data.NewMemoryBalanceRepository())
+		if err != nil {
+			t.Fatalf("%s: unable to create harness: %v", test.name,
+				err)
+		}
+
+		for _, block := range test.blocks(g) {
+			if err := h.processBlock(block); err != nil {
+				h.teardown()
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		if err := h.sm.explorerErr; err != nil {
+			h.teardown()
+			t.Fatalf("%s: explorer halted: %v", test.name, err)
+		}
+		checkExploredBalances(t, test.name, h, test.want)
+		for _, want := range test.wantStats {
+			got, err := h.sm.balanceRepo.Get(want.PublicKey)
+			if err != nil {
+				h.teardown()
+				t.Fatalf("%s: Get: unexpected error: %v", test.name,
+					err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				h.teardown()
+				t.Fatalf("%s: mismatched balance -- got %+v, "+
+					"want %+v", test.name, got, want)
+			}
+		}
+
+		var wantDisconnected []chainhash.Hash
+		for _, name := range test.disconnected {
+			wantDisconnected = append(wantDisconnected,
+				g.blocksByName[name].BlockHash())
+		}
+		if !reflect.DeepEqual(h.notifier.disconnected, wantDisconnected) {
+			h.teardown()
+			t.Fatalf("%s: mismatched disconnected blocks -- got %v, "+
+				"want %v", test.name, h.notifier.disconnected,
+				wantDisconnected)
+		}
+		h.teardown()
+	}
+}
+
+// TestExplorerHaltResume ensures the explorer halts when the balance repository
+// fails and catches up with the blocks it missed once it resumes.
+func TestExplorerHaltResume(t *testing.T) {
+	g := newExplorerTestGenerator()
+	repo := &mockBalanceRepo{
+		MemoryBalanceRepository: data.NewMemoryBalanceRepository(),
+	}
+	h, err := newExplorerHarness(g.params, repo)
+	if err != nil {
+		t.Fatalf("unable to create harness: %v", err)
+	}
+	defer h.teardown()
+
+	a := newOpTrueAddress(1, g.params)
+	b := newOpTrueAddress(2, g.params)
+	subsidy := blockchain.CalcBlockSubsidy(1, g.params)
+	if err := h.processBlock(g.nextBlock("b1", a)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The explorer halts on the failed update and skips further blocks
+	// while halted.
+	repo.updateErr = errors.New("repository unavailable")
+	tx, _ := spendTx([]testOutput{g.coinbaseOut("b1", a)}, b)
+	if err := h.processBlock(g.nextBlock("b2", a, tx)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.processBlock(g.nextBlock("b3", b)); err != nil {
+		t.Fatal(err)
+	}
+	rerr, ok := h.sm.explorerErr.(ExplorerError)
+	if !ok || rerr.ErrorCode != ErrBalanceRepo {
+		t.Fatalf("explorer error: got %v, want %v", h.sm.explorerErr,
+			ErrBalanceRepo)
+	}
+	tipHash, _, err := repo.Tip()
+	if err != nil {
+		t.Fatalf("Tip: unexpected error: %v", err)
+	}
+	if want := g.blocksByName["b1"].BlockHash(); *tipHash != want {
+		t.Fatalf("Tip: mismatched tip while halted -- got %v, want %v",
+			tipHash, want)
+	}
+
+	// Once resumed, the explorer catches up with the blocks it missed.
+	repo.updateErr = nil
+	h.sm.explorerHaltTime = time.Now().Add(-explorerResumeInterval)
+	if err := h.processBlock(g.nextBlock("b4", a)); err != nil {
+		t.Fatal(err)
+	}
+	if h.sm.explorerErr != nil {
+		t.Fatalf("explorer still halted: %v", h.sm.explorerErr)
+	}
+	checkExploredBalances(t, "resume", h, map[string]int64{
+		a.key: 2 * subsidy,
+		b.key: 2 * subsidy,
+	})
+}
+
+// TestExplorerUnwindOrphaned ensures the explorer unwinds the blocks it applied
+// that were reorganized out of the main chain while it was halted using the
+// balance changes kept by the address balance index.
+func TestExplorerUnwindOrphaned(t *testing.T) {
+	g := newExplorerTestGenerator()
+	repo := &mockBalanceRepo{
+		MemoryBalanceRepository: data.NewMemoryBalanceRepository(),
+	}
+	h, err := newExplorerHarness(g.params, repo)
+	if err != nil {
+		t.Fatalf("unable to create harness: %v", err)
+	}
+	defer h.teardown()
+
+	a := newOpTrueAddress(1, g.params)
+	b := newOpTrueAddress(2, g.params)
+	c := newOpTrueAddress(3, g.params)
+	subsidy := blockchain.CalcBlockSubsidy(1, g.params)
+	if err := h.processBlock(g.nextBlock("b1", a)); err != nil {
+		t.Fatal(err)
+	}
+	tx, _ := spendTx([]testOutput{g.coinbaseOut("b1", a)}, b)
+	if err := h.processBlock(g.nextBlock("b2a", a, tx)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reorganize to a longer side chain while the repository fails, so its
+	// tip is left on the orphaned block.
+	repo.updateErr = errors.New("repository unavailable")
+	g.setTip("b1")
+	if err := h.processBlock(g.nextBlock("b2b", c)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.processBlock(g.nextBlock("b3b", c)); err != nil {
+		t.Fatal(err)
+	}
+	tipHash, _, err := repo.Tip()
+	if err != nil {
+		t.Fatalf("Tip: unexpected error: %v", err)
+	}
+	if want := g.blocksByName["b2a"].BlockHash(); *tipHash != want {
+		t.Fatalf("Tip: mismatched tip while halted -- got %v, want %v",
+			tipHash, want)
+	}
+
+	// Once resumed, the explorer unwinds the orphaned block and applies
+	// the side chain.
+	repo.updateErr = nil
+	h.sm.explorerHaltTime = time.Now().Add(-explorerResumeInterval)
+	h.sm.resumeExplorer()
+	if h.sm.explorerErr != nil {
+		t.Fatalf("explorer still halted: %v", h.sm.explorerErr)
+	}
+	tipHash, tipHeight, err := repo.Tip()
+	if err != nil {
+		t.Fatalf("Tip: unexpected error: %v", err)
+	}
+	best := h.sm.chain.BestSnapshot()
+	if *tipHash != best.Hash || tipHeight != best.Height {
+		t.Fatalf("Tip: mismatched tip -- got %v (height %d), want %v "+
+			"(height %d)", tipHash, tipHeight, best.Hash, best.Height)
+	}
+	checkExploredBalances(t, "unwind", h, map[string]int64{
+		a.key: subsidy,
+		c.key: 2 * subsidy,
+	})
+}
+
+// TestExplorerTransientRetry ensures the explorer halts right away when the
+// balance repository fails with a transient error, retries from a timer with a
+// delay that doubles on every consecutive failure, and catches up once the
+// repository recovers.
+func TestExplorerTransientRetry(t *testing.T) {
+	g := newExplorerTestGenerator()
+	repo := &mockBalanceRepo{
+		MemoryBalanceRepository: data.NewMemoryBalanceRepository(),
+	}
+	h, err := newExplorerHarness(g.params, repo)
+	if err != nil {
+		t.Fatalf("unable to create harness: %v", err)
+	}
+	defer h.teardown()
+	h.sm.msgChan = make(chan interface{}, 1)
+
+	checkHalted := func(wantDelay time.Duration) {
+		t.Helper()
+
+		if !isTransientExplorerError(h.sm.explorerErr) {
+			t.Fatalf("explorer error: got %v, want transient error",
+				h.sm.explorerErr)
+		}
+		if h.sm.explorerResumeDelay != wantDelay {
+			t.Fatalf("resume delay: got %v, want %v",
+				h.sm.explorerResumeDelay, wantDelay)
+		}
+	}
+
+	a := newOpTrueAddress(1, g.params)
+	subsidy := blockchain.CalcBlockSubsidy(1, g.params)
+	repo.updateErr = &net.DNSError{Err: "i/o timeout", IsTimeout: true}
+	if err := h.processBlock(g.nextBlock("b1", a)); err != nil {
+		t.Fatal(err)
+	}
+	checkHalted(explorerRetryDelay)
+
+	// The timer asks the block handler to resume the explorer.
+	select {
+	case msg := <-h.sm.msgChan:
+		if _, ok := msg.(resumeExplorerMsg); !ok {
+			t.Fatalf("unexpected message %T", msg)
+		}
+	case <-time.After(explorerRetryDelay * 10):
+		t.Fatalf("explorer resume was not requested")
+	}
+
+	// Another failure while resuming doubles the delay.
+	h.sm.resumeExplorer()
+	checkHalted(explorerRetryDelay * 2)
+
+	// The explorer doesn't resume before the delay has passed and catches
+	// up once it has.
+	repo.updateErr = nil
+	h.sm.resumeExplorer()
+	checkHalted(explorerRetryDelay * 2)
+	h.sm.explorerHaltTime = time.Now().Add(-explorerRetryDelay * 2)
+	h.sm.resumeExplorer()
+	if h.sm.explorerErr != nil {
+		t.Fatalf("explorer still halted: %v", h.sm.explorerErr)
+	}
+	if h.sm.explorerRetryDelay != 0 {
+		t.Fatalf("retry delay not reset: %v", h.sm.explorerRetryDelay)
+	}
+	checkExploredBalances(t, "retry", h, map[string]int64{a.key: subsidy})
+}
+
+// TestExplorerStartupCatchUp ensures a lagging balance repository is caught up
+// with the best chain when the sync manager is created and that an interrupt
+// cancels the catch up.
+func TestExplorerStartupCatchUp(t *testing.T) {
+	g := newExplorerTestGenerator()
+	repo := &mockBalanceRepo{
+		MemoryBalanceRepository: data.NewMemoryBalanceRepository(),
+	}
+	h, err := newExplorerHarness(g.params, repo)
+	if err != nil {
+		t.Fatalf("unable to create harness: %v", err)
+	}
+	defer h.teardown()
+
+	// Connect the blocks while the repository fails so it lags behind.
+	a := newOpTrueAddress(1, g.params)
+	subsidy := blockchain.CalcBlockSubsidy(1, g.params)
+	repo.updateErr = errors.New("repository unavailable")
+	const numBlocks = 5
+	for i := 1; i <= numBlocks; i++ {
+		block := g.nextBlock("b"+strconv.Itoa(i), a)
+		if err := h.processBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo.updateErr = nil
+
+	config := Config{
+		PeerNotifier:       new(mockPeerNotifier),
+		Chain:              h.sm.chain,
+		ChainParams:        g.params,
+		BalanceRepo:        repo,
+		BalanceIndex:       h.sm.balanceIndex,
+		DisableCheckpoints: true,
+		MaxPeers:           8,
+	}
+	interrupt := make(chan struct{})
+	close(interrupt)
+	config.Interrupt = interrupt
+	if _, err := New(&config); err != errInterruptRequested {
+		t.Fatalf("New: unexpected error -- got %v, want %v", err,
+			errInterruptRequested)
+	}
+
+	config.Interrupt = nil
+	sm, err := New(&config)
+	if err != nil {
+		t.Fatalf("New: unexpected error: %v", err)
+	}
+	if err := sm.ExplorerHaltErr(); err != nil {
+		t.Fatalf("explorer halted: %v", err)
+	}
+	tipHash, tipHeight, err := repo.Tip()
+	if err != nil {
+		t.Fatalf("Tip: unexpected error: %v", err)
+	}
+	best := h.sm.chain.BestSnapshot()
+	if *tipHash != best.Hash || tipHeight != best.Height {
+		t.Fatalf("Tip: mismatched tip -- got %v (height %d), want %v "+
+			"(height %d)", tipHash, tipHeight, best.Hash, best.Height)
+	}
+	checkExploredBalances(t, "startup", &explorerHarness{sm: sm},
+		map[string]int64{a.key: numBlocks * subsidy})
+}
+
+// TestExploreConcurrentInvalidate ensures the explorer keeps the balances in
+// line with the best chain when blocks are repeatedly invalidated and
+// reconsidered through a running sync manager while other blocks arrive.
+func TestExploreConcurrentInvalidate(t *testing.T) {
+	g := newExplorerTestGenerator()
+	dbPath, err := ioutil.TempDir("", "explorer")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "blocks"),
+		g.params.Net)
+	if err != nil {
+		t.Fatalf("unable to create database: %v", err)
+	}
+	defer db.Close()
+	balanceIndex := indexers.NewBalanceIndex(db,
+		&data.KeyPolicy{ChainParams: g.params})
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:           db,
+		ChainParams:  g.params,
+		TimeSource:   blockchain.NewMedianTime(),
+		IndexManager: newTestIndexManager(db, balanceIndex),
+	})
+	if err != nil {
+		t.Fatalf("unable to create chain: %v", err)
+	}
+
+	// The blocks only have a coinbase, so the sync manager doesn't need a
+	// transaction pool to handle their notifications.
+	sm, err := New(&Config{
+		PeerNotifier:       new(mockPeerNotifier),
+		Chain:              chain,
+		ChainParams:        g.params,
+		BalanceRepo:        data.NewMemoryBalanceRepository(),
+		BalanceIndex:       balanceIndex,
+		DisableCheckpoints: true,
+		MaxPeers:           8,
+	})
+	if err != nil {
+		t.Fatalf("unable to create sync manager: %v", err)
+	}
+	sm.Start()
+	defer sm.Stop()
+
+	a := newOpTrueAddress(1, g.params)
+	const numBlocks = 30
+	blocks := make([]*btcutil.Block, 0, numBlocks)
+	for i := 1; i <= numBlocks; i++ {
+		blocks = append(blocks, g.nextBlock("b"+strconv.Itoa(i), a))
+	}
+	if _, err := sm.ProcessBlock(blocks[0], blockchain.BFNone); err != nil {
+		t.Fatalf("ProcessBlock: unexpected error: %v", err)
+	}
+
+	// Feed the remaining blocks from another goroutine.  The blocks are
+	// rejected while the first block is invalidated, so they are retried
+	// until it is reconsidered.
+	done := make(chan error, 1)
+	go func() {
+		for _, block := range blocks[1:] {
+			for {
+				_, err := sm.ProcessBlock(block, blockchain.BFNone)
+				if err == nil {
+					break
+				}
+				if _, ok := err.(blockchain.RuleError); !ok {
+					done <- err
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+		done <- nil
+	}()
+
+	// Rewind the chain to the genesis block and back until all blocks have
+	// been processed.
+	hash := blocks[0].Hash()
+	for finished := false; !finished; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("ProcessBlock: unexpected error: %v", err)
+			}
+			finished = true
+		default:
+			if err := sm.InvalidateBlock(hash); err != nil {
+				t.Fatalf("InvalidateBlock: unexpected error: %v",
+					err)
+			}
+			if err := sm.ReconsiderBlock(hash); err != nil {
+				t.Fatalf("ReconsiderBlock: unexpected error: %v",
+					err)
+			}
+		}
+	}
+
+	if best := chain.BestSnapshot(); best.Height != numBlocks {
+		t.Fatalf("mismatched best chain height -- got %d, want %d",
+			best.Height, numBlocks)
+	}
+	if err := sm.ExplorerHaltErr(); err != nil {
+		t.Fatalf("explorer halted: %v", err)
+	}
+	subsidy := blockchain.CalcBlockSubsidy(1, g.params)
+	checkExploredBalances(t, "concurrent invalidate",
+		&explorerHarness{sm: sm}, map[string]int64{
+			a.key: numBlocks * subsidy,
+		})
+}
+
+func BenchmarkConnectBlock(b *testing.B) {
+	path, err := ioutil.TempDir("", "balances")
+	if err != nil {
+		b.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(path)
+
+	balanceRepo, err := data.NewLevelDbBalanceRepository(path)
+	if err != nil {
+		b.Fatalf("unable to open balance repository: %v", err)
+	}
+	defer balanceRepo.Close()
+
+	deltas := make(map[string][]data.BalanceDelta)
+	for i := 1; i <= 1000; i++ {
+		deltas[strconv.Itoa(i)] = []data.BalanceDelta{
+			{Value: int64(i)},
+		}
+	}
+
+	b.ResetTimer()
 	for i := 1; i <= b.N; i++ {
-		sm.writeTxs(addressMap)
+		err := balanceRepo.ConnectBlock(&chainhash.Hash{}, int32(i),
+			deltas)
+		if err != nil {
+			b.Fatalf("ConnectBlock: %v", err)
+		}
 	}
 }
@@ -6,8 +6,10 @@ package netsync
 
 import (
 	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/blockchain/indexers"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/data"
 	"github.com/btcsuite/btcd/mempool"
 	"github.com/btcsuite/btcd/peer"
 	"github.com/btcsuite/btcd/wire"
@@ -25,6 +27,12 @@ type PeerNotifier interface {
 	RelayInventory(invVect *wire.InvVect, data interface{})
 
 	TransactionConfirmed(tx *btcutil.Tx)
+
+	// BalancesChanged is invoked after the explorer applied the balance
+	// changes of the block with the passed hash and height to the balance
+	// repository, or reversed them when connected is false.
+	BalancesChanged(blockHash *chainhash.Hash, height int32,
+		deltas map[string][]data.BalanceDelta, connected bool)
 }
 
 // Config is a configuration struct used to initialize a new SyncManager.
@@ -34,6 +42,21 @@ type Config struct {
 	TxMemPool    *mempool.TxPool
 	ChainParams  *chaincfg.Params
 
+	// BalanceRepo is the repository the explorer records address balances
+	// to as blocks are connected and disconnected.  The explorer is
+	// disabled when it is nil.
+	BalanceRepo data.IBalanceRepository
+
+	// BalanceIndex feeds the explorer the balance changes of the blocks
+	// it applies to and unwinds from the balance repository.  It is
+	// required when BalanceRepo is set.
+	BalanceIndex *indexers.BalanceIndex
+
+	// Interrupt specifies a channel the caller can close to cancel catching
+	// the balance repository up with the best chain while the sync manager
+	// is created.
+	Interrupt <-chan struct{}
+
 	DisableCheckpoints bool
 	MaxPeers           int
 }
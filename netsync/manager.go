@@ -11,10 +11,11 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/btcsuite/btcd/data"
 	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/blockchain/indexers"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/data"
 	"github.com/btcsuite/btcd/database"
 	"github.com/btcsuite/btcd/mempool"
 	peerpkg "github.com/btcsuite/btcd/peer"
@@ -108,6 +109,26 @@ type processBlockMsg struct {
 	reply chan processBlockResponse
 }
 
+// invalidateBlockMsg, reconsiderBlockMsg and preciousBlockMsg are message
+// types to be sent across the message channel for manually changing which
+// chain is the best one.  They are handled by the block handler, like blocks
+// are, so the reorganizations they cause are never processed concurrently with
+// the ones caused by new blocks.
+type invalidateBlockMsg struct {
+	hash  *chainhash.Hash
+	reply chan error
+}
+
+type reconsiderBlockMsg struct {
+	hash  *chainhash.Hash
+	reply chan error
+}
+
+type preciousBlockMsg struct {
+	hash  *chainhash.Hash
+	reply chan error
+}
+
 // isCurrentMsg is a message type to be sent across the message channel for
 // requesting whether or not the sync manager believes it is synced with the
 // currently connected peers.
@@ -123,6 +144,10 @@ type pauseMsg struct {
 	unpause <-chan struct{}
 }
 
+// resumeExplorerMsg is a message type to be sent across the message channel
+// for resuming the halted balance explorer once its resume delay has passed.
+type resumeExplorerMsg struct{}
+
 // headerNode is used as a node in a list of headers that are linked together
 // between checkpoints.
 type headerNode struct {
@@ -169,7 +194,19 @@ type SyncManager struct {
 	startHeader      *list.Element
 	nextCheckpoint   *chaincfg.Checkpoint
 
-	balanceRepo data.IBalanceRepository
+	// The following fields are used by the balance explorer.
+	balanceRepo         data.IBalanceRepository
+	balanceIndex        *indexers.BalanceIndex
+	balanceTipHash      *chainhash.Hash
+	balanceTipHeight    int32
+	explorerMtx         sync.Mutex
+	explorerErr         error
+	explorerHaltTime    time.Time
+	explorerResumeDelay time.Duration
+
+	// explorerRetryDelay is the resume delay of the explorer for the next
+	// transient error.  It is only accessed by the block handler.
+	explorerRetryDelay time.Duration
 }
 
 // resetHeaderState sets the headers-first mode state to values appropriate for
@@ -898,12 +935,26 @@ func (sm *SyncManager) haveInventory(invVect *wire.InvVect) (bool, error) {
 		}
 
 		// Check if the transaction exists from the point of view of the
-		// end of the main chain.
-		entry, err := sm.chain.FetchUtxoEntry(&invVect.Hash)
-		if err != nil {
-			return false, err
+		// end of the main chain.  Note that this is only a best effort
+		// since it is expensive to check existence of every output and
+		// the only purpose of this check is to avoid downloading
+		// already known transactions.  Only the first two outputs are
+		// checked because the vast majority of transactions consist of
+		// two outputs where one is some form of "pay-to-somebody-else"
+		// and the other is a change output.
+		prevOut := wire.OutPoint{Hash: invVect.Hash}
+		for i := uint32(0); i < 2; i++ {
+			prevOut.Index = i
+			entry, err := sm.chain.FetchUtxoEntry(prevOut)
+			if err != nil {
+				return false, err
+			}
+			if entry != nil && !entry.IsSpent() {
+				return true, nil
+			}
 		}
-		return entry != nil && !entry.IsFullySpent(), nil
+
+		return false, nil
 	}
 
 	// The requested inventory is is an unsupported type, so just claim
@@ -1150,7 +1201,6 @@ out:
 
 			case *blockMsg:
 				sm.handleBlockMsg(msg)
-				//sm.explore(msg)
 				msg.reply <- struct{}{}
 
 			case *invMsg:
@@ -1184,9 +1234,21 @@ out:
 					err:      nil,
 				}
 
+			case invalidateBlockMsg:
+				msg.reply <- sm.chain.InvalidateBlock(msg.hash)
+
+			case reconsiderBlockMsg:
+				msg.reply <- sm.chain.ReconsiderBlock(msg.hash)
+
+			case preciousBlockMsg:
+				msg.reply <- sm.chain.PreciousBlock(msg.hash)
+
 			case isCurrentMsg:
 				msg.reply <- sm.current()
 
+			case resumeExplorerMsg:
+				sm.resumeExplorer()
+
 			case pauseMsg:
 				// Wait until the sender unpauses the manager.
 				<-msg.unpause
@@ -1253,6 +1315,11 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 			sm.peerNotifier.AnnounceNewTransactions(acceptedTxs)
 		}
 
+		// Update the balances of the addresses the block touches.
+		if sm.balanceRepo != nil {
+			sm.exploreConnectedBlock(block)
+		}
+
 	// A block has been disconnected from the main block chain.
 	case blockchain.NTBlockDisconnected:
 		block, ok := notification.Data.(*btcutil.Block)
@@ -1273,6 +1340,12 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 				sm.txMemPool.RemoveTransaction(tx, true)
 			}
 		}
+
+		// Reverse the balance changes the block made when it was
+		// connected so the balances match the new best chain.
+		if sm.balanceRepo != nil {
+			sm.exploreDisconnectedBlock(block)
+		}
 	}
 }
 
@@ -1387,6 +1460,30 @@ func (sm *SyncManager) ProcessBlock(block *btcutil.Block, flags blockchain.Behav
 	return response.isOrphan, response.err
 }
 
+// InvalidateBlock makes use of InvalidateBlock on an internal instance of a
+// block chain.
+func (sm *SyncManager) InvalidateBlock(hash *chainhash.Hash) error {
+	reply := make(chan error, 1)
+	sm.msgChan <- invalidateBlockMsg{hash: hash, reply: reply}
+	return <-reply
+}
+
+// ReconsiderBlock makes use of ReconsiderBlock on an internal instance of a
+// block chain.
+func (sm *SyncManager) ReconsiderBlock(hash *chainhash.Hash) error {
+	reply := make(chan error, 1)
+	sm.msgChan <- reconsiderBlockMsg{hash: hash, reply: reply}
+	return <-reply
+}
+
+// PreciousBlock makes use of PreciousBlock on an internal instance of a block
+// chain.
+func (sm *SyncManager) PreciousBlock(hash *chainhash.Hash) error {
+	reply := make(chan error, 1)
+	sm.msgChan <- preciousBlockMsg{hash: hash, reply: reply}
+	return <-reply
+}
+
 // IsCurrent returns whether or not the sync manager believes it is synced with
 // the connected peers.
 func (sm *SyncManager) IsCurrent() bool {
@@ -1405,17 +1502,20 @@ func (sm *SyncManager) Pause() chan<- struct{} {
 	return c
 }
 
+// ExplorerHaltErr returns the error that caused the balance explorer to halt or
+// nil when it is running normally or disabled.  A non-nil error means address
+// balances are not being updated.
+//
+// This function is safe for concurrent access.
+func (sm *SyncManager) ExplorerHaltErr() error {
+	sm.explorerMtx.Lock()
+	defer sm.explorerMtx.Unlock()
+	return sm.explorerErr
+}
+
 // New constructs a new SyncManager. Use Start to begin processing asynchronous
 // block, tx, and inv updates.
 func New(config *Config) (*SyncManager, error) {
-	//tableRepo := data.NewAzureStorageTableRepository("devstoreaccount1", "")
-	//balanceRepo, err := data.NewAzureBalanceRepository(tableRepo, "balance")
-	//balanceRepo, err := data.NewDynamoBalanceRepository("123", "123", "balance")
-	balanceRepo, err := data.NewLevelDbBalanceRepository("D:\\LevelDB")
-	if err != nil {
-		panic(err)
-	}
-
 	sm := SyncManager{
 		peerNotifier:    config.PeerNotifier,
 		chain:           config.Chain,
@@ -1429,7 +1529,8 @@ func New(config *Config) (*SyncManager, error) {
 		msgChan:         make(chan interface{}, config.MaxPeers*3),
 		headerList:      list.New(),
 		quit:            make(chan struct{}),
-		balanceRepo:     balanceRepo,
+		balanceRepo:     config.BalanceRepo,
+		balanceIndex:    config.BalanceIndex,
 	}
 
 	best := sm.chain.BestSnapshot()
@@ -1443,6 +1544,22 @@ func New(config *Config) (*SyncManager, error) {
 		log.Info("Checkpoints are disabled")
 	}
 
+	// Bring the balance repository up to date with the best chain before
+	// any blocks are processed so the explorer is able to apply them as
+	// they are connected.  This is done before the sync manager is started
+	// since it can take a long time when the repository lags far behind.
+	// Only an interrupt aborts startup since the explorer resumes on its
+	// own after any other failure.
+	if sm.balanceRepo != nil {
+		err := sm.catchUpBalanceRepo(config.Interrupt)
+		if err == errInterruptRequested {
+			return nil, err
+		}
+		if err != nil {
+			sm.haltExplorer(err)
+		}
+	}
+
 	sm.chain.Subscribe(sm.handleBlockchainNotification)
 
 	return &sm, nil
@@ -277,3 +277,39 @@ func (b *rpcSyncMgr) SyncPeerID() int32 {
 func (b *rpcSyncMgr) LocateHeaders(locators []*chainhash.Hash, hashStop *chainhash.Hash) []wire.BlockHeader {
 	return b.server.chain.LocateHeaders(locators, hashStop)
 }
+
+// ExplorerHaltErr returns the error that caused the balance explorer to halt or
+// nil when it is running normally or disabled.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverSyncManager interface implementation.
+func (b *rpcSyncMgr) ExplorerHaltErr() error {
+	return b.syncMgr.ExplorerHaltErr()
+}
+
+// InvalidateBlock marks the block with the provided hash and its descendants as
+// invalid and reorganizes the chain away from them.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverSyncManager interface implementation.
+func (b *rpcSyncMgr) InvalidateBlock(hash *chainhash.Hash) error {
+	return b.syncMgr.InvalidateBlock(hash)
+}
+
+// ReconsiderBlock undoes the effect of InvalidateBlock on the block with the
+// provided hash and reorganizes the chain as needed.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverSyncManager interface implementation.
+func (b *rpcSyncMgr) ReconsiderBlock(hash *chainhash.Hash) error {
+	return b.syncMgr.ReconsiderBlock(hash)
+}
+
+// PreciousBlock treats the block with the provided hash as if it was received
+// before any other block with the same work.
+//
+// This function is safe for concurrent access and is part of the
+// rpcserverSyncManager interface implementation.
+func (b *rpcSyncMgr) PreciousBlock(hash *chainhash.Hash) error {
+	return b.syncMgr.PreciousBlock(hash)
+}
@@ -471,3 +471,182 @@ func (c *Client) VersionAsync() FutureVersionResult {
 func (c *Client) Version() (map[string]btcjson.VersionResult, error) {
 	return c.VersionAsync().Receive()
 }
+
+// FutureGetAddressBalanceResult is a future promise to deliver the result of a
+// GetAddressBalanceAsync RPC invocation (or an applicable error).
+type FutureGetAddressBalanceResult chan *response
+
+// Receive waits for the response promised by the future and returns the
+// confirmed and unconfirmed balance of the requested address along with the
+// block the confirmed balance is current as of.
+func (r FutureGetAddressBalanceResult) Receive() (*btcjson.GetAddressBalanceResult, error) {
+	res, err := receiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as a getaddressbalance result object.
+	var balance btcjson.GetAddressBalanceResult
+	err = json.Unmarshal(res, &balance)
+	if err != nil {
+		return nil, err
+	}
+
+	return &balance, nil
+}
+
+// GetAddressBalanceAsync returns an instance of a type that can be used to get
+// the result of the RPC at some future time by invoking the Receive function on
+// the returned instance.
+//
+// See GetAddressBalance for the blocking version and more details.
+//
+// NOTE: This is a btcd extension.
+func (c *Client) GetAddressBalanceAsync(address btcutil.Address) FutureGetAddressBalanceResult {
+	cmd := btcjson.NewGetAddressBalanceCmd(address.EncodeAddress(), nil)
+	return c.sendCmd(cmd)
+}
+
+// GetAddressBalance returns the confirmed balance of the provided address as
+// recorded by the server's balance explorer along with the change caused by the
+// unconfirmed transactions in the server's memory pool.
+//
+// NOTE: This is a btcd extension.
+func (c *Client) GetAddressBalance(address btcutil.Address) (*btcjson.GetAddressBalanceResult, error) {
+	return c.GetAddressBalanceAsync(address).Receive()
+}
+
+// GetAddressBalanceAtHeightAsync returns an instance of a type that can be used
+// to get the result of the RPC at some future time by invoking the Receive
+// function on the returned instance.
+//
+// See GetAddressBalanceAtHeight for the blocking version and more details.
+//
+// NOTE: This is a btcd extension.
+func (c *Client) GetAddressBalanceAtHeightAsync(address btcutil.Address, height int32) FutureGetAddressBalanceResult {
+	cmd := btcjson.NewGetAddressBalanceCmd(address.EncodeAddress(), &height)
+	return c.sendCmd(cmd)
+}
+
+// GetAddressBalanceAtHeight returns the confirmed balance of the provided
+// address right after the block at the provided height was connected.
+//
+// NOTE: This is a btcd extension.
+func (c *Client) GetAddressBalanceAtHeight(address btcutil.Address, height int32) (*btcjson.GetAddressBalanceResult, error) {
+	return c.GetAddressBalanceAtHeightAsync(address, height).Receive()
+}
+
+// FutureGetAddressBalanceHistoryResult is a future promise to deliver the
+// result of a GetAddressBalanceHistoryAsync RPC invocation (or an applicable
+// error).
+type FutureGetAddressBalanceHistoryResult chan *response
+
+// Receive waits for the response promised by the future and returns the
+// changes to the balance of the requested address.
+func (r FutureGetAddressBalanceHistoryResult) Receive() ([]btcjson.BalanceChangeResult, error) {
+	res, err := receiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as an array of getaddressbalancehistory result
+	// objects.
+	var changes []btcjson.BalanceChangeResult
+	err = json.Unmarshal(res, &changes)
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// GetAddressBalanceHistoryAsync returns an instance of a type that can be used
+// to get the result of the RPC at some future time by invoking the Receive
+// function on the returned instance.
+//
+// See GetAddressBalanceHistory for the blocking version and more details.
+//
+// NOTE: This is a btcd extension.
+func (c *Client) GetAddressBalanceHistoryAsync(address btcutil.Address, fromHeight, toHeight int32) FutureGetAddressBalanceHistoryResult {
+	cmd := btcjson.NewGetAddressBalanceHistoryCmd(address.EncodeAddress(),
+		&fromHeight, &toHeight)
+	return c.sendCmd(cmd)
+}
+
+// GetAddressBalanceHistory returns every change to the confirmed balance of the
+// provided address in blocks fromHeight through toHeight, inclusive, in chain
+// order along with the running balance after each change.
+//
+// NOTE: This is a btcd extension.
+func (c *Client) GetAddressBalanceHistory(address btcutil.Address, fromHeight, toHeight int32) ([]btcjson.BalanceChangeResult, error) {
+	return c.GetAddressBalanceHistoryAsync(address, fromHeight,
+		toHeight).Receive()
+}
+
+// FutureListTopBalancesResult is a future promise to deliver the result of a
+// ListTopBalancesAsync or ListTopBalancesMinAsync RPC invocation (or an
+// applicable error).
+type FutureListTopBalancesResult chan *response
+
+// Receive waits for the response promised by the future and returns the
+// addresses with the largest confirmed balances.
+func (r FutureListTopBalancesResult) Receive() ([]btcjson.TopBalanceResult, error) {
+	res, err := receiveFuture(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal result as an array of listtopbalances result objects.
+	var balances []btcjson.TopBalanceResult
+	err = json.Unmarshal(res, &balances)
+	if err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
+
+// ListTopBalancesAsync returns an instance of a type that can be used to get
+// the result of the RPC at some future time by invoking the Receive function on
+// the returned instance.
+//
+// See ListTopBalances for the blocking version and more details.
+//
+// NOTE: This is a btcd extension.
+func (c *Client) ListTopBalancesAsync(count int) FutureListTopBalancesResult {
+	cmd := btcjson.NewListTopBalancesCmd(count, nil)
+	return c.sendCmd(cmd)
+}
+
+// ListTopBalances returns up to count addresses with the largest confirmed
+// balances as recorded by the server's balance explorer, ordered from the
+// largest balance.
+//
+// See ListTopBalancesMin to only include addresses holding at least a given
+// amount.
+//
+// NOTE: This is a btcd extension.
+func (c *Client) ListTopBalances(count int) ([]btcjson.TopBalanceResult, error) {
+	return c.ListTopBalancesAsync(count).Receive()
+}
+
+// ListTopBalancesMinAsync returns an instance of a type that can be used to get
+// the result of the RPC at some future time by invoking the Receive function on
+// the returned instance.
+//
+// See ListTopBalancesMin for the blocking version and more details.
+//
+// NOTE: This is a btcd extension.
+func (c *Client) ListTopBalancesMinAsync(count int, minBalance btcutil.Amount) FutureListTopBalancesResult {
+	cmd := btcjson.NewListTopBalancesCmd(count,
+		btcjson.Float64(minBalance.ToBTC()))
+	return c.sendCmd(cmd)
+}
+
+// ListTopBalancesMin returns up to count addresses with a confirmed balance of
+// at least minBalance, ordered from the largest balance.
+//
+// NOTE: This is a btcd extension.
+func (c *Client) ListTopBalancesMin(count int, minBalance btcutil.Amount) ([]btcjson.TopBalanceResult, error) {
+	return c.ListTopBalancesMinAsync(count, minBalance).Receive()
+}
@@ -258,6 +258,11 @@ func (c *Client) trackRegisteredNtfns(cmd interface{}) {
 		for _, addr := range bcmd.Addresses {
 			c.ntfnState.notifyReceived[addr] = struct{}{}
 		}
+
+	case *btcjson.NotifyBalanceChangesCmd:
+		for _, addr := range bcmd.Addresses {
+			c.ntfnState.notifyBalances[addr] = struct{}{}
+		}
 	}
 }
 
@@ -549,6 +554,22 @@ func (c *Client) reregisterNtfns() error {
 		}
 	}
 
+	// Reregister the combination of all previously registered
+	// notifybalancechanges addresses in one command if needed.
+	nblen := len(stateCopy.notifyBalances)
+	if nblen > 0 {
+		addresses := make([]string, 0, nblen)
+		for addr := range stateCopy.notifyBalances {
+			addresses = append(addresses, addr)
+		}
+		log.Debugf("Reregistering [notifybalancechanges] addresses: %v",
+			addresses)
+		err := c.notifyBalanceChangesInternal(addresses).Receive()
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
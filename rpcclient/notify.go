@@ -37,6 +37,7 @@ type notificationState struct {
 	notifyNewTxVerbose bool
 	notifyReceived     map[string]struct{}
 	notifySpent        map[btcjson.OutPoint]struct{}
+	notifyBalances     map[string]struct{}
 }
 
 // Copy returns a deep copy of the receiver.
@@ -53,6 +54,10 @@ func (s *notificationState) Copy() *notificationState {
 	for op := range s.notifySpent {
 		stateCopy.notifySpent[op] = struct{}{}
 	}
+	stateCopy.notifyBalances = make(map[string]struct{})
+	for addr := range s.notifyBalances {
+		stateCopy.notifyBalances[addr] = struct{}{}
+	}
 
 	return &stateCopy
 }
@@ -62,6 +67,7 @@ func newNotificationState() *notificationState {
 	return &notificationState{
 		notifyReceived: make(map[string]struct{}),
 		notifySpent:    make(map[btcjson.OutPoint]struct{}),
+		notifyBalances: make(map[string]struct{}),
 	}
 }
 
@@ -179,6 +185,15 @@ type NotificationHandlers struct {
 	// made to register for the notification and the function is non-nil.
 	OnTxAcceptedVerbose func(txDetails *btcjson.TxRawResult)
 
+	// OnBalanceChanged is invoked when the balance explorer applies a block
+	// that changes the balance of an address, or reverses such a block
+	// during a reorganize, in which case the hash and height are the ones
+	// of the disconnected block.  It will only be invoked if a preceding
+	// call to NotifyBalanceChanges has been made to register for the
+	// notification and the function is non-nil.
+	OnBalanceChanged func(address string, oldBalance, newBalance,
+		delta btcutil.Amount, blockHash *chainhash.Hash, height int32)
+
 	// OnBtcdConnected is invoked when a wallet connects or disconnects from
 	// btcd.
 	//
@@ -341,6 +356,25 @@ func (c *Client) handleNotification(ntfn *rawNotification) {
 
 		c.ntfnHandlers.OnRelevantTxAccepted(transaction)
 
+	// OnBalanceChanged
+	case btcjson.BalanceChangedNtfnMethod:
+		// Ignore the notification if the client is not interested in
+		// it.
+		if c.ntfnHandlers.OnBalanceChanged == nil {
+			return
+		}
+
+		address, oldBalance, newBalance, delta, blockHash, height, err :=
+			parseBalanceChangedNtfnParams(ntfn.Params)
+		if err != nil {
+			log.Warnf("Received invalid balancechanged "+
+				"notification: %v", err)
+			return
+		}
+
+		c.ntfnHandlers.OnBalanceChanged(address, oldBalance, newBalance,
+			delta, blockHash, height)
+
 	// OnRescanFinished
 	case btcjson.RescanFinishedNtfnMethod:
 		// Ignore the notification if the client is not interested in
@@ -634,6 +668,61 @@ func parseRelevantTxAcceptedParams(params []json.RawMessage) (transaction []byte
 	return parseHexParam(params[0])
 }
 
+// parseBalanceChangedNtfnParams parses out the address, old and new balance,
+// balance delta, block hash and block height from the parameters of a
+// balancechanged notification.
+func parseBalanceChangedNtfnParams(params []json.RawMessage) (string,
+	btcutil.Amount, btcutil.Amount, btcutil.Amount, *chainhash.Hash, int32,
+	error) {
+
+	if len(params) != 6 {
+		return "", 0, 0, 0, nil, 0, wrongNumParams(len(params))
+	}
+
+	// Unmarshal first parameter as a string.
+	var address string
+	err := json.Unmarshal(params[0], &address)
+	if err != nil {
+		return "", 0, 0, 0, nil, 0, err
+	}
+
+	// Unmarshal the second through fourth parameters as floats and
+	// convert them to amounts.
+	var amounts [3]btcutil.Amount
+	for i := range amounts {
+		var value float64
+		err = json.Unmarshal(params[i+1], &value)
+		if err != nil {
+			return "", 0, 0, 0, nil, 0, err
+		}
+		amounts[i], err = btcutil.NewAmount(value)
+		if err != nil {
+			return "", 0, 0, 0, nil, 0, err
+		}
+	}
+
+	// Unmarshal fifth parameter as a string and create a hash from it.
+	var blockHashStr string
+	err = json.Unmarshal(params[4], &blockHashStr)
+	if err != nil {
+		return "", 0, 0, 0, nil, 0, err
+	}
+	blockHash, err := chainhash.NewHashFromStr(blockHashStr)
+	if err != nil {
+		return "", 0, 0, 0, nil, 0, err
+	}
+
+	// Unmarshal sixth parameter as an integer.
+	var height int32
+	err = json.Unmarshal(params[5], &height)
+	if err != nil {
+		return "", 0, 0, 0, nil, 0, err
+	}
+
+	return address, amounts[0], amounts[1], amounts[2], blockHash, height,
+		nil
+}
+
 // parseChainTxNtfnParams parses out the transaction and optional details about
 // the block it's mined in from the parameters of recvtx and redeemingtx
 // notifications.
@@ -1123,6 +1212,65 @@ func (c *Client) NotifyReceived(addresses []btcutil.Address) error {
 	return c.NotifyReceivedAsync(addresses).Receive()
 }
 
+// FutureNotifyBalanceChangesResult is a future promise to deliver the result of
+// a NotifyBalanceChangesAsync RPC invocation (or an applicable error).
+type FutureNotifyBalanceChangesResult chan *response
+
+// Receive waits for the response promised by the future and returns an error
+// if the registration was not successful.
+func (r FutureNotifyBalanceChangesResult) Receive() error {
+	_, err := receiveFuture(r)
+	return err
+}
+
+// notifyBalanceChangesInternal is the same as NotifyBalanceChangesAsync except
+// it accepts the converted addresses as a parameter so the client can more
+// efficiently recreate the previous notification state on reconnect.
+func (c *Client) notifyBalanceChangesInternal(addresses []string) FutureNotifyBalanceChangesResult {
+	// Not supported in HTTP POST mode.
+	if c.config.HTTPPostMode {
+		return newFutureError(ErrWebsocketsRequired)
+	}
+
+	// Ignore the notification if the client is not interested in
+	// notifications.
+	if c.ntfnHandlers == nil {
+		return newNilFutureResult()
+	}
+
+	cmd := btcjson.NewNotifyBalanceChangesCmd(addresses)
+	return c.sendCmd(cmd)
+}
+
+// NotifyBalanceChangesAsync returns an instance of a type that can be used to
+// get the result of the RPC at some future time by invoking the Receive
+// function on the returned instance.
+//
+// See NotifyBalanceChanges for the blocking version and more details.
+//
+// NOTE: This is a btcd extension and requires a websocket connection.
+func (c *Client) NotifyBalanceChangesAsync(addresses []btcutil.Address) FutureNotifyBalanceChangesResult {
+	// Convert addresses to strings.
+	addrs := make([]string, 0, len(addresses))
+	for _, addr := range addresses {
+		addrs = append(addrs, addr.String())
+	}
+	return c.notifyBalanceChangesInternal(addrs)
+}
+
+// NotifyBalanceChanges registers the client to receive notifications every time
+// the balance explorer of the server applies a block that changes the balance
+// of one of the passed addresses, or reverses such a block during a
+// reorganize.  The notifications are delivered to the OnBalanceChanged
+// notification handler associated with the client.  Calling this function has
+// no effect if there are no notification handlers and will result in an error
+// if the client is configured to run in HTTP POST mode.
+//
+// NOTE: This is a btcd extension and requires a websocket connection.
+func (c *Client) NotifyBalanceChanges(addresses []btcutil.Address) error {
+	return c.NotifyBalanceChangesAsync(addresses).Receive()
+}
+
 // FutureRescanResult is a future promise to deliver the result of a RescanAsync
 // or RescanEndHeightAsync RPC invocation (or an applicable error).
 //
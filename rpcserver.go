@@ -21,6 +21,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -33,6 +34,7 @@ import (
 	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/data"
 	"github.com/btcsuite/btcd/database"
 	"github.com/btcsuite/btcd/mempool"
 	"github.com/btcsuite/btcd/mining"
@@ -127,49 +129,57 @@ type commandHandler func(*rpcServer, interface{}, <-chan struct{}) (interface{},
 // a dependency loop.
 var rpcHandlers map[string]commandHandler
 var rpcHandlersBeforeInit = map[string]commandHandler{
-	"addnode":               handleAddNode,
-	"createrawtransaction":  handleCreateRawTransaction,
-	"debuglevel":            handleDebugLevel,
-	"decoderawtransaction":  handleDecodeRawTransaction,
-	"decodescript":          handleDecodeScript,
-	"generate":              handleGenerate,
-	"getaddednodeinfo":      handleGetAddedNodeInfo,
-	"getbestblock":          handleGetBestBlock,
-	"getbestblockhash":      handleGetBestBlockHash,
-	"getblock":              handleGetBlock,
-	"getblockchaininfo":     handleGetBlockChainInfo,
-	"getblockcount":         handleGetBlockCount,
-	"getblockhash":          handleGetBlockHash,
-	"getblockheader":        handleGetBlockHeader,
-	"getblocktemplate":      handleGetBlockTemplate,
-	"getconnectioncount":    handleGetConnectionCount,
-	"getcurrentnet":         handleGetCurrentNet,
-	"getdifficulty":         handleGetDifficulty,
-	"getgenerate":           handleGetGenerate,
-	"gethashespersec":       handleGetHashesPerSec,
-	"getheaders":            handleGetHeaders,
-	"getinfo":               handleGetInfo,
-	"getmempoolinfo":        handleGetMempoolInfo,
-	"getmininginfo":         handleGetMiningInfo,
-	"getnettotals":          handleGetNetTotals,
-	"getnetworkhashps":      handleGetNetworkHashPS,
-	"getpeerinfo":           handleGetPeerInfo,
-	"getrawmempool":         handleGetRawMempool,
-	"getrawtransaction":     handleGetRawTransaction,
-	"gettxout":              handleGetTxOut,
-	"help":                  handleHelp,
-	"node":                  handleNode,
-	"ping":                  handlePing,
-	"searchrawtransactions": handleSearchRawTransactions,
-	"sendrawtransaction":    handleSendRawTransaction,
-	"setgenerate":           handleSetGenerate,
-	"stop":                  handleStop,
-	"submitblock":           handleSubmitBlock,
-	"uptime":                handleUptime,
-	"validateaddress":       handleValidateAddress,
-	"verifychain":           handleVerifyChain,
-	"verifymessage":         handleVerifyMessage,
-	"version":               handleVersion,
+	"addnode":                  handleAddNode,
+	"createrawtransaction":     handleCreateRawTransaction,
+	"debuglevel":               handleDebugLevel,
+	"decoderawtransaction":     handleDecodeRawTransaction,
+	"decodescript":             handleDecodeScript,
+	"dumpbalances":             handleDumpBalances,
+	"generate":                 handleGenerate,
+	"getaddednodeinfo":         handleGetAddedNodeInfo,
+	"getaddressbalance":        handleGetAddressBalance,
+	"getaddressbalancehistory": handleGetAddressBalanceHistory,
+	"getbestblock":             handleGetBestBlock,
+	"getbestblockhash":         handleGetBestBlockHash,
+	"getblock":                 handleGetBlock,
+	"getblockchaininfo":        handleGetBlockChainInfo,
+	"getblockcount":            handleGetBlockCount,
+	"getblockhash":             handleGetBlockHash,
+	"getblockheader":           handleGetBlockHeader,
+	"getblocktemplate":         handleGetBlockTemplate,
+	"getchaintips":             handleGetChainTips,
+	"getconnectioncount":       handleGetConnectionCount,
+	"getcurrentnet":            handleGetCurrentNet,
+	"getdifficulty":            handleGetDifficulty,
+	"getgenerate":              handleGetGenerate,
+	"gethashespersec":          handleGetHashesPerSec,
+	"getheaders":               handleGetHeaders,
+	"getinfo":                  handleGetInfo,
+	"getmempoolinfo":           handleGetMempoolInfo,
+	"getmininginfo":            handleGetMiningInfo,
+	"getnettotals":             handleGetNetTotals,
+	"getnetworkhashps":         handleGetNetworkHashPS,
+	"getpeerinfo":              handleGetPeerInfo,
+	"getrawmempool":            handleGetRawMempool,
+	"getrawtransaction":        handleGetRawTransaction,
+	"gettxout":                 handleGetTxOut,
+	"help":                     handleHelp,
+	"invalidateblock":          handleInvalidateBlock,
+	"listtopbalances":          handleListTopBalances,
+	"node":                     handleNode,
+	"ping":                     handlePing,
+	"preciousblock":            handlePreciousBlock,
+	"reconsiderblock":          handleReconsiderBlock,
+	"searchrawtransactions":    handleSearchRawTransactions,
+	"sendrawtransaction":       handleSendRawTransaction,
+	"setgenerate":              handleSetGenerate,
+	"stop":                     handleStop,
+	"submitblock":              handleSubmitBlock,
+	"uptime":                   handleUptime,
+	"validateaddress":          handleValidateAddress,
+	"verifychain":              handleVerifyChain,
+	"verifymessage":            handleVerifyMessage,
+	"version":                  handleVersion,
 }
 
 // list of commands that we recognize, but for which btcd has no support because
@@ -224,13 +234,9 @@ var rpcAskWallet = map[string]struct{}{
 var rpcUnimplemented = map[string]struct{}{
 	"estimatefee":      {},
 	"estimatepriority": {},
-	"getchaintips":     {},
 	"getmempoolentry":  {},
 	"getnetworkinfo":   {},
 	"getwork":          {},
-	"invalidateblock":  {},
-	"preciousblock":    {},
-	"reconsiderblock":  {},
 }
 
 // Commands that are available to a limited user
@@ -258,6 +264,7 @@ var rpcLimited = map[string]struct{}{
 	"getblockcount":         {},
 	"getblockhash":          {},
 	"getblockheader":        {},
+	"getchaintips":          {},
 	"getcurrentnet":         {},
 	"getdifficulty":         {},
 	"getheaders":            {},
@@ -849,6 +856,193 @@ func handleDecodeScript(s *rpcServer, cmd interface{}, closeChan <-chan struct{}
 	return reply, nil
 }
 
+// dumpBalanceClasses are the address types the balances written by
+// dumpbalances can be filtered by.
+var dumpBalanceClasses = map[string]struct{}{
+	txscript.PubKeyHashTy.String():          {},
+	txscript.ScriptHashTy.String():          {},
+	txscript.PubKeyTy.String():              {},
+	txscript.WitnessV0PubKeyHashTy.String(): {},
+	txscript.WitnessV0ScriptHashTy.String(): {},
+}
+
+// handleDumpBalances implements the dumpbalances command.
+func handleDumpBalances(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	balanceRepo := s.cfg.BalanceRepo
+	if balanceRepo == nil {
+		return nil, errBalanceExplorerDisabled
+	}
+
+	c := cmd.(*btcjson.DumpBalancesCmd)
+	format := data.DumpFormatCSV
+	if c.Format != nil {
+		format = *c.Format
+	}
+	if format != data.DumpFormatCSV && format != data.DumpFormatJSONL {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "Format must be csv or jsonl",
+		}
+	}
+	var minBalance btcutil.Amount
+	if c.MinBalance != nil {
+		var err error
+		minBalance, err = btcutil.NewAmount(*c.MinBalance)
+		if err != nil || minBalance < 0 {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: "Invalid minimum balance",
+			}
+		}
+	}
+	if c.AddressType != nil {
+		if _, ok := dumpBalanceClasses[*c.AddressType]; !ok {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: "Unknown address type " + *c.AddressType,
+			}
+		}
+	}
+
+	// Pin the snapshot to the current tip of the repository.  The explorer
+	// keeps applying blocks while the balances are written, so every
+	// balance is looked up as of the pinned height in the balance history.
+	tipHash, tipHeight, err := balanceRepo.Tip()
+	if err != nil {
+		context := "Failed to load balance repository tip"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	if tipHash == nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCMisc,
+			Message: "Balance repository is empty",
+		}
+	}
+	// The file is always created in the balance dump directory, so only
+	// plain file names are accepted, and an existing file is never
+	// overwritten since the name is chosen by the client.
+	name := c.Filename
+	if name == "" || name == "." || name == ".." ||
+		name != filepath.Base(name) {
+
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: "Filename must be a file name without a " +
+				"directory",
+		}
+	}
+	if err := os.MkdirAll(s.cfg.BalanceDumpDir, 0700); err != nil {
+		context := "Failed to create balance dump directory"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	filename := filepath.Join(s.cfg.BalanceDumpDir, name)
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+		0600)
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCMisc,
+			Message: "Unable to create file: " + err.Error(),
+		}
+	}
+
+	rpcsLog.Infof("Dumping balances as of block %v (height %d) to %s",
+		tipHash, tipHeight, filename)
+	count, untracked, err := dumpBalances(s, file, format, tipHash,
+		tipHeight, int64(minBalance), c.AddressType, closeChan)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename)
+		if rpcErr, ok := err.(*btcjson.RPCError); ok {
+			return nil, rpcErr
+		}
+		context := "Failed to dump balances"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
+	if untracked != 0 {
+		rpcsLog.Warnf("The balance dump holds %d balances recorded "+
+			"before the height of their last change was tracked, "+
+			"their first and last seen heights are unknown", untracked)
+	}
+
+	return &btcjson.DumpBalancesResult{
+		Filename:  filename,
+		Format:    format,
+		Hash:      tipHash.String(),
+		Height:    tipHeight,
+		Count:     count,
+		Untracked: untracked,
+	}, nil
+}
+
+// dumpBalances writes the balances in the repository as of the passed height,
+// which must be the one of the repository tip when the dump is started, to w and
+// returns the number of balances written along with how many of them are
+// untracked.  Only non-zero balances of at least minBalance and of the passed
+// address type, if any, are written.  The balances are streamed from the
+// repository, so the dump doesn't need to fit into memory.
+//
+// Untracked balances are written as is since any change after the dump was
+// started would have recorded the height of the change, but their first and
+// last seen heights are unknown.
+func dumpBalances(s *rpcServer, w io.Writer, format string, hash *chainhash.Hash,
+	height int32, minBalance int64, addressType *string,
+	closeChan <-chan struct{}) (int64, int64, error) {
+
+	dumpWriter, err := data.NewBalanceDumpWriter(w, format, hash, height)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var visited, count, untracked int64
+	err = s.cfg.BalanceRepo.ForEachBalance(func(balance *data.Balance) error {
+		// Stop when the client disconnects.
+		visited++
+		if visited%1000 == 0 {
+			select {
+			case <-closeChan:
+				return &btcjson.RPCError{
+					Code:    btcjson.ErrRPCMisc,
+					Message: "Balance dump interrupted",
+				}
+			default:
+			}
+		}
+
+		class := s.cfg.BalanceKeys.KeyClass(balance.PublicKey).String()
+		if addressType != nil && class != *addressType {
+			return nil
+		}
+		isUntracked := balance.IsUntracked()
+		if !isUntracked {
+			var err error
+			balance, err = data.BalanceAsOf(s.cfg.BalanceRepo,
+				balance.PublicKey, balance, height)
+			if err != nil {
+				return err
+			}
+		}
+		if balance.Value == 0 || balance.Value < minBalance {
+			return nil
+		}
+		if err := dumpWriter.Write(balance, class); err != nil {
+			return err
+		}
+		count++
+		if isUntracked {
+			untracked++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return count, untracked, dumpWriter.Flush()
+}
+
 // handleGenerate handles generate commands.
 func handleGenerate(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// Respond with an error if there are no addresses to pay the
@@ -993,6 +1187,199 @@ func handleGetAddedNodeInfo(s *rpcServer, cmd interface{}, closeChan <-chan stru
 	return results, nil
 }
 
+// errBalanceExplorerDisabled is returned by the balance commands when the
+// balance explorer is not enabled.
+var errBalanceExplorerDisabled = &btcjson.RPCError{
+	Code:    btcjson.ErrRPCMisc,
+	Message: "Balance explorer must be enabled (--balancebackend)",
+}
+
+// balanceKey decodes the passed address and returns the key the balance
+// explorer records its balance under.
+func balanceKey(s *rpcServer, address string) (string, error) {
+	addr, err := btcutil.DecodeAddress(address, s.cfg.ChainParams)
+	if err != nil {
+		return "", &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Invalid address or key: " + err.Error(),
+		}
+	}
+	publicKey, err := s.cfg.BalanceKeys.AddressKey(addr)
+	if err != nil {
+		return "", &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Invalid address or key: " + err.Error(),
+		}
+	}
+	return publicKey, nil
+}
+
+// handleGetAddressBalance implements the getaddressbalance command.
+func handleGetAddressBalance(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	balanceRepo := s.cfg.BalanceRepo
+	if balanceRepo == nil {
+		return nil, errBalanceExplorerDisabled
+	}
+
+	c := cmd.(*btcjson.GetAddressBalanceCmd)
+	publicKey, err := balanceKey(s, c.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the tip first so the reported block is never newer than the
+	// balance.
+	tipHash, tipHeight, err := balanceRepo.Tip()
+	if err != nil {
+		context := "Failed to load balance repository tip"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
+	balance, err := balanceRepo.Get(publicKey)
+	if err != nil {
+		context := "Failed to load balance"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
+	// Look up the balance as of the requested height from the balance
+	// history when one is specified.
+	if c.Height != nil {
+		height := *c.Height
+		if height < 0 || height > tipHeight {
+			return nil, &btcjson.RPCError{
+				Code: btcjson.ErrRPCOutOfRange,
+				Message: fmt.Sprintf("Height %d is out of range "+
+					"[0, %d]", height, tipHeight),
+			}
+		}
+
+		// The hash of the requested height is taken from the main
+		// chain, so it only matches the balance history when the
+		// explorer is not behind a reorganization.
+		if !s.cfg.Chain.MainChainHasBlock(tipHash) {
+			return nil, &btcjson.RPCError{
+				Code: btcjson.ErrRPCMisc,
+				Message: "Balance repository tip is not in the " +
+					"main chain",
+			}
+		}
+		hash, err := s.cfg.Chain.BlockHashByHeight(height)
+		if err != nil {
+			context := "Failed to load block hash"
+			return nil, internalRPCError(err.Error(), context)
+		}
+		balance, err := data.BalanceAsOf(balanceRepo, publicKey,
+			balance, height)
+		if err == data.ErrUntrackedBalance {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCMisc,
+				Message: err.Error(),
+			}
+		}
+		if err != nil {
+			context := "Failed to load balance"
+			return nil, internalRPCError(err.Error(), context)
+		}
+
+		return &btcjson.GetAddressBalanceResult{
+			Address:   c.Address,
+			Balance:   btcutil.Amount(balance.Value).ToBTC(),
+			Total:     btcutil.Amount(balance.Value).ToBTC(),
+			Received:  btcutil.Amount(balance.Received).ToBTC(),
+			Sent:      btcutil.Amount(balance.Sent).ToBTC(),
+			TxCount:   balance.TxCount,
+			FirstSeen: balance.FirstSeen,
+			LastSeen:  balance.LastSeen,
+			Hash:      hash.String(),
+			Height:    height,
+		}, nil
+	}
+
+	if balance == nil {
+		balance = &data.Balance{PublicKey: publicKey}
+	}
+	var unconfirmed int64
+	if s.cfg.UnconfirmedBalances != nil {
+		unconfirmed = s.cfg.UnconfirmedBalances.Balance(publicKey)
+	}
+
+	confirmed := balance.Value
+	result := &btcjson.GetAddressBalanceResult{
+		Address:     c.Address,
+		Balance:     btcutil.Amount(confirmed).ToBTC(),
+		Unconfirmed: btcutil.Amount(unconfirmed).ToBTC(),
+		Total:       btcutil.Amount(confirmed + unconfirmed).ToBTC(),
+		Received:    btcutil.Amount(balance.Received).ToBTC(),
+		Sent:        btcutil.Amount(balance.Sent).ToBTC(),
+		TxCount:     balance.TxCount,
+		FirstSeen:   balance.FirstSeen,
+		LastSeen:    balance.LastSeen,
+		Height:      tipHeight,
+	}
+	if tipHash != nil {
+		result.Hash = tipHash.String()
+	}
+	return result, nil
+}
+
+// handleGetAddressBalanceHistory implements the getaddressbalancehistory
+// command.
+func handleGetAddressBalanceHistory(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	balanceRepo := s.cfg.BalanceRepo
+	if balanceRepo == nil {
+		return nil, errBalanceExplorerDisabled
+	}
+
+	c := cmd.(*btcjson.GetAddressBalanceHistoryCmd)
+	publicKey, err := balanceKey(s, c.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Default to the tip of the balance repository when no end height is
+	// specified.
+	var fromHeight, toHeight int32
+	if c.FromHeight != nil {
+		fromHeight = *c.FromHeight
+	}
+	if c.ToHeight != nil {
+		toHeight = *c.ToHeight
+	} else {
+		_, toHeight, err = balanceRepo.Tip()
+		if err != nil {
+			context := "Failed to load balance repository tip"
+			return nil, internalRPCError(err.Error(), context)
+		}
+	}
+	if fromHeight < 0 || toHeight < fromHeight {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Invalid height range [%d, %d]",
+				fromHeight, toHeight),
+		}
+	}
+
+	history, err := balanceRepo.BalanceHistory(publicKey, fromHeight,
+		toHeight)
+	if err != nil {
+		context := "Failed to load balance history"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
+	results := make([]btcjson.BalanceChangeResult, 0, len(history))
+	for _, entry := range history {
+		results = append(results, btcjson.BalanceChangeResult{
+			Height:   entry.Height,
+			TxID:     entry.TxHash.String(),
+			Delta:    btcutil.Amount(entry.Delta).ToBTC(),
+			Received: btcutil.Amount(entry.Received).ToBTC(),
+			Sent:     btcutil.Amount(entry.Sent).ToBTC(),
+			Balance:  btcutil.Amount(entry.Balance).ToBTC(),
+		})
+	}
+	return results, nil
+}
+
 // handleGetBestBlock implements the getbestblock command.
 func handleGetBestBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// All other "get block" commands give either the height, the
@@ -1169,7 +1556,8 @@ func handleGetBlockChainInfo(s *rpcServer, cmd interface{}, closeChan <-chan str
 		BestBlockHash: chainSnapshot.Hash.String(),
 		Difficulty:    getDifficultyRatio(chainSnapshot.Bits, params),
 		MedianTime:    chainSnapshot.MedianTime.Unix(),
-		Pruned:        false,
+		Pruned:        cfg.Prune != 0,
+		PruneHeight:   chain.PruneHeight(),
 		Bip9SoftForks: make(map[string]*btcjson.Bip9SoftForkDescription),
 	}
 
@@ -2052,6 +2440,8 @@ func chainErrToGBTErrString(err error) string {
 		return "bad-prevblk"
 	case blockchain.ErrPrevBlockNotBest:
 		return "inconclusive-not-best-prvblk"
+	case blockchain.ErrInvalidatedBlock:
+		return "duplicate-invalid"
 	}
 
 	return "rejected: " + err.Error()
@@ -2144,6 +2534,45 @@ func handleGetBlockTemplate(s *rpcServer, cmd interface{}, closeChan <-chan stru
 	}
 }
 
+// chainTipStatusString returns the status of the passed chain tip as expected
+// by the getchaintips command.
+func chainTipStatusString(status blockchain.ChainTipStatus) (string, error) {
+	switch status {
+	case blockchain.ChainTipActive:
+		return "active", nil
+	case blockchain.ChainTipValidFork:
+		return "valid-fork", nil
+	case blockchain.ChainTipValidHeaders:
+		return "valid-headers", nil
+	case blockchain.ChainTipHeadersOnly:
+		return "headers-only", nil
+	case blockchain.ChainTipInvalid:
+		return "invalid", nil
+	default:
+		return "", fmt.Errorf("unknown chain tip status: %v", status)
+	}
+}
+
+// handleGetChainTips implements the getchaintips command.
+func handleGetChainTips(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	tips := s.cfg.Chain.ChainTips()
+	results := make([]btcjson.GetChainTipsResult, 0, len(tips))
+	for _, tip := range tips {
+		status, err := chainTipStatusString(tip.Status)
+		if err != nil {
+			context := "Failed to convert chain tip status"
+			return nil, internalRPCError(err.Error(), context)
+		}
+		results = append(results, btcjson.GetChainTipsResult{
+			Height:    tip.Height,
+			Hash:      tip.Hash.String(),
+			BranchLen: tip.BranchLen,
+			Status:    status,
+		})
+	}
+	return results, nil
+}
+
 // handleGetConnectionCount implements the getconnectioncount command.
 func handleGetConnectionCount(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	return s.cfg.ConnMgr.ConnectedCount(), nil
@@ -2227,6 +2656,12 @@ func handleGetInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (in
 		RelayFee:        cfg.minRelayTxFee.ToBTC(),
 	}
 
+	// Warn about the balance explorer being halted since it means address
+	// balances are out of date.
+	if err := s.cfg.SyncMgr.ExplorerHaltErr(); err != nil {
+		ret.Errors = fmt.Sprintf("Balance explorer halted: %v", err)
+	}
+
 	return ret, nil
 }
 
@@ -2580,7 +3015,6 @@ func handleGetTxOut(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 	// from there, otherwise attempt to fetch from the block database.
 	var bestBlockHash string
 	var confirmations int32
-	var txVersion int32
 	var value int64
 	var pkScript []byte
 	var isCoinbase bool
@@ -2615,12 +3049,12 @@ func handleGetTxOut(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 		best := s.cfg.Chain.BestSnapshot()
 		bestBlockHash = best.Hash.String()
 		confirmations = 0
-		txVersion = mtx.Version
 		value = txOut.Value
 		pkScript = txOut.PkScript
 		isCoinbase = blockchain.IsCoinBaseTx(mtx)
 	} else {
-		entry, err := s.cfg.Chain.FetchUtxoEntry(txHash)
+		out := wire.OutPoint{Hash: *txHash, Index: c.Vout}
+		entry, err := s.cfg.Chain.FetchUtxoEntry(out)
 		if err != nil {
 			return nil, rpcNoTxInfoError(txHash)
 		}
@@ -2630,16 +3064,15 @@ func handleGetTxOut(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 		// transaction already in the main chain.  Mined transactions
 		// that are spent by a mempool transaction are not affected by
 		// this.
-		if entry == nil || entry.IsOutputSpent(c.Vout) {
+		if entry == nil || entry.IsSpent() {
 			return nil, nil
 		}
 
 		best := s.cfg.Chain.BestSnapshot()
 		bestBlockHash = best.Hash.String()
 		confirmations = 1 + best.Height - entry.BlockHeight()
-		txVersion = entry.Version()
-		value = entry.AmountByIndex(c.Vout)
-		pkScript = entry.PkScriptByIndex(c.Vout)
+		value = entry.Amount()
+		pkScript = entry.PkScript()
 		isCoinbase = entry.IsCoinBase()
 	}
 
@@ -2662,7 +3095,6 @@ func handleGetTxOut(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 		BestBlock:     bestBlockHash,
 		Confirmations: int64(confirmations),
 		Value:         btcutil.Amount(value).ToBTC(),
-		Version:       txVersion,
 		ScriptPubKey: btcjson.ScriptPubKeyResult{
 			Asm:       disbuf,
 			Hex:       hex.EncodeToString(pkScript),
@@ -2714,6 +3146,72 @@ func handleHelp(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (inter
 	return help, nil
 }
 
+// handleInvalidateBlock implements the invalidateblock command.
+func handleInvalidateBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.InvalidateBlockCmd)
+
+	hash, err := chainhash.NewHashFromStr(c.BlockHash)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.BlockHash)
+	}
+	if _, err := s.cfg.Chain.FetchHeader(hash); err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCBlockNotFound,
+			Message: "Block not found",
+		}
+	}
+	if err := s.cfg.SyncMgr.InvalidateBlock(hash); err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCMisc,
+			Message: "Failed to invalidate block: " + err.Error(),
+		}
+	}
+
+	return nil, nil
+}
+
+// handleListTopBalances implements the listtopbalances command.
+func handleListTopBalances(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	balanceRepo := s.cfg.BalanceRepo
+	if balanceRepo == nil {
+		return nil, errBalanceExplorerDisabled
+	}
+
+	c := cmd.(*btcjson.ListTopBalancesCmd)
+	if c.Count <= 0 {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "Count must be greater than zero",
+		}
+	}
+	var minBalance btcutil.Amount
+	if c.MinBalance != nil {
+		var err error
+		minBalance, err = btcutil.NewAmount(*c.MinBalance)
+		if err != nil || minBalance < 0 {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: "Invalid minimum balance",
+			}
+		}
+	}
+
+	balances, err := balanceRepo.TopBalances(c.Count, int64(minBalance))
+	if err != nil {
+		context := "Failed to load top balances"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
+	results := make([]btcjson.TopBalanceResult, 0, len(balances))
+	for _, balance := range balances {
+		results = append(results, btcjson.TopBalanceResult{
+			Address: balance.PublicKey,
+			Balance: btcutil.Amount(balance.Value).ToBTC(),
+		})
+	}
+	return results, nil
+}
+
 // handlePing implements the ping command.
 func handlePing(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// Ask server to ping \o_
@@ -2727,6 +3225,30 @@ func handlePing(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (inter
 	return nil, nil
 }
 
+// handlePreciousBlock implements the preciousblock command.
+func handlePreciousBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.PreciousBlockCmd)
+
+	hash, err := chainhash.NewHashFromStr(c.BlockHash)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.BlockHash)
+	}
+	if _, err := s.cfg.Chain.FetchHeader(hash); err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCBlockNotFound,
+			Message: "Block not found",
+		}
+	}
+	if err := s.cfg.SyncMgr.PreciousBlock(hash); err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCMisc,
+			Message: "Failed to prefer block: " + err.Error(),
+		}
+	}
+
+	return nil, nil
+}
+
 // retrievedTx represents a transaction that was either loaded from the
 // transaction memory pool or from the database.  When a transaction is loaded
 // from the database, it is loaded with the raw serialized bytes while the
@@ -2948,6 +3470,30 @@ func fetchMempoolTxnsForAddress(s *rpcServer, addr btcutil.Address, numToSkip, n
 	return mpTxns[numToSkip:rangeEnd], numToSkip
 }
 
+// handleReconsiderBlock implements the reconsiderblock command.
+func handleReconsiderBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.ReconsiderBlockCmd)
+
+	hash, err := chainhash.NewHashFromStr(c.BlockHash)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.BlockHash)
+	}
+	if _, err := s.cfg.Chain.FetchHeader(hash); err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCBlockNotFound,
+			Message: "Block not found",
+		}
+	}
+	if err := s.cfg.SyncMgr.ReconsiderBlock(hash); err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCMisc,
+			Message: "Failed to reconsider block: " + err.Error(),
+		}
+	}
+
+	return nil, nil
+}
+
 // handleSearchRawTransactions implements the searchrawtransactions command.
 func handleSearchRawTransactions(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// Respond with an error if the address index is not enabled.
@@ -3634,6 +4180,15 @@ func (s *rpcServer) NotifyNewTransactions(txns []*mempool.TxDesc) {
 	}
 }
 
+// NotifyBalanceChanges notifies websocket clients of the balance changes the
+// explorer applied to or reversed from the balance repository for the passed
+// block.
+func (s *rpcServer) NotifyBalanceChanges(blockHash *chainhash.Hash, height int32,
+	deltas map[string][]data.BalanceDelta, connected bool) {
+
+	s.ntfnMgr.NotifyBalanceChanges(blockHash, height, deltas, connected)
+}
+
 // limitConnections responds with a 503 service unavailable and returns true if
 // adding another client would exceed the maximum allow RPC clients.
 //
@@ -4137,6 +4692,22 @@ type rpcserverSyncManager interface {
 	// current tip is reached, up to a max of wire.MaxBlockHeadersPerMsg
 	// hashes.
 	LocateHeaders(locators []*chainhash.Hash, hashStop *chainhash.Hash) []wire.BlockHeader
+
+	// ExplorerHaltErr returns the error that caused the balance explorer
+	// to halt or nil when it is running normally or disabled.
+	ExplorerHaltErr() error
+
+	// InvalidateBlock marks the block with the provided hash and its
+	// descendants as invalid and reorganizes the chain away from them.
+	InvalidateBlock(hash *chainhash.Hash) error
+
+	// ReconsiderBlock undoes the effect of InvalidateBlock on the block
+	// with the provided hash and reorganizes the chain as needed.
+	ReconsiderBlock(hash *chainhash.Hash) error
+
+	// PreciousBlock treats the block with the provided hash as if it was
+	// received before any other block with the same work.
+	PreciousBlock(hash *chainhash.Hash) error
 }
 
 // rpcserverConfig is a descriptor containing the RPC server configuration.
@@ -4182,6 +4753,23 @@ type rpcserverConfig struct {
 	// of to provide additional data when queried.
 	TxIndex   *indexers.TxIndex
 	AddrIndex *indexers.AddrIndex
+
+	// BalanceRepo is the repository the balance explorer records address
+	// balances to.  It is nil when the explorer is disabled.
+	BalanceRepo data.IBalanceRepository
+
+	// BalanceKeys defines the keys the balance explorer records the
+	// balances of addresses under.
+	BalanceKeys *data.KeyPolicy
+
+	// UnconfirmedBalances tracks the balance changes caused by the
+	// transactions in the memory pool.  It is nil when the explorer is
+	// disabled.
+	UnconfirmedBalances *data.UnconfirmedBalances
+
+	// BalanceDumpDir is the directory the dumpbalances command writes its
+	// files to.  It is created when needed.
+	BalanceDumpDir string
 }
 
 // newRPCServer returns a new instance of the rpcServer struct.
@@ -115,6 +115,23 @@ var helpDescsEnUS = map[string]string{
 	"decodescript--synopsis": "Returns a JSON object with information about the provided hex-encoded script.",
 	"decodescript-hexscript": "Hex-encoded script",
 
+	// DumpBalancesCmd help.
+	"dumpbalances--synopsis": "Writes every non-zero confirmed balance recorded by the balance explorer to a file in the balancedumps directory under the data directory of the server as of the last block applied to the balance repository.\n" +
+		"Every entry also holds the total value the address received and sent, the number of transactions that touched it and the heights of the blocks of the first and last of them as of the same block.\n" +
+		"The file must not exist yet.",
+	"dumpbalances-filename":    "Name of the file to write, without a directory",
+	"dumpbalances-format":      "Format of the file, either csv or jsonl",
+	"dumpbalances-minbalance":  "Only include addresses with at least this balance in BTC",
+	"dumpbalances-addresstype": "Only include addresses of this type (pubkeyhash, scripthash, pubkey, witness_v0_keyhash or witness_v0_scripthash)",
+
+	// DumpBalancesResult help.
+	"dumpbalancesresult-filename":  "The path of the written file on the server",
+	"dumpbalancesresult-format":    "The format of the file",
+	"dumpbalancesresult-hash":      "Hex-encoded bytes of the hash of the block the balances are current as of",
+	"dumpbalancesresult-height":    "Height of the block the balances are current as of",
+	"dumpbalancesresult-count":     "The number of balances written",
+	"dumpbalancesresult-untracked": "The number of balances written that were recorded before the height of their last change was tracked, whose first and last seen heights are unknown until the balance repository is rebuilt",
+
 	// GenerateCmd help
 	"generate--synopsis": "Generates a set number of blocks (simnet or regtest only) and returns a JSON\n" +
 		" array of their hashes.",
@@ -138,6 +155,44 @@ var helpDescsEnUS = map[string]string{
 	"getaddednodeinfo--condition1": "dns=true",
 	"getaddednodeinfo--result0":    "List of added peers",
 
+	// GetAddressBalanceResult help.
+	"getaddressbalanceresult-address":     "The address the balance is for",
+	"getaddressbalanceresult-balance":     "The confirmed balance of the address in BTC",
+	"getaddressbalanceresult-unconfirmed": "The net change of the balance caused by the transactions in the memory pool in BTC (always 0 for a historical balance)",
+	"getaddressbalanceresult-total":       "The sum of the confirmed balance and the unconfirmed change in BTC",
+	"getaddressbalanceresult-received":    "The total value of the confirmed outputs paid to the address in BTC",
+	"getaddressbalanceresult-sent":        "The total value of the confirmed outputs spent from the address in BTC",
+	"getaddressbalanceresult-txcount":     "The number of confirmed transactions that paid to or spent from the address",
+	"getaddressbalanceresult-firstseen":   "Height of the block the first of those transactions is in (0 when there are none)",
+	"getaddressbalanceresult-lastseen":    "Height of the block the last of those transactions is in (0 when there are none)",
+	"getaddressbalanceresult-hash":        "Hex-encoded bytes of the hash of the last block applied to the balance repository",
+	"getaddressbalanceresult-height":      "Height of the last block applied to the balance repository",
+
+	// GetAddressBalanceCmd help.
+	"getaddressbalance--synopsis": "Returns the confirmed balance of an address as recorded by the balance explorer along with the change caused by unconfirmed transactions in the memory pool.\n" +
+		"Pay-to-pubkey outputs are included in the balance of the associated pay-to-pubkey-hash address unless --balanceseparatep2pk is set, in which case their balance is returned for the hex-encoded public key.\n" +
+		"Bare multisig outputs are included in the balance of the pay-to-script-hash address of their script.\n" +
+		"The balance explorer must be enabled with --balancebackend.",
+	"getaddressbalance-address": "The Bitcoin address to return the balance for",
+	"getaddressbalance-height":  "Return the balance right after the block at this height was connected instead of the current one",
+
+	// BalanceChangeResult help.
+	"balancechangeresult-height":   "Height of the block the change happened in",
+	"balancechangeresult-txid":     "The hash of the transaction that changed the balance",
+	"balancechangeresult-delta":    "The change in balance caused by the transaction in BTC",
+	"balancechangeresult-received": "The value of the outputs of the transaction paid to the address in BTC",
+	"balancechangeresult-sent":     "The value of the outputs spent from the address by the transaction in BTC",
+	"balancechangeresult-balance":  "The balance of the address right after the change in BTC",
+
+	// GetAddressBalanceHistoryCmd help.
+	"getaddressbalancehistory--synopsis": "Returns every change to the confirmed balance of an address in a range of blocks as recorded by the balance explorer, in chain order.\n" +
+		"Each change is the net effect of a single transaction on the balance of the address.\n" +
+		"The balance explorer must be enabled with --balancebackend.",
+	"getaddressbalancehistory-address":    "The Bitcoin address to return the balance history for",
+	"getaddressbalancehistory-fromheight": "Height of the first block to include changes from",
+	"getaddressbalancehistory-toheight":   "Height of the last block to include changes from (default: the last block recorded by the balance explorer)",
+	"getaddressbalancehistory--result0":   "The changes to the balance of the address",
+
 	// GetBestBlockResult help.
 	"getbestblockresult-hash":   "Hex-encoded bytes of the best block hash",
 	"getbestblockresult-height": "Height of the best block",
@@ -323,6 +378,15 @@ var helpDescsEnUS = map[string]string{
 	"getblocktemplate--condition2": "mode=proposal, accepted",
 	"getblocktemplate--result1":    "An error string which represents why the proposal was rejected or nothing if accepted",
 
+	// GetChainTipsResult help.
+	"getchaintipsresult-height":    "The height of the chain tip",
+	"getchaintipsresult-hash":      "The hash of the chain tip",
+	"getchaintipsresult-branchlen": "The number of blocks from the chain tip back to where its branch forks from the main chain, or zero for the main chain",
+	"getchaintipsresult-status":    "The status of the branch (active, valid-fork, valid-headers, headers-only or invalid)",
+
+	// GetChainTipsCmd help.
+	"getchaintips--synopsis": "Returns information about the tips of the main chain and of all known side chains.",
+
 	// GetConnectionCountCmd help.
 	"getconnectioncount--synopsis": "Returns the number of active connections to other peers.",
 	"getconnectioncount--result0":  "The number of connections",
@@ -379,6 +443,16 @@ var helpDescsEnUS = map[string]string{
 	"getheaders-hashstop":      "Block hash to stop including block headers for; if not found, all headers to the latest known block are returned.",
 	"getheaders--result0":      "Serialized block headers of all located blocks, limited to some arbitrary maximum number of hashes (currently 2000, which matches the wire protocol headers message, but this is not guaranteed)",
 
+	// TopBalanceResult help.
+	"topbalanceresult-address": "The address",
+	"topbalanceresult-balance": "The confirmed balance of the address in BTC",
+
+	// ListTopBalancesCmd help.
+	"listtopbalances--synopsis": "Returns the addresses with the largest confirmed balances as recorded by the balance explorer, ordered from the largest balance.\n" +
+		"The balance explorer must be enabled with --balancebackend.",
+	"listtopbalances-count":      "The maximum number of addresses to return",
+	"listtopbalances-minbalance": "Only include addresses with at least this balance in BTC",
+
 	// GetInfoCmd help.
 	"getinfo--synopsis": "Returns a JSON object containing various state info.",
 
@@ -476,7 +550,6 @@ var helpDescsEnUS = map[string]string{
 	"gettxoutresult-confirmations": "The number of confirmations",
 	"gettxoutresult-value":         "The transaction amount in BTC",
 	"gettxoutresult-scriptPubKey":  "The public key script used to pay coins as a JSON object",
-	"gettxoutresult-version":       "The transaction version",
 	"gettxoutresult-coinbase":      "Whether or not the transaction is a coinbase",
 
 	// GetTxOutCmd help.
@@ -493,10 +566,25 @@ var helpDescsEnUS = map[string]string{
 	"help--result0":    "List of commands",
 	"help--result1":    "Help for specified command",
 
+	// InvalidateBlockCmd help.
+	"invalidateblock--synopsis": "Permanently marks a block and all of its descendants as invalid, reorganizing the chain away from them if needed.\n" +
+		"The block remains invalid across restarts until it is passed to reconsiderblock.",
+	"invalidateblock-blockhash": "The hash of the block to invalidate",
+
 	// PingCmd help.
 	"ping--synopsis": "Queues a ping to be sent to each connected peer.\n" +
 		"Ping times are provided by getpeerinfo via the pingtime and pingwait fields.",
 
+	// PreciousBlockCmd help.
+	"preciousblock--synopsis": "Treats a block as if it was received before other blocks with the same amount of work, reorganizing the chain to it if needed.\n" +
+		"The preference is lost on restart.",
+	"preciousblock-blockhash": "The hash of the block to prefer",
+
+	// ReconsiderBlockCmd help.
+	"reconsiderblock--synopsis": "Removes the invalidity status of a block along with its ancestors and descendants, reorganizing the chain to the one with the most work if needed.\n" +
+		"This undoes the effects of invalidateblock.",
+	"reconsiderblock-blockhash": "The hash of the block to reconsider",
+
 	// SearchRawTransactionsCmd help.
 	"searchrawtransactions--synopsis": "Returns raw data for transactions involving the passed address.\n" +
 		"Returned transactions are pulled from both the database, and transactions currently in the mempool.\n" +
@@ -593,6 +681,14 @@ var helpDescsEnUS = map[string]string{
 	"stopnotifyreceived--synopsis": "Cancel registered receive notifications for each passed address.",
 	"stopnotifyreceived-addresses": "List of address to cancel receive notifications for",
 
+	// NotifyBalanceChangesCmd help.
+	"notifybalancechanges--synopsis": "Send a balancechanged notification whenever the balance explorer applies a block that changes the balance of any of the passed addresses or reverses such a block during a reorganize.",
+	"notifybalancechanges-addresses": "List of addresses to receive balance notifications about",
+
+	// StopNotifyBalanceChangesCmd help.
+	"stopnotifybalancechanges--synopsis": "Cancel registered balance notifications for each passed address.",
+	"stopnotifybalancechanges-addresses": "List of addresses to cancel balance notifications for",
+
 	// OutPoint help.
 	"outpoint-hash":  "The hex-encoded bytes of the outpoint hash",
 	"outpoint-index": "The index of the outpoint",
@@ -653,49 +749,57 @@ var helpDescsEnUS = map[string]string{
 // This information is used to generate the help.  Each result type must be a
 // pointer to the type (or nil to indicate no return value).
 var rpcResultTypes = map[string][]interface{}{
-	"addnode":               nil,
-	"createrawtransaction":  {(*string)(nil)},
-	"debuglevel":            {(*string)(nil), (*string)(nil)},
-	"decoderawtransaction":  {(*btcjson.TxRawDecodeResult)(nil)},
-	"decodescript":          {(*btcjson.DecodeScriptResult)(nil)},
-	"generate":              {(*[]string)(nil)},
-	"getaddednodeinfo":      {(*[]string)(nil), (*[]btcjson.GetAddedNodeInfoResult)(nil)},
-	"getbestblock":          {(*btcjson.GetBestBlockResult)(nil)},
-	"getbestblockhash":      {(*string)(nil)},
-	"getblock":              {(*string)(nil), (*btcjson.GetBlockVerboseResult)(nil)},
-	"getblockcount":         {(*int64)(nil)},
-	"getblockhash":          {(*string)(nil)},
-	"getblockheader":        {(*string)(nil), (*btcjson.GetBlockHeaderVerboseResult)(nil)},
-	"getblocktemplate":      {(*btcjson.GetBlockTemplateResult)(nil), (*string)(nil), nil},
-	"getblockchaininfo":     {(*btcjson.GetBlockChainInfoResult)(nil)},
-	"getconnectioncount":    {(*int32)(nil)},
-	"getcurrentnet":         {(*uint32)(nil)},
-	"getdifficulty":         {(*float64)(nil)},
-	"getgenerate":           {(*bool)(nil)},
-	"gethashespersec":       {(*float64)(nil)},
-	"getheaders":            {(*[]string)(nil)},
-	"getinfo":               {(*btcjson.InfoChainResult)(nil)},
-	"getmempoolinfo":        {(*btcjson.GetMempoolInfoResult)(nil)},
-	"getmininginfo":         {(*btcjson.GetMiningInfoResult)(nil)},
-	"getnettotals":          {(*btcjson.GetNetTotalsResult)(nil)},
-	"getnetworkhashps":      {(*int64)(nil)},
-	"getpeerinfo":           {(*[]btcjson.GetPeerInfoResult)(nil)},
-	"getrawmempool":         {(*[]string)(nil), (*btcjson.GetRawMempoolVerboseResult)(nil)},
-	"getrawtransaction":     {(*string)(nil), (*btcjson.TxRawResult)(nil)},
-	"gettxout":              {(*btcjson.GetTxOutResult)(nil)},
-	"node":                  nil,
-	"help":                  {(*string)(nil), (*string)(nil)},
-	"ping":                  nil,
-	"searchrawtransactions": {(*string)(nil), (*[]btcjson.SearchRawTransactionsResult)(nil)},
-	"sendrawtransaction":    {(*string)(nil)},
-	"setgenerate":           nil,
-	"stop":                  {(*string)(nil)},
-	"submitblock":           {nil, (*string)(nil)},
-	"uptime":                {(*int64)(nil)},
-	"validateaddress":       {(*btcjson.ValidateAddressChainResult)(nil)},
-	"verifychain":           {(*bool)(nil)},
-	"verifymessage":         {(*bool)(nil)},
-	"version":               {(*map[string]btcjson.VersionResult)(nil)},
+	"addnode":                  nil,
+	"createrawtransaction":     {(*string)(nil)},
+	"debuglevel":               {(*string)(nil), (*string)(nil)},
+	"decoderawtransaction":     {(*btcjson.TxRawDecodeResult)(nil)},
+	"decodescript":             {(*btcjson.DecodeScriptResult)(nil)},
+	"dumpbalances":             {(*btcjson.DumpBalancesResult)(nil)},
+	"generate":                 {(*[]string)(nil)},
+	"getaddednodeinfo":         {(*[]string)(nil), (*[]btcjson.GetAddedNodeInfoResult)(nil)},
+	"getaddressbalance":        {(*btcjson.GetAddressBalanceResult)(nil)},
+	"getaddressbalancehistory": {(*[]btcjson.BalanceChangeResult)(nil)},
+	"getbestblock":             {(*btcjson.GetBestBlockResult)(nil)},
+	"getbestblockhash":         {(*string)(nil)},
+	"getblock":                 {(*string)(nil), (*btcjson.GetBlockVerboseResult)(nil)},
+	"getblockcount":            {(*int64)(nil)},
+	"getblockhash":             {(*string)(nil)},
+	"getblockheader":           {(*string)(nil), (*btcjson.GetBlockHeaderVerboseResult)(nil)},
+	"getblocktemplate":         {(*btcjson.GetBlockTemplateResult)(nil), (*string)(nil), nil},
+	"getblockchaininfo":        {(*btcjson.GetBlockChainInfoResult)(nil)},
+	"getchaintips":             {(*[]btcjson.GetChainTipsResult)(nil)},
+	"getconnectioncount":       {(*int32)(nil)},
+	"getcurrentnet":            {(*uint32)(nil)},
+	"getdifficulty":            {(*float64)(nil)},
+	"getgenerate":              {(*bool)(nil)},
+	"gethashespersec":          {(*float64)(nil)},
+	"getheaders":               {(*[]string)(nil)},
+	"getinfo":                  {(*btcjson.InfoChainResult)(nil)},
+	"getmempoolinfo":           {(*btcjson.GetMempoolInfoResult)(nil)},
+	"getmininginfo":            {(*btcjson.GetMiningInfoResult)(nil)},
+	"getnettotals":             {(*btcjson.GetNetTotalsResult)(nil)},
+	"getnetworkhashps":         {(*int64)(nil)},
+	"getpeerinfo":              {(*[]btcjson.GetPeerInfoResult)(nil)},
+	"getrawmempool":            {(*[]string)(nil), (*btcjson.GetRawMempoolVerboseResult)(nil)},
+	"getrawtransaction":        {(*string)(nil), (*btcjson.TxRawResult)(nil)},
+	"gettxout":                 {(*btcjson.GetTxOutResult)(nil)},
+	"help":                     {(*string)(nil), (*string)(nil)},
+	"invalidateblock":          nil,
+	"listtopbalances":          {(*[]btcjson.TopBalanceResult)(nil)},
+	"node":                     nil,
+	"ping":                     nil,
+	"preciousblock":            nil,
+	"reconsiderblock":          nil,
+	"searchrawtransactions":    {(*string)(nil), (*[]btcjson.SearchRawTransactionsResult)(nil)},
+	"sendrawtransaction":       {(*string)(nil)},
+	"setgenerate":              nil,
+	"stop":                     {(*string)(nil)},
+	"submitblock":              {nil, (*string)(nil)},
+	"uptime":                   {(*int64)(nil)},
+	"validateaddress":          {(*btcjson.ValidateAddressChainResult)(nil)},
+	"verifychain":              {(*bool)(nil)},
+	"verifymessage":            {(*bool)(nil)},
+	"version":                  {(*map[string]btcjson.VersionResult)(nil)},
 
 	// Websocket commands.
 	"loadtxfilter":              nil,
@@ -706,6 +810,8 @@ var rpcResultTypes = map[string][]interface{}{
 	"stopnotifynewtransactions": nil,
 	"notifyreceived":            nil,
 	"stopnotifyreceived":        nil,
+	"notifybalancechanges":      nil,
+	"stopnotifybalancechanges":  nil,
 	"notifyspent":               nil,
 	"stopnotifyspent":           nil,
 	"rescan":                    nil,
@@ -26,6 +26,7 @@ import (
 	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/data"
 	"github.com/btcsuite/btcd/database"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
@@ -66,11 +67,13 @@ var wsHandlers map[string]wsCommandHandler
 var wsHandlersBeforeInit = map[string]wsCommandHandler{
 	"loadtxfilter":              handleLoadTxFilter,
 	"help":                      handleWebsocketHelp,
+	"notifybalancechanges":      handleNotifyBalanceChanges,
 	"notifyblocks":              handleNotifyBlocks,
 	"notifynewtransactions":     handleNotifyNewTransactions,
 	"notifyreceived":            handleNotifyReceived,
 	"notifyspent":               handleNotifySpent,
 	"session":                   handleSession,
+	"stopnotifybalancechanges":  handleStopNotifyBalanceChanges,
 	"stopnotifyblocks":          handleStopNotifyBlocks,
 	"stopnotifynewtransactions": handleStopNotifyNewTransactions,
 	"stopnotifyspent":           handleStopNotifySpent,
@@ -247,6 +250,29 @@ func (m *wsNotificationManager) NotifyMempoolTx(tx *btcutil.Tx, isNew bool) {
 	}
 }
 
+// NotifyBalanceChanges passes the balance changes the explorer applied to or
+// reversed from the balance repository for a block to the notification manager
+// for balance notification processing.
+func (m *wsNotificationManager) NotifyBalanceChanges(blockHash *chainhash.Hash,
+	height int32, deltas map[string][]data.BalanceDelta, connected bool) {
+
+	n := &notificationBalanceChanges{
+		blockHash: blockHash,
+		height:    height,
+		deltas:    deltas,
+		connected: connected,
+	}
+
+	// As NotifyBalanceChanges will be called by the explorer and the RPC
+	// server may no longer be running, use a select statement to unblock
+	// enqueuing the notification once the RPC server has begun shutting
+	// down.
+	select {
+	case m.queueNotification <- n:
+	case <-m.quit:
+	}
+}
+
 // wsClientFilter tracks relevant addresses for each websocket client for
 // the `rescanblocks` extension. It is modified by the `loadtxfilter` command.
 //
@@ -452,6 +478,12 @@ type notificationTxAcceptedByMempool struct {
 	isNew bool
 	tx    *btcutil.Tx
 }
+type notificationBalanceChanges struct {
+	blockHash *chainhash.Hash
+	height    int32
+	deltas    map[string][]data.BalanceDelta
+	connected bool
+}
 
 // Notification control requests
 type notificationRegisterClient wsClient
@@ -476,6 +508,14 @@ type notificationUnregisterAddr struct {
 	wsc  *wsClient
 	addr string
 }
+type notificationRegisterBalances struct {
+	wsc  *wsClient
+	keys map[string]string
+}
+type notificationUnregisterBalance struct {
+	wsc  *wsClient
+	addr string
+}
 
 // notificationHandler reads notifications and control messages from the queue
 // handler and processes one at a time.
@@ -494,6 +534,7 @@ func (m *wsNotificationManager) notificationHandler() {
 	txNotifications := make(map[chan struct{}]*wsClient)
 	watchedOutPoints := make(map[wire.OutPoint]map[chan struct{}]*wsClient)
 	watchedAddrs := make(map[string]map[chan struct{}]*wsClient)
+	watchedBalances := make(map[string]map[chan struct{}]*wsClient)
 
 out:
 	for {
@@ -540,6 +581,11 @@ out:
 				m.notifyForTx(watchedOutPoints, watchedAddrs, n.tx, nil)
 				m.notifyRelevantTxAccepted(n.tx, clients)
 
+			case *notificationBalanceChanges:
+				if len(watchedBalances) != 0 {
+					m.notifyBalanceChanges(watchedBalances, n)
+				}
+
 			case *notificationRegisterBlocks:
 				wsc := (*wsClient)(n)
 				blockNotifications[wsc.quit] = wsc
@@ -565,6 +611,10 @@ out:
 				for addr := range wsc.addrRequests {
 					m.removeAddrRequest(watchedAddrs, wsc, addr)
 				}
+				for addr := range wsc.balanceRequests {
+					m.removeBalanceRequest(watchedBalances, wsc,
+						addr)
+				}
 				delete(clients, wsc.quit)
 
 			case *notificationRegisterSpent:
@@ -579,6 +629,12 @@ out:
 			case *notificationUnregisterAddr:
 				m.removeAddrRequest(watchedAddrs, n.wsc, n.addr)
 
+			case *notificationRegisterBalances:
+				m.addBalanceRequests(watchedBalances, n.wsc, n.keys)
+
+			case *notificationUnregisterBalance:
+				m.removeBalanceRequest(watchedBalances, n.wsc, n.addr)
+
 			case *notificationRegisterNewMempoolTxs:
 				wsc := (*wsClient)(n)
 				txNotifications[wsc.quit] = wsc
@@ -1161,6 +1217,135 @@ func (*wsNotificationManager) removeAddrRequest(addrs map[string]map[chan struct
 	}
 }
 
+// RegisterBalanceRequests requests notifications to the passed websocket client
+// when the balance of any of the passed addresses changes.  The addresses are
+// mapped to the keys the explorer records their balances under.
+func (m *wsNotificationManager) RegisterBalanceRequests(wsc *wsClient, keys map[string]string) {
+	m.queueNotification <- &notificationRegisterBalances{
+		wsc:  wsc,
+		keys: keys,
+	}
+}
+
+// addBalanceRequests adds the websocket client wsc to the balance key to client
+// set keyMap so wsc will be notified when the balance of any of the addresses
+// in keys changes.
+func (*wsNotificationManager) addBalanceRequests(keyMap map[string]map[chan struct{}]*wsClient,
+	wsc *wsClient, keys map[string]string) {
+
+	for addr, key := range keys {
+		// Track the request in the client as well so it can be quickly
+		// be removed on disconnect.
+		wsc.balanceRequests[addr] = key
+
+		// Add the client to the set of clients to notify when the
+		// balance changes.  Create map as needed.
+		cmap, ok := keyMap[key]
+		if !ok {
+			cmap = make(map[chan struct{}]*wsClient)
+			keyMap[key] = cmap
+		}
+		cmap[wsc.quit] = wsc
+	}
+}
+
+// UnregisterBalanceRequest removes a request from the passed websocket client
+// to be notified when the balance of the passed address changes.
+func (m *wsNotificationManager) UnregisterBalanceRequest(wsc *wsClient, addr string) {
+	m.queueNotification <- &notificationUnregisterBalance{
+		wsc:  wsc,
+		addr: addr,
+	}
+}
+
+// removeBalanceRequest removes the websocket client wsc from the balance key to
+// client set keyMap so it will no longer receive notifications for the balance
+// of addr.
+func (*wsNotificationManager) removeBalanceRequest(keyMap map[string]map[chan struct{}]*wsClient,
+	wsc *wsClient, addr string) {
+
+	// Remove the request tracking from the client.
+	key, ok := wsc.balanceRequests[addr]
+	if !ok {
+		rpcsLog.Warnf("Attempt to remove nonexistent balance request "+
+			"<%s> for websocket client %s", addr, wsc.addr)
+		return
+	}
+	delete(wsc.balanceRequests, addr)
+
+	// Keep notifying the client when it is still interested in another
+	// address that shares the same key, such as the pay-to-pubkey-hash
+	// address of a public key.
+	for _, otherKey := range wsc.balanceRequests {
+		if otherKey == key {
+			return
+		}
+	}
+
+	// Remove the client from the list to notify and the map entry
+	// altogether if there are no more clients interested in it.
+	cmap := keyMap[key]
+	delete(cmap, wsc.quit)
+	if len(cmap) == 0 {
+		delete(keyMap, key)
+	}
+}
+
+// notifyBalanceChanges notifies websocket clients that have registered for
+// balance updates of an address when the explorer applied or reversed a block
+// that changed its balance.
+//
+// The balances are resolved as of the parent of the block since the explorer
+// may have applied more blocks by the time the notification is processed.
+func (m *wsNotificationManager) notifyBalanceChanges(keyMap map[string]map[chan struct{}]*wsClient,
+	n *notificationBalanceChanges) {
+
+	balanceRepo := m.server.cfg.BalanceRepo
+	blockHash := n.blockHash.String()
+	for key, cmap := range keyMap {
+		deltas, ok := n.deltas[key]
+		if !ok {
+			continue
+		}
+
+		prevValue, err := balanceRepo.BalanceAtHeight(key, n.height-1)
+		if err != nil {
+			rpcsLog.Errorf("Failed to load balance of %s at height "+
+				"%d: %v", key, n.height-1, err)
+			continue
+		}
+		var sum int64
+		for i := range deltas {
+			sum += deltas[i].Value
+		}
+		oldValue, newValue := prevValue, prevValue+sum
+		if !n.connected {
+			oldValue, newValue = newValue, oldValue
+		}
+
+		for _, wsc := range cmap {
+			for addr, addrKey := range wsc.balanceRequests {
+				if addrKey != key {
+					continue
+				}
+
+				ntfn := btcjson.NewBalanceChangedNtfn(addr,
+					btcutil.Amount(oldValue).ToBTC(),
+					btcutil.Amount(newValue).ToBTC(),
+					btcutil.Amount(newValue-oldValue).ToBTC(),
+					blockHash, n.height)
+				marshalledJSON, err := btcjson.MarshalCmd(nil, ntfn)
+				if err != nil {
+					rpcsLog.Errorf("Failed to marshal balance "+
+						"notification: %v", err)
+					continue
+				}
+				wsc.QueueNotification(marshalledJSON)
+			}
+		}
+	}
+}
+
 // AddClient adds the passed websocket client to the notification manager.
 func (m *wsNotificationManager) AddClient(wsc *wsClient) {
 	m.queueNotification <- (*notificationRegisterClient)(wsc)
@@ -1267,6 +1452,12 @@ type wsClient struct {
 	// when a wallet disconnects.  Owned by the notification manager.
 	addrRequests map[string]struct{}
 
+	// balanceRequests maps the addresses the caller has requested balance
+	// notifications for to the keys the explorer records their balances
+	// under.  It is maintained here so all requests can be removed when
+	// a client disconnects.  Owned by the notification manager.
+	balanceRequests map[string]string
+
 	// spentRequests is a set of unspent Outpoints a wallet has requested
 	// notifications for when they are spent by a processed transaction.
 	// Owned by the notification manager.
@@ -1709,6 +1900,7 @@ func newWebsocketClient(server *rpcServer, conn *websocket.Conn,
 		sessionID:         sessionID,
 		server:            server,
 		addrRequests:      make(map[string]struct{}),
+		balanceRequests:   make(map[string]string),
 		spentRequests:     make(map[wire.OutPoint]struct{}),
 		serviceRequestSem: makeSemaphore(cfg.RPCMaxConcurrentReqs),
 		ntfnChan:          make(chan []byte, 1), // nonblocking sync
@@ -1886,6 +2078,33 @@ func handleNotifyReceived(wsc *wsClient, icmd interface{}) (interface{}, error)
 	return nil, nil
 }
 
+// handleNotifyBalanceChanges implements the notifybalancechanges command
+// extension for websocket connections.
+func handleNotifyBalanceChanges(wsc *wsClient, icmd interface{}) (interface{}, error) {
+	cmd, ok := icmd.(*btcjson.NotifyBalanceChangesCmd)
+	if !ok {
+		return nil, btcjson.ErrRPCInternal
+	}
+
+	if wsc.server.cfg.BalanceRepo == nil {
+		return nil, errBalanceExplorerDisabled
+	}
+
+	// Resolve all keys up front so no request is registered when any of
+	// the addresses is invalid.
+	keys := make(map[string]string, len(cmd.Addresses))
+	for _, addr := range cmd.Addresses {
+		key, err := balanceKey(wsc.server, addr)
+		if err != nil {
+			return nil, err
+		}
+		keys[addr] = key
+	}
+
+	wsc.server.ntfnMgr.RegisterBalanceRequests(wsc, keys)
+	return nil, nil
+}
+
 // handleStopNotifySpent implements the stopnotifyspent command extension for
 // websocket connections.
 func handleStopNotifySpent(wsc *wsClient, icmd interface{}) (interface{}, error) {
@@ -1928,6 +2147,28 @@ func handleStopNotifyReceived(wsc *wsClient, icmd interface{}) (interface{}, err
 	return nil, nil
 }
 
+// handleStopNotifyBalanceChanges implements the stopnotifybalancechanges
+// command extension for websocket connections.
+func handleStopNotifyBalanceChanges(wsc *wsClient, icmd interface{}) (interface{}, error) {
+	cmd, ok := icmd.(*btcjson.StopNotifyBalanceChangesCmd)
+	if !ok {
+		return nil, btcjson.ErrRPCInternal
+	}
+
+	// Decode addresses to validate input, but the strings slice is used
+	// directly if these are all ok.
+	err := checkAddressValidity(cmd.Addresses, wsc.server.cfg.ChainParams)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range cmd.Addresses {
+		wsc.server.ntfnMgr.UnregisterBalanceRequest(wsc, addr)
+	}
+
+	return nil, nil
+}
+
 // checkAddressValidity checks the validity of each address in the passed
 // string slice. It does this by attempting to decode each address using the
 // current active network parameters. If any single address fails to decode
@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strconv"
@@ -28,6 +29,7 @@ import (
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/connmgr"
+	"github.com/btcsuite/btcd/data"
 	"github.com/btcsuite/btcd/database"
 	"github.com/btcsuite/btcd/mempool"
 	"github.com/btcsuite/btcd/mining"
@@ -226,8 +228,9 @@ type server struct {
 	// if the associated index is not enabled.  These fields are set during
 	// initial creation of the server and never changed afterwards, so they
 	// do not need to be protected for concurrent access.
-	txIndex   *indexers.TxIndex
-	addrIndex *indexers.AddrIndex
+	txIndex      *indexers.TxIndex
+	addrIndex    *indexers.AddrIndex
+	balanceIndex *indexers.BalanceIndex
 }
 
 // serverPeer extends the peer to maintain state shared by the server and
@@ -1022,6 +1025,18 @@ func (s *server) TransactionConfirmed(tx *btcutil.Tx) {
 	s.RemoveRebroadcastInventory(iv)
 }
 
+// BalancesChanged notifies websocket clients of the balance changes the
+// explorer applied to or reversed from the balance repository for the passed
+// block.
+func (s *server) BalancesChanged(blockHash *chainhash.Hash, height int32,
+	deltas map[string][]data.BalanceDelta, connected bool) {
+
+	if s.rpcServer != nil {
+		s.rpcServer.NotifyBalanceChanges(blockHash, height, deltas,
+			connected)
+	}
+}
+
 // pushTxMsg sends a tx message for the provided transaction hash to the
 // connected peer.  An error is returned if the transaction hash is not known.
 func (s *server) pushTxMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<- struct{},
@@ -2151,13 +2166,18 @@ func setupRPCListeners() ([]net.Listener, error) {
 }
 
 // newServer returns a new btcd server configured to listen on addr for the
-// bitcoin network type specified by chainParams.  Use start to begin accepting
-// connections from peers.
-func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Params, interrupt <-chan struct{}) (*server, error) {
+// bitcoin network type specified by chainParams.  The explorer records address
+// balances to the passed balance repository unless it is nil.  Use start to
+// begin accepting connections from peers.
+func newServer(listenAddrs []string, db database.DB, balanceRepo data.IBalanceRepository, chainParams *chaincfg.Params, interrupt <-chan struct{}) (*server, error) {
 	services := defaultServices
 	if cfg.NoPeerBloomFilters {
 		services &^= wire.SFNodeBloom
 	}
+	if cfg.Prune != 0 {
+		services &^= wire.SFNodeNetwork
+		services |= wire.SFNodeNetworkLimited
+	}
 
 	amgr := addrmgr.New(cfg.DataDir, btcdLookup)
 
@@ -2194,19 +2214,31 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		hashCache:            txscript.NewHashCache(cfg.SigCacheMaxSize),
 	}
 
-	// Create the transaction and address indexes if needed.
+	// The keys address balances are recorded under by the address balance
+	// index, the balance explorer and the unconfirmed balance tracking.
+	balanceKeys := &data.KeyPolicy{
+		ChainParams:  chainParams,
+		SeparateP2PK: cfg.BalanceSeparateP2PK,
+	}
+
+	// Create the transaction, address and address balance indexes if
+	// needed.
 	//
 	// CAUTION: the txindex needs to be first in the indexes array because
-	// the addrindex uses data from the txindex during catchup.  If the
-	// addrindex is run first, it may not have the transactions from the
-	// current block indexed.
+	// the addrindex and balanceindex use data from the txindex during
+	// catchup.  If either of them is run first, it may not have the
+	// transactions from the current block indexed.
 	var indexes []indexers.Indexer
-	if cfg.TxIndex || cfg.AddrIndex {
-		// Enable transaction index if address index is enabled since it
-		// requires it.
+	if cfg.TxIndex || cfg.AddrIndex || cfg.BalanceIndex ||
+		cfg.BalanceBackend != "" {
+
+		// Enable transaction index if the address or address balance
+		// index or the balance explorer is enabled since they require
+		// it.
 		if !cfg.TxIndex {
 			indxLog.Infof("Transaction index enabled because it " +
-				"is required by the address index")
+				"is required by the address and address " +
+				"balance indexes and the balance explorer")
 			cfg.TxIndex = true
 		} else {
 			indxLog.Info("Transaction index is enabled")
@@ -2220,6 +2252,20 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		s.addrIndex = indexers.NewAddrIndex(db, chainParams)
 		indexes = append(indexes, s.addrIndex)
 	}
+	if cfg.BalanceIndex || cfg.BalanceBackend != "" {
+		// Enable the address balance index if the balance explorer is
+		// enabled since it is fed by it.
+		if !cfg.BalanceIndex {
+			indxLog.Infof("Address balance index enabled because " +
+				"it is required by the balance explorer")
+			cfg.BalanceIndex = true
+		} else {
+			indxLog.Info("Address balance index is enabled")
+		}
+
+		s.balanceIndex = indexers.NewBalanceIndex(db, balanceKeys)
+		indexes = append(indexes, s.balanceIndex)
+	}
 
 	// Create an index manager if any of the optional indexes are enabled.
 	var indexManager blockchain.IndexManager
@@ -2236,19 +2282,28 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 	// Create a new block chain instance with the appropriate configuration.
 	var err error
 	s.chain, err = blockchain.New(&blockchain.Config{
-		DB:           s.db,
-		Interrupt:    interrupt,
-		ChainParams:  s.chainParams,
-		Checkpoints:  checkpoints,
-		TimeSource:   s.timeSource,
-		SigCache:     s.sigCache,
-		IndexManager: indexManager,
-		HashCache:    s.hashCache,
+		DB:               s.db,
+		Interrupt:        interrupt,
+		ChainParams:      s.chainParams,
+		Checkpoints:      checkpoints,
+		TimeSource:       s.timeSource,
+		SigCache:         s.sigCache,
+		IndexManager:     indexManager,
+		HashCache:        s.hashCache,
+		UtxoCacheMaxSize: uint64(cfg.UtxoCacheMaxSizeMiB) * 1024 * 1024,
+		Prune:            cfg.Prune * 1024 * 1024,
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	// Track the balance changes of unconfirmed transactions when the
+	// balance explorer is enabled.
+	var unconfirmedBalances *data.UnconfirmedBalances
+	if balanceRepo != nil {
+		unconfirmedBalances = data.NewUnconfirmedBalances(balanceKeys)
+	}
+
 	txC := mempool.Config{
 		Policy: mempool.Policy{
 			DisableRelayPriority: cfg.NoRelayPriority,
@@ -2272,6 +2327,10 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		HashCache:          s.hashCache,
 		AddrIndex:          s.addrIndex,
 	}
+	if unconfirmedBalances != nil {
+		txC.OnTxAccepted = unconfirmedBalances.AddUnconfirmedTx
+		txC.OnTxRemoved = unconfirmedBalances.RemoveUnconfirmedTx
+	}
 	s.txMemPool = mempool.New(&txC)
 
 	s.syncManager, err = netsync.New(&netsync.Config{
@@ -2279,6 +2338,9 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		Chain:              s.chain,
 		TxMemPool:          s.txMemPool,
 		ChainParams:        s.chainParams,
+		BalanceRepo:        balanceRepo,
+		BalanceIndex:       s.balanceIndex,
+		Interrupt:          interrupt,
 		DisableCheckpoints: cfg.DisableCheckpoints,
 		MaxPeers:           cfg.MaxPeers,
 	})
@@ -2418,6 +2480,12 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 			CPUMiner:    s.cpuMiner,
 			TxIndex:     s.txIndex,
 			AddrIndex:   s.addrIndex,
+			BalanceRepo: balanceRepo,
+			BalanceKeys: balanceKeys,
+
+			UnconfirmedBalances: unconfirmedBalances,
+			BalanceDumpDir: filepath.Join(cfg.DataDir,
+				defaultBalanceDumpDirname),
 		})
 		if err != nil {
 			return nil, err